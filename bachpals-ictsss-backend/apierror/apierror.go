@@ -0,0 +1,276 @@
+// Package apierror is the error model of the api. Every failed request is answered
+// with an RFC 7807 problem document carrying a stable code that clients can act on,
+// field details for invalid input and the id of the request.
+package apierror
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// Code identifies the kind of error, codes are part of the api and never change meaning.
+type Code string
+
+const (
+	// InvalidRequest is a malformed body, query or path parameter.
+	InvalidRequest Code = "invalid_request"
+	// ValidationFailed is well formed input with invalid fields, listed in Fields.
+	ValidationFailed Code = "validation_failed"
+	Unauthenticated  Code = "unauthenticated"
+	AdminRequired    Code = "admin_required"
+	// Forbidden is an authenticated user acting on something that is not theirs.
+	Forbidden Code = "forbidden"
+	NotFound  Code = "not_found"
+	// Conflict is a request that clashes with the current state, like a duplicate.
+	Conflict          Code = "conflict"
+	AlreadyMember     Code = "already_member"
+	NotMember         Code = "not_member"
+	OwnerNotRemovable Code = "owner_not_removable"
+	AlreadyTracked    Code = "already_tracked"
+	// LeaseExpired is a member starting a virtual machine whose lease ended, it has to be renewed first.
+	LeaseExpired Code = "lease_expired"
+	// InsufficientCapacity is an order the OpenStack quota has no room for, the capacity is in Data.
+	InsufficientCapacity Code = "insufficient_capacity"
+	// OpenStack, Canvas and AuthProvider are failures of the services the api depends on.
+	OpenStack    Code = "openstack_error"
+	Canvas       Code = "canvas_error"
+	AuthProvider Code = "auth_provider_error"
+	Internal     Code = "internal_error"
+	Unavailable  Code = "service_unavailable"
+)
+
+var statuses = map[Code]int{
+	InvalidRequest:       http.StatusBadRequest,
+	ValidationFailed:     http.StatusBadRequest,
+	Unauthenticated:      http.StatusUnauthorized,
+	AdminRequired:        http.StatusForbidden,
+	Forbidden:            http.StatusForbidden,
+	NotFound:             http.StatusNotFound,
+	Conflict:             http.StatusConflict,
+	AlreadyMember:        http.StatusConflict,
+	NotMember:            http.StatusNotFound,
+	OwnerNotRemovable:    http.StatusConflict,
+	AlreadyTracked:       http.StatusConflict,
+	LeaseExpired:         http.StatusConflict,
+	InsufficientCapacity: http.StatusConflict,
+	OpenStack:            http.StatusBadGateway,
+	Canvas:               http.StatusBadGateway,
+	AuthProvider:         http.StatusBadGateway,
+	Internal:             http.StatusInternalServerError,
+	Unavailable:          http.StatusServiceUnavailable,
+}
+
+// FieldError describes one invalid field of the input.
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Error is a failed request. Message is shown to users, Err is the cause and is
+// only logged.
+type Error struct {
+	Code    Code
+	Message string
+	Fields  []FieldError
+	// Data is sent along as "data", for details that do not fit Fields.
+	Data interface{}
+	Err  error
+}
+
+func New(code Code, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+func Wrap(code Code, message string, err error) *Error {
+	return &Error{Code: code, Message: message, Err: err}
+}
+
+// Status is the HTTP status of the code, unknown codes are internal errors.
+func (e *Error) Status() int {
+	if status, ok := statuses[e.Code]; ok {
+		return status
+	}
+	return http.StatusInternalServerError
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return string(e.Code) + ": " + e.Message + ": " + e.Err.Error()
+	}
+	return string(e.Code) + ": " + e.Message
+}
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// WithField returns a copy of e with one more invalid field.
+func (e *Error) WithField(field string, code string, message string) *Error {
+	copied := *e
+	copied.Fields = append(append([]FieldError{}, e.Fields...), FieldError{Field: field, Code: code, Message: message})
+	return &copied
+}
+
+// WithData returns a copy of e sending data along.
+func (e *Error) WithData(data interface{}) *Error {
+	copied := *e
+	copied.Data = data
+	return &copied
+}
+
+// From returns err as an *Error, anything else is an internal error that hides its cause.
+func From(err error) *Error {
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+	return Wrap(Internal, "Something went wrong!", err)
+}
+
+// FromBind describes why a json body could not be bound or did not validate.
+func FromBind(err error) *Error {
+	var invalid validator.ValidationErrors
+	if errors.As(err, &invalid) {
+		return fromValidation(invalid)
+	}
+
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		field := typeErr.Field
+		if len(field) == 0 {
+			field = "body"
+		}
+		message := "must be " + describeType(typeErr.Type.Kind().String())
+		return Wrap(ValidationFailed, "Invalid request: "+field+" "+message+"!", err).
+			WithField(field, "invalid_type", message)
+	}
+
+	if errors.Is(err, io.EOF) {
+		return Wrap(InvalidRequest, "Request body is empty!", err)
+	}
+	return Wrap(InvalidRequest, "Request body is not valid json!", err)
+}
+
+// messages describe failed validation tags, %s is the parameter of the tag.
+var messages = map[string]string{
+	"required":    "is required",
+	"min":         "must have at least %s",
+	"max":         "must have at most %s",
+	"len":         "must have exactly %s",
+	"uuid":        "must be a uuid",
+	"url":         "must be a url",
+	"numeric":     "must be a number",
+	"hexadecimal": "must be hexadecimal",
+	"oneof":       "must be one of %s",
+}
+
+// RegisterMessage describes a custom validation tag in field errors.
+func RegisterMessage(tag string, message string) {
+	messages[tag] = message
+}
+
+func fromValidation(invalid validator.ValidationErrors) *Error {
+	apiErr := New(ValidationFailed, "")
+
+	var details []string
+	for _, v := range invalid {
+		message, ok := messages[v.Tag()]
+		if !ok {
+			message = "is invalid"
+		}
+		if strings.Contains(message, "%s") {
+			message = strings.Replace(message, "%s", v.Param()+unit(v), 1)
+		}
+
+		field := fieldPath(v.Namespace())
+		apiErr = apiErr.WithField(field, v.Tag(), message)
+		details = append(details, field+" "+message)
+	}
+
+	apiErr.Message = "Invalid request: " + strings.Join(details, ", ") + "!"
+	return apiErr
+}
+
+// unit is what the length tags count for the kind of the field.
+func unit(v validator.FieldError) string {
+	switch v.Tag() {
+	case "min", "max", "len":
+	default:
+		return ""
+	}
+
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return " items"
+	case reflect.String:
+		return " characters"
+	}
+	return ""
+}
+
+// fieldPath turns the namespace of a failed field into its json path. The first element
+// is the struct and embedded structs keep their Go name, neither is part of the json.
+func fieldPath(namespace string) string {
+	var path []string
+	for _, v := range strings.Split(namespace, ".")[1:] {
+		if len(v) > 0 && v[0] >= 'A' && v[0] <= 'Z' {
+			continue
+		}
+		path = append(path, v)
+	}
+	return strings.Join(path, ".")
+}
+
+func describeType(kind string) string {
+	switch {
+	case strings.HasPrefix(kind, "int"), strings.HasPrefix(kind, "uint"), strings.HasPrefix(kind, "float"):
+		return "a number"
+	case kind == "bool":
+		return "true or false"
+	case kind == "slice", kind == "array":
+		return "a list"
+	case kind == "map", kind == "struct":
+		return "an object"
+	}
+	return "a " + kind
+}
+
+// ProblemContentType is the media type of problem documents.
+const ProblemContentType = "application/problem+json"
+
+// Problem is the RFC 7807 body of an error, Code, RequestId, Errors and Data are extension members.
+type Problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Instance  string       `json:"instance,omitempty"`
+	Code      Code         `json:"code"`
+	RequestId string       `json:"request_id,omitempty"`
+	Errors    []FieldError `json:"errors,omitempty"`
+	Data      interface{}  `json:"data,omitempty"`
+}
+
+// TypeBase prefixes the code in the type of a problem.
+const TypeBase = "urn:ictsss:problem:"
+
+// Problem renders e for the request at instance.
+func (e *Error) Problem(instance string, requestId string) Problem {
+	status := e.Status()
+	return Problem{
+		Type:      TypeBase + string(e.Code),
+		Title:     http.StatusText(status),
+		Status:    status,
+		Detail:    e.Message,
+		Instance:  instance,
+		Code:      e.Code,
+		RequestId: requestId,
+		Errors:    e.Fields,
+		Data:      e.Data,
+	}
+}
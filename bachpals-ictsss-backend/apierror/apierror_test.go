@@ -0,0 +1,232 @@
+package apierror
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-playground/validator/v10"
+)
+
+func TestProblem(t *testing.T) {
+	cause := errors.New("connection refused")
+
+	tests := []struct {
+		name       string
+		err        *Error
+		wantStatus int
+		wantTitle  string
+	}{
+		{"validation", New(ValidationFailed, "Invalid request!"), http.StatusBadRequest, "Bad Request"},
+		{"admin required", New(AdminRequired, "Admin required!"), http.StatusForbidden, "Forbidden"},
+		{"not member", New(NotMember, "Not a member!"), http.StatusNotFound, "Not Found"},
+		{"lease expired", New(LeaseExpired, "Renew the lease!"), http.StatusConflict, "Conflict"},
+		{"openstack", Wrap(OpenStack, "Could not connect to OpenStack!", cause), http.StatusBadGateway, "Bad Gateway"},
+		{"unavailable", New(Unavailable, "Try again later!"), http.StatusServiceUnavailable, "Service Unavailable"},
+		{"unknown code", New("made_up", "Oops!"), http.StatusInternalServerError, "Internal Server Error"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			problem := tt.err.Problem("/api/v1/vms", "request")
+
+			if problem.Status != tt.wantStatus || problem.Title != tt.wantTitle {
+				t.Errorf("Problem() = %d %q, want %d %q", problem.Status, problem.Title, tt.wantStatus, tt.wantTitle)
+			}
+			if problem.Type != TypeBase+string(tt.err.Code) || problem.Code != tt.err.Code {
+				t.Errorf("Problem() type = %q and code %q", problem.Type, problem.Code)
+			}
+			if problem.Detail != tt.err.Message || problem.Instance != "/api/v1/vms" || problem.RequestId != "request" {
+				t.Errorf("Problem() = %+v", problem)
+			}
+		})
+	}
+}
+
+func TestProblemJson(t *testing.T) {
+	tests := []struct {
+		name string
+		err  *Error
+		want string
+	}{
+		{
+			"without details",
+			New(NotFound, "Schedule not found!"),
+			`{"type":"urn:ictsss:problem:not_found","title":"Not Found","status":404,"detail":"Schedule not found!","instance":"/api/v1/schedules/","code":"not_found","request_id":"request"}`,
+		},
+		{
+			"with fields",
+			New(ValidationFailed, "Invalid request!").WithField("name", "required", "is required"),
+			`{"type":"urn:ictsss:problem:validation_failed","title":"Bad Request","status":400,"detail":"Invalid request!","instance":"/api/v1/schedules/","code":"validation_failed","request_id":"request","errors":[{"field":"name","code":"required","message":"is required"}]}`,
+		},
+		{
+			"with data",
+			New(InsufficientCapacity, "Not enough capacity!").WithData(map[string]int{"free": 2}),
+			`{"type":"urn:ictsss:problem:insufficient_capacity","title":"Conflict","status":409,"detail":"Not enough capacity!","instance":"/api/v1/schedules/","code":"insufficient_capacity","request_id":"request","data":{"free":2}}`,
+		},
+		{
+			"the cause is never sent",
+			Wrap(Internal, "Something went wrong!", errors.New("secret dsn")),
+			`{"type":"urn:ictsss:problem:internal_error","title":"Internal Server Error","status":500,"detail":"Something went wrong!","instance":"/api/v1/schedules/","code":"internal_error","request_id":"request"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, err := json.Marshal(tt.err.Problem("/api/v1/schedules/", "request"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(body) != tt.want {
+				t.Errorf("json = %s\nwant %s", body, tt.want)
+			}
+		})
+	}
+}
+
+func TestFrom(t *testing.T) {
+	notFound := New(NotFound, "Schedule not found!")
+
+	tests := []struct {
+		name     string
+		err      error
+		wantCode Code
+		wantMsg  string
+	}{
+		{"api error", notFound, NotFound, "Schedule not found!"},
+		{"wrapped api error", fmt.Errorf("update: %w", notFound), NotFound, "Schedule not found!"},
+		{"plain error", errors.New("secret dsn"), Internal, "Something went wrong!"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := From(tt.err)
+			if got.Code != tt.wantCode || got.Message != tt.wantMsg {
+				t.Errorf("From() = %s %q, want %s %q", got.Code, got.Message, tt.wantCode, tt.wantMsg)
+			}
+		})
+	}
+}
+
+func TestWithFieldCopies(t *testing.T) {
+	base := New(ValidationFailed, "Invalid request!").WithField("name", "required", "is required")
+	extended := base.WithField("url", "url", "must be a url")
+
+	if len(base.Fields) != 1 || len(extended.Fields) != 2 {
+		t.Errorf("WithField() changed the original, it has %d fields and the copy %d", len(base.Fields), len(extended.Fields))
+	}
+}
+
+type Sizing struct {
+	Size int `json:"size" validate:"min=1"`
+}
+
+type bindBody struct {
+	Sizing
+	Name     string   `json:"name" validate:"required,max=8"`
+	Members  []string `json:"members" validate:"min=1"`
+	Settings Sizing   `json:"settings"`
+	Url      string   `json:"url" validate:"omitempty,url"`
+	Color    string   `json:"color" validate:"omitempty,hexcolor"`
+}
+
+// bind decodes body like gin does and validates it with fields named after their json keys.
+func bind(body string) error {
+	var v bindBody
+	if err := json.NewDecoder(strings.NewReader(body)).Decode(&v); err != nil {
+		return err
+	}
+
+	validate := validator.New()
+	validate.RegisterTagNameFunc(func(field reflect.StructField) string {
+		return strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+	})
+	return validate.Struct(v)
+}
+
+func TestFromBind(t *testing.T) {
+	valid := `"name": "vm", "members": ["a"], "size": 1, "settings": {"size": 1}`
+
+	tests := []struct {
+		name       string
+		body       string
+		wantCode   Code
+		wantMsg    string
+		wantFields []FieldError
+	}{
+		{
+			"missing field",
+			`{"members": ["a"], "size": 1, "settings": {"size": 1}}`,
+			ValidationFailed,
+			"Invalid request: name is required!",
+			[]FieldError{{"name", "required", "is required"}},
+		},
+		{
+			"too long string",
+			`{"name": "a-long-name", "members": ["a"], "size": 1, "settings": {"size": 1}}`,
+			ValidationFailed,
+			"Invalid request: name must have at most 8 characters!",
+			[]FieldError{{"name", "max", "must have at most 8 characters"}},
+		},
+		{
+			"empty list",
+			`{"name": "vm", "members": [], "size": 1, "settings": {"size": 1}}`,
+			ValidationFailed,
+			"Invalid request: members must have at least 1 items!",
+			[]FieldError{{"members", "min", "must have at least 1 items"}},
+		},
+		{
+			"nested and embedded fields",
+			`{"name": "vm", "members": ["a"], "size": 0, "settings": {"size": 0}}`,
+			ValidationFailed,
+			"Invalid request: size must have at least 1, settings.size must have at least 1!",
+			[]FieldError{{"size", "min", "must have at least 1"}, {"settings.size", "min", "must have at least 1"}},
+		},
+		{
+			"tag with a message",
+			`{` + valid + `, "url": "nope"}`,
+			ValidationFailed,
+			"Invalid request: url must be a url!",
+			[]FieldError{{"url", "url", "must be a url"}},
+		},
+		{
+			"tag without a message",
+			`{` + valid + `, "color": "nope"}`,
+			ValidationFailed,
+			"Invalid request: color is invalid!",
+			[]FieldError{{"color", "hexcolor", "is invalid"}},
+		},
+		{
+			"wrong type",
+			`{"name": 5}`,
+			ValidationFailed,
+			"Invalid request: name must be a string!",
+			[]FieldError{{"name", "invalid_type", "must be a string"}},
+		},
+		{
+			"wrong type of body",
+			`[]`,
+			ValidationFailed,
+			"Invalid request: body must be an object!",
+			[]FieldError{{"body", "invalid_type", "must be an object"}},
+		},
+		{"empty body", ``, InvalidRequest, "Request body is empty!", nil},
+		{"not json", `{"name": `, InvalidRequest, "Request body is not valid json!", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := FromBind(bind(tt.body))
+			if got.Code != tt.wantCode || got.Message != tt.wantMsg {
+				t.Errorf("FromBind() = %s %q, want %s %q", got.Code, got.Message, tt.wantCode, tt.wantMsg)
+			}
+			if !reflect.DeepEqual(got.Fields, tt.wantFields) {
+				t.Errorf("FromBind() fields = %+v, want %+v", got.Fields, tt.wantFields)
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/services"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/storage"
+)
+
+func init() {
+	register("admins list", "[-o table|json]", "Lists administrators.", adminsList)
+	register("admins add", "USER_ID [--name NAME]", "Adds an administrator.", adminsAdd)
+	register("admins remove", "USER_ID", "Removes an administrator, the last one cannot be removed.", adminsRemove)
+}
+
+func adminsList(ctx context.Context, args []string) error {
+	var out output
+	fs := flag.NewFlagSet("admins list", flag.ContinueOnError)
+	out.bind(fs)
+
+	if _, err := parse(fs, args); err != nil {
+		return err
+	}
+	if err := out.validate(); err != nil {
+		return err
+	}
+
+	admins, err := storage.Admins().ReadAdmins(ctx)
+	if err != nil {
+		return err
+	}
+
+	var rows [][]string
+	for _, v := range admins {
+		rows = append(rows, []string{v.UserId, dash(v.Name)})
+	}
+
+	return out.print(admins, []string{"USER ID", "NAME"}, rows)
+}
+
+func adminsAdd(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("admins add", flag.ContinueOnError)
+	name := fs.String("name", "", "display name of the administrator")
+
+	positional, err := parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return fmt.Errorf("%w: admins add needs exactly one user id", ErrUsage)
+	}
+
+	if err := services.AddAdmin(ctx, positional[0], *name); err != nil {
+		return err
+	}
+
+	fmt.Printf("Added administrator %s\n", positional[0])
+	return nil
+}
+
+func adminsRemove(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("admins remove", flag.ContinueOnError)
+
+	positional, err := parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return fmt.Errorf("%w: admins remove needs exactly one user id", ErrUsage)
+	}
+
+	if err := services.RemoveAdmin(ctx, positional[0]); err != nil {
+		return err
+	}
+
+	fmt.Printf("Removed administrator %s\n", positional[0])
+	return nil
+}
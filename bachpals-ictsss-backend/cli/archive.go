@@ -0,0 +1,123 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/services"
+)
+
+func init() {
+	register("export", "[--file PATH]", "Writes administrators, images and virtual machines as json, to stdout by default.", export)
+	register("import", "[--file PATH] [--map-image OLD=NEW]... [--rebuild] [--dry-run] [-o table|json]", "Validates an export and adds what is not stored yet, reads stdin by default. --rebuild adds missing virtual machines from OpenStack.", importArchive)
+}
+
+// bindImportOptions adds the flags shared by import and restore.
+func bindImportOptions(fs *flag.FlagSet) *services.ImportOptions {
+	options := &services.ImportOptions{ImageIds: map[string]string{}}
+	fs.BoolVar(&options.Rebuild, "rebuild", false, "add records for servers in OpenStack created by this service that are missing")
+	fs.BoolVar(&options.DryRun, "dry-run", false, "only validate the archive")
+	fs.Func("map-image", "replace an OpenStack image id, OLD=NEW", func(value string) error {
+		pair := strings.SplitN(value, "=", 2)
+		if len(pair) != 2 || len(pair[0]) == 0 || len(pair[1]) == 0 {
+			return fmt.Errorf("%q is not OLD=NEW", value)
+		}
+		options.ImageIds[pair[0]] = pair[1]
+		return nil
+	})
+	return options
+}
+
+func printImportResult(out output, result services.ImportResult) error {
+	count := strconv.Itoa
+	rows := [][]string{{count(result.Admins), count(result.Images), count(result.Vms), count(result.Rebuilt), count(result.Templates), count(result.Schedules), count(result.Webhooks), count(result.Skipped)}}
+	return out.print(result, []string{"ADMINS", "IMAGES", "VMS", "REBUILT", "TEMPLATES", "SCHEDULES", "WEBHOOKS", "SKIPPED"}, rows)
+}
+
+// importFailed lists every problem of an invalid archive on its own line.
+func importFailed(err error) error {
+	var invalid *services.ValidationError
+	if errors.As(err, &invalid) {
+		for _, v := range invalid.Problems {
+			fmt.Fprintln(os.Stderr, v)
+		}
+		return fmt.Errorf("archive is invalid, nothing was imported")
+	}
+	return err
+}
+
+func export(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	file := fs.String("file", "", "write the archive to this file instead of stdout")
+
+	if _, err := parse(fs, args); err != nil {
+		return err
+	}
+
+	archive, err := services.Export(ctx)
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if len(*file) > 0 {
+		f, err := os.Create(*file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	if err := services.WriteArchive(w, archive); err != nil {
+		return err
+	}
+
+	if len(*file) > 0 {
+		fmt.Printf("Exported %d administrators, %d images and %d virtual machines to %s\n", len(archive.Admins), len(archive.Images), len(archive.Vms), *file)
+	}
+	return nil
+}
+
+func importArchive(ctx context.Context, args []string) error {
+	var out output
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	file := fs.String("file", "", "read the archive from this file instead of stdin")
+	options := bindImportOptions(fs)
+	out.bind(fs)
+
+	if _, err := parse(fs, args); err != nil {
+		return err
+	}
+	if err := out.validate(); err != nil {
+		return err
+	}
+
+	var r io.Reader = os.Stdin
+	if len(*file) > 0 {
+		f, err := os.Open(*file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	archive, err := services.ReadArchive(r)
+	if err != nil {
+		return err
+	}
+
+	result, err := services.Import(ctx, archive, *options)
+	if err != nil {
+		return importFailed(err)
+	}
+
+	return printImportResult(out, result)
+}
@@ -0,0 +1,70 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/services"
+)
+
+func init() {
+	register("canvas order", "--course ID --image IMAGE_ID --name NAME [--include-teacher] [--include-ta] [--ordered-by USER_ID] [--trim] [-o table|json]", "Creates a virtual machine for every user in a Canvas course, the order is refused when the quota has no room for all of them unless --trim is given.", canvasOrder)
+}
+
+func canvasOrder(ctx context.Context, args []string) error {
+	var out output
+	var order services.CourseOrder
+	fs := flag.NewFlagSet("canvas order", flag.ContinueOnError)
+	fs.StringVar(&order.CourseCode, "course", "", "canvas course id")
+	fs.StringVar(&order.ImageId, "image", "", "openstack image id")
+	fs.StringVar(&order.ServerName, "name", "", "server name prefix, the user name is appended")
+	fs.BoolVar(&order.IncludeTeacher, "include-teacher", false, "also create virtual machines for teachers")
+	fs.BoolVar(&order.IncludeTa, "include-ta", false, "also create virtual machines for teaching assistants")
+	fs.StringVar(&order.OrderedBy, "ordered-by", "", "user id emailed about virtual machines that could not be created")
+	fs.BoolVar(&order.Trim, "trim", false, "create as many virtual machines as the quota has room for")
+	out.bind(fs)
+
+	if _, err := parse(fs, args); err != nil {
+		return err
+	}
+	if err := out.validate(); err != nil {
+		return err
+	}
+	if len(order.CourseCode) == 0 {
+		return fmt.Errorf("%w: canvas order needs --course", ErrUsage)
+	}
+
+	userIds, err := services.CourseUsers(ctx, order)
+	if err != nil {
+		return err
+	}
+
+	plan, err := services.PlanCourse(ctx, &order, userIds)
+	if err != nil {
+		return err
+	}
+
+	results := services.ProvisionCourse(ctx, order, plan.UserIds)
+	for _, v := range plan.Skipped {
+		results = append(results, services.CourseOrderResult{UserId: v, Error: "no room in the quota of target " + plan.Capacity.Target})
+	}
+
+	failed := 0
+	var rows [][]string
+	for _, v := range results {
+		if len(v.Error) > 0 {
+			failed++
+		}
+		rows = append(rows, []string{v.UserId, dash(v.ServerName), dash(v.ServerId), dash(v.ServerIp), dash(v.Error)})
+	}
+
+	if err := out.print(results, []string{"USER ID", "NAME", "SERVER ID", "IP", "ERROR"}, rows); err != nil {
+		return err
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d virtual machines could not be created", failed, len(results))
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+// Package cli implements the administrative commands of the server binary,
+// for example `server vms list -o json`. They call the same services as the
+// http handlers and expect the database and storage backend to be opened.
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// ErrUsage is returned when a command is unknown or called with the wrong arguments.
+var ErrUsage = errors.New("invalid usage")
+
+type command struct {
+	args        string
+	description string
+	run         func(ctx context.Context, args []string) error
+}
+
+// commands maps "group action" (or a single word) to its implementation.
+var commands = map[string]command{}
+
+func register(name string, args string, description string, run func(ctx context.Context, args []string) error) {
+	commands[name] = command{args: args, description: description, run: run}
+}
+
+// IsCommand is true when name is the first word of a command, like "vms" or "export".
+func IsCommand(name string) bool {
+	for k := range commands {
+		if strings.SplitN(k, " ", 2)[0] == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Usage lists the commands with their arguments, the description is on the line below.
+func Usage() string {
+	var names []string
+	for k := range commands {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, v := range names {
+		fmt.Fprintf(&b, "%s %s\n    %s\n", v, commands[v].args, commands[v].description)
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// Run looks up the command in args and runs it with the remaining arguments.
+func Run(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return ErrUsage
+	}
+
+	if c, ok := commands[args[0]]; ok {
+		return c.run(ctx, args[1:])
+	}
+
+	if len(args) > 1 {
+		if c, ok := commands[args[0]+" "+args[1]]; ok {
+			return c.run(ctx, args[2:])
+		}
+	}
+
+	return fmt.Errorf("%w: unknown command %q", ErrUsage, strings.Join(args, " "))
+}
+
+// output is the -o flag every listing command has.
+type output struct {
+	format string
+}
+
+func (o *output) bind(fs *flag.FlagSet) {
+	fs.StringVar(&o.format, "o", "table", "output format, table or json")
+}
+
+func (o *output) validate() error {
+	if o.format != "table" && o.format != "json" {
+		return fmt.Errorf("%w: unknown output format %q", ErrUsage, o.format)
+	}
+	return nil
+}
+
+// print writes v as indented json, or rows under headers as an aligned table.
+func (o *output) print(v interface{}, headers []string, rows [][]string) error {
+	if o.format == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(v)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(headers, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
+
+// parse parses fs and returns the positional arguments, flags may come before or after them.
+func parse(fs *flag.FlagSet, args []string) ([]string, error) {
+	fs.SetOutput(io.Discard)
+
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrUsage, err)
+		}
+
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+func dash(s string) string {
+	if len(s) == 0 {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,77 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strconv"
+
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/services"
+)
+
+func init() {
+	register("floating-ips list", "[-o table|json]", "Lists the floating ips of every OpenStack target as attached, claimed, allocated, orphaned, untracked or missing.", floatingIpsList)
+	register("floating-ips cleanup", "[--dry-run] [-o table|json]", "Keeps orphaned floating ips for reuse or deletes them, forgets missing ones and tracks the ones of virtual machines.", floatingIpsCleanup)
+}
+
+func floatingIpsList(ctx context.Context, args []string) error {
+	var out output
+	fs := flag.NewFlagSet("floating-ips list", flag.ContinueOnError)
+	out.bind(fs)
+
+	if _, err := parse(fs, args); err != nil {
+		return err
+	}
+	if err := out.validate(); err != nil {
+		return err
+	}
+
+	statuses, err := services.FloatingIps(ctx)
+	if err != nil {
+		return err
+	}
+
+	var rows [][]string
+	for _, v := range statuses {
+		rows = append(rows, []string{v.Ip, v.Target, v.State, dash(v.ServerId), dash(v.ServerName), strconv.FormatBool(v.Tracked)})
+	}
+
+	return out.print(statuses, []string{"IP", "TARGET", "STATE", "SERVER ID", "NAME", "TRACKED"}, rows)
+}
+
+func floatingIpsCleanup(ctx context.Context, args []string) error {
+	var out output
+	fs := flag.NewFlagSet("floating-ips cleanup", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "only report what would change")
+	out.bind(fs)
+
+	if _, err := parse(fs, args); err != nil {
+		return err
+	}
+	if err := out.validate(); err != nil {
+		return err
+	}
+
+	results, err := services.CleanupFloatingIps(ctx, *dryRun)
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	var rows [][]string
+	for _, v := range results {
+		if v.Action == services.FloatingIpFailed {
+			failed++
+		}
+		rows = append(rows, []string{v.Ip, v.Target, v.State, v.Action, dash(v.ServerId), dash(v.Error)})
+	}
+
+	if err := out.print(results, []string{"IP", "TARGET", "STATE", "ACTION", "SERVER ID", "ERROR"}, rows); err != nil {
+		return err
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d floating ips could not be cleaned up", failed, len(results))
+	}
+	return nil
+}
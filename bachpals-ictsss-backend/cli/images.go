@@ -0,0 +1,37 @@
+package cli
+
+import (
+	"context"
+	"flag"
+
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/services"
+)
+
+func init() {
+	register("images sync", "[-o table|json]", "Adds OpenStack images missing from the database as unpublished.", imagesSync)
+}
+
+func imagesSync(ctx context.Context, args []string) error {
+	var out output
+	fs := flag.NewFlagSet("images sync", flag.ContinueOnError)
+	out.bind(fs)
+
+	if _, err := parse(fs, args); err != nil {
+		return err
+	}
+	if err := out.validate(); err != nil {
+		return err
+	}
+
+	added, err := services.SyncImages(ctx)
+	if err != nil {
+		return err
+	}
+
+	var rows [][]string
+	for _, v := range added {
+		rows = append(rows, []string{v.ImageId, v.ImageName, v.Published})
+	}
+
+	return out.print(added, []string{"IMAGE ID", "NAME", "PUBLISHED"}, rows)
+}
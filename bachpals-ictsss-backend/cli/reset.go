@@ -0,0 +1,131 @@
+package cli
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/viper"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/services"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/storage"
+)
+
+const defaultBackupDir = "backups"
+
+// errNotConfirmed is returned when the operator did not confirm a reset.
+var errNotConfirmed = errors.New("reset was not confirmed, nothing was changed")
+
+func init() {
+	register("reset", "[--yes] [--backup-dir DIR] [--teardown]", "Backs up, then removes all administrators, virtual machines and the initialization state. --teardown also deletes the OpenStack servers.", reset)
+	register("restore", "--file PATH [--map-image OLD=NEW]... [--rebuild] [--dry-run] [-o table|json]", "Loads a backup or export into the database, records that exist already are kept.", restore)
+}
+
+func backupDir() string {
+	if dir := viper.GetString("IKT_STACK_BACKUP_DIR"); len(dir) > 0 {
+		return dir
+	}
+	return defaultBackupDir
+}
+
+// confirm asks the operator to type the word, it fails when stdin is not a terminal.
+func confirm(prompt string, word string) error {
+	info, err := os.Stdin.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return fmt.Errorf("%w, pass --yes when not running interactively", errNotConfirmed)
+	}
+
+	fmt.Printf("%s\nType %q to continue: ", prompt, word)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	if strings.TrimSpace(answer) != word {
+		return errNotConfirmed
+	}
+	return nil
+}
+
+func reset(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("reset", flag.ContinueOnError)
+	yes := fs.Bool("yes", false, "do not ask for confirmation")
+	dir := fs.String("backup-dir", backupDir(), "directory the backup is written to")
+	teardown := fs.Bool("teardown", false, "also delete the servers and floating ips of all virtual machines in OpenStack")
+
+	if _, err := parse(fs, args); err != nil {
+		return err
+	}
+
+	vms, err := storage.Vms().GetVMS(ctx)
+	if err != nil {
+		return err
+	}
+
+	if !*yes {
+		prompt := fmt.Sprintf("This removes all administrators and %d virtual machines from the database.", len(vms))
+		if *teardown {
+			prompt = fmt.Sprintf("This deletes %d servers in OpenStack and removes all administrators and virtual machines from the database.", len(vms))
+		}
+		if err := confirm(prompt, "reset"); err != nil {
+			return err
+		}
+	}
+
+	// The backup is written first, nothing is touched when it fails.
+	path, err := services.Backup(ctx, *dir)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Backup written to %s\n", path)
+
+	if *teardown {
+		deleted, err := services.Teardown(ctx)
+		fmt.Printf("Deleted %d of %d servers\n", deleted, len(vms))
+		if err != nil {
+			return fmt.Errorf("%w, the database was not reset", err)
+		}
+	}
+
+	if err := storage.AppState().Reset(ctx); err != nil {
+		return fmt.Errorf("could not reset the database, restore %s if data is missing: %w", path, err)
+	}
+
+	fmt.Printf("System has been reinitialized! Run --migrate, then --init or restore --file %s\n", path)
+	return nil
+}
+
+func restore(ctx context.Context, args []string) error {
+	var out output
+	fs := flag.NewFlagSet("restore", flag.ContinueOnError)
+	file := fs.String("file", "", "backup or export to load")
+	options := bindImportOptions(fs)
+	out.bind(fs)
+
+	if _, err := parse(fs, args); err != nil {
+		return err
+	}
+	if err := out.validate(); err != nil {
+		return err
+	}
+	if len(*file) == 0 {
+		return fmt.Errorf("%w: restore needs --file", ErrUsage)
+	}
+
+	f, err := os.Open(*file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	archive, err := services.ReadArchive(f)
+	if err != nil {
+		return err
+	}
+
+	result, err := services.Restore(ctx, archive, *options)
+	if err != nil {
+		return importFailed(err)
+	}
+
+	return printImportResult(out, result)
+}
@@ -0,0 +1,169 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/database"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/services"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/storage"
+)
+
+func init() {
+	register("vms list", "[--course CODE] [--user USER_ID] [-o table|json]", "Lists virtual machines.", vmsList)
+	register("vms delete", "SERVER_ID...", "Deletes virtual machines from OpenStack and the database.", vmsDelete)
+	register("vms adopt", "[--repair] [--dry-run] [-o table|json]", "Recreates missing virtual machine records from OpenStack server metadata, --repair also fixes records that differ.", vmsAdopt)
+	register("vms reconcile", "[SERVER_ID...] [-o table|json]", "Updates the status of virtual machines from OpenStack.", vmsReconcile)
+}
+
+func vmsList(ctx context.Context, args []string) error {
+	var out output
+	fs := flag.NewFlagSet("vms list", flag.ContinueOnError)
+	course := fs.String("course", "", "only virtual machines of this course code")
+	user := fs.String("user", "", "only virtual machines this user owns or is a member of")
+	out.bind(fs)
+
+	if _, err := parse(fs, args); err != nil {
+		return err
+	}
+	if err := out.validate(); err != nil {
+		return err
+	}
+
+	var vms []database.VirtualMachine
+	var err error
+	switch {
+	case len(*course) > 0:
+		vms, err = storage.Vms().GetVMByCourseCode(ctx, *course)
+	case len(*user) > 0:
+		vms, err = storage.Vms().GetVMByUserId(ctx, *user)
+	default:
+		vms, err = storage.Vms().GetVMS(ctx)
+	}
+	if err != nil {
+		return err
+	}
+
+	var rows [][]string
+	for _, v := range vms {
+		rows = append(rows, []string{v.ServerId, v.ServerName, v.ServerStatus, dash(v.ServerIp), v.UserId, dash(strings.Join(v.GroupMembers, ",")), dash(v.CourseCode), dash(v.Target), v.Created.Format(time.RFC3339)})
+	}
+
+	return out.print(vms, []string{"SERVER ID", "NAME", "STATUS", "IP", "OWNER", "MEMBERS", "COURSE", "TARGET", "CREATED"}, rows)
+}
+
+func vmsDelete(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("vms delete", flag.ContinueOnError)
+	ids, err := parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		return fmt.Errorf("%w: vms delete needs at least one server id", ErrUsage)
+	}
+
+	failed := 0
+	for _, id := range ids {
+		vm, err := storage.Vms().GetVMById(ctx, id)
+		if err == nil {
+			err = services.DeleteVm(ctx, vm)
+		}
+		if err != nil {
+			fmt.Printf("Could not delete %s: %s\n", id, err)
+			failed++
+			continue
+		}
+
+		fmt.Printf("Deleted %s (%s)\n", vm.ServerName, vm.ServerId)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d virtual machines could not be deleted", failed, len(ids))
+	}
+	return nil
+}
+
+func vmsReconcile(ctx context.Context, args []string) error {
+	var out output
+	fs := flag.NewFlagSet("vms reconcile", flag.ContinueOnError)
+	out.bind(fs)
+
+	ids, err := parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if err := out.validate(); err != nil {
+		return err
+	}
+
+	var results []services.ReconcileResult
+	if len(ids) == 0 {
+		if results, err = services.ReconcileVms(ctx); err != nil {
+			return err
+		}
+	}
+
+	for _, id := range ids {
+		vm, err := storage.Vms().GetVMById(ctx, id)
+		if err != nil {
+			results = append(results, services.ReconcileResult{ServerId: id, Action: services.ReconcileFailed, Error: err.Error()})
+			continue
+		}
+
+		result, err := services.ReconcileVm(ctx, vm)
+		if err != nil {
+			result.Action = services.ReconcileFailed
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+
+	var rows [][]string
+	for _, v := range results {
+		rows = append(rows, []string{v.ServerId, dash(v.ServerName), v.Action, dash(v.PreviousStatus), dash(v.Status), dash(v.Error)})
+	}
+
+	return out.print(results, []string{"SERVER ID", "NAME", "ACTION", "PREVIOUS", "STATUS", "ERROR"}, rows)
+}
+
+func vmsAdopt(ctx context.Context, args []string) error {
+	var out output
+	var options services.AdoptOptions
+	fs := flag.NewFlagSet("vms adopt", flag.ContinueOnError)
+	fs.BoolVar(&options.Repair, "repair", false, "update records that differ from their server and write missing owner metadata")
+	fs.BoolVar(&options.DryRun, "dry-run", false, "only report what would change")
+	out.bind(fs)
+
+	if _, err := parse(fs, args); err != nil {
+		return err
+	}
+	if err := out.validate(); err != nil {
+		return err
+	}
+
+	results, err := services.AdoptVms(ctx, options)
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	var rows [][]string
+	for _, v := range results {
+		if v.Action == services.AdoptFailed {
+			failed++
+		}
+		rows = append(rows, []string{v.ServerId, v.ServerName, v.Action, dash(strings.Join(v.Changes, ",")), dash(v.Error)})
+	}
+
+	if err := out.print(results, []string{"SERVER ID", "NAME", "ACTION", "CHANGES", "ERROR"}, rows); err != nil {
+		return err
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d servers could not be adopted", failed, len(results))
+	}
+	return nil
+}
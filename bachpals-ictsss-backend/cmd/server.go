@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -14,9 +15,21 @@ import (
 	"github.com/spf13/viper"
 	ginSwagger "github.com/swaggo/gin-swagger"
 	"github.com/swaggo/gin-swagger/swaggerFiles"
-	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/database/repositories"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/cli"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/database"
+	_ "gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/database/repositories"
 	_ "gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/docs"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/gopher"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/leases"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/logging"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/notify"
 	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/router"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/scheduler"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/services"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/storage"
+	_ "gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/storage/sqlstore"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/tracing"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/webhooks"
 )
 
 // @title           ICT-Stack Self-Service API
@@ -35,6 +48,47 @@ import (
 func serve() {
 	gin.SetMode(viper.GetString("IKT_STACK_SERVER_MODE"))
 
+	// The server writes json lines, also for what gin and the standard logger print.
+	level, err := logging.ParseLevel(viper.GetString("IKT_STACK_LOG_LEVEL"))
+	if err != nil {
+		logging.Warn(context.Background(), "using log level info", "error", err)
+	}
+	logging.SetLevel(level)
+	log.SetFlags(0)
+	log.SetOutput(logging.Writer(logging.LevelInfo))
+	gin.DefaultWriter = logging.Writer(logging.LevelDebug)
+	gin.DefaultErrorWriter = logging.Writer(logging.LevelError)
+
+	// A missing credential or an unreadable CA bundle stops the server before it serves.
+	openstack, err := gopher.Configure()
+	if err != nil {
+		logging.Error(context.Background(), "invalid openstack configuration", err)
+		os.Exit(1)
+	}
+	logging.Info(context.Background(), "openstack configuration loaded", "auth", openstack.Method, "cloud", openstack.Cloud, "region", openstack.Endpoint.Region)
+
+	shutdownTracing, err := tracing.Setup(context.Background())
+	if err != nil {
+		logging.Error(context.Background(), "could not set up tracing", err)
+		os.Exit(1)
+	}
+	// Flushes the spans that are left once the server has stopped.
+	defer shutdownTracing(context.Background())
+
+	if states, err := storage.Migrations().Status(context.Background()); err != nil {
+		logging.Error(context.Background(), "could not read migrations", err, "backend", storageBackend())
+	} else {
+		pending := 0
+		for _, v := range states {
+			if v.Applied.IsZero() {
+				pending++
+			}
+		}
+		if pending > 0 {
+			logging.Warn(context.Background(), "migrations are pending, run --migrate before serving", "backend", storageBackend(), "pending", pending)
+		}
+	}
+
 	r := router.Router()
 
 	// Swaggo MUST only run when Mode == "debug"
@@ -47,26 +101,115 @@ func serve() {
 		Handler: r,
 	}
 
+	// Power schedules, the notification outbox, webhook deliveries and lease expiry run next to the http server and stop with it.
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
+	go scheduler.Start(workerCtx)
+	go webhooks.Start(workerCtx)
+
+	if services.LeaseDuration() > 0 {
+		go leases.Start(workerCtx)
+	}
+
+	if viper.GetBool("IKT_STACK_NOTIFICATIONS_ENABLED") {
+		go notify.Start(workerCtx, notify.NewSMTPSender())
+	}
+
 	go func() {
 		// service connections
-		if err := srv.ListenAndServe(); err != nil {
-			log.Printf("listen: %s\n", err)
+		logging.Info(context.Background(), "listening", "addr", srv.Addr)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logging.Error(context.Background(), "could not listen", err, "addr", srv.Addr)
 		}
 	}()
 
 	// Wait for interrupt signal to gracefully shutdown the server with
 	// a timeout of 5 seconds.
-	quit := make(chan os.Signal)
+	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, os.Interrupt)
 	<-quit
-	log.Println("Shutdown Server ...")
+	logging.Info(context.Background(), "shutting down")
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 	if err := srv.Shutdown(ctx); err != nil {
-		log.Fatal("Server Shutdown:", err)
+		logging.Error(ctx, "could not shut down", err)
+	}
+	logging.Info(ctx, "server stopped")
+}
+
+func storageBackend() string {
+	if backend := viper.GetString("IKT_STACK_STORAGE_BACKEND"); len(backend) > 0 {
+		return backend
+	}
+	return storage.DefaultBackend
+}
+
+// isMigrateCommand reports whether arg is one of the commands that take --backend.
+func isMigrateCommand(arg string) bool {
+	return arg == "--migrate" || arg == "--migrate-down" || arg == "--migrate-status"
+}
+
+// backendFlag returns the backend named by --backend NAME in args, the configured one
+// when it is not given.
+func backendFlag(args []string) (string, error) {
+	backend := storageBackend()
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "--backend":
+			if i+1 == len(args) {
+				return "", errors.New("--backend needs a name")
+			}
+			backend = args[i+1]
+			i++
+		case strings.HasPrefix(args[i], "--backend="):
+			backend = strings.TrimPrefix(args[i], "--backend=")
+		default:
+			return "", fmt.Errorf("unknown argument %q", args[i])
+		}
+	}
+
+	if len(backend) == 0 {
+		return "", errors.New("--backend needs a name")
+	}
+	return backend, nil
+}
+
+// initialize adds the default administrator from the config and marks the application as initialized.
+func initialize(ctx context.Context) {
+	userId := viper.GetString("IKT_STACK_DEFAULT_ADMIN")
+
+	_, err := storage.Admins().ReadAdminById(ctx, userId)
+	if err == nil {
+		fmt.Printf("Application has already been initialized!\n")
+		return
+	}
+	if !errors.Is(err, storage.ErrNotFound) {
+		log.Fatal("Error while initializing default administrator: ", err)
+	}
+
+	fmt.Printf("Default administrator doesn't exist! Adding one provided in the config\n")
+
+	if err := storage.Admins().InsertAdmin(ctx, userId, "Default admin"); err != nil {
+		log.Fatal("Error while adding default administrator: ", err)
+	}
+
+	if err := storage.AppState().SetInitialized(ctx); err != nil {
+		log.Fatal("Failed updating initialization state: ", err)
+	}
+
+	fmt.Printf("Added default administrator successfully!\n %s\n", userId)
+}
+
+// runCommand runs an administrative command and exits when it fails.
+func runCommand(ctx context.Context, args []string) {
+	if err := cli.Run(ctx, args); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		if errors.Is(err, cli.ErrUsage) {
+			fmt.Fprintln(os.Stderr, cli.Usage())
+		}
+		os.Exit(1)
 	}
-	log.Println("Server exiting")
 }
 
 func main() {
@@ -92,30 +235,106 @@ func main() {
 
 	if len(os.Args) > 1 {
 		arg := os.Args[1]
+		ctx := context.Background()
+
+		// Every command except --help talks to the storage backend, only the mongo
+		// backend needs the client to IKT_STACK_DB_URL.
+		if arg != "--help" {
+			backend := storageBackend()
+			if isMigrateCommand(arg) {
+				if backend, err = backendFlag(os.Args[2:]); err != nil {
+					log.Fatal(err)
+				}
+			}
+
+			if backend == storage.DefaultBackend {
+				if err := database.Connect(ctx); err != nil {
+					log.Fatal("Could not connect to database: ", err)
+				}
+				defer database.Disconnect(ctx)
+			}
+
+			if err := storage.Open(ctx, backend, viper.GetString("IKT_STACK_STORAGE_DSN")); err != nil {
+				log.Fatal("Could not open storage: ", err)
+			}
+			defer storage.Close(ctx)
+		}
+
+		if cli.IsCommand(arg) {
+			runCommand(ctx, os.Args[1:])
+			return
+		}
 
 		if arg == "--reset" {
-			repositories.RemoveInitializationStatus()
-			os.Exit(0)
+			// Takes the flags of the reset command, a backup is always written before anything is removed.
+			runCommand(ctx, append([]string{"reset"}, os.Args[2:]...))
+			return
 		}
 
 		if arg == "--help" {
 			fmt.Println(`Available commands
---reset    Removes all administrators and resets default settings in database.
---init     Initializes administrators and default settings in database.
---serve    Starts the http server.
---help     Shows this page.`)
+--reset          Backs up, then removes all administrators and virtual machines from the database.
+                 Asks for confirmation unless --yes is given, --teardown also deletes the OpenStack servers.
+--init           Initializes administrators and default settings in database.
+--migrate        Applies all pending database migrations.
+--migrate-down   Reverts the newest applied database migration.
+--migrate-status Lists database migrations and when they were applied.
+                 The migrate commands take --backend NAME to migrate another storage backend than
+                 IKT_STACK_STORAGE_BACKEND, with its IKT_STACK_STORAGE_DSN.
+--serve          Starts the http server.
+--help           Shows this page.
+
+Administrative commands`)
+			fmt.Println(cli.Usage())
 			os.Exit(0)
 		}
 
+		if arg == "--migrate" {
+			applied, err := storage.Migrations().Up(ctx)
+			for _, v := range applied {
+				fmt.Printf("Applied migration %d: %s\n", v.Version, v.Description)
+			}
+			if err != nil {
+				log.Fatal("Migration failed: ", err)
+			}
+			fmt.Printf("Database is up to date!\n")
+			return
+		}
+
+		if arg == "--migrate-down" {
+			reverted, err := storage.Migrations().Down(ctx)
+			if err != nil {
+				log.Fatal("Migration failed: ", err)
+			}
+			if reverted == nil {
+				fmt.Printf("No migrations to revert!\n")
+			} else {
+				fmt.Printf("Reverted migration %d: %s\n", reverted.Version, reverted.Description)
+			}
+			return
+		}
+
+		if arg == "--migrate-status" {
+			states, err := storage.Migrations().Status(ctx)
+			if err != nil {
+				log.Fatal("Could not read migrations: ", err)
+			}
+			for _, v := range states {
+				applied := "pending"
+				if !v.Applied.IsZero() {
+					applied = v.Applied.Format(time.RFC3339)
+				}
+				fmt.Printf("%3d  %-25s  %s\n", v.Version, applied, v.Description)
+			}
+			return
+		}
+
 		if arg == "--serve" {
 			serve()
 		}
 
 		if arg == "--init" {
-			// If application is not initialized, add default administrator.
-			if repositories.CheckInitializationStatus() {
-				repositories.InitializeDefaultAdministrator()
-			}
+			initialize(ctx)
 		}
 	}
 }
@@ -3,8 +3,12 @@ package database
 import (
     "context"
     "github.com/spf13/viper"
+    "gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/metrics"
+    "gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/tracing"
+    "go.mongodb.org/mongo-driver/event"
     "go.mongodb.org/mongo-driver/mongo"
     "go.mongodb.org/mongo-driver/mongo/options"
+    "go.mongodb.org/mongo-driver/mongo/readpref"
     "log"
     "time"
 )
@@ -14,23 +18,100 @@ const VmCollection = "virtual_machines"
 const AdminCollection = "administrators"
 const ServerCollection = "server"
 const ImagesCollection = "images"
+const SchedulesCollection = "schedules"
+const ScheduleRunsCollection = "schedule_runs"
+const NotificationsCollection = "notification_outbox"
+const NotificationPreferencesCollection = "notification_preferences"
+const WebhooksCollection = "webhooks"
+const WebhookDeliveriesCollection = "webhook_deliveries"
+const FloatingIpsCollection = "floating_ips"
+const SchemaMigrationsCollection = "schema_migrations"
+const SchemaMigrationsLockCollection = "schema_migrations_lock"
 
 type MongoHandler struct {
     Mongo *mongo.Collection
 }
 
-func GetClient() (*mongo.Client, context.Context, context.CancelFunc) {
-    client, err := mongo.NewClient(options.Client().ApplyURI(viper.GetString("IKT_STACK_DB_URL")))
-    if err != nil {
-        log.Fatal(err)
+var client *mongo.Client
+
+// Connect creates the mongo client shared by every repository.
+// It is called once at startup, the driver pools connections behind it.
+func Connect(ctx context.Context) error {
+    opts := options.Client().
+        ApplyURI(viper.GetString("IKT_STACK_DB_URL")).
+        SetConnectTimeout(10 * time.Second).
+        SetServerSelectionTimeout(10 * time.Second).
+        SetMonitor(monitors(metrics.CommandMonitor(), tracing.CommandMonitor()))
+
+    if poolSize := viper.GetUint64("IKT_STACK_DB_MAX_POOL_SIZE"); poolSize > 0 {
+        opts.SetMaxPoolSize(poolSize)
     }
 
-    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-    err = client.Connect(ctx)
+    c, err := mongo.Connect(ctx, opts)
     if err != nil {
-        defer cancel()
-        log.Fatal(err)
+        return err
     }
 
-    return client, ctx, cancel
+    if err := c.Ping(ctx, nil); err != nil {
+        c.Disconnect(ctx)
+        return err
+    }
+
+    client = c
+    return nil
+}
+
+// monitors passes the command events to every monitor, a client takes only one.
+func monitors(list ...*event.CommandMonitor) *event.CommandMonitor {
+    return &event.CommandMonitor{
+        Started: func(ctx context.Context, e *event.CommandStartedEvent) {
+            for _, v := range list {
+                v.Started(ctx, e)
+            }
+        },
+        Succeeded: func(ctx context.Context, e *event.CommandSucceededEvent) {
+            for _, v := range list {
+                v.Succeeded(ctx, e)
+            }
+        },
+        Failed: func(ctx context.Context, e *event.CommandFailedEvent) {
+            for _, v := range list {
+                v.Failed(ctx, e)
+            }
+        },
+    }
+}
+
+// Ping checks that the primary of the replica set answers, for the health checks.
+func Ping(ctx context.Context) error {
+    return Client().Ping(ctx, readpref.Primary())
+}
+
+// Disconnect closes the shared client, called on shutdown.
+func Disconnect(ctx context.Context) error {
+    if client == nil {
+        return nil
+    }
+    return client.Disconnect(ctx)
+}
+
+// Client returns the shared client, Connect must have been called first.
+func Client() *mongo.Client {
+    if client == nil {
+        log.Fatal("Database client used before database.Connect was called!")
+    }
+    return client
+}
+
+// Name is the database in IKT_STACK_DB_NAME, DefaultDB when it is not set.
+func Name() string {
+    if name := viper.GetString("IKT_STACK_DB_NAME"); len(name) > 0 {
+        return name
+    }
+    return DefaultDB
+}
+
+// Collection returns a collection in the database named by Name.
+func Collection(name string) *mongo.Collection {
+    return Client().Database(Name()).Collection(name)
 }
\ No newline at end of file
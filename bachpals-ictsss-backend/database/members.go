@@ -0,0 +1,43 @@
+package database
+
+import (
+    "strings"
+)
+
+// MembersFromUserIds gives the first user the owner role and everyone else the member role.
+func MembersFromUserIds(userIds []string) []VirtualMachineMember {
+    var members []VirtualMachineMember
+    for _, v := range userIds {
+        if len(v) == 0 {
+            continue
+        }
+
+        role := VirtualMachineRoleMember
+        if len(members) == 0 {
+            role = VirtualMachineRoleOwner
+        }
+
+        members = append(members, VirtualMachineMember{UserId: v, Role: role})
+    }
+
+    return members
+}
+
+// FillMembers sets the owner and group member names the api has always returned.
+func FillMembers(vm *VirtualMachine) {
+    vm.GroupMembers = []string{}
+    for _, v := range vm.Members {
+        if v.Role == VirtualMachineRoleOwner {
+            vm.UserId = v.UserId
+        }
+
+        userName := ""
+        if strings.Contains(v.UserId, "@uia.no") {
+            userName = strings.Replace(v.UserId, "@uia.no", "", -1)
+        } else if strings.Contains(v.UserId, "@student.uia.no") {
+            userName = strings.Replace(v.UserId, "@student.uia.no", "", -1)
+        }
+
+        vm.GroupMembers = append(vm.GroupMembers, userName)
+    }
+}
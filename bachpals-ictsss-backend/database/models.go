@@ -9,16 +9,48 @@ const VirtualMachineStatusActive = "ACTIVE"
 
 const ServerStatusPollingTime = 300 // Seconds
 
+const ScheduleTargetVm = "vm"
+const ScheduleTargetUser = "user"
+const ScheduleTargetCourse = "course"
+
+const ScheduleActionStart = "start"
+const ScheduleActionStop = "stop"
+
+const ScheduleResultOk = "ok"
+const ScheduleResultSkipped = "skipped"
+const ScheduleResultFailed = "failed"
+
+const VirtualMachineRoleOwner = "owner"
+const VirtualMachineRoleMember = "member"
+
+const VirtualMachineLeaseExpiring = "expiring"
+const VirtualMachineLeaseExpired = "expired"
+
+// VirtualMachine is stored as one document per server, the users with access are in Members.
+// UserId (the owner) and GroupMembers (user names without domain) are filled in when reading.
+// Target is the OpenStack target the server runs in, empty for the default one.
+// LeaseExpires is zero for virtual machines without a lease, LeaseNotice is the last lease
+// notice the members got and is empty again after the lease was renewed.
 type VirtualMachine struct {
-    ServerIp     string    `bson:"server_ip"`
-    ServerImage  string    `bson:"server_image"`
-    ServerName   string    `bson:"server_name"`
-    ServerStatus string    `bson:"server_status"`
-    UserId       string    `bson:"user_id"`
-    ServerId     string    `bson:"server_id"`
-    Created      time.Time `bson:"created"`
-    GroupMembers []string  `bson:"group_members"`
-    VirtualMachineImageMeta
+    ServerIp     string                 `bson:"server_ip"`
+    ServerImage  string                 `bson:"server_image"`
+    ServerName   string                 `bson:"server_name"`
+    ServerStatus string                 `bson:"server_status"`
+    UserId       string                 `bson:"-"`
+    ServerId     string                 `bson:"server_id"`
+    Created      time.Time              `bson:"created"`
+    Members      []VirtualMachineMember `bson:"members"`
+    GroupMembers []string               `bson:"-"`
+    CourseCode   string                 `bson:"course_code"`
+    Target       string                 `bson:"target"`
+    LeaseExpires time.Time              `bson:"lease_expires"`
+    LeaseNotice  string                 `bson:"lease_notice"`
+    VirtualMachineImageMeta `bson:",inline"`
+}
+
+type VirtualMachineMember struct {
+    UserId string `bson:"user_id"`
+    Role   string `bson:"role"`
 }
 
 type VirtualMachineImageMeta struct {
@@ -26,6 +58,18 @@ type VirtualMachineImageMeta struct {
     ImageDisplayName      string `bson:"image_display_name"`
 }
 
+// FloatingIp is a floating ip the service allocated in a target, Id is its OpenStack id.
+// ServerId is empty while the ip is free and kept for the next virtual machine.
+type FloatingIp struct {
+    Id        string    `bson:"_id"`
+    Ip        string    `bson:"ip"`
+    Target    string    `bson:"target"`
+    Pool      string    `bson:"pool"`
+    ServerId  string    `bson:"server_id"`
+    Allocated time.Time `bson:"allocated"`
+    Updated   time.Time `bson:"updated"`
+}
+
 type Admin struct {
     UserId string `bson:"user_id"`
     Name   string `bson:"name"`
@@ -44,4 +88,105 @@ type Images struct {
     ImageDisplayName      string `bson:"image_display_name"`
     ImageConfig           string `bson:"image_config"`
     ImageReadRootPassword bool   `bson:"image_read_root_password"`
+}
+// Schedule describes when the virtual machines of a target should be running.
+// StartCron and StopCron are standard five field cron expressions, windows are
+// calendar ranges formatted as ScheduleWindowLayout. Both are evaluated in TimeZone.
+type Schedule struct {
+    Id         string           `bson:"_id"`
+    Name       string           `bson:"name"`
+    TargetType string           `bson:"target_type"`
+    TargetId   string           `bson:"target_id"`
+    TimeZone   string           `bson:"time_zone"`
+    StartCron  string           `bson:"start_cron"`
+    StopCron   string           `bson:"stop_cron"`
+    Windows    []ScheduleWindow `bson:"windows"`
+    Enabled    bool             `bson:"enabled"`
+    CreatedBy  string           `bson:"created_by"`
+    LastRun    time.Time        `bson:"last_run"`
+}
+
+const ScheduleWindowLayout = "2006-01-02T15:04"
+
+type ScheduleWindow struct {
+    Start string `bson:"start"`
+    End   string `bson:"end"`
+}
+
+type ScheduleRun struct {
+    Id         string              `bson:"_id,omitempty"`
+    ScheduleId string              `bson:"schedule_id"`
+    Action     string              `bson:"action"`
+    Trigger    string              `bson:"trigger"`
+    Started    time.Time           `bson:"started"`
+    Finished   time.Time           `bson:"finished"`
+    Succeeded  int                 `bson:"succeeded"`
+    Skipped    int                 `bson:"skipped"`
+    Failed     int                 `bson:"failed"`
+    Results    []ScheduleRunResult `bson:"results"`
+}
+
+type ScheduleRunResult struct {
+    ServerId   string `bson:"server_id"`
+    ServerName string `bson:"server_name"`
+    Result     string `bson:"result"`
+    Error      string `bson:"error"`
+}
+
+const NotificationStatusPending = "pending"
+const NotificationStatusSending = "sending"
+const NotificationStatusSent = "sent"
+const NotificationStatusFailed = "failed"
+
+// Notification is an email waiting in, or delivered from, the outbox.
+type Notification struct {
+    Id          string    `bson:"_id,omitempty"`
+    UserId      string    `bson:"user_id"`
+    Event       string    `bson:"event"`
+    Subject     string    `bson:"subject"`
+    Text        string    `bson:"text"`
+    Html        string    `bson:"html"`
+    Status      string    `bson:"status"`
+    Attempts    int       `bson:"attempts"`
+    LastError   string    `bson:"last_error"`
+    NextAttempt time.Time `bson:"next_attempt"`
+    Created     time.Time `bson:"created"`
+    Sent        time.Time `bson:"sent"`
+}
+
+type NotificationPreferences struct {
+    UserId   string   `bson:"user_id"`
+    Disabled bool     `bson:"disabled"`
+    OptOut   []string `bson:"opt_out"`
+}
+
+const WebhookDeliveryPending = "pending"
+const WebhookDeliverySending = "sending"
+const WebhookDeliveryDelivered = "delivered"
+const WebhookDeliveryDead = "dead"
+
+// WebhookSubscription receives the events listed in Events, or every event if it is empty.
+type WebhookSubscription struct {
+    Id          string    `bson:"_id"`
+    Url         string    `bson:"url"`
+    Secret      string    `bson:"secret"`
+    Description string    `bson:"description"`
+    Events      []string  `bson:"events"`
+    Enabled     bool      `bson:"enabled"`
+    CreatedBy   string    `bson:"created_by"`
+    Created     time.Time `bson:"created"`
+}
+
+type WebhookDelivery struct {
+    Id             string    `bson:"_id,omitempty"`
+    SubscriptionId string    `bson:"subscription_id"`
+    Event          string    `bson:"event"`
+    Payload        string    `bson:"payload"`
+    Status         string    `bson:"status"`
+    Attempts       int       `bson:"attempts"`
+    LastError      string    `bson:"last_error"`
+    LastStatusCode int       `bson:"last_status_code"`
+    NextAttempt    time.Time `bson:"next_attempt"`
+    Created        time.Time `bson:"created"`
+    Delivered      time.Time `bson:"delivered"`
 }
\ No newline at end of file
@@ -1,146 +1,107 @@
 package repositories
 
 import (
-    "fmt"
+    "context"
     "gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/database"
+    "gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/storage"
+    "gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/tracing"
     "go.mongodb.org/mongo-driver/bson"
-    "log"
+    "go.mongodb.org/mongo-driver/mongo"
 )
 
-func InsertAdmin(userId string, name string) interface{} {
-    if len(userId) == 0 {
-        return nil
-    }
+// AdminRepository stores administrators in MongoDB.
+type AdminRepository struct{}
 
-    if len(name) == 0 {
-        return nil
-    }
+func (AdminRepository) InsertAdmin(ctx context.Context, userId string, name string) error {
+    ctx, span := tracing.Start(ctx, "repositories.AdminRepository.InsertAdmin")
+    defer span.End()
 
     insertData := bson.D{{Key: "user_id", Value: userId}, {Key: "name", Value: name}}
 
-    db, context, cancel := database.GetClient()
-    collection := db.Database(database.DefaultDB).Collection(database.AdminCollection)
-    inserted, err := collection.InsertOne(context, insertData)
-
-    if err != nil {
-        defer db.Disconnect(context)
-        defer cancel()
-        return nil
-    }
+    collection := database.Collection(database.AdminCollection)
+    _, err := collection.InsertOne(ctx, insertData)
 
-    defer db.Disconnect(context)
-    defer cancel()
-
-    return inserted
+    return err
 }
 
-func ReadAdmins() []*database.Admin {
-    db, context, cancel := database.GetClient()
-    collection := db.Database(database.DefaultDB).Collection(database.AdminCollection)
-
-    filter := bson.D{}
+func (AdminRepository) ReadAdmins(ctx context.Context) ([]*database.Admin, error) {
+    ctx, span := tracing.Start(ctx, "repositories.AdminRepository.ReadAdmins")
+    defer span.End()
 
-    var userData []*database.Admin
-    cursor, err := collection.Find(context, filter)
+    collection := database.Collection(database.AdminCollection)
 
+    cursor, err := collection.Find(ctx, bson.D{})
     if err != nil {
-        defer cancel()
-        defer db.Disconnect(context)
-        return nil
+        return nil, err
     }
+    defer cursor.Close(ctx)
 
-    for cursor.Next(context) {
+    userData := []*database.Admin{}
+    for cursor.Next(ctx) {
         var elem database.Admin
-        err := cursor.Decode(&elem)
-        if err != nil {
-            fmt.Println("An error occurred while reading data!")
-            defer cancel()
-            defer db.Disconnect(context)
-            defer cursor.Close(context)
-            return nil
+        if err := cursor.Decode(&elem); err != nil {
+            return nil, err
         }
 
         userData = append(userData, &elem)
     }
 
-    defer cancel()
-    defer db.Disconnect(context)
-    defer cursor.Close(context)
-
-    return userData
+    return userData, cursor.Err()
 }
 
-func ReadAdminById(userId string) interface{} {
-    if len(userId) == 0 {
-        return nil
-    }
+func (AdminRepository) ReadAdminById(ctx context.Context, userId string) (*database.Admin, error) {
+    ctx, span := tracing.Start(ctx, "repositories.AdminRepository.ReadAdminById")
+    defer span.End()
 
-    db, context, cancel := database.GetClient()
-    collection := db.Database(database.DefaultDB).Collection(database.AdminCollection)
+    collection := database.Collection(database.AdminCollection)
 
     filter := bson.M{"user_id": bson.M{"$eq": userId}}
 
     var userData database.Admin
-    err := collection.FindOne(context, filter).Decode(&userData)
+    err := collection.FindOne(ctx, filter).Decode(&userData)
 
+    if err == mongo.ErrNoDocuments {
+        return nil, storage.ErrNotFound
+    }
     if err != nil {
-        defer cancel()
-        defer db.Disconnect(context)
-        return nil
+        return nil, err
     }
 
-    defer cancel()
-    defer db.Disconnect(context)
-
-    return userData
+    return &userData, nil
 }
 
-func DeleteAdminById(userId string) interface{} {
-    if len(userId) == 0 {
-        return nil
-    }
+func (AdminRepository) DeleteAdminById(ctx context.Context, userId string) (int, error) {
+    ctx, span := tracing.Start(ctx, "repositories.AdminRepository.DeleteAdminById")
+    defer span.End()
 
-    db, context, cancel := database.GetClient()
-    collection := db.Database(database.DefaultDB).Collection(database.AdminCollection)
+    collection := database.Collection(database.AdminCollection)
 
     filter := bson.M{"user_id": userId}
-    res, err := collection.DeleteOne(context, filter)
+    res, err := collection.DeleteOne(ctx, filter)
 
     if err != nil {
-        defer cancel()
-        defer db.Disconnect(context)
-        return nil
+        return 0, err
     }
 
-    defer cancel()
-    defer db.Disconnect(context)
-
-    return int(res.DeletedCount)
+    return int(res.DeletedCount), nil
 }
 
-func UpdateAdminById(id string, newId string, name string) bool {
-    if len(id) == 0 {
-        return false
-    }
+func (AdminRepository) UpdateAdminById(ctx context.Context, userId string, newUserId string, name string) error {
+    ctx, span := tracing.Start(ctx, "repositories.AdminRepository.UpdateAdminById")
+    defer span.End()
 
-    log.Println(bson.M{"$set": bson.M{"user_id": newId, "name": name}})
-
-    findFilter := bson.M{"user_id": id}
-    updateFilter := bson.M{"$set": bson.M{"user_id": newId, "name": name}}
-
-    db, context, cancel := database.GetClient()
-    vms := db.Database(database.DefaultDB).Collection(database.AdminCollection)
-    _, err := vms.UpdateOne(context, findFilter, updateFilter)
+    findFilter := bson.M{"user_id": userId}
+    updateFilter := bson.M{"$set": bson.M{"user_id": newUserId, "name": name}}
 
+    collection := database.Collection(database.AdminCollection)
+    res, err := collection.UpdateOne(ctx, findFilter, updateFilter)
     if err != nil {
-        log.Println("Result: ", err)
-        defer cancel()
-        defer db.Disconnect(context)
-        return false
+        return err
     }
 
-    defer cancel()
-    defer db.Disconnect(context)
+    if res.MatchedCount == 0 {
+        return storage.ErrNotFound
+    }
 
-    return true
-}
\ No newline at end of file
+    return nil
+}
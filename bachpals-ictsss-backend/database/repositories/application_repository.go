@@ -1,151 +1,67 @@
 package repositories
 
 import (
-    "fmt"
-    "github.com/spf13/viper"
+    "context"
     "gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/database"
+    "gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/tracing"
     "go.mongodb.org/mongo-driver/bson"
     "go.mongodb.org/mongo-driver/mongo"
-    "os"
+    "go.mongodb.org/mongo-driver/mongo/options"
 )
 
-func InitializeDefaultAdministrator() interface{} {
-    db, context, cancel := database.GetClient()
-    collection := db.Database(database.DefaultDB).Collection(database.AdminCollection)
+// ApplicationRepository keeps the initialization state in the server collection.
+type ApplicationRepository struct{}
 
-    filter := bson.D{{"user_id", viper.GetString("IKT_STACK_DEFAULT_ADMIN")}}
-
-    var defaultAdmin database.Admin
-    if err := collection.FindOne(context, filter).Decode(&defaultAdmin); err != nil {
-        if err == mongo.ErrNoDocuments {
-            defer cancel()
-            defer db.Disconnect(context)
-            fmt.Printf("Default administrator doesn't exist! Adding one provided in the config\n")
-
-            insertData := bson.D{{Key: "user_id", Value: viper.GetString("IKT_STACK_DEFAULT_ADMIN")}, {Key: "name", Value: "Default admin"}}
-
-            collection := db.Database(database.DefaultDB).Collection(database.AdminCollection)
-            inserted, insertErr := collection.InsertOne(context, insertData)
-
-            if insertErr != nil {
-                defer cancel()
-                defer db.Disconnect(context)
-                fmt.Printf("Error while adding default administrator!\n %+v\n", insertErr)
-                os.Exit(0)
-            }
-
-            if inserted.InsertedID != nil {
-                defer cancel()
-                defer db.Disconnect(context)
-
-                collection := db.Database(database.DefaultDB).Collection(database.ServerCollection)
-                filter := bson.D{{"first_run", 1}}
-                inserted, err := collection.InsertOne(context, filter)
+// True if server is already initialized, false if not.
+func (ApplicationRepository) IsInitialized(ctx context.Context) (bool, error) {
+    ctx, span := tracing.Start(ctx, "repositories.ApplicationRepository.IsInitialized")
+    defer span.End()
 
-                if err != nil {
-                    defer cancel()
-                    defer db.Disconnect(context)
-                    fmt.Printf("Failed updating initialization state!\n %+v\n", err)
-                    return nil
-                }
+    collection := database.Collection(database.ServerCollection)
 
-                if inserted.InsertedID != nil {
-                    defer cancel()
-                    defer db.Disconnect(context)
-                    fmt.Printf("Added default administrator successfully!\n %s\n", viper.GetString("IKT_STACK_DEFAULT_ADMIN"))
-                    return nil
-                }
-            }
-        }
+    var server database.Application
+    err := collection.FindOne(ctx, bson.D{{Key: "first_run", Value: 1}}).Decode(&server)
 
-        defer cancel()
-        defer db.Disconnect(context)
-        fmt.Printf("Error while initializing default administrator!\n %+v\n", err)
-        os.Exit(0)
+    if err == mongo.ErrNoDocuments {
+        return false, nil
     }
-
-    if defaultAdmin.UserId != "" {
-        fmt.Printf("Application has already been initialized!\n")
+    if err != nil {
+        return false, err
     }
 
-    return nil
+    return server.FirstRun == 1, nil
 }
 
-// True if server is already initialized, false if not.
-func CheckInitializationStatus() bool {
-    db, context, cancel := database.GetClient()
-    collection := db.Database(database.DefaultDB).Collection(database.ServerCollection)
+func (ApplicationRepository) SetInitialized(ctx context.Context) error {
+    ctx, span := tracing.Start(ctx, "repositories.ApplicationRepository.SetInitialized")
+    defer span.End()
 
     filter := bson.D{{Key: "first_run", Value: 1}}
 
-    var server database.Application
-    err := collection.FindOne(context, filter).Decode(&server)
-
-    if err != nil {
-        defer cancel()
-        defer db.Disconnect(context)
-
-        if err == mongo.ErrNoDocuments {
-            fmt.Printf("No initialization status document in database! Creating document!\n %+v\n", err)
-
-            inserted, insertErr := collection.InsertOne(context, bson.D{{Key: "first_run", Value: 1}})
-
-            if insertErr != nil {
-                fmt.Printf("Error while creating initialization document!\n %+v\n", insertErr)
-                defer cancel()
-                defer db.Disconnect(context)
-                os.Exit(0)
-            }
-
-            if inserted.InsertedID != "" {
-                defer cancel()
-                defer db.Disconnect(context)
-
-                fmt.Printf("Successfully created initialization document!\n %s\n", inserted.InsertedID)
-                return true
-            }
-        } else {
-            defer cancel()
-            defer db.Disconnect(context)
-            fmt.Printf("Error while checking server initialization status!\n", err)
-            os.Exit(0)
-        }
-    }
+    collection := database.Collection(database.ServerCollection)
+    _, err := collection.UpdateOne(ctx, filter, bson.M{"$set": filter}, options.Update().SetUpsert(true))
 
-    if server.FirstRun != 1 {
-        defer cancel()
-        defer db.Disconnect(context)
-        return false
-    }
-    return true
+    return err
 }
 
-func RemoveInitializationStatus() {
-    db, context, cancel := database.GetClient()
-    if err := db.Database(database.DefaultDB).Collection(database.ServerCollection).Drop(context); err != nil {
-        fmt.Printf("Could not drop sever collection!\n")
-        defer cancel()
-        defer db.Disconnect(context)
-        os.Exit(0)
-    }
-
-    if err := db.Database(database.DefaultDB).Collection(database.AdminCollection).Drop(context); err != nil {
-        fmt.Printf("Could not drop administrators collection!\n")
-        defer cancel()
-        defer db.Disconnect(context)
-        os.Exit(0)
+func (ApplicationRepository) Reset(ctx context.Context) error {
+    ctx, span := tracing.Start(ctx, "repositories.ApplicationRepository.Reset")
+    defer span.End()
+
+    collections := []string{
+        database.ServerCollection,
+        database.AdminCollection,
+        database.VmCollection,
+        database.FloatingIpsCollection,
+        // Dropping collections removes their indexes, forget the applied migrations so --migrate creates them again.
+        database.SchemaMigrationsCollection,
     }
 
-    if err := db.Database(database.DefaultDB).Collection(database.VmCollection).Drop(context); err != nil {
-        fmt.Printf("Could not drop vm collection!\n")
-        defer cancel()
-        defer db.Disconnect(context)
-        os.Exit(0)
+    for _, v := range collections {
+        if err := database.Collection(v).Drop(ctx); err != nil {
+            return err
+        }
     }
 
-    defer cancel()
-    defer db.Disconnect(context)
-
-    fmt.Printf("System has been reinitialized!\n")
-    os.Exit(0)
-}
\ No newline at end of file
+    return nil
+}
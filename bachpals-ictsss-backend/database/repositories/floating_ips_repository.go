@@ -0,0 +1,79 @@
+package repositories
+
+import (
+    "context"
+    "gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/database"
+    "gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/storage"
+    "gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/tracing"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
+    "time"
+)
+
+// FloatingIpRepository stores the floating ips allocated by the service in MongoDB,
+// one document per ip with the OpenStack id as _id.
+type FloatingIpRepository struct{}
+
+func (FloatingIpRepository) SaveFloatingIp(ctx context.Context, ip database.FloatingIp) error {
+    ctx, span := tracing.Start(ctx, "repositories.FloatingIpRepository.SaveFloatingIp")
+    defer span.End()
+
+    collection := database.Collection(database.FloatingIpsCollection)
+    _, err := collection.ReplaceOne(ctx, bson.M{"_id": ip.Id}, ip, options.Replace().SetUpsert(true))
+
+    return err
+}
+
+func (FloatingIpRepository) GetFloatingIps(ctx context.Context) ([]database.FloatingIp, error) {
+    ctx, span := tracing.Start(ctx, "repositories.FloatingIpRepository.GetFloatingIps")
+    defer span.End()
+
+    collection := database.Collection(database.FloatingIpsCollection)
+    cursor, err := collection.Find(ctx, bson.D{}, options.Find().SetSort(bson.D{{Key: "target", Value: 1}, {Key: "ip", Value: 1}}))
+    if err != nil {
+        return nil, err
+    }
+    defer cursor.Close(ctx)
+
+    ips := []database.FloatingIp{}
+    for cursor.Next(ctx) {
+        var elem database.FloatingIp
+        if err := cursor.Decode(&elem); err != nil {
+            return nil, err
+        }
+
+        ips = append(ips, elem)
+    }
+
+    return ips, cursor.Err()
+}
+
+func (FloatingIpRepository) ClaimFloatingIp(ctx context.Context, target string, serverId string) (database.FloatingIp, error) {
+    ctx, span := tracing.Start(ctx, "repositories.FloatingIpRepository.ClaimFloatingIp")
+    defer span.End()
+
+    // The oldest free ip is taken, a single update so a second claim sees it taken.
+    filter := bson.M{"target": target, "server_id": ""}
+    update := bson.M{"$set": bson.M{"server_id": serverId, "updated": time.Now()}}
+    opts := options.FindOneAndUpdate().SetSort(bson.D{{Key: "updated", Value: 1}}).SetReturnDocument(options.After)
+
+    var ip database.FloatingIp
+    collection := database.Collection(database.FloatingIpsCollection)
+    err := collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&ip)
+
+    if err == mongo.ErrNoDocuments {
+        return ip, storage.ErrNotFound
+    }
+    return ip, err
+}
+
+func (FloatingIpRepository) DeleteFloatingIp(ctx context.Context, id string) error {
+    ctx, span := tracing.Start(ctx, "repositories.FloatingIpRepository.DeleteFloatingIp")
+    defer span.End()
+
+    collection := database.Collection(database.FloatingIpsCollection)
+    _, err := collection.DeleteOne(ctx, bson.M{"_id": id})
+
+    return err
+}
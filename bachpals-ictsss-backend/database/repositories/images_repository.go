@@ -1,173 +1,155 @@
 package repositories
 
 import (
-    "fmt"
+    "context"
     "gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/database"
+    "gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/storage"
+    "gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/tracing"
     "go.mongodb.org/mongo-driver/bson"
     "go.mongodb.org/mongo-driver/bson/primitive"
+    "go.mongodb.org/mongo-driver/mongo"
     "go.mongodb.org/mongo-driver/mongo/options"
-    "log"
 )
 
-func InsertImage(image map[string]interface{}) interface{} {
-    if len(image["ImageId"].(string)) == 0 || len(image["ImageDisplayName"].(string)) == 0 {
-        return nil
-    }
+// ImageRepository stores the images offered by the service in MongoDB.
+type ImageRepository struct{}
+
+func (ImageRepository) InsertImage(ctx context.Context, image database.Images) error {
+    ctx, span := tracing.Start(ctx, "repositories.ImageRepository.InsertImage")
+    defer span.End()
 
     insertFilter := bson.D{
-        {Key: "image_id", Value: image["ImageId"]},
-        {Key: "image_name", Value: image["ImageName"]},
-        {Key: "image_display_name", Value: image["ImageDisplayName"]},
-        {Key: "image_description", Value: image["ImageDescription"]},
-        {Key: "published", Value: image["Published"]},
-        {Key: "image_config", Value: image["ImageConfig"]},
-        {Key: "image_read_root_password", Value: image["ImageReadRootPassword"]},
+        {Key: "image_id", Value: image.ImageId},
+        {Key: "image_name", Value: image.ImageName},
+        {Key: "image_display_name", Value: image.ImageDisplayName},
+        {Key: "image_description", Value: image.ImageDescription},
+        {Key: "published", Value: image.Published},
+        {Key: "image_config", Value: image.ImageConfig},
+        {Key: "image_read_root_password", Value: image.ImageReadRootPassword},
     }
 
-    db, context, cancel := database.GetClient()
-    collection := db.Database(database.DefaultDB).Collection(database.ImagesCollection)
-    inserted, insertErr := collection.InsertOne(context, insertFilter)
+    collection := database.Collection(database.ImagesCollection)
+    _, err := collection.InsertOne(ctx, insertFilter)
 
-    if insertErr != nil {
-        log.Println("Error while inserting image to database!", insertErr)
-        defer cancel()
-        defer db.Disconnect(context)
-        return nil
-    }
-
-    defer cancel()
-    defer db.Disconnect(context)
-    return inserted
+    return err
 }
 
-func GetImages() []*database.Images {
-    db, context, cancel := database.GetClient()
-    collection := db.Database(database.DefaultDB).Collection(database.ImagesCollection)
-    cursor, err := collection.Find(context, bson.D{})
-
+func findImages(ctx context.Context, filter interface{}, opts ...*options.FindOptions) ([]*database.Images, error) {
+    collection := database.Collection(database.ImagesCollection)
+    cursor, err := collection.Find(ctx, filter, opts...)
     if err != nil {
-        fmt.Println("Error while reading images!", err)
-        defer cancel()
-        defer db.Disconnect(context)
-        return nil
+        return nil, err
     }
+    defer cursor.Close(ctx)
 
-    var images []*database.Images
-    for cursor.Next(context) {
+    images := []*database.Images{}
+    for cursor.Next(ctx) {
         var elem database.Images
-        err := cursor.Decode(&elem)
-        if err != nil {
-            fmt.Println("An error occurred while reading data!")
-            defer cancel()
-            defer cursor.Close(context)
-            defer db.Disconnect(context)
-            return nil
+        if err := cursor.Decode(&elem); err != nil {
+            return nil, err
         }
 
         images = append(images, &elem)
     }
 
-    defer cancel()
-    defer db.Disconnect(context)
-    return images
+    return images, cursor.Err()
 }
 
-func GetImageByImageId(id interface{}) *database.Images {
-    findFilter := bson.M{"image_id": id.(string)}
-
+func findImage(ctx context.Context, filter interface{}) (*database.Images, error) {
     var image database.Images
 
-    db, context, cancel := database.GetClient()
-    collection := db.Database(database.DefaultDB).Collection(database.ImagesCollection)
-    err := collection.FindOne(context, findFilter).Decode(&image)
+    collection := database.Collection(database.ImagesCollection)
+    err := collection.FindOne(ctx, filter).Decode(&image)
 
+    if err == mongo.ErrNoDocuments {
+        return nil, storage.ErrNotFound
+    }
     if err != nil {
-        fmt.Println("Error while reading image!", err)
-        defer cancel()
-        defer db.Disconnect(context)
-        return nil
+        return nil, err
     }
 
-    defer cancel()
-    defer db.Disconnect(context)
-    return &image
+    return &image, nil
 }
 
-func GetImageById(id interface{}) *database.Images {
-    documentId, _ := primitive.ObjectIDFromHex(id.(string))
-    findFilter := bson.M{"_id": documentId}
+func (ImageRepository) GetImages(ctx context.Context) ([]*database.Images, error) {
+    ctx, span := tracing.Start(ctx, "repositories.ImageRepository.GetImages")
+    defer span.End()
 
-    var image database.Images
+    return findImages(ctx, bson.D{})
+}
+
+func (ImageRepository) GetImageByImageId(ctx context.Context, imageId string) (*database.Images, error) {
+    ctx, span := tracing.Start(ctx, "repositories.ImageRepository.GetImageByImageId")
+    defer span.End()
 
-    db, context, cancel := database.GetClient()
-    collection := db.Database(database.DefaultDB).Collection(database.ImagesCollection)
-    err := collection.FindOne(context, findFilter).Decode(&image)
+    return findImage(ctx, bson.M{"image_id": imageId})
+}
 
+func (ImageRepository) GetImageById(ctx context.Context, id string) (*database.Images, error) {
+    ctx, span := tracing.Start(ctx, "repositories.ImageRepository.GetImageById")
+    defer span.End()
+
+    documentId, err := primitive.ObjectIDFromHex(id)
     if err != nil {
-        fmt.Println("Error while updating image!", err)
-        defer cancel()
-        defer db.Disconnect(context)
-        return nil
+        return nil, storage.ErrNotFound
     }
 
-    defer cancel()
-    defer db.Disconnect(context)
-    return &image
+    return findImage(ctx, bson.M{"_id": documentId})
 }
 
-func UpdateImageById(image map[string]interface{}) interface{} {
-    documentId, _ := primitive.ObjectIDFromHex(image["Id"].(string))
+func (ImageRepository) UpdateImageById(ctx context.Context, image database.Images) error {
+    ctx, span := tracing.Start(ctx, "repositories.ImageRepository.UpdateImageById")
+    defer span.End()
+
+    documentId, err := primitive.ObjectIDFromHex(image.Id)
+    if err != nil {
+        return storage.ErrNotFound
+    }
     findFilter := bson.M{"_id": documentId}
 
     updateFilter := bson.M{"$set": bson.D{
-        {Key: "image_id", Value: image["ImageId"]},
-        {Key: "image_name", Value: image["ImageName"]},
-        {Key: "image_display_name", Value: image["ImageDisplayName"]},
-        {Key: "image_description", Value: image["ImageDescription"]},
-        {Key: "published", Value: image["Published"]},
-        {Key: "image_config", Value: image["ImageConfig"]},
-        {Key: "image_read_root_password", Value: image["ImageReadRootPassword"]},
+        {Key: "image_id", Value: image.ImageId},
+        {Key: "image_name", Value: image.ImageName},
+        {Key: "image_display_name", Value: image.ImageDisplayName},
+        {Key: "image_description", Value: image.ImageDescription},
+        {Key: "published", Value: image.Published},
+        {Key: "image_config", Value: image.ImageConfig},
+        {Key: "image_read_root_password", Value: image.ImageReadRootPassword},
     }}
 
-    db, context, cancel := database.GetClient()
-    collection := db.Database(database.DefaultDB).Collection(database.ImagesCollection)
-    _, err := collection.UpdateOne(context, findFilter, updateFilter)
-
+    collection := database.Collection(database.ImagesCollection)
+    res, err := collection.UpdateOne(ctx, findFilter, updateFilter)
     if err != nil {
-        fmt.Println("Error while updating image!", err)
-        defer cancel()
-        defer db.Disconnect(context)
-        return nil
+        return err
     }
 
-    defer cancel()
-    defer db.Disconnect(context)
-    return true
-}
+    if res.MatchedCount == 0 {
+        return storage.ErrNotFound
+    }
 
-func DeleteImageById(id string) interface{} {
-    documentId, _ := primitive.ObjectIDFromHex(id)
-    findFilter := bson.M{"_id": documentId}
+    return nil
+}
 
-    db, context, cancel := database.GetClient()
-    collection := db.Database(database.DefaultDB).Collection(database.ImagesCollection)
-    _, err := collection.DeleteOne(context, findFilter)
+func (ImageRepository) DeleteImageById(ctx context.Context, id string) error {
+    ctx, span := tracing.Start(ctx, "repositories.ImageRepository.DeleteImageById")
+    defer span.End()
 
+    documentId, err := primitive.ObjectIDFromHex(id)
     if err != nil {
-        fmt.Println("Error while updating image!", err)
-        defer cancel()
-        defer db.Disconnect(context)
-        return nil
+        return storage.ErrNotFound
     }
 
-    defer cancel()
-    defer db.Disconnect(context)
-    return true
+    collection := database.Collection(database.ImagesCollection)
+    _, err = collection.DeleteOne(ctx, bson.M{"_id": documentId})
+
+    return err
 }
 
-func GetPublishedImages() []*database.Images {
-    opts := options.Find().SetProjection(bson.D{
+func (ImageRepository) GetPublishedImages(ctx context.Context) ([]*database.Images, error) {
+    ctx, span := tracing.Start(ctx, "repositories.ImageRepository.GetPublishedImages")
+    defer span.End()
 
+    opts := options.Find().SetProjection(bson.D{
         {
             Key:   "_id",
             Value: 0,
@@ -186,36 +168,8 @@ func GetPublishedImages() []*database.Images {
         },
     })
 
-    db, context, cancel := database.GetClient()
-    collection := db.Database(database.DefaultDB).Collection(database.ImagesCollection)
-    cursor, err := collection.Find(context, bson.D{{
+    return findImages(ctx, bson.D{{
         Key:   "published",
         Value: "true",
     }}, opts)
-
-    if err != nil {
-        fmt.Println("Error while reading images!", err)
-        defer cancel()
-        defer db.Disconnect(context)
-        return nil
-    }
-
-    var images []*database.Images
-    for cursor.Next(context) {
-        var elem database.Images
-        err := cursor.Decode(&elem)
-        if err != nil {
-            fmt.Println("An error occurred while reading data!")
-            defer cancel()
-            defer cursor.Close(context)
-            defer db.Disconnect(context)
-            return nil
-        }
-
-        images = append(images, &elem)
-    }
-
-    defer cancel()
-    defer db.Disconnect(context)
-    return images
-}
\ No newline at end of file
+}
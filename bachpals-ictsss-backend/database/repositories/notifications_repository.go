@@ -0,0 +1,117 @@
+package repositories
+
+import (
+    "context"
+    "gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/database"
+    "gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/storage"
+    "gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/tracing"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+    "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
+    "time"
+)
+
+// NotificationRepository stores the notification outbox and preferences in MongoDB.
+type NotificationRepository struct{}
+
+func (NotificationRepository) InsertNotification(ctx context.Context, notification database.Notification) error {
+    ctx, span := tracing.Start(ctx, "repositories.NotificationRepository.InsertNotification")
+    defer span.End()
+
+    collection := database.Collection(database.NotificationsCollection)
+    _, err := collection.InsertOne(ctx, notification)
+
+    return err
+}
+
+func (NotificationRepository) ClaimDueNotification(ctx context.Context, now time.Time, lease time.Duration) (*database.Notification, error) {
+    ctx, span := tracing.Start(ctx, "repositories.NotificationRepository.ClaimDueNotification")
+    defer span.End()
+
+    // A single update so a second instance sees it taken.
+    filter := bson.M{
+        "status":       bson.M{"$in": bson.A{database.NotificationStatusPending, database.NotificationStatusSending}},
+        "next_attempt": bson.M{"$lte": now},
+    }
+    update := bson.M{"$set": bson.M{
+        "status":       database.NotificationStatusSending,
+        "next_attempt": now.Add(lease),
+    }}
+    opts := options.FindOneAndUpdate().SetSort(bson.D{{Key: "next_attempt", Value: 1}}).SetReturnDocument(options.After)
+
+    var notification database.Notification
+    collection := database.Collection(database.NotificationsCollection)
+    err := collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&notification)
+
+    if err == mongo.ErrNoDocuments {
+        return nil, storage.ErrNotFound
+    }
+    if err != nil {
+        return nil, err
+    }
+
+    return &notification, nil
+}
+
+func (NotificationRepository) UpdateNotificationDelivery(ctx context.Context, notification database.Notification) error {
+    ctx, span := tracing.Start(ctx, "repositories.NotificationRepository.UpdateNotificationDelivery")
+    defer span.End()
+
+    documentId, err := primitive.ObjectIDFromHex(notification.Id)
+    if err != nil {
+        return storage.ErrNotFound
+    }
+
+    updateFilter := bson.M{"$set": bson.M{
+        "status":       notification.Status,
+        "attempts":     notification.Attempts,
+        "last_error":   notification.LastError,
+        "next_attempt": notification.NextAttempt,
+        "sent":         notification.Sent,
+    }}
+
+    collection := database.Collection(database.NotificationsCollection)
+    res, err := collection.UpdateOne(ctx, bson.M{"_id": documentId}, updateFilter)
+    if err != nil {
+        return err
+    }
+
+    if res.MatchedCount == 0 {
+        return storage.ErrNotFound
+    }
+
+    return nil
+}
+
+func (NotificationRepository) GetNotificationPreferences(ctx context.Context, userId string) (*database.NotificationPreferences, error) {
+    ctx, span := tracing.Start(ctx, "repositories.NotificationRepository.GetNotificationPreferences")
+    defer span.End()
+
+    preferences := database.NotificationPreferences{UserId: userId, OptOut: []string{}}
+
+    collection := database.Collection(database.NotificationPreferencesCollection)
+    err := collection.FindOne(ctx, bson.M{"user_id": userId}).Decode(&preferences)
+
+    if err != nil && err != mongo.ErrNoDocuments {
+        return nil, err
+    }
+
+    return &preferences, nil
+}
+
+func (NotificationRepository) UpdateNotificationPreferences(ctx context.Context, preferences database.NotificationPreferences) error {
+    ctx, span := tracing.Start(ctx, "repositories.NotificationRepository.UpdateNotificationPreferences")
+    defer span.End()
+
+    findFilter := bson.M{"user_id": preferences.UserId}
+    updateFilter := bson.M{"$set": bson.M{
+        "disabled": preferences.Disabled,
+        "opt_out":  preferences.OptOut,
+    }}
+
+    collection := database.Collection(database.NotificationPreferencesCollection)
+    _, err := collection.UpdateOne(ctx, findFilter, updateFilter, options.Update().SetUpsert(true))
+
+    return err
+}
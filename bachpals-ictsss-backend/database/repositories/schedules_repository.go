@@ -0,0 +1,198 @@
+package repositories
+
+import (
+    "context"
+    "gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/database"
+    "gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/storage"
+    "gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/tracing"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+    "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
+    "time"
+)
+
+// ScheduleRepository stores power schedules and their run reports in MongoDB.
+type ScheduleRepository struct{}
+
+func scheduleDocument(schedule database.Schedule) bson.D {
+    return bson.D{
+        {Key: "name", Value: schedule.Name},
+        {Key: "target_type", Value: schedule.TargetType},
+        {Key: "target_id", Value: schedule.TargetId},
+        {Key: "time_zone", Value: schedule.TimeZone},
+        {Key: "start_cron", Value: schedule.StartCron},
+        {Key: "stop_cron", Value: schedule.StopCron},
+        {Key: "windows", Value: schedule.Windows},
+        {Key: "enabled", Value: schedule.Enabled},
+    }
+}
+
+func (ScheduleRepository) InsertSchedule(ctx context.Context, schedule database.Schedule) (string, error) {
+    ctx, span := tracing.Start(ctx, "repositories.ScheduleRepository.InsertSchedule")
+    defer span.End()
+
+    document := append(scheduleDocument(schedule),
+        bson.E{Key: "created_by", Value: schedule.CreatedBy},
+        bson.E{Key: "last_run", Value: time.Now()},
+    )
+
+    collection := database.Collection(database.SchedulesCollection)
+    inserted, err := collection.InsertOne(ctx, document)
+    if err != nil {
+        return "", err
+    }
+
+    return InsertedId(inserted), nil
+}
+
+func (ScheduleRepository) GetSchedules(ctx context.Context) ([]*database.Schedule, error) {
+    ctx, span := tracing.Start(ctx, "repositories.ScheduleRepository.GetSchedules")
+    defer span.End()
+
+    collection := database.Collection(database.SchedulesCollection)
+    cursor, err := collection.Find(ctx, bson.D{}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+    if err != nil {
+        return nil, err
+    }
+    defer cursor.Close(ctx)
+
+    schedules := []*database.Schedule{}
+    for cursor.Next(ctx) {
+        var elem database.Schedule
+        if err := cursor.Decode(&elem); err != nil {
+            return nil, err
+        }
+
+        schedules = append(schedules, &elem)
+    }
+
+    return schedules, cursor.Err()
+}
+
+func (ScheduleRepository) GetScheduleById(ctx context.Context, id string) (*database.Schedule, error) {
+    ctx, span := tracing.Start(ctx, "repositories.ScheduleRepository.GetScheduleById")
+    defer span.End()
+
+    documentId, err := primitive.ObjectIDFromHex(id)
+    if err != nil {
+        return nil, storage.ErrNotFound
+    }
+
+    var schedule database.Schedule
+    collection := database.Collection(database.SchedulesCollection)
+    err = collection.FindOne(ctx, bson.M{"_id": documentId}).Decode(&schedule)
+
+    if err == mongo.ErrNoDocuments {
+        return nil, storage.ErrNotFound
+    }
+    if err != nil {
+        return nil, err
+    }
+
+    return &schedule, nil
+}
+
+func (ScheduleRepository) UpdateScheduleById(ctx context.Context, schedule database.Schedule) error {
+    ctx, span := tracing.Start(ctx, "repositories.ScheduleRepository.UpdateScheduleById")
+    defer span.End()
+
+    documentId, err := primitive.ObjectIDFromHex(schedule.Id)
+    if err != nil {
+        return storage.ErrNotFound
+    }
+
+    collection := database.Collection(database.SchedulesCollection)
+    res, err := collection.UpdateOne(ctx, bson.M{"_id": documentId}, bson.M{"$set": scheduleDocument(schedule)})
+    if err != nil {
+        return err
+    }
+
+    if res.MatchedCount == 0 {
+        return storage.ErrNotFound
+    }
+
+    return nil
+}
+
+func (ScheduleRepository) ClaimScheduleRun(ctx context.Context, id string, lastRun time.Time, now time.Time) (bool, error) {
+    ctx, span := tracing.Start(ctx, "repositories.ScheduleRepository.ClaimScheduleRun")
+    defer span.End()
+
+    documentId, err := primitive.ObjectIDFromHex(id)
+    if err != nil {
+        return false, storage.ErrNotFound
+    }
+
+    filter := bson.M{"_id": documentId, "last_run": lastRun}
+    if lastRun.IsZero() {
+        // Schedules from before last_run was stored have no such field.
+        filter["last_run"] = bson.M{"$in": bson.A{nil, lastRun}}
+    }
+
+    collection := database.Collection(database.SchedulesCollection)
+    res, err := collection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"last_run": now}})
+    if err != nil {
+        return false, err
+    }
+
+    return res.ModifiedCount == 1, nil
+}
+
+func (ScheduleRepository) DeleteScheduleById(ctx context.Context, id string) error {
+    ctx, span := tracing.Start(ctx, "repositories.ScheduleRepository.DeleteScheduleById")
+    defer span.End()
+
+    documentId, err := primitive.ObjectIDFromHex(id)
+    if err != nil {
+        return storage.ErrNotFound
+    }
+
+    collection := database.Collection(database.SchedulesCollection)
+    res, err := collection.DeleteOne(ctx, bson.M{"_id": documentId})
+    if err != nil {
+        return err
+    }
+
+    if res.DeletedCount == 0 {
+        return storage.ErrNotFound
+    }
+
+    return nil
+}
+
+func (ScheduleRepository) InsertScheduleRun(ctx context.Context, run database.ScheduleRun) error {
+    ctx, span := tracing.Start(ctx, "repositories.ScheduleRepository.InsertScheduleRun")
+    defer span.End()
+
+    collection := database.Collection(database.ScheduleRunsCollection)
+    _, err := collection.InsertOne(ctx, run)
+
+    return err
+}
+
+func (ScheduleRepository) GetScheduleRuns(ctx context.Context, scheduleId string, limit int) ([]*database.ScheduleRun, error) {
+    ctx, span := tracing.Start(ctx, "repositories.ScheduleRepository.GetScheduleRuns")
+    defer span.End()
+
+    opts := options.Find().SetSort(bson.D{{Key: "started", Value: -1}}).SetLimit(int64(limit))
+
+    collection := database.Collection(database.ScheduleRunsCollection)
+    cursor, err := collection.Find(ctx, bson.M{"schedule_id": scheduleId}, opts)
+    if err != nil {
+        return nil, err
+    }
+    defer cursor.Close(ctx)
+
+    runs := []*database.ScheduleRun{}
+    for cursor.Next(ctx) {
+        var elem database.ScheduleRun
+        if err := cursor.Decode(&elem); err != nil {
+            return nil, err
+        }
+
+        runs = append(runs, &elem)
+    }
+
+    return runs, cursor.Err()
+}
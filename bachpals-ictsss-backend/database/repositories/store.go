@@ -0,0 +1,83 @@
+package repositories
+
+import (
+    "context"
+    "gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/database"
+    "gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/migrations"
+    "gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/storage"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+    "go.mongodb.org/mongo-driver/mongo"
+)
+
+func init() {
+    storage.Register("mongo", func(ctx context.Context, dsn string) (storage.Store, error) {
+        // The shared client from database.Connect is used, the dsn is IKT_STACK_DB_URL.
+        return MongoStore{}, nil
+    })
+}
+
+// MongoStore is the MongoDB storage backend.
+type MongoStore struct{}
+
+func (MongoStore) Vms() storage.VmStore                     { return VmRepository{} }
+func (MongoStore) Images() storage.ImageStore               { return ImageRepository{} }
+func (MongoStore) Admins() storage.AdminStore               { return AdminRepository{} }
+func (MongoStore) AppState() storage.AppStateStore          { return ApplicationRepository{} }
+func (MongoStore) FloatingIps() storage.FloatingIpStore     { return FloatingIpRepository{} }
+func (MongoStore) Schedules() storage.ScheduleStore         { return ScheduleRepository{} }
+func (MongoStore) Notifications() storage.NotificationStore { return NotificationRepository{} }
+func (MongoStore) Webhooks() storage.WebhookStore           { return WebhookRepository{} }
+func (MongoStore) Migrator() storage.Migrator               { return mongoMigrator{} }
+func (MongoStore) Ping(ctx context.Context) error           { return database.Ping(ctx) }
+func (MongoStore) Close(ctx context.Context) error          { return nil }
+
+type mongoMigrator struct{}
+
+func migrationState(m migrations.Migration) storage.MigrationState {
+    return storage.MigrationState{Version: m.Version, Description: m.Description}
+}
+
+func (mongoMigrator) Up(ctx context.Context) ([]storage.MigrationState, error) {
+    applied, err := migrations.Up(ctx)
+
+    var states []storage.MigrationState
+    for _, v := range applied {
+        states = append(states, migrationState(v))
+    }
+
+    return states, err
+}
+
+func (mongoMigrator) Down(ctx context.Context) (*storage.MigrationState, error) {
+    reverted, err := migrations.Down(ctx)
+    if err != nil || reverted == nil {
+        return nil, err
+    }
+
+    state := migrationState(*reverted)
+    return &state, nil
+}
+
+func (mongoMigrator) Status(ctx context.Context) ([]storage.MigrationState, error) {
+    list, err := migrations.Status(ctx)
+    if err != nil {
+        return nil, err
+    }
+
+    var states []storage.MigrationState
+    for _, v := range list {
+        states = append(states, storage.MigrationState{Version: v.Version, Description: v.Description, Applied: v.Applied})
+    }
+
+    return states, nil
+}
+
+// InsertedId returns the id of a document added by a repository that returns the insert result.
+func InsertedId(inserted interface{}) string {
+    if result, ok := inserted.(*mongo.InsertOneResult); ok {
+        if id, ok := result.InsertedID.(primitive.ObjectID); ok {
+            return id.Hex()
+        }
+    }
+    return ""
+}
@@ -1,340 +1,400 @@
 package repositories
 
 import (
-    "fmt"
-    "github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
+    "context"
     "gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/database"
-    "gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/gopher"
+    "gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/storage"
+    "gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/tracing"
     "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/primitive"
     "go.mongodb.org/mongo-driver/mongo"
     "go.mongodb.org/mongo-driver/mongo/options"
-    "log"
-    "strings"
+    "regexp"
     "time"
 )
 
-func InsertMultipleVms(server *servers.Server, serverIp string, serverName string, users string, serverImage string) *mongo.InsertManyResult {
+// VmRepository stores virtual machines in MongoDB, one document per server.
+type VmRepository struct{}
 
-    var documents []interface{}
+func (VmRepository) InsertVm(ctx context.Context, vm database.VirtualMachine) error {
+    ctx, span := tracing.Start(ctx, "repositories.VmRepository.InsertVm")
+    defer span.End()
 
-    time := time.Now()
-    for _, k := range strings.Split(users, ",") {
-        documents = append(documents, bson.D{
-            {Key: "server_ip", Value: serverIp},
-            {Key: "server_image", Value: serverImage},
-            {Key: "server_name", Value: serverName},
-            {Key: "user_id", Value: k},
-            {Key: "server_status", Value: database.VirtualMachineStatusActive},
-            {Key: "server_id", Value: server.ID},
-            {Key: "created", Value: time},
-        })
+    created := vm.Created
+    if created.IsZero() {
+        created = time.Now()
     }
 
-    db, context, cancel := database.GetClient()
-    vms := db.Database(database.DefaultDB).Collection(database.VmCollection)
-    insertResponse, insertError := vms.InsertMany(context, documents)
-
-    if insertError != nil {
-        client := gopher.GetClient()
+    virtualMachineMetadata := bson.D{
+        {Key: "server_ip", Value: vm.ServerIp},
+        {Key: "server_image", Value: vm.ServerImage},
+        {Key: "server_name", Value: vm.ServerName},
+        {Key: "server_status", Value: vm.ServerStatus},
+        {Key: "server_id", Value: vm.ServerId},
+        {Key: "created", Value: created},
+        {Key: "course_code", Value: vm.CourseCode},
+        {Key: "target", Value: vm.Target},
+        {Key: "members", Value: vm.Members},
+        {Key: "lease_expires", Value: vm.LeaseExpires},
+        {Key: "lease_notice", Value: vm.LeaseNotice},
+    }
 
-        // If for any reason a server could not be stored in the database, remove it from bare metal.
-        result := servers.Delete(client, server.ID)
+    vms := database.Collection(database.VmCollection)
+    _, err := vms.InsertOne(ctx, virtualMachineMetadata)
 
-        // Do we want to print a response or fail silently?
-        // This should probably be logged into some kind of logging system.
-        if result.ExtractErr() != nil {
-            fmt.Println("This error occurred while deleting vm,"+
-                "after database failed insertion of a new vm.", result.ExtractErr())
-        }
-        defer cancel()
-        defer db.Disconnect(context)
-        return nil
-    }
-    defer cancel()
-    defer db.Disconnect(context)
+    return err
+}
 
-    return insertResponse
+// imageLookup joins the image display name and root password flag in from the images collection.
+var imageLookup = []bson.M{
+    {"$lookup": bson.M{
+        "from":         database.ImagesCollection,
+        "localField":   "server_image",
+        "foreignField": "image_id",
+        "as":           "image",
+    }},
+    {"$addFields": bson.M{
+        "image_display_name":       bson.M{"$ifNull": bson.A{bson.M{"$arrayElemAt": bson.A{"$image.image_display_name", 0}}, ""}},
+        "image_read_root_password": bson.M{"$ifNull": bson.A{bson.M{"$arrayElemAt": bson.A{"$image.image_read_root_password", 0}}, false}},
+    }},
+    {"$project": bson.M{"image": 0}},
 }
 
-func InsertVm(server *servers.Server, serverIp string, serverName string, userId string) *mongo.InsertOneResult {
+// findVms reads the virtual machines matching filter in a single query, with their image.
+func findVms(ctx context.Context, filter bson.M) ([]database.VirtualMachine, error) {
+    pipeline := append([]bson.M{
+        {"$match": filter},
+        {"$sort": bson.M{"created": 1}},
+    }, imageLookup...)
 
-    virtualMachineMetadata := bson.D{
-        {Key: "server_ip", Value: serverIp},
-        {Key: "server_image", Value: server.Image},
-        {Key: "server_name", Value: serverName},
-        {Key: "user_id", Value: userId},
-        {Key: "server_status", Value: database.VirtualMachineStatusActive},
-        {Key: "server_id", Value: server.ID},
-        {Key: "created", Value: time.Now()},
-    }
-
-    db, context, cancel := database.GetClient()
-    vms := db.Database(database.DefaultDB).Collection(database.VmCollection)
-    insertResponse, insertError := vms.InsertOne(context, virtualMachineMetadata)
-
-    if insertError != nil {
-        client := gopher.GetClient()
-
-        // If for any reason a server could not be stored in the database, remove it from bare metal.
-        result := servers.Delete(client, server.ID)
-
-        // Do we want to print a response or fail silently?
-        // This should probably be logged into some kind of logging system.
-        if result.ExtractErr() != nil {
-            fmt.Println("This error occurred while deleting vm,"+
-                "after database failed insertion of a new vm.", result.ExtractErr())
+    vms := database.Collection(database.VmCollection)
+    cur, err := vms.Aggregate(ctx, pipeline)
+    if err != nil {
+        return nil, err
+    }
+    defer cur.Close(ctx)
+
+    virtualMachines := []database.VirtualMachine{}
+    for cur.Next(ctx) {
+        var elem database.VirtualMachine
+        if err := cur.Decode(&elem); err != nil {
+            return nil, err
         }
-        defer cancel()
-        defer db.Disconnect(context)
-        return nil
+
+        database.FillMembers(&elem)
+        virtualMachines = append(virtualMachines, elem)
     }
-    defer cancel()
-    defer db.Disconnect(context)
 
-    return insertResponse
+    return virtualMachines, cur.Err()
 }
 
-func GetVMS() []database.VirtualMachine {
-    groupStage := []bson.M{{
-        "$group": bson.M{
-            "_id": "$server_id",
-            "doc": bson.M{
-                "$first": "$$ROOT",
-            },
-        },
-    }}
+func (VmRepository) GetVMS(ctx context.Context) ([]database.VirtualMachine, error) {
+    ctx, span := tracing.Start(ctx, "repositories.VmRepository.GetVMS")
+    defer span.End()
 
-    db, context, cancel := database.GetClient()
-    vms := db.Database(database.DefaultDB).Collection(database.VmCollection)
-    cur, err := vms.Aggregate(context, groupStage)
+    return findVms(ctx, bson.M{})
+}
 
-    if err != nil {
-        defer cancel()
-        defer db.Disconnect(context)
-        defer cur.Close(context)
-        return nil
+// vmQueryFilter is the $match of the filters in query.
+func vmQueryFilter(query storage.VmQuery) bson.M {
+    filter := bson.M{}
+    if len(query.Status) > 0 {
+        filter["server_status"] = query.Status
     }
-
-    var tmp []map[string]interface{}
-    err = cur.All(context, &tmp)
-    if err != nil {
-        defer cancel()
-        defer db.Disconnect(context)
-        defer cur.Close(context)
-        return nil
+    if len(query.ImageId) > 0 {
+        filter["server_image"] = query.ImageId
     }
-
-    var virtualMachines []database.VirtualMachine
-    for _, v := range tmp {
-        var t database.VirtualMachine
-        d, _ := bson.Marshal(v["doc"])
-        bson.Unmarshal(d, &t)
-        virtualMachines = append(virtualMachines, t)
+    if len(query.CourseCode) > 0 {
+        filter["course_code"] = query.CourseCode
+    }
+    if len(query.Target) > 0 {
+        filter["target"] = query.Target
+    }
+    if len(query.OwnerId) > 0 {
+        filter["members"] = bson.M{"$elemMatch": bson.M{"user_id": query.OwnerId, "role": database.VirtualMachineRoleOwner}}
+    }
+    if len(query.Name) > 0 {
+        filter["server_name"] = primitive.Regex{Pattern: regexp.QuoteMeta(query.Name), Options: "i"}
     }
 
-    var data []database.VirtualMachine
-    for _, v := range virtualMachines {
-        members := GetVmGroupMembers(v.ServerId)
-        v.GroupMembers = members
-
-        image := GetImageByImageId(v.ServerImage)
-        v.ImageReadRootPassword = image.ImageReadRootPassword
-        v.ImageDisplayName = image.ImageDisplayName
-
-        data = append(data, v)
+    created := bson.M{}
+    if !query.CreatedFrom.IsZero() {
+        created["$gte"] = query.CreatedFrom
+    }
+    if !query.CreatedTo.IsZero() {
+        created["$lt"] = query.CreatedTo
+    }
+    if len(created) > 0 {
+        filter["created"] = created
     }
 
-    defer cancel()
-    defer db.Disconnect(context)
-    defer cur.Close(context)
-    return data
+    return filter
 }
 
-func GetVMByUserId(id interface{}) interface{} {
-    var virtualMachines []interface{}
-    findOptions := options.Find()
-
-    db, context, cancel := database.GetClient()
-    vms := db.Database(database.DefaultDB).Collection(database.VmCollection)
-    cur, err := vms.Find(context, bson.D{{"user_id", id}}, findOptions)
+// ListVms counts the matches and reads one page of them in a single aggregation, the
+// page continues after the cursor by comparing the sort field and then the server id.
+func (VmRepository) ListVms(ctx context.Context, query storage.VmQuery) (storage.VmPage, error) {
+    ctx, span := tracing.Start(ctx, "repositories.VmRepository.ListVms")
+    defer span.End()
 
+    cursor, err := query.Normalize()
     if err != nil {
-        defer cancel()
-        defer db.Disconnect(context)
-        defer cur.Close(context)
-        return nil
+        return storage.VmPage{}, err
     }
 
-    for cur.Next(context) {
-        var elem map[string]interface{}
-        err := cur.Decode(&elem)
-        if err != nil {
-            fmt.Println("An error occurred while reading data!")
-            defer cancel()
-            defer db.Disconnect(context)
-            defer cur.Close(context)
-            return nil
+    direction, after := 1, "$gt"
+    if query.Descending {
+        direction, after = -1, "$lt"
+    }
+
+    page := []bson.M{}
+    if cursor != nil {
+        var value interface{} = cursor.Value
+        if query.Sort == storage.VmSortCreated {
+            value = cursor.Created
         }
 
-        members := GetVmGroupMembers(elem["server_id"].(string))
-        elem["group_members"] = members
+        page = append(page, bson.M{"$match": bson.M{"$or": bson.A{
+            bson.M{query.Sort: bson.M{after: value}},
+            bson.M{query.Sort: value, "server_id": bson.M{after: cursor.ServerId}},
+        }}})
+    }
+    page = append(page,
+        bson.M{"$sort": bson.D{{Key: query.Sort, Value: direction}, {Key: "server_id", Value: direction}}},
+        bson.M{"$limit": query.Limit + 1},
+    )
+    page = append(page, imageLookup...)
+
+    pipeline := []bson.M{
+        {"$match": vmQueryFilter(query)},
+        {"$facet": bson.M{
+            "total": bson.A{bson.M{"$count": "n"}},
+            "vms":   page,
+        }},
+    }
 
-        virtualMachines = append(virtualMachines, elem)
+    vms := database.Collection(database.VmCollection)
+    cur, err := vms.Aggregate(ctx, pipeline)
+    if err != nil {
+        return storage.VmPage{}, err
     }
+    defer cur.Close(ctx)
 
-    var tmp []database.VirtualMachine
+    var result struct {
+        Total []struct {
+            N int `bson:"n"`
+        } `bson:"total"`
+        Vms []database.VirtualMachine `bson:"vms"`
+    }
+    if cur.Next(ctx) {
+        if err := cur.Decode(&result); err != nil {
+            return storage.VmPage{}, err
+        }
+    }
+    if err := cur.Err(); err != nil {
+        return storage.VmPage{}, err
+    }
 
-    for _, v := range virtualMachines {
-        var t database.VirtualMachine
-        d, _ := bson.Marshal(v)
-        bson.Unmarshal(d, &t)
-        tmp = append(tmp, t)
+    res := storage.VmPage{Vms: result.Vms}
+    if len(result.Total) > 0 {
+        res.Total = result.Total[0].N
+    }
+    if res.Vms == nil {
+        res.Vms = []database.VirtualMachine{}
+    }
+    if len(res.Vms) > query.Limit {
+        res.Vms = res.Vms[:query.Limit]
+        res.NextCursor = query.NextCursor(res.Vms[query.Limit-1])
+    }
+    for i := range res.Vms {
+        database.FillMembers(&res.Vms[i])
     }
 
-    var data []database.VirtualMachine
+    return res, nil
+}
 
-    for _, v := range tmp {
-        image := GetImageByImageId(v.ServerImage)
-        v.ImageReadRootPassword = image.ImageReadRootPassword
-        v.ImageDisplayName = image.ImageDisplayName
+func (VmRepository) GetVMByUserId(ctx context.Context, userId string) ([]database.VirtualMachine, error) {
+    ctx, span := tracing.Start(ctx, "repositories.VmRepository.GetVMByUserId")
+    defer span.End()
 
-        data = append(data, v)
-    }
+    return findVms(ctx, bson.M{"members.user_id": userId})
+}
 
-    defer cancel()
-    defer db.Disconnect(context)
-    defer cur.Close(context)
-    return data
+// GetVMByCourseCode returns the virtual machines ordered for a Canvas course.
+func (VmRepository) GetVMByCourseCode(ctx context.Context, courseCode string) ([]database.VirtualMachine, error) {
+    ctx, span := tracing.Start(ctx, "repositories.VmRepository.GetVMByCourseCode")
+    defer span.End()
+
+    return findVms(ctx, bson.M{"course_code": courseCode})
 }
 
-func GetVMById(id interface{}) (v database.VirtualMachine, erro error) {
-    var result database.VirtualMachine
-    db, context, cancel := database.GetClient()
-    vms := db.Database(database.DefaultDB).Collection(database.VmCollection)
-    err := vms.FindOne(context, bson.D{{"server_id", id}}).Decode(&result)
+func (VmRepository) GetVMById(ctx context.Context, serverId string) (database.VirtualMachine, error) {
+    ctx, span := tracing.Start(ctx, "repositories.VmRepository.GetVMById")
+    defer span.End()
 
+    virtualMachines, err := findVms(ctx, bson.M{"server_id": serverId})
     if err != nil {
-        defer cancel()
-        defer db.Disconnect(context)
-        return result, err
+        return database.VirtualMachine{}, err
+    }
+
+    if len(virtualMachines) == 0 {
+        return database.VirtualMachine{}, storage.ErrNotFound
     }
 
-    members := GetVmGroupMembers(result.ServerId)
-    result.GroupMembers = members
+    return virtualMachines[0], nil
+}
+
+func (VmRepository) UpdateVMStatusById(ctx context.Context, serverId string, status string) error {
+    ctx, span := tracing.Start(ctx, "repositories.VmRepository.UpdateVMStatusById")
+    defer span.End()
 
-    image := GetImageByImageId(result.ServerImage)
-    result.ImageReadRootPassword = image.ImageReadRootPassword
-    result.ImageDisplayName = image.ImageDisplayName
+    findFilter := bson.M{"server_id": serverId}
+    updateFilter := bson.D{{Key: "$set", Value: bson.M{"server_status": status}}}
 
-    defer cancel()
-    defer db.Disconnect(context)
-    return result, nil
+    vms := database.Collection(database.VmCollection)
+    _, err := vms.UpdateOne(ctx, findFilter, updateFilter)
+
+    return err
 }
 
-func UpdateVMStatusById(id interface{}, status string) bool {
+func (VmRepository) UpdateVm(ctx context.Context, vm database.VirtualMachine) error {
+    ctx, span := tracing.Start(ctx, "repositories.VmRepository.UpdateVm")
+    defer span.End()
 
-    findFilter := bson.M{"server_id": id}
-    updateFilter := bson.D{{"$set", bson.M{"server_status": status}}}
+    findFilter := bson.M{"server_id": vm.ServerId}
+    updateFilter := bson.D{{Key: "$set", Value: bson.M{
+        "server_ip":     vm.ServerIp,
+        "server_image":  vm.ServerImage,
+        "server_name":   vm.ServerName,
+        "server_status": vm.ServerStatus,
+        "course_code":   vm.CourseCode,
+        "target":        vm.Target,
+        "members":       vm.Members,
+    }}}
 
-    db, context, cancel := database.GetClient()
-    vms := db.Database(database.DefaultDB).Collection(database.VmCollection)
-    _, err := vms.UpdateMany(context, findFilter, updateFilter)
+    vms := database.Collection(database.VmCollection)
+    res, err := vms.UpdateOne(ctx, findFilter, updateFilter)
 
     if err != nil {
-        log.Println("Result: ", err)
-        defer cancel()
-        defer db.Disconnect(context)
-        return false
+        return err
+    }
+    if res.MatchedCount == 0 {
+        return storage.ErrNotFound
     }
 
-    defer cancel()
-    defer db.Disconnect(context)
-
-    return true
+    return nil
 }
 
-func DeleteVMById(id interface{}) (r int, error error) {
+func (VmRepository) RenewVmLease(ctx context.Context, serverId string, expires time.Time) error {
+    ctx, span := tracing.Start(ctx, "repositories.VmRepository.RenewVmLease")
+    defer span.End()
 
-    filter := bson.M{"server_id": bson.M{"$eq": id}}
+    findFilter := bson.M{"server_id": serverId}
+    updateFilter := bson.D{{Key: "$set", Value: bson.M{"lease_expires": expires, "lease_notice": ""}}}
 
-    db, context, cancel := database.GetClient()
-    vms := db.Database(database.DefaultDB).Collection(database.VmCollection)
-    res, err := vms.DeleteMany(context, filter)
+    vms := database.Collection(database.VmCollection)
+    res, err := vms.UpdateOne(ctx, findFilter, updateFilter)
 
     if err != nil {
-        defer cancel()
-        defer db.Disconnect(context)
-        return 0, err
+        return err
+    }
+    if res.MatchedCount == 0 {
+        return storage.ErrNotFound
     }
 
-    defer cancel()
-    defer db.Disconnect(context)
-    return int(res.DeletedCount), nil
+    return nil
 }
 
-func GetVmGroupMembers(server_id string) []string {
-    var virtualMachines []*database.VirtualMachine
-    findOptions := options.Find()
+func (VmRepository) ClaimVmLeaseNotice(ctx context.Context, serverId string, from string, to string) (bool, error) {
+    ctx, span := tracing.Start(ctx, "repositories.VmRepository.ClaimVmLeaseNotice")
+    defer span.End()
+
+    findFilter := bson.M{"server_id": serverId, "lease_notice": from}
+    if len(from) == 0 {
+        // Documents from before leases existed have no lease_notice.
+        findFilter["lease_notice"] = bson.M{"$in": bson.A{nil, ""}}
+    }
+    updateFilter := bson.D{{Key: "$set", Value: bson.M{"lease_notice": to}}}
 
-    db, context, cancel := database.GetClient()
-    vms := db.Database(database.DefaultDB).Collection(database.VmCollection)
-    cur, err := vms.Find(context, bson.D{{"server_id", server_id}}, findOptions)
+    vms := database.Collection(database.VmCollection)
+    res, err := vms.UpdateOne(ctx, findFilter, updateFilter)
 
     if err != nil {
-        defer cancel()
-        defer db.Disconnect(context)
-        defer cur.Close(context)
-        return nil
+        return false, err
     }
 
-    for cur.Next(context) {
-        var elem database.VirtualMachine
-        err := cur.Decode(&elem)
+    return res.ModifiedCount == 1, nil
+}
+
+// membersUpdateAttempts bounds how often UpdateVmMembers retries when the members changed under it.
+const membersUpdateAttempts = 5
+
+func (VmRepository) UpdateVmMembers(ctx context.Context, serverId string, update storage.MembersUpdate) ([]database.VirtualMachineMember, error) {
+    ctx, span := tracing.Start(ctx, "repositories.VmRepository.UpdateVmMembers")
+    defer span.End()
+
+    vms := database.Collection(database.VmCollection)
+
+    for attempt := 0; attempt < membersUpdateAttempts; attempt++ {
+        var current database.VirtualMachine
+        err := vms.FindOne(ctx, bson.M{"server_id": serverId}).Decode(&current)
+        if err == mongo.ErrNoDocuments {
+            return nil, storage.ErrNotFound
+        }
         if err != nil {
-            fmt.Println("An error occurred while reading data!")
-            defer cancel()
-            defer db.Disconnect(context)
-            defer cur.Close(context)
-            return nil
+            return nil, err
         }
 
-        virtualMachines = append(virtualMachines, &elem)
-    }
+        members, err := update(current.Members)
+        if err != nil {
+            return nil, err
+        }
+
+        // The members are only replaced when nobody changed them since they were read.
+        findFilter := bson.M{"server_id": serverId, "members": current.Members}
+        updateFilter := bson.D{{Key: "$set", Value: bson.M{"members": members}}}
 
-    var members []string
-    for _, v := range virtualMachines {
-        userName := ""
-        if strings.Contains(v.UserId, "@uia.no") {
-            userName = strings.Replace(v.UserId, "@uia.no", "", -1)
-        } else if strings.Contains(v.UserId, "@student.uia.no") {
-            userName = strings.Replace(v.UserId, "@student.uia.no", "", -1)
+        res, err := vms.UpdateOne(ctx, findFilter, updateFilter)
+        if err != nil {
+            return nil, err
         }
+        if res.MatchedCount == 1 {
+            return members, nil
+        }
+    }
 
-        members = append(members, userName)
+    return nil, storage.ErrConflict
+}
+
+func (VmRepository) DeleteVMById(ctx context.Context, serverId string) (int, error) {
+    ctx, span := tracing.Start(ctx, "repositories.VmRepository.DeleteVMById")
+    defer span.End()
+
+    filter := bson.M{"server_id": bson.M{"$eq": serverId}}
+
+    vms := database.Collection(database.VmCollection)
+    res, err := vms.DeleteMany(ctx, filter)
+
+    if err != nil {
+        return 0, err
     }
 
-    defer cancel()
-    defer db.Disconnect(context)
-    defer cur.Close(context)
-    return members
+    return int(res.DeletedCount), nil
 }
 
-func CheckIfOwnsVm(server_id interface{}, userId string) bool {
-    var result database.VirtualMachine
-    db, context, cancel := database.GetClient()
-    vms := db.Database(database.DefaultDB).Collection(database.VmCollection)
-    err := vms.FindOne(context, bson.D{
-        {"server_id", server_id},
-        {"user_id", userId},
-    }).Decode(&result)
+func (VmRepository) CheckIfOwnsVm(ctx context.Context, serverId string, userId string) (bool, error) {
+    ctx, span := tracing.Start(ctx, "repositories.VmRepository.CheckIfOwnsVm")
+    defer span.End()
+
+    vms := database.Collection(database.VmCollection)
+    count, err := vms.CountDocuments(ctx, bson.M{
+        "server_id":       serverId,
+        "members.user_id": userId,
+    }, options.Count().SetLimit(1))
 
     if err != nil {
-        defer cancel()
-        defer db.Disconnect(context)
-        return false
+        return false, err
     }
 
-    defer cancel()
-    defer db.Disconnect(context)
-    return true
-}
\ No newline at end of file
+    return count > 0, nil
+}
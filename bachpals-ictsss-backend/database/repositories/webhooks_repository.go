@@ -0,0 +1,280 @@
+package repositories
+
+import (
+    "context"
+    "gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/database"
+    "gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/storage"
+    "gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/tracing"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+    "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
+    "time"
+)
+
+// WebhookRepository stores webhook subscriptions and their deliveries in MongoDB.
+type WebhookRepository struct{}
+
+func (WebhookRepository) InsertWebhook(ctx context.Context, webhook database.WebhookSubscription) (string, error) {
+    ctx, span := tracing.Start(ctx, "repositories.WebhookRepository.InsertWebhook")
+    defer span.End()
+
+    created := webhook.Created
+    if created.IsZero() {
+        created = time.Now()
+    }
+
+    document := bson.D{
+        {Key: "url", Value: webhook.Url},
+        {Key: "secret", Value: webhook.Secret},
+        {Key: "description", Value: webhook.Description},
+        {Key: "events", Value: webhook.Events},
+        {Key: "enabled", Value: webhook.Enabled},
+        {Key: "created_by", Value: webhook.CreatedBy},
+        {Key: "created", Value: created},
+    }
+
+    collection := database.Collection(database.WebhooksCollection)
+    inserted, err := collection.InsertOne(ctx, document)
+    if err != nil {
+        return "", err
+    }
+
+    return InsertedId(inserted), nil
+}
+
+func findWebhooks(ctx context.Context, filter bson.M) ([]*database.WebhookSubscription, error) {
+    collection := database.Collection(database.WebhooksCollection)
+    cursor, err := collection.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+    if err != nil {
+        return nil, err
+    }
+    defer cursor.Close(ctx)
+
+    webhooks := []*database.WebhookSubscription{}
+    for cursor.Next(ctx) {
+        var elem database.WebhookSubscription
+        if err := cursor.Decode(&elem); err != nil {
+            return nil, err
+        }
+
+        webhooks = append(webhooks, &elem)
+    }
+
+    return webhooks, cursor.Err()
+}
+
+func (WebhookRepository) GetWebhooks(ctx context.Context) ([]*database.WebhookSubscription, error) {
+    ctx, span := tracing.Start(ctx, "repositories.WebhookRepository.GetWebhooks")
+    defer span.End()
+
+    return findWebhooks(ctx, bson.M{})
+}
+
+func (WebhookRepository) GetWebhooksForEvent(ctx context.Context, event string) ([]*database.WebhookSubscription, error) {
+    ctx, span := tracing.Start(ctx, "repositories.WebhookRepository.GetWebhooksForEvent")
+    defer span.End()
+
+    return findWebhooks(ctx, bson.M{
+        "enabled": true,
+        "$or": bson.A{
+            bson.M{"events": event},
+            bson.M{"events": bson.A{}},
+            bson.M{"events": nil},
+        },
+    })
+}
+
+func (WebhookRepository) GetWebhookById(ctx context.Context, id string) (*database.WebhookSubscription, error) {
+    ctx, span := tracing.Start(ctx, "repositories.WebhookRepository.GetWebhookById")
+    defer span.End()
+
+    documentId, err := primitive.ObjectIDFromHex(id)
+    if err != nil {
+        return nil, storage.ErrNotFound
+    }
+
+    var webhook database.WebhookSubscription
+    collection := database.Collection(database.WebhooksCollection)
+    err = collection.FindOne(ctx, bson.M{"_id": documentId}).Decode(&webhook)
+
+    if err == mongo.ErrNoDocuments {
+        return nil, storage.ErrNotFound
+    }
+    if err != nil {
+        return nil, err
+    }
+
+    return &webhook, nil
+}
+
+func (WebhookRepository) UpdateWebhookById(ctx context.Context, webhook database.WebhookSubscription) error {
+    ctx, span := tracing.Start(ctx, "repositories.WebhookRepository.UpdateWebhookById")
+    defer span.End()
+
+    documentId, err := primitive.ObjectIDFromHex(webhook.Id)
+    if err != nil {
+        return storage.ErrNotFound
+    }
+
+    updateFilter := bson.M{"$set": bson.D{
+        {Key: "url", Value: webhook.Url},
+        {Key: "secret", Value: webhook.Secret},
+        {Key: "description", Value: webhook.Description},
+        {Key: "events", Value: webhook.Events},
+        {Key: "enabled", Value: webhook.Enabled},
+    }}
+
+    collection := database.Collection(database.WebhooksCollection)
+    res, err := collection.UpdateOne(ctx, bson.M{"_id": documentId}, updateFilter)
+    if err != nil {
+        return err
+    }
+
+    if res.MatchedCount == 0 {
+        return storage.ErrNotFound
+    }
+
+    return nil
+}
+
+func (WebhookRepository) DeleteWebhookById(ctx context.Context, id string) error {
+    ctx, span := tracing.Start(ctx, "repositories.WebhookRepository.DeleteWebhookById")
+    defer span.End()
+
+    documentId, err := primitive.ObjectIDFromHex(id)
+    if err != nil {
+        return storage.ErrNotFound
+    }
+
+    collection := database.Collection(database.WebhooksCollection)
+    res, err := collection.DeleteOne(ctx, bson.M{"_id": documentId})
+    if err != nil {
+        return err
+    }
+
+    if res.DeletedCount == 0 {
+        return storage.ErrNotFound
+    }
+
+    return nil
+}
+
+func (WebhookRepository) InsertWebhookDelivery(ctx context.Context, delivery database.WebhookDelivery) error {
+    ctx, span := tracing.Start(ctx, "repositories.WebhookRepository.InsertWebhookDelivery")
+    defer span.End()
+
+    collection := database.Collection(database.WebhookDeliveriesCollection)
+    _, err := collection.InsertOne(ctx, delivery)
+
+    return err
+}
+
+func (WebhookRepository) GetWebhookDeliveries(ctx context.Context, status string, limit int) ([]*database.WebhookDelivery, error) {
+    ctx, span := tracing.Start(ctx, "repositories.WebhookRepository.GetWebhookDeliveries")
+    defer span.End()
+
+    opts := options.Find().SetSort(bson.D{{Key: "created", Value: -1}, {Key: "_id", Value: -1}}).SetLimit(int64(limit))
+
+    collection := database.Collection(database.WebhookDeliveriesCollection)
+    cursor, err := collection.Find(ctx, bson.M{"status": status}, opts)
+    if err != nil {
+        return nil, err
+    }
+    defer cursor.Close(ctx)
+
+    deliveries := []*database.WebhookDelivery{}
+    for cursor.Next(ctx) {
+        var elem database.WebhookDelivery
+        if err := cursor.Decode(&elem); err != nil {
+            return nil, err
+        }
+
+        deliveries = append(deliveries, &elem)
+    }
+
+    return deliveries, cursor.Err()
+}
+
+func (WebhookRepository) ClaimDueWebhookDelivery(ctx context.Context, now time.Time, lease time.Duration) (*database.WebhookDelivery, error) {
+    ctx, span := tracing.Start(ctx, "repositories.WebhookRepository.ClaimDueWebhookDelivery")
+    defer span.End()
+
+    // A single update so a second instance sees it taken.
+    filter := bson.M{
+        "status":       bson.M{"$in": bson.A{database.WebhookDeliveryPending, database.WebhookDeliverySending}},
+        "next_attempt": bson.M{"$lte": now},
+    }
+    update := bson.M{"$set": bson.M{
+        "status":       database.WebhookDeliverySending,
+        "next_attempt": now.Add(lease),
+    }}
+    opts := options.FindOneAndUpdate().SetSort(bson.D{{Key: "next_attempt", Value: 1}}).SetReturnDocument(options.After)
+
+    var delivery database.WebhookDelivery
+    collection := database.Collection(database.WebhookDeliveriesCollection)
+    err := collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&delivery)
+
+    if err == mongo.ErrNoDocuments {
+        return nil, storage.ErrNotFound
+    }
+    if err != nil {
+        return nil, err
+    }
+
+    return &delivery, nil
+}
+
+func (WebhookRepository) GetWebhookDeliveryById(ctx context.Context, id string) (*database.WebhookDelivery, error) {
+    ctx, span := tracing.Start(ctx, "repositories.WebhookRepository.GetWebhookDeliveryById")
+    defer span.End()
+
+    documentId, err := primitive.ObjectIDFromHex(id)
+    if err != nil {
+        return nil, storage.ErrNotFound
+    }
+
+    var delivery database.WebhookDelivery
+    collection := database.Collection(database.WebhookDeliveriesCollection)
+    err = collection.FindOne(ctx, bson.M{"_id": documentId}).Decode(&delivery)
+
+    if err == mongo.ErrNoDocuments {
+        return nil, storage.ErrNotFound
+    }
+    if err != nil {
+        return nil, err
+    }
+
+    return &delivery, nil
+}
+
+func (WebhookRepository) UpdateWebhookDelivery(ctx context.Context, delivery database.WebhookDelivery) error {
+    ctx, span := tracing.Start(ctx, "repositories.WebhookRepository.UpdateWebhookDelivery")
+    defer span.End()
+
+    documentId, err := primitive.ObjectIDFromHex(delivery.Id)
+    if err != nil {
+        return storage.ErrNotFound
+    }
+
+    updateFilter := bson.M{"$set": bson.M{
+        "status":           delivery.Status,
+        "attempts":         delivery.Attempts,
+        "last_error":       delivery.LastError,
+        "last_status_code": delivery.LastStatusCode,
+        "next_attempt":     delivery.NextAttempt,
+        "delivered":        delivery.Delivered,
+    }}
+
+    collection := database.Collection(database.WebhookDeliveriesCollection)
+    res, err := collection.UpdateOne(ctx, bson.M{"_id": documentId}, updateFilter)
+    if err != nil {
+        return err
+    }
+
+    if res.MatchedCount == 0 {
+        return storage.ErrNotFound
+    }
+
+    return nil
+}
@@ -0,0 +1,234 @@
+package gopher
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/utils/openstack/clientconfig"
+	"github.com/spf13/viper"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+)
+
+// Auth methods, by the settings they are picked from.
+const (
+	AuthPassword              = "password"
+	AuthApplicationCredential = "application_credential"
+	AuthToken                 = "token"
+)
+
+// Config is how the service signs in to OpenStack and reaches its endpoints.
+type Config struct {
+	// Method is the auth method, Cloud the clouds.yaml profile it was read from.
+	Method   string
+	Cloud    string
+	Auth     gophercloud.AuthOptions
+	Endpoint gophercloud.EndpointOpts
+	TLS      *tls.Config
+	// Targets are where virtual machines are created, see loadTargets.
+	Targets   []Target
+	Placement PlacementPolicy
+}
+
+// tlsFiles are the TLS settings of a cloud, each is replaced by its IKT_STACK_ variable when set.
+type tlsFiles struct {
+	CAFile   string
+	CertFile string
+	KeyFile  string
+	Insecure bool
+}
+
+// LoadConfig reads and validates the OpenStack settings. A cloud named by
+// IKT_STACK_OPENSTACK_CLOUD is read from clouds.yaml, found like the openstack cli does.
+// Otherwise the IKT_STACK_ variables give the identity endpoint and one of an application
+// credential, a token, or a user name and password. The targets are read last, see loadTargets.
+func LoadConfig() (Config, error) {
+	var config Config
+	var files tlsFiles
+	var err error
+
+	if cloud := viper.GetString("IKT_STACK_OPENSTACK_CLOUD"); len(cloud) > 0 {
+		config, files, err = cloudConfig(cloud)
+	} else {
+		config, err = environmentConfig()
+	}
+	if err != nil {
+		return config, err
+	}
+
+	if _, err := url.ParseRequestURI(config.Auth.IdentityEndpoint); err != nil {
+		return config, fmt.Errorf("identity endpoint %q is not a url", config.Auth.IdentityEndpoint)
+	}
+
+	// A token can not be renewed, the service stops working when it expires.
+	config.Auth.AllowReauth = config.Method != AuthToken
+
+	if config.TLS, err = tlsConfig(files); err != nil {
+		return config, err
+	}
+
+	err = loadTargets(&config)
+	return config, err
+}
+
+func environmentConfig() (Config, error) {
+	config := Config{
+		Auth: gophercloud.AuthOptions{IdentityEndpoint: viper.GetString("IKT_STACK_IDENTITY_ENDPOINT")},
+		Endpoint: gophercloud.EndpointOpts{
+			Region:       viper.GetString("IKT_STACK_REGION"),
+			Availability: gophercloud.Availability(viper.GetString("IKT_STACK_OPENSTACK_INTERFACE")),
+		},
+	}
+	if len(config.Auth.IdentityEndpoint) == 0 {
+		return config, errors.New("IKT_STACK_IDENTITY_ENDPOINT is not set")
+	}
+	if len(config.Endpoint.Availability) == 0 {
+		config.Endpoint.Availability = gophercloud.AvailabilityPublic
+	}
+
+	credentialId := viper.GetString("IKT_STACK_APPLICATION_CREDENTIAL_ID")
+	credentialName := viper.GetString("IKT_STACK_APPLICATION_CREDENTIAL_NAME")
+	token := viper.GetString("IKT_STACK_TOKEN")
+
+	switch {
+	case len(credentialId) > 0 || len(credentialName) > 0:
+		// An application credential is scoped to its project, a project or domain may not be given with it.
+		config.Method = AuthApplicationCredential
+		config.Auth.ApplicationCredentialID = credentialId
+		config.Auth.ApplicationCredentialName = credentialName
+		config.Auth.ApplicationCredentialSecret = viper.GetString("IKT_STACK_APPLICATION_CREDENTIAL_SECRET")
+		if len(config.Auth.ApplicationCredentialSecret) == 0 {
+			return config, errors.New("IKT_STACK_APPLICATION_CREDENTIAL_SECRET is not set")
+		}
+		if len(credentialId) == 0 {
+			// A credential is found by name within its user.
+			config.Auth.Username = viper.GetString("IKT_STACK_USERNAME")
+			config.Auth.DomainName = viper.GetString("IKT_STACK_DOMAIN_NAME")
+			if len(config.Auth.Username) == 0 || len(config.Auth.DomainName) == 0 {
+				return config, errors.New("IKT_STACK_APPLICATION_CREDENTIAL_NAME needs IKT_STACK_USERNAME and IKT_STACK_DOMAIN_NAME")
+			}
+		}
+	case len(token) > 0:
+		config.Method = AuthToken
+		config.Auth.TokenID = token
+	default:
+		config.Method = AuthPassword
+		config.Auth.Username = viper.GetString("IKT_STACK_USERNAME")
+		config.Auth.Password = viper.GetString("IKT_STACK_PASSWORD")
+		config.Auth.DomainName = viper.GetString("IKT_STACK_DOMAIN_NAME")
+		config.Auth.TenantName = viper.GetString("IKT_STACK_TENANT_NAME")
+		for _, key := range []string{"IKT_STACK_USERNAME", "IKT_STACK_PASSWORD", "IKT_STACK_DOMAIN_NAME"} {
+			if len(viper.GetString(key)) == 0 {
+				return config, fmt.Errorf("%s is not set, or set an application credential, a token or IKT_STACK_OPENSTACK_CLOUD", key)
+			}
+		}
+	}
+
+	return config, nil
+}
+
+func cloudConfig(name string) (Config, tlsFiles, error) {
+	opts := &clientconfig.ClientOpts{Cloud: name}
+
+	cloud, err := clientconfig.GetCloudFromYAML(opts)
+	if err != nil {
+		return Config{}, tlsFiles{}, fmt.Errorf("cloud %q: %w", name, err)
+	}
+
+	auth, err := clientconfig.AuthOptions(opts)
+	if err != nil {
+		return Config{}, tlsFiles{}, fmt.Errorf("cloud %q: %w", name, err)
+	}
+
+	config := Config{Cloud: name, Auth: *auth}
+	files := tlsFiles{CAFile: cloud.CACertFile, CertFile: cloud.ClientCertFile, KeyFile: cloud.ClientKeyFile}
+	if cloud.Verify != nil {
+		files.Insecure = !*cloud.Verify
+	}
+
+	switch {
+	case len(auth.ApplicationCredentialID) > 0 || len(auth.ApplicationCredentialName) > 0:
+		config.Method = AuthApplicationCredential
+	case len(auth.TokenID) > 0:
+		config.Method = AuthToken
+	case len(auth.Password) > 0:
+		config.Method = AuthPassword
+	default:
+		return config, files, fmt.Errorf("cloud %q has no password, application credential or token", name)
+	}
+
+	config.Endpoint.Region = cloud.RegionName
+	if region := viper.GetString("IKT_STACK_REGION"); len(region) > 0 {
+		config.Endpoint.Region = region
+	}
+	config.Endpoint.Availability = gophercloud.AvailabilityPublic
+	if v := viper.GetString("IKT_STACK_OPENSTACK_INTERFACE"); len(v) > 0 {
+		config.Endpoint.Availability = gophercloud.Availability(v)
+	} else if v := cloud.EndpointType; len(v) > 0 {
+		config.Endpoint.Availability = gophercloud.Availability(v)
+	} else if v := cloud.Interface; len(v) > 0 {
+		config.Endpoint.Availability = gophercloud.Availability(v)
+	}
+
+	return config, files, nil
+}
+
+// tlsConfig adds the CA bundle and client certificate of IKT_STACK_OPENSTACK_CA_FILE,
+// _CERT_FILE and _KEY_FILE, and turns verification off for IKT_STACK_OPENSTACK_INSECURE.
+// Those that are not set are taken from files, the settings of the cloud.
+func tlsConfig(files tlsFiles) (*tls.Config, error) {
+	for key, value := range map[string]*string{
+		"IKT_STACK_OPENSTACK_CA_FILE":   &files.CAFile,
+		"IKT_STACK_OPENSTACK_CERT_FILE": &files.CertFile,
+		"IKT_STACK_OPENSTACK_KEY_FILE":  &files.KeyFile,
+	} {
+		if v := viper.GetString(key); len(v) > 0 {
+			*value = v
+		}
+	}
+	if viper.IsSet("IKT_STACK_OPENSTACK_INSECURE") {
+		files.Insecure = viper.GetBool("IKT_STACK_OPENSTACK_INSECURE")
+	}
+
+	config := &tls.Config{MinVersion: tls.VersionTLS12, InsecureSkipVerify: files.Insecure}
+
+	if file := files.CAFile; len(file) > 0 {
+		pem, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("ca bundle: %w", err)
+		}
+
+		// The bundle is added to the system roots, so public endpoints keep working.
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("ca bundle: no certificates in %s", file)
+		}
+		config.RootCAs = pool
+	}
+
+	if len(files.CertFile) > 0 || len(files.KeyFile) > 0 {
+		if len(files.CertFile) == 0 || len(files.KeyFile) == 0 {
+			return nil, errors.New("a client certificate needs both IKT_STACK_OPENSTACK_CERT_FILE and IKT_STACK_OPENSTACK_KEY_FILE")
+		}
+		certificate, err := tls.LoadX509KeyPair(files.CertFile, files.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+
+	return config, nil
+}
+
+// transport is the http transport for the calls to OpenStack with the TLS settings of config.
+func (c Config) transport() http.RoundTripper {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = c.TLS
+	return transport
+}
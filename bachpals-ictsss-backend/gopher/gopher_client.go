@@ -1,32 +1,304 @@
+// Package gopher holds the OpenStack clients of the service. One provider per target is
+// signed in to Keystone and shared by every request, it renews its token when OpenStack
+// no longer accepts it.
 package gopher
 
 import (
-    "fmt"
-    "github.com/gophercloud/gophercloud"
-    "github.com/gophercloud/gophercloud/openstack"
-    "github.com/spf13/viper"
+	"context"
+	"fmt"
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack"
+	"github.com/gophercloud/gophercloud/openstack/identity/v3/tokens"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/metrics"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/tracing"
+	"net/http"
+	"sync"
+	"time"
 )
 
-func GetClient() *gophercloud.ServiceClient {
-    opts := gophercloud.AuthOptions{
-        IdentityEndpoint: viper.GetString("IKT_STACK_IDENTITY_ENDPOINT"),
-        Username:         viper.GetString("IKT_STACK_USERNAME"),
-        Password:         viper.GetString("IKT_STACK_PASSWORD"),
-        DomainName:       viper.GetString("IKT_STACK_DOMAIN_NAME"),
-        TenantName:       viper.GetString("IKT_STACK_TENANT_NAME"),
-    }
-
-    provider, err := openstack.AuthenticatedClient(opts)
-    if err != nil {
-        fmt.Println("Provider err: ", err)
-    }
-
-    opts1 := gophercloud.EndpointOpts{Region: viper.GetString("IKT_STACK_REGION")}
-
-    client, err := openstack.NewComputeV2(provider, opts1)
-    if err != nil {
-        fmt.Println("Client err: ", err)
-    }
-
-    return client
-}
\ No newline at end of file
+// requestTimeout bounds every call to OpenStack, authentication included, so a hanging
+// Keystone can not block the requests waiting for the shared provider.
+const requestTimeout = 2 * time.Minute
+
+var (
+	mu       sync.Mutex
+	config   *Config
+	shared   = map[string]*gophercloud.ProviderClient{}
+	services = map[string]*gophercloud.ServiceClient{}
+	// signIns serializes signing in per target, mu is not held while Keystone answers.
+	signIns = map[string]*sync.Mutex{}
+)
+
+// Configure loads and validates the OpenStack settings, the server calls it at startup so
+// a wrong setting stops it before it serves. Commands that skip it load them on first use.
+func Configure() (Config, error) {
+	loaded, err := LoadConfig()
+	if err != nil {
+		return loaded, err
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	config = &loaded
+	return loaded, nil
+}
+
+// currentConfig returns the configuration, loading it when Configure has not been called. mu is held.
+func currentConfig() (Config, error) {
+	if config == nil {
+		loaded, err := LoadConfig()
+		if err != nil {
+			return loaded, err
+		}
+		config = &loaded
+	}
+	return *config, nil
+}
+
+// Targets returns the configured targets, the first one is the default.
+func Targets() ([]Target, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	current, err := currentConfig()
+	if err != nil {
+		return nil, fmt.Errorf("openstack configuration: %w", err)
+	}
+	return current.Targets, nil
+}
+
+// TargetByName returns the target called name, the first one when name is empty.
+func TargetByName(name string) (Target, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	current, err := currentConfig()
+	if err != nil {
+		return Target{}, fmt.Errorf("openstack configuration: %w", err)
+	}
+	return current.target(name)
+}
+
+// Policy returns the rules that choose the target of a new virtual machine.
+func Policy() (PlacementPolicy, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	current, err := currentConfig()
+	if err != nil {
+		return PlacementPolicy{}, fmt.Errorf("openstack configuration: %w", err)
+	}
+	return current.Placement, nil
+}
+
+// resolveProvider returns the target called name with its configuration, its provider
+// when it is signed in already, and the lock to hold while signing in to it.
+func resolveProvider(name string) (*gophercloud.ProviderClient, Target, Config, *sync.Mutex, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	base, err := currentConfig()
+	if err != nil {
+		return nil, Target{}, base, nil, fmt.Errorf("openstack configuration: %w", err)
+	}
+
+	target, err := base.target(name)
+	if err != nil {
+		return nil, target, base, nil, err
+	}
+
+	current, err := base.forTarget(target)
+	if err != nil {
+		return nil, target, current, nil, err
+	}
+
+	signIn, ok := signIns[target.Name]
+	if !ok {
+		signIn = &sync.Mutex{}
+		signIns[target.Name] = signIn
+	}
+
+	return shared[target.Name], target, current, signIn, nil
+}
+
+// sharedProvider signs in to a target on first use and returns its provider with the
+// configuration it signed in with. A failed sign in is not kept, the next call tries again.
+// Only calls for the same target wait while Keystone is asked.
+func sharedProvider(name string) (*gophercloud.ProviderClient, Target, Config, error) {
+	provider, target, current, signIn, err := resolveProvider(name)
+	if err != nil || provider != nil {
+		return provider, target, current, err
+	}
+
+	signIn.Lock()
+	defer signIn.Unlock()
+
+	// Another call may have signed in while this one waited.
+	mu.Lock()
+	provider, ok := shared[target.Name]
+	mu.Unlock()
+	if ok {
+		return provider, target, current, nil
+	}
+
+	provider, err = openstack.NewClient(current.Auth.IdentityEndpoint)
+	if err != nil {
+		return nil, target, current, fmt.Errorf("openstack identity endpoint: %w", err)
+	}
+
+	// Every call is traced and goes through the metrics transport, including authentication.
+	provider.HTTPClient = http.Client{Transport: tracing.OpenStackTransport(metrics.OpenStackTransport(current.transport())), Timeout: requestTimeout}
+	provider.UseTokenLock()
+
+	// The provider has no context, signing in again must not depend on the request that happened to sign in first.
+	if err := openstack.Authenticate(provider, current.Auth); err != nil {
+		return nil, target, current, fmt.Errorf("openstack authentication (%s, target %s): %w", current.Method, target.Name, err)
+	}
+
+	mu.Lock()
+	shared[target.Name] = provider
+	mu.Unlock()
+	return provider, target, current, nil
+}
+
+// bind returns a provider that makes its calls with ctx, so their spans belong to the
+// request or job that made them. It uses the token of the shared provider and renews it
+// through the shared provider, so every request sees the new token.
+func bind(ctx context.Context, provider *gophercloud.ProviderClient) *gophercloud.ProviderClient {
+	bound := &gophercloud.ProviderClient{
+		IdentityBase:     provider.IdentityBase,
+		IdentityEndpoint: provider.IdentityEndpoint,
+		EndpointLocator:  provider.EndpointLocator,
+		HTTPClient:       provider.HTTPClient,
+		UserAgent:        provider.UserAgent,
+		Context:          ctx,
+	}
+	bound.UseTokenLock()
+	bound.CopyTokenFrom(provider)
+
+	bound.ReauthFunc = func() error {
+		if err := provider.Reauthenticate(bound.Token()); err != nil {
+			return err
+		}
+		bound.CopyTokenFrom(provider)
+		return nil
+	}
+
+	return bound
+}
+
+// Provider returns the signed in provider of a target for calls made with ctx, the
+// default target when target is empty.
+func Provider(ctx context.Context, target string) (*gophercloud.ProviderClient, error) {
+	provider, _, _, err := sharedProvider(target)
+	if err != nil {
+		return nil, err
+	}
+	return bind(ctx, provider), nil
+}
+
+// ProjectId returns the id of the project a target is signed in to, for the apis that
+// take it in the path like the Neutron quotas.
+func ProjectId(target string) (string, error) {
+	provider, resolved, _, err := sharedProvider(target)
+	if err != nil {
+		return "", err
+	}
+
+	// Both the result of signing in and of signing in again with a token have the project.
+	result, ok := provider.GetAuthResult().(interface {
+		ExtractProject() (*tokens.Project, error)
+	})
+	if !ok {
+		return "", fmt.Errorf("openstack target %s: keystone did not return the project", resolved.Name)
+	}
+
+	project, err := result.ExtractProject()
+	if err != nil {
+		return "", fmt.Errorf("openstack target %s: %w", resolved.Name, err)
+	}
+	if project == nil || len(project.ID) == 0 {
+		return "", fmt.Errorf("openstack target %s: the token is not scoped to a project", resolved.Name)
+	}
+	return project.ID, nil
+}
+
+type newServiceClient func(*gophercloud.ProviderClient, gophercloud.EndpointOpts) (*gophercloud.ServiceClient, error)
+
+// serviceClient looks up the endpoint of a service of a target once and returns a client
+// for it bound to ctx.
+func serviceClient(ctx context.Context, target string, name string, create newServiceClient) (*gophercloud.ServiceClient, error) {
+	provider, resolved, current, err := sharedProvider(target)
+	if err != nil {
+		return nil, err
+	}
+
+	key := resolved.Name + "/" + name
+	mu.Lock()
+	client, ok := services[key]
+	if !ok {
+		client, err = create(provider, current.Endpoint)
+		if err == nil {
+			services[key] = client
+		}
+	}
+	mu.Unlock()
+
+	if err != nil {
+		return nil, fmt.Errorf("openstack %s endpoint of target %s: %w", name, resolved.Name, err)
+	}
+
+	bound := *client
+	bound.ProviderClient = bind(ctx, provider)
+	return &bound, nil
+}
+
+// Compute is the Nova client, for servers, flavors, images and floating ips.
+func Compute(ctx context.Context, target string) (*gophercloud.ServiceClient, error) {
+	return serviceClient(ctx, target, "compute", openstack.NewComputeV2)
+}
+
+// Image is the Glance client.
+func Image(ctx context.Context, target string) (*gophercloud.ServiceClient, error) {
+	return serviceClient(ctx, target, "image", openstack.NewImageServiceV2)
+}
+
+// Network is the Neutron client.
+func Network(ctx context.Context, target string) (*gophercloud.ServiceClient, error) {
+	return serviceClient(ctx, target, "network", openstack.NewNetworkV2)
+}
+
+// BlockStorage is the Cinder client.
+func BlockStorage(ctx context.Context, target string) (*gophercloud.ServiceClient, error) {
+	return serviceClient(ctx, target, "block storage", openstack.NewBlockStorageV3)
+}
+
+// Placement is the Placement client, for resource providers and usages.
+func Placement(ctx context.Context, target string) (*gophercloud.ServiceClient, error) {
+	return serviceClient(ctx, target, "placement", openstack.NewPlacementV1)
+}
+
+// CheckToken asks Keystone whether the token of every target is valid, for the health checks.
+func CheckToken(ctx context.Context) error {
+	targets, err := Targets()
+	if err != nil {
+		return err
+	}
+
+	for _, v := range targets {
+		identity, err := serviceClient(ctx, v.Name, "identity", openstack.NewIdentityV3)
+		if err != nil {
+			return err
+		}
+
+		valid, err := tokens.Validate(identity, identity.Token())
+		if err != nil {
+			return fmt.Errorf("target %s: %w", v.Name, err)
+		}
+		if !valid {
+			return fmt.Errorf("keystone does not accept the token of target %s", v.Name)
+		}
+	}
+	return nil
+}
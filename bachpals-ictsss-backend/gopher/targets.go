@@ -0,0 +1,181 @@
+package gopher
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gophercloud/gophercloud"
+	"github.com/spf13/viper"
+	"io/ioutil"
+)
+
+// DefaultTarget is the name of the target made from the IKT_STACK_ variables when no
+// targets file is configured.
+const DefaultTarget = "default"
+
+// ErrUnknownTarget is returned for a target name that is not configured.
+var ErrUnknownTarget = errors.New("unknown openstack target")
+
+// Target is a project and region virtual machines are created in, with the network,
+// floating ip pool, security group, key pair and flavors used there. Cloud names an entry
+// of clouds.yaml with its own credentials, otherwise the credentials of the service are
+// scoped to Project.
+type Target struct {
+	Name              string `json:"name"`
+	Cloud             string `json:"cloud,omitempty"`
+	Project           string `json:"project,omitempty"`
+	ProjectId         string `json:"project_id,omitempty"`
+	ProjectDomain     string `json:"project_domain,omitempty"`
+	Region            string `json:"region,omitempty"`
+	NetworkId         string `json:"network_id"`
+	FloatingNetworkId string `json:"floating_network_id"`
+	SecurityGroupId   string `json:"security_group_id"`
+	KeyName           string `json:"key_name"`
+	FlavorId          string `json:"flavor_id"`
+	// ImageFlavors gives the images that need another flavor than FlavorId theirs, by image id.
+	ImageFlavors map[string]string `json:"image_flavors,omitempty"`
+}
+
+// Flavor returns the flavor for servers booted from imageId.
+func (t Target) Flavor(imageId string) string {
+	if flavor, ok := t.ImageFlavors[imageId]; ok {
+		return flavor
+	}
+	return t.FlavorId
+}
+
+// PlacementPolicy chooses the target of a new virtual machine. The target of its course is
+// used first, then the target of its image, then the least loaded project when
+// LeastLoaded is set, and the first target otherwise.
+type PlacementPolicy struct {
+	Courses     map[string]string `json:"courses,omitempty"`
+	Images      map[string]string `json:"images,omitempty"`
+	LeastLoaded bool              `json:"least_loaded"`
+}
+
+type targetsFile struct {
+	Targets   []Target        `json:"targets"`
+	Placement PlacementPolicy `json:"placement"`
+}
+
+// environmentTarget is the only target when IKT_STACK_OPENSTACK_TARGETS_FILE is not set,
+// it is the project the service signs in to.
+func environmentTarget() Target {
+	return Target{
+		Name:              DefaultTarget,
+		NetworkId:         viper.GetString("IKT_STACK_VM_NETWORK_ID"),
+		FloatingNetworkId: viper.GetString("IKT_STACK_VM_FLOATING_NETWORK_ID"),
+		SecurityGroupId:   viper.GetString("IKT_STACK_VM_SECURITY_GROUP_ID"),
+		KeyName:           viper.GetString("IKT_STACK_VM_KEY_NAME"),
+		FlavorId:          viper.GetString("IKT_STACK_VM_FLAVOR_ID"),
+	}
+}
+
+// loadTargets reads the targets and placement of IKT_STACK_OPENSTACK_TARGETS_FILE and
+// checks every target can be signed in to with the settings of config.
+func loadTargets(config *Config) error {
+	file := viper.GetString("IKT_STACK_OPENSTACK_TARGETS_FILE")
+	if len(file) == 0 {
+		config.Targets = []Target{environmentTarget()}
+		return nil
+	}
+
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("targets file: %w", err)
+	}
+
+	var parsed targetsFile
+	if err := json.Unmarshal(content, &parsed); err != nil {
+		return fmt.Errorf("targets file %s: %w", file, err)
+	}
+	if len(parsed.Targets) == 0 {
+		return fmt.Errorf("targets file %s has no targets", file)
+	}
+
+	names := map[string]bool{}
+	for _, v := range parsed.Targets {
+		if len(v.Name) == 0 {
+			return fmt.Errorf("targets file %s: a target has no name", file)
+		}
+		if names[v.Name] {
+			return fmt.Errorf("targets file %s: target %q is listed twice", file, v.Name)
+		}
+		names[v.Name] = true
+
+		if len(v.NetworkId) == 0 || len(v.FlavorId) == 0 {
+			return fmt.Errorf("target %q needs a network_id and a flavor_id", v.Name)
+		}
+		if _, err := config.forTarget(v); err != nil {
+			return err
+		}
+	}
+
+	for kind, rules := range map[string]map[string]string{"course": parsed.Placement.Courses, "image": parsed.Placement.Images} {
+		for key, name := range rules {
+			if !names[name] {
+				return fmt.Errorf("placement of %s %s: %w %q", kind, key, ErrUnknownTarget, name)
+			}
+		}
+	}
+
+	config.Targets = parsed.Targets
+	config.Placement = parsed.Placement
+	return nil
+}
+
+// target returns the target called name, the first one when name is empty. Virtual
+// machines stored before targets were configured have no target and belong to the first.
+func (c Config) target(name string) (Target, error) {
+	if len(name) == 0 && len(c.Targets) > 0 {
+		return c.Targets[0], nil
+	}
+	for _, v := range c.Targets {
+		if v.Name == name {
+			return v, nil
+		}
+	}
+	return Target{}, fmt.Errorf("%w %q", ErrUnknownTarget, name)
+}
+
+// forTarget returns the configuration that signs in to the project and region of target.
+func (c Config) forTarget(target Target) (Config, error) {
+	config := c
+	if len(target.Cloud) > 0 {
+		cloud, files, err := cloudConfig(target.Cloud)
+		if err != nil {
+			return config, fmt.Errorf("target %q: %w", target.Name, err)
+		}
+		if cloud.TLS, err = tlsConfig(files); err != nil {
+			return config, fmt.Errorf("target %q: %w", target.Name, err)
+		}
+		cloud.Auth.AllowReauth = cloud.Method != AuthToken
+		cloud.Targets, cloud.Placement = c.Targets, c.Placement
+		config = cloud
+	}
+
+	if len(target.Project) > 0 || len(target.ProjectId) > 0 {
+		// An application credential can only sign in to the project it was made in.
+		if config.Method == AuthApplicationCredential {
+			return config, fmt.Errorf("target %q: an application credential is bound to its project, give the target its own cloud", target.Name)
+		}
+
+		scope := &gophercloud.AuthScope{ProjectID: target.ProjectId}
+		if len(target.ProjectId) == 0 {
+			scope.ProjectName = target.Project
+			scope.DomainName = target.ProjectDomain
+			if len(scope.DomainName) == 0 {
+				scope.DomainName = config.Auth.DomainName
+			}
+			if len(scope.DomainName) == 0 {
+				return config, fmt.Errorf("target %q needs a project_domain", target.Name)
+			}
+		}
+		config.Auth.Scope = scope
+	}
+
+	if len(target.Region) > 0 {
+		config.Endpoint.Region = target.Region
+	}
+	return config, nil
+}
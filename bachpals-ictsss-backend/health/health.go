@@ -0,0 +1,178 @@
+// Package health runs the checks of the dependencies of the api, storage, Keystone, Canvas
+// and the template directories, and serves them on /healthz and /readyz. Results are
+// cached so probes do not put load on the dependencies.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// defaultCacheDuration is how long a result is reused when IKT_STACK_HEALTH_CACHE_SECONDS is not set.
+const defaultCacheDuration = 10 * time.Second
+
+// checkTimeout bounds one run of a check, a dependency that hangs counts as failing.
+const checkTimeout = 5 * time.Second
+
+const (
+	StatusOk      = "ok"
+	StatusFailing = "failing"
+)
+
+// Check is a dependency of the api. The api is not ready while a critical check fails,
+// the others are only reported.
+type Check struct {
+	Name     string
+	Critical bool
+	Run      func(ctx context.Context) error
+}
+
+// Result is the last run of a check, with when it last succeeded and failed.
+type Result struct {
+	Name        string     `json:"name"`
+	Critical    bool       `json:"critical"`
+	Status      string     `json:"status"`
+	LatencyMs   int64      `json:"latency_ms"`
+	CheckedAt   time.Time  `json:"checked_at"`
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+	LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+}
+
+type state struct {
+	mu     sync.Mutex
+	check  Check
+	result Result
+}
+
+// run returns the cached result while it is fresh. Callers that arrive while the check
+// runs wait for it and share its result.
+func (s *state) run(now time.Time) Result {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.result.CheckedAt.IsZero() && now.Sub(s.result.CheckedAt) < cacheDuration() {
+		return s.result
+	}
+
+	// The check does not use the context of the request, a probe that gives up
+	// must not leave a cancelled result in the cache.
+	ctx, cancel := context.WithTimeout(context.Background(), checkTimeout)
+	defer cancel()
+
+	started := time.Now()
+	err := s.check.Run(ctx)
+	checkedAt := time.Now()
+
+	s.result.Name = s.check.Name
+	s.result.Critical = s.check.Critical
+	s.result.LatencyMs = checkedAt.Sub(started).Milliseconds()
+	s.result.CheckedAt = checkedAt
+	if err != nil {
+		s.result.Status = StatusFailing
+		s.result.LastError = err.Error()
+		s.result.LastErrorAt = &checkedAt
+	} else {
+		s.result.Status = StatusOk
+		s.result.LastSuccess = &checkedAt
+	}
+
+	return s.result
+}
+
+var (
+	mu     sync.RWMutex
+	checks = map[string]*state{}
+)
+
+// Register adds checks, a check with the name of one that is registered replaces it.
+func Register(list ...Check) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	for _, v := range list {
+		checks[v.Name] = &state{check: v}
+	}
+}
+
+func cacheDuration() time.Duration {
+	if viper.IsSet("IKT_STACK_HEALTH_CACHE_SECONDS") {
+		return time.Duration(viper.GetInt("IKT_STACK_HEALTH_CACHE_SECONDS")) * time.Second
+	}
+	return defaultCacheDuration
+}
+
+// Results runs the checks that are not cached at the same time, sorted by name.
+func Results() []Result {
+	mu.RLock()
+	list := make([]*state, 0, len(checks))
+	for _, v := range checks {
+		list = append(list, v)
+	}
+	mu.RUnlock()
+
+	now := time.Now()
+	results := make([]Result, len(list))
+
+	var wg sync.WaitGroup
+	for i, v := range list {
+		wg.Add(1)
+		go func(i int, s *state) {
+			defer wg.Done()
+			results[i] = s.run(now)
+		}(i, v)
+	}
+	wg.Wait()
+
+	sort.Slice(results, func(i, j int) bool { return results[i].Name < results[j].Name })
+	return results
+}
+
+// Ready is true when no critical check fails.
+func Ready(results []Result) bool {
+	for _, v := range results {
+		if v.Critical && v.Status != StatusOk {
+			return false
+		}
+	}
+	return true
+}
+
+func writeJson(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// LiveHandler answers as long as the process serves requests, it checks no dependency.
+func LiveHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJson(w, http.StatusOK, map[string]string{"status": StatusOk})
+	})
+}
+
+// ReadyHandler answers 503 while a critical check fails. Only the status of every check
+// is shown, the errors are in the admin status of the api.
+func ReadyHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		results := Results()
+
+		statuses := map[string]string{}
+		for _, v := range results {
+			statuses[v.Name] = v.Status
+		}
+
+		if !Ready(results) {
+			writeJson(w, http.StatusServiceUnavailable, map[string]interface{}{"status": "not_ready", "checks": statuses})
+			return
+		}
+		writeJson(w, http.StatusOK, map[string]interface{}{"status": "ready", "checks": statuses})
+	})
+}
@@ -1,6 +1,12 @@
 package httputils
 
-import "github.com/gin-gonic/gin"
+import (
+	"github.com/gin-gonic/gin"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/apierror"
+)
+
+// RequestIdKey is where the request id middleware stores the id in the gin context.
+const RequestIdKey = "request_id"
 
 type ResponseType struct {
 	StatusCode int         `json:"status_code"`
@@ -8,13 +14,20 @@ type ResponseType struct {
 	Data       interface{} `json:"data"`
 }
 
-func AbortWithStatusJSON(c *gin.Context, statusCode int, message string, data interface{}) {
-	responseStruct := ResponseType{
-		StatusCode: statusCode,
-		Message:    message,
-		Data:       data,
+// AbortWithError answers with err as a problem document, errors that are not an
+// *apierror.Error are reported as internal errors. The cause is kept in c.Errors
+// for the request log and never sent to the client.
+func AbortWithError(c *gin.Context, err error) {
+	apiErr := apierror.From(err)
+	c.Error(apiErr)
+
+	problem := apiErr.Problem(c.Request.URL.Path, c.GetString(RequestIdKey))
+	if apiErr.Code == apierror.Unauthenticated {
+		c.Header("WWW-Authenticate", "Bearer")
 	}
-	c.AbortWithStatusJSON(responseStruct.StatusCode, responseStruct)
+
+	c.Header("Content-Type", apierror.ProblemContentType)
+	c.AbortWithStatusJSON(problem.Status, problem)
 	return
 }
 
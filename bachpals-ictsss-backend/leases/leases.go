@@ -0,0 +1,177 @@
+// Package leases ends the lease of virtual machines. Members are warned before the lease
+// of a virtual machine ends and it is stopped when it has, nothing is deleted.
+package leases
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/startstop"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
+	"github.com/spf13/viper"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/database"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/gopher"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/logging"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/notify"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/services"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/storage"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/tracing"
+)
+
+const defaultWarningDays = 7
+const checkInterval = time.Hour
+
+// dateLayout is how the end of a lease is written in mails.
+const dateLayout = "2006-01-02 15:04 MST"
+
+// ErrNoLease is returned when renewing a virtual machine without a lease, or with leases switched off.
+var ErrNoLease = errors.New("no lease")
+
+func warning() time.Duration {
+	days := viper.GetInt("IKT_STACK_VM_LEASE_WARNING_DAYS")
+	if days <= 0 {
+		days = defaultWarningDays
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// Start checks the leases once an hour until ctx is cancelled.
+func Start(ctx context.Context) {
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			Expire(logging.Job(ctx, "lease_expiry"), now)
+		}
+	}
+}
+
+// Notice returns the lease notice that became due for the virtual machine at now, or an
+// empty string when none did. Every notice is only due once per lease.
+func Notice(vm database.VirtualMachine, now time.Time, warning time.Duration) string {
+	if vm.LeaseExpires.IsZero() {
+		return ""
+	}
+
+	if !now.Before(vm.LeaseExpires) {
+		if vm.LeaseNotice != database.VirtualMachineLeaseExpired {
+			return database.VirtualMachineLeaseExpired
+		}
+		return ""
+	}
+
+	if !now.Add(warning).Before(vm.LeaseExpires) && len(vm.LeaseNotice) == 0 {
+		return database.VirtualMachineLeaseExpiring
+	}
+
+	return ""
+}
+
+// Expire warns the members of virtual machines whose lease ends soon and stops the ones
+// whose lease ended. Every instance runs it, the one that claims a notice sends it.
+func Expire(ctx context.Context, now time.Time) {
+	ctx, span := tracing.Start(ctx, "leases.Expire")
+	defer span.End()
+
+	vms, err := storage.Vms().GetVMS(ctx)
+	if err != nil {
+		logging.Error(ctx, "could not read virtual machines", err)
+		return
+	}
+
+	warning := warning()
+	for _, vm := range vms {
+		notice := Notice(vm, now, warning)
+		if len(notice) == 0 {
+			continue
+		}
+
+		claimed, err := storage.Vms().ClaimVmLeaseNotice(ctx, vm.ServerId, vm.LeaseNotice, notice)
+		if err != nil {
+			logging.Error(ctx, "could not claim lease notice", err, logging.VmId, vm.ServerId)
+			continue
+		}
+		if !claimed {
+			continue
+		}
+
+		data := notify.Data{ServerName: vm.ServerName, ServerIp: vm.ServerIp, LeaseExpires: vm.LeaseExpires.Format(dateLayout)}
+
+		if notice == database.VirtualMachineLeaseExpiring {
+			notify.Notify(ctx, notify.EventVmLeaseExpiring, services.MemberIds(vm), data)
+			continue
+		}
+
+		if err := stop(ctx, vm); err != nil {
+			logging.Error(ctx, "could not stop virtual machine with an ended lease", err, logging.VmId, vm.ServerId)
+			// The notice is given back so the next check tries again.
+			if _, err := storage.Vms().ClaimVmLeaseNotice(ctx, vm.ServerId, notice, vm.LeaseNotice); err != nil {
+				logging.Error(ctx, "could not give back lease notice", err, logging.VmId, vm.ServerId)
+			}
+			continue
+		}
+
+		logging.Info(ctx, "stopped virtual machine, its lease ended", logging.VmId, vm.ServerId, "server_name", vm.ServerName)
+		notify.Notify(ctx, notify.EventVmLeaseExpired, services.MemberIds(vm), data)
+	}
+}
+
+// stop shuts the server of the virtual machine off, one that is off already is left alone.
+func stop(ctx context.Context, vm database.VirtualMachine) error {
+	client, err := gopher.Compute(ctx, vm.Target)
+	if err != nil {
+		return err
+	}
+
+	server, err := servers.Get(client, vm.ServerId).Extract()
+	if err != nil {
+		return err
+	}
+	if server.Status == database.VirtualMachineStatusInactive {
+		return nil
+	}
+
+	if err := startstop.Stop(client, vm.ServerId).ExtractErr(); err != nil {
+		return err
+	}
+
+	if err := servers.WaitForStatus(client, vm.ServerId, database.VirtualMachineStatusInactive, database.ServerStatusPollingTime); err != nil {
+		return err
+	}
+
+	if err := storage.Vms().UpdateVMStatusById(ctx, vm.ServerId, database.VirtualMachineStatusInactive); err != nil {
+		return err
+	}
+
+	vm.ServerStatus = database.VirtualMachineStatusInactive
+	services.PublishStatusChanged(ctx, vm, server.Status)
+	return nil
+}
+
+// Renew gives the virtual machine a full lease from now and returns it, the members are
+// warned again before the new lease ends.
+func Renew(ctx context.Context, vm database.VirtualMachine, now time.Time) (database.VirtualMachine, error) {
+	duration := services.LeaseDuration()
+	if vm.LeaseExpires.IsZero() || duration <= 0 {
+		return vm, ErrNoLease
+	}
+
+	expires := now.Add(duration)
+	if err := storage.Vms().RenewVmLease(ctx, vm.ServerId, expires); err != nil {
+		return vm, err
+	}
+
+	vm.LeaseExpires = expires
+	vm.LeaseNotice = ""
+	return vm, nil
+}
+
+// Expired reports whether the lease of the virtual machine has ended.
+func Expired(vm database.VirtualMachine, now time.Time) bool {
+	return !vm.LeaseExpires.IsZero() && !now.Before(vm.LeaseExpires)
+}
@@ -0,0 +1,38 @@
+package leases
+
+import (
+	"testing"
+	"time"
+
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/database"
+)
+
+func TestNotice(t *testing.T) {
+	now := time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC)
+	warning := 7 * 24 * time.Hour
+
+	tests := []struct {
+		name    string
+		expires time.Time
+		notice  string
+		want    string
+	}{
+		{"no lease", time.Time{}, "", ""},
+		{"far from its end", now.Add(30 * 24 * time.Hour), "", ""},
+		{"just before the warning", now.Add(warning + time.Second), "", ""},
+		{"start of the warning", now.Add(warning), "", database.VirtualMachineLeaseExpiring},
+		{"warned already", now.Add(time.Hour), database.VirtualMachineLeaseExpiring, ""},
+		{"ends now", now, database.VirtualMachineLeaseExpiring, database.VirtualMachineLeaseExpired},
+		{"ended without a warning", now.Add(-time.Hour), "", database.VirtualMachineLeaseExpired},
+		{"stopped already", now.Add(-time.Hour), database.VirtualMachineLeaseExpired, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vm := database.VirtualMachine{LeaseExpires: tt.expires, LeaseNotice: tt.notice}
+			if got := Notice(vm, now, warning); got != tt.want {
+				t.Errorf("Notice() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
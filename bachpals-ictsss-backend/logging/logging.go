@@ -0,0 +1,236 @@
+// Package logging writes structured json log lines with a level. Fields attached to a
+// context with With, like the request, user, virtual machine and job ids, are written
+// on every line logged with that context, also by background workers and repositories.
+package logging
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Keys of the fields that correlate log lines.
+const (
+	RequestId = "request_id"
+	UserId    = "user_id"
+	VmId      = "vm_id"
+	JobId     = "job_id"
+)
+
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < LevelDebug || l > LevelError {
+		return "unknown"
+	}
+	return levelNames[l]
+}
+
+// ParseLevel reads a level name, an empty name is info.
+func ParseLevel(name string) (Level, error) {
+	if len(name) == 0 {
+		return LevelInfo, nil
+	}
+
+	for i, v := range levelNames {
+		if strings.EqualFold(name, v) {
+			return Level(i), nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("unknown log level %q", name)
+}
+
+var (
+	mu    sync.Mutex
+	out   io.Writer = os.Stderr
+	level           = LevelInfo
+)
+
+// SetLevel sets the lowest level that is written.
+func SetLevel(l Level) {
+	mu.Lock()
+	defer mu.Unlock()
+	level = l
+}
+
+// SetOutput sets where lines are written, standard error by default.
+func SetOutput(w io.Writer) {
+	mu.Lock()
+	defer mu.Unlock()
+	out = w
+}
+
+func Enabled(l Level) bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return l >= level
+}
+
+type fieldsKey struct{}
+
+type field struct {
+	key   string
+	value interface{}
+}
+
+func fields(ctx context.Context) []field {
+	if ctx == nil {
+		return nil
+	}
+	list, _ := ctx.Value(fieldsKey{}).([]field)
+	return list
+}
+
+// pairs turns alternating keys and values into fields, a key without a value is kept
+// under "extra".
+func pairs(args []interface{}) []field {
+	var list []field
+	for i := 0; i < len(args); i += 2 {
+		key, ok := args[i].(string)
+		if !ok || i+1 == len(args) {
+			list = append(list, field{key: "extra", value: args[i]})
+			i--
+			continue
+		}
+		list = append(list, field{key: key, value: args[i+1]})
+	}
+	return list
+}
+
+// With returns a context whose log lines carry the given key value pairs.
+func With(ctx context.Context, args ...interface{}) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	existing := fields(ctx)
+	list := make([]field, 0, len(existing)+len(args)/2)
+	list = append(list, existing...)
+	list = append(list, pairs(args)...)
+	return context.WithValue(ctx, fieldsKey{}, list)
+}
+
+// Detach returns a background context with the log fields of ctx, for work that goes on
+// after the request that started it has been answered.
+func Detach(ctx context.Context) context.Context {
+	return context.WithValue(context.Background(), fieldsKey{}, fields(ctx))
+}
+
+// Job attaches a new job id and the job name, for one run of a background worker.
+func Job(ctx context.Context, name string) context.Context {
+	return With(ctx, JobId, NewId(), "job", name)
+}
+
+// NewId returns a random id for requests and jobs.
+func NewId() string {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+func Debug(ctx context.Context, msg string, args ...interface{}) {
+	write(ctx, LevelDebug, msg, args)
+}
+
+func Info(ctx context.Context, msg string, args ...interface{}) {
+	write(ctx, LevelInfo, msg, args)
+}
+
+func Warn(ctx context.Context, msg string, args ...interface{}) {
+	write(ctx, LevelWarn, msg, args)
+}
+
+// Error logs msg with err under "error", err may be nil.
+func Error(ctx context.Context, msg string, err error, args ...interface{}) {
+	if err != nil {
+		args = append([]interface{}{"error", err}, args...)
+	}
+	write(ctx, LevelError, msg, args)
+}
+
+func write(ctx context.Context, l Level, msg string, args []interface{}) {
+	if !Enabled(l) {
+		return
+	}
+
+	// Later fields replace earlier ones with the same key, in the position of the first.
+	list := append(append([]field{}, fields(ctx)...), pairs(args)...)
+	index := map[string]int{}
+	var unique []field
+	for _, v := range list {
+		if i, ok := index[v.key]; ok {
+			unique[i].value = v.value
+			continue
+		}
+		index[v.key] = len(unique)
+		unique = append(unique, v)
+	}
+
+	var line bytes.Buffer
+	line.WriteString(`{"time":`)
+	encode(&line, time.Now().UTC().Format(time.RFC3339Nano))
+	line.WriteString(`,"level":`)
+	encode(&line, l.String())
+	line.WriteString(`,"msg":`)
+	encode(&line, msg)
+	for _, v := range unique {
+		line.WriteByte(',')
+		encode(&line, v.key)
+		line.WriteByte(':')
+		encode(&line, v.value)
+	}
+	line.WriteString("}\n")
+
+	mu.Lock()
+	defer mu.Unlock()
+	out.Write(line.Bytes())
+}
+
+func encode(buf *bytes.Buffer, value interface{}) {
+	switch v := value.(type) {
+	case error:
+		value = v.Error()
+	case time.Duration:
+		value = v.String()
+	case fmt.Stringer:
+		value = v.String()
+	}
+
+	b, err := json.Marshal(value)
+	if err != nil {
+		b, _ = json.Marshal(fmt.Sprint(value))
+	}
+	buf.Write(b)
+}
+
+type writer struct {
+	level Level
+}
+
+func (w writer) Write(p []byte) (int, error) {
+	write(context.Background(), w.level, strings.TrimSpace(string(p)), nil)
+	return len(p), nil
+}
+
+// Writer returns a writer that logs every write as a line at level, for the standard
+// library logger and gin.
+func Writer(l Level) io.Writer {
+	return writer{level: l}
+}
@@ -0,0 +1,132 @@
+// Package metrics holds the Prometheus collectors of the api, the OpenStack, Canvas and
+// Mongo clients and provisioning, served on /metrics.
+package metrics
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/spf13/viper"
+)
+
+const namespace = "ictsss"
+
+var httpRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: namespace,
+	Name:      "http_request_duration_seconds",
+	Help:      "Duration of api requests by route and status.",
+	Buckets:   prometheus.DefBuckets,
+}, []string{"method", "route", "status"})
+
+var openStackRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: namespace,
+	Name:      "openstack_request_duration_seconds",
+	Help:      "Duration of OpenStack api calls by operation.",
+	Buckets:   prometheus.ExponentialBuckets(0.05, 2, 12),
+}, []string{"operation"})
+
+var openStackErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "openstack_errors_total",
+	Help:      "OpenStack api calls that failed, by operation and status code or network for calls without a response.",
+}, []string{"operation", "code"})
+
+var canvasRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "canvas_requests_total",
+	Help:      "Canvas api calls by operation and status code, network for calls without a response.",
+}, []string{"operation", "code"})
+
+var canvasRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: namespace,
+	Name:      "canvas_request_duration_seconds",
+	Help:      "Duration of Canvas api calls by operation.",
+	Buckets:   prometheus.ExponentialBuckets(0.05, 2, 10),
+}, []string{"operation"})
+
+var mongoCommandDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: namespace,
+	Name:      "mongo_command_duration_seconds",
+	Help:      "Duration of Mongo commands by command, collection and result.",
+	Buckets:   prometheus.ExponentialBuckets(0.001, 2, 14),
+}, []string{"command", "collection", "result"})
+
+var provisionStepDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: namespace,
+	Name:      "provision_step_duration_seconds",
+	Help:      "Duration of the steps of provisioning a virtual machine by step and result.",
+	Buckets:   prometheus.ExponentialBuckets(0.25, 2, 13),
+}, []string{"step", "result"})
+
+func init() {
+	prometheus.MustRegister(
+		httpRequestDuration,
+		openStackRequestDuration,
+		openStackErrors,
+		canvasRequests,
+		canvasRequestDuration,
+		mongoCommandDuration,
+		provisionStepDuration,
+	)
+}
+
+// Register adds collectors that are defined outside of this package, like the
+// virtual machine gauges of services.
+func Register(collectors ...prometheus.Collector) {
+	for _, v := range collectors {
+		if err := prometheus.Register(v); err != nil {
+			if _, ok := err.(prometheus.AlreadyRegisteredError); !ok {
+				panic(err)
+			}
+		}
+	}
+}
+
+// ObserveRequest records an api request, route is the route pattern and not the path
+// so ids do not end up in the labels.
+func ObserveRequest(method string, route string, status int, duration time.Duration) {
+	httpRequestDuration.WithLabelValues(method, route, strconv.Itoa(status)).Observe(duration.Seconds())
+}
+
+// result labels an outcome.
+func result(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "success"
+}
+
+// Step times one step of provisioning, Done records it with the error of the step.
+type Step struct {
+	name    string
+	started time.Time
+}
+
+func StartStep(name string) Step {
+	return Step{name: name, started: time.Now()}
+}
+
+func (s Step) Done(err error) {
+	provisionStepDuration.WithLabelValues(s.name, result(err)).Observe(time.Since(s.started).Seconds())
+}
+
+// Handler serves the metrics. When IKT_STACK_METRICS_TOKEN is set scrapes have to
+// send it as a bearer token.
+func Handler() http.Handler {
+	handler := promhttp.Handler()
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := viper.GetString("IKT_STACK_METRICS_TOKEN")
+		if len(token) > 0 && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
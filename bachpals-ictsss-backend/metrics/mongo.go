@@ -0,0 +1,40 @@
+package metrics
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/event"
+)
+
+// CommandMonitor times the commands of a Mongo client. The collection is only part of
+// the started event, it is kept by request id until the command finishes.
+func CommandMonitor() *event.CommandMonitor {
+	var collections sync.Map
+
+	finished := func(e event.CommandFinishedEvent, result string) {
+		collection := ""
+		if v, ok := collections.LoadAndDelete(e.RequestID); ok {
+			collection = v.(string)
+		}
+		mongoCommandDuration.WithLabelValues(e.CommandName, collection, result).Observe(time.Duration(e.DurationNanos).Seconds())
+	}
+
+	return &event.CommandMonitor{
+		Started: func(_ context.Context, e *event.CommandStartedEvent) {
+			// The first element of a command is its name with the collection as value.
+			if element, err := e.Command.IndexErr(0); err == nil {
+				if collection, ok := element.Value().StringValueOK(); ok {
+					collections.Store(e.RequestID, collection)
+				}
+			}
+		},
+		Succeeded: func(_ context.Context, e *event.CommandSucceededEvent) {
+			finished(e.CommandFinishedEvent, "success")
+		},
+		Failed: func(_ context.Context, e *event.CommandFailedEvent) {
+			finished(e.CommandFinishedEvent, "error")
+		},
+	}
+}
@@ -0,0 +1,74 @@
+package metrics
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// idSegment matches the path segments that are ids, uuids, project ids and numbers.
+var idSegment = regexp.MustCompile(`^([0-9a-fA-F-]{16,}|[0-9]+)$`)
+
+// Operation names a call after its method and path with the ids taken out,
+// "GET /v2.1/servers/{id}". Tracing names its client spans the same way.
+func Operation(method string, path string) string {
+	segments := strings.Split(path, "/")
+	for i, v := range segments {
+		if idSegment.MatchString(v) {
+			segments[i] = "{id}"
+		}
+	}
+	return method + " " + strings.Join(segments, "/")
+}
+
+func code(status int, err error) string {
+	if err != nil {
+		return "network"
+	}
+	return strconv.Itoa(status)
+}
+
+type transport struct {
+	next    http.RoundTripper
+	observe func(operation string, status int, err error, duration time.Duration)
+}
+
+func (t transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	started := time.Now()
+	resp, err := t.next.RoundTrip(req)
+
+	status := 0
+	if resp != nil {
+		status = resp.StatusCode
+	}
+	t.observe(Operation(req.Method, req.URL.Path), status, err, time.Since(started))
+
+	return resp, err
+}
+
+func orDefault(next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		return http.DefaultTransport
+	}
+	return next
+}
+
+// OpenStackTransport times every call of the OpenStack clients and counts the failed ones.
+func OpenStackTransport(next http.RoundTripper) http.RoundTripper {
+	return transport{next: orDefault(next), observe: func(operation string, status int, err error, duration time.Duration) {
+		openStackRequestDuration.WithLabelValues(operation).Observe(duration.Seconds())
+		if err != nil || status >= 400 {
+			openStackErrors.WithLabelValues(operation, code(status, err)).Inc()
+		}
+	}}
+}
+
+// CanvasTransport counts and times the calls to the Canvas api.
+func CanvasTransport(next http.RoundTripper) http.RoundTripper {
+	return transport{next: orDefault(next), observe: func(operation string, status int, err error, duration time.Duration) {
+		canvasRequests.WithLabelValues(operation, code(status, err)).Inc()
+		canvasRequestDuration.WithLabelValues(operation).Observe(duration.Seconds())
+	}}
+}
@@ -4,18 +4,18 @@ import (
     "encoding/json"
     "github.com/gin-gonic/gin"
     "github.com/spf13/viper"
+    "gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/apierror"
     "gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/httputils"
+    "gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/logging"
     "io"
     "net/http"
     "strings"
 )
 
-func Authenticate(c *gin.Context) {
-    authorization := c.GetHeader("Authorization")
-
+// Userinfo asks the identity provider who the bearer of authorization is.
+func Userinfo(authorization string) (map[string]interface{}, error) {
     if len(authorization) <= 0 {
-        c.AbortWithStatus(http.StatusUnauthorized)
-        return
+        return nil, apierror.New(apierror.Unauthenticated, "Missing authorization header!")
     }
 
     client := &http.Client{}
@@ -23,42 +23,59 @@ func Authenticate(c *gin.Context) {
     req, err := http.NewRequest("GET", viper.GetString("IKT_STACK_AUTH_MIDDLEWARE_URL"), nil)
 
     if err != nil {
-        httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Error while reaching endpoint", err)
-        return
+        return nil, apierror.Wrap(apierror.Internal, "Error while reaching identity provider!", err)
     }
 
     req.Header.Add("Authorization", authorization)
     resp, err := client.Do(req)
 
-    // Any status code that is not 200 OK should return false
+    if err != nil {
+        return nil, apierror.Wrap(apierror.AuthProvider, "Error while reaching identity provider!", err)
+    }
+    defer resp.Body.Close()
+
     if resp.StatusCode == http.StatusUnauthorized {
-        httputils.AbortWithStatusJSON(c, http.StatusUnauthorized, "Missing authorization header", err)
-        return
+        return nil, apierror.New(apierror.Unauthenticated, "Invalid or expired token!")
     }
 
-    if resp.StatusCode != 200 {
-        httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Something went wrong!", err)
-        return
+    if resp.StatusCode != http.StatusOK {
+        return nil, apierror.New(apierror.AuthProvider, "Identity provider answered "+resp.Status+"!")
     }
 
-    // Read users email address from Feide, then pass it to next request.
     bodyBytes, err := io.ReadAll(resp.Body)
     if err != nil {
-        httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Error while reading data", err)
-        return
+        return nil, apierror.Wrap(apierror.AuthProvider, "Error while reading user info!", err)
     }
 
     var jsonData map[string]interface{}
     err = json.Unmarshal(bodyBytes, &jsonData)
     if err != nil {
-        httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Error while encoding data", err)
+        return nil, apierror.Wrap(apierror.AuthProvider, "Error while decoding user info!", err)
+    }
+
+    return jsonData, nil
+}
+
+func Authenticate(c *gin.Context) {
+    jsonData, err := Userinfo(c.GetHeader("Authorization"))
+
+    if err != nil {
+        httputils.AbortWithError(c, err)
+        return
+    }
+
+    // Read users email address from Feide, then pass it to next request.
+    email, _ := jsonData["email"].(string)
+    if len(email) == 0 {
+        httputils.AbortWithError(c, apierror.New(apierror.AuthProvider, "User info has no email address!"))
         return
     }
 
-    user := strings.Split(jsonData["email"].(string), "@")
+    user := strings.Split(email, "@")
     formattedUser := user[0] + "@uia.no"
 
     c.Set("user_id", formattedUser)
+    c.Request = c.Request.WithContext(logging.With(c.Request.Context(), logging.UserId, formattedUser))
 
     c.Next()
-}
\ No newline at end of file
+}
@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/logging"
+)
+
+// Logger writes one line per request once it is answered, with the causes of errors
+// recorded by httputils.AbortWithError. Server errors are logged as errors.
+func Logger() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		started := time.Now()
+		c.Next()
+
+		status := c.Writer.Status()
+		args := []interface{}{
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"route", c.FullPath(),
+			"status", status,
+			"duration_ms", time.Since(started).Milliseconds(),
+			"client_ip", c.ClientIP(),
+			"size", c.Writer.Size(),
+		}
+		if len(c.Errors) > 0 {
+			args = append(args, "error", strings.Join(c.Errors.Errors(), "; "))
+		}
+
+		if status >= 500 {
+			logging.Error(c.Request.Context(), "request failed", nil, args...)
+			return
+		}
+		logging.Info(c.Request.Context(), "request", args...)
+	}
+}
+
+// LogParam attaches a path parameter to the log lines of the request under key,
+// like the id of a virtual machine as vm_id.
+func LogParam(key string, param string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if value := c.Param(param); len(value) > 0 {
+			c.Request = c.Request.WithContext(logging.With(c.Request.Context(), key, value))
+		}
+		c.Next()
+	}
+}
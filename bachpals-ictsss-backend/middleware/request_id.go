@@ -0,0 +1,30 @@
+package middleware
+
+import (
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/httputils"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/logging"
+)
+
+const RequestIdHeader = "X-Request-Id"
+
+// validRequestId limits ids passed in by proxies to something safe to log and echo.
+var validRequestId = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+// RequestId keeps the X-Request-Id of the caller or assigns a new one, it is echoed in
+// the response, included in error responses and logged with everything the request does.
+func RequestId() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIdHeader)
+		if !validRequestId.MatchString(id) {
+			id = logging.NewId()
+		}
+
+		c.Request = c.Request.WithContext(logging.With(c.Request.Context(), logging.RequestId, id))
+		c.Set(httputils.RequestIdKey, id)
+		c.Header(RequestIdHeader, id)
+		c.Next()
+	}
+}
@@ -0,0 +1,31 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/httputils"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/logging"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/tracing"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Tracing makes a server span for every request, named after its route, and continues
+// the trace of a caller that sends a traceparent header.
+func Tracing() gin.HandlerFunc {
+	return otelgin.Middleware(tracing.ServiceName)
+}
+
+// TraceId ties the span of the request to its log lines, the span gets the request id
+// and the log lines the trace id. It runs after Tracing and RequestId.
+func TraceId() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		trace.SpanFromContext(ctx).SetAttributes(attribute.String(logging.RequestId, c.GetString(httputils.RequestIdKey)))
+
+		if id := tracing.TraceId(ctx); len(id) > 0 {
+			c.Request = c.Request.WithContext(logging.With(ctx, "trace_id", id))
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,37 @@
+package migrations
+
+import (
+	"context"
+
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/database"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// floatingIpsMigration indexes the floating ips by target and server, the free ips of a
+// target are looked up for every new virtual machine.
+var floatingIpsMigration = Migration{
+	Version:     3,
+	Description: "create the index for claiming free floating ips",
+	Up:          floatingIpsUp,
+	Down:        floatingIpsDown,
+}
+
+var floatingIpsIndex = mongo.IndexModel{
+	Keys:    bson.D{{Key: "target", Value: 1}, {Key: "server_id", Value: 1}, {Key: "updated", Value: 1}},
+	Options: options.Index().SetName("target_server_id_updated"),
+}
+
+func floatingIpsUp(ctx context.Context) error {
+	_, err := database.Collection(database.FloatingIpsCollection).Indexes().CreateOne(ctx, floatingIpsIndex)
+	return err
+}
+
+func floatingIpsDown(ctx context.Context) error {
+	_, err := database.Collection(database.FloatingIpsCollection).Indexes().DropOne(ctx, *floatingIpsIndex.Options.Name)
+	if err != nil && !isIndexNotFound(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package migrations
+
+import (
+	"context"
+
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/database"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// indexesMigration creates the indexes the repositories query by. The unique
+// server_id index relies on vmMembersMigration having removed the per user copies.
+var indexesMigration = Migration{
+	Version:     2,
+	Description: "create indexes for virtual machines, images, administrators and outboxes",
+	Up:          indexesUp,
+	Down:        indexesDown,
+}
+
+type collectionIndex struct {
+	collection string
+	model      mongo.IndexModel
+}
+
+var indexes = []collectionIndex{
+	{database.VmCollection, mongo.IndexModel{
+		Keys:    bson.D{{Key: "server_id", Value: 1}},
+		Options: options.Index().SetName("server_id_unique").SetUnique(true),
+	}},
+	{database.VmCollection, mongo.IndexModel{
+		Keys:    bson.D{{Key: "members.user_id", Value: 1}},
+		Options: options.Index().SetName("members_user_id"),
+	}},
+	{database.VmCollection, mongo.IndexModel{
+		Keys:    bson.D{{Key: "course_code", Value: 1}},
+		Options: options.Index().SetName("course_code"),
+	}},
+	{database.ImagesCollection, mongo.IndexModel{
+		Keys:    bson.D{{Key: "image_id", Value: 1}},
+		Options: options.Index().SetName("image_id"),
+	}},
+	{database.AdminCollection, mongo.IndexModel{
+		Keys:    bson.D{{Key: "user_id", Value: 1}},
+		Options: options.Index().SetName("user_id_unique").SetUnique(true),
+	}},
+	{database.NotificationsCollection, mongo.IndexModel{
+		Keys:    bson.D{{Key: "status", Value: 1}, {Key: "next_attempt", Value: 1}},
+		Options: options.Index().SetName("status_next_attempt"),
+	}},
+	{database.WebhookDeliveriesCollection, mongo.IndexModel{
+		Keys:    bson.D{{Key: "status", Value: 1}, {Key: "next_attempt", Value: 1}},
+		Options: options.Index().SetName("status_next_attempt"),
+	}},
+}
+
+func indexesUp(ctx context.Context) error {
+	for _, v := range indexes {
+		if _, err := database.Collection(v.collection).Indexes().CreateOne(ctx, v.model); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func indexesDown(ctx context.Context) error {
+	for _, v := range indexes {
+		_, err := database.Collection(v.collection).Indexes().DropOne(ctx, *v.model.Options.Name)
+		if err != nil && !isIndexNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func isIndexNotFound(err error) bool {
+	if e, ok := err.(mongo.CommandError); ok {
+		return e.Code == 27 // IndexNotFound
+	}
+	return false
+}
@@ -0,0 +1,222 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/database"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const lockId = "lock"
+const lockTimeout = 10 * time.Minute
+
+// ErrLocked is returned when another instance is running migrations.
+var ErrLocked = errors.New("migrations are locked by another instance")
+
+// Migration is one versioned change to the database. Versions are applied in
+// ascending order by Up and reverted newest first by Down.
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(ctx context.Context) error
+	Down        func(ctx context.Context) error
+}
+
+// State is a registered migration and when it was applied, Applied is zero while pending.
+type State struct {
+	Version     int
+	Description string
+	Applied     time.Time
+}
+
+type appliedMigration struct {
+	Version     int       `bson:"_id"`
+	Description string    `bson:"description"`
+	Applied     time.Time `bson:"applied"`
+}
+
+// registry holds every migration, new ones are appended with the next version.
+var registry = []Migration{
+	vmMembersMigration,
+	indexesMigration,
+	floatingIpsMigration,
+}
+
+func sorted() []Migration {
+	list := append([]Migration{}, registry...)
+	sort.Slice(list, func(i, j int) bool { return list[i].Version < list[j].Version })
+	return list
+}
+
+// Up applies every pending migration and returns the ones it applied.
+func Up(ctx context.Context) ([]Migration, error) {
+	release, err := lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	applied, err := appliedVersions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for _, m := range sorted() {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+
+		if err := m.Up(ctx); err != nil {
+			return done, fmt.Errorf("migration %d (%s): %w", m.Version, m.Description, err)
+		}
+
+		record := appliedMigration{Version: m.Version, Description: m.Description, Applied: time.Now()}
+		if _, err := database.Collection(database.SchemaMigrationsCollection).InsertOne(ctx, record); err != nil {
+			return done, fmt.Errorf("migration %d applied but not recorded: %w", m.Version, err)
+		}
+
+		done = append(done, m)
+		extendLock(ctx)
+	}
+
+	return done, nil
+}
+
+// Down reverts the newest applied migration, it returns nil when nothing is applied.
+func Down(ctx context.Context) (*Migration, error) {
+	release, err := lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	applied, err := appliedVersions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	list := sorted()
+	for i := len(list) - 1; i >= 0; i-- {
+		m := list[i]
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+
+		if m.Down == nil {
+			return nil, fmt.Errorf("migration %d (%s) can not be reverted", m.Version, m.Description)
+		}
+
+		if err := m.Down(ctx); err != nil {
+			return nil, fmt.Errorf("migration %d (%s): %w", m.Version, m.Description, err)
+		}
+
+		if _, err := database.Collection(database.SchemaMigrationsCollection).DeleteOne(ctx, bson.M{"_id": m.Version}); err != nil {
+			return nil, fmt.Errorf("migration %d reverted but still recorded: %w", m.Version, err)
+		}
+
+		return &m, nil
+	}
+
+	return nil, nil
+}
+
+// Status lists every registered migration with the time it was applied.
+func Status(ctx context.Context) ([]State, error) {
+	applied, err := appliedVersions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var states []State
+	for _, m := range sorted() {
+		states = append(states, State{
+			Version:     m.Version,
+			Description: m.Description,
+			Applied:     applied[m.Version],
+		})
+	}
+
+	return states, nil
+}
+
+// Pending returns the migrations that have not been applied yet.
+func Pending(ctx context.Context) ([]Migration, error) {
+	applied, err := appliedVersions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []Migration
+	for _, m := range sorted() {
+		if _, ok := applied[m.Version]; !ok {
+			pending = append(pending, m)
+		}
+	}
+
+	return pending, nil
+}
+
+func appliedVersions(ctx context.Context) (map[int]time.Time, error) {
+	cursor, err := database.Collection(database.SchemaMigrationsCollection).Find(ctx, bson.D{})
+	if err != nil {
+		return nil, err
+	}
+
+	var records []appliedMigration
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, err
+	}
+
+	applied := map[int]time.Time{}
+	for _, v := range records {
+		applied[v.Version] = v.Applied
+	}
+
+	return applied, nil
+}
+
+// lockOwner identifies this process in the lock document.
+var lockOwner = func() string {
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s-%d-%d", host, os.Getpid(), time.Now().UnixNano())
+}()
+
+// lock takes the migration lock. The lock expires after lockTimeout so a crashed
+// instance can not block migrations forever.
+func lock(ctx context.Context) (func(), error) {
+	collection := database.Collection(database.SchemaMigrationsLockCollection)
+
+	// Only an expired lock matches the filter, a held one makes the upsert insert a
+	// second document with the same _id which fails with a duplicate key error.
+	filter := bson.M{"_id": lockId, "expires": bson.M{"$lt": time.Now()}}
+	update := bson.M{"$set": bson.M{"owner": lockOwner, "expires": time.Now().Add(lockTimeout)}}
+
+	_, err := collection.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, ErrLocked
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	release := func() {
+		collection.DeleteOne(context.Background(), bson.M{"_id": lockId, "owner": lockOwner})
+	}
+
+	return release, nil
+}
+
+func extendLock(ctx context.Context) {
+	database.Collection(database.SchemaMigrationsLockCollection).UpdateOne(ctx,
+		bson.M{"_id": lockId, "owner": lockOwner},
+		bson.M{"$set": bson.M{"expires": time.Now().Add(lockTimeout)}},
+	)
+}
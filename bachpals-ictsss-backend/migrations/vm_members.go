@@ -0,0 +1,161 @@
+package migrations
+
+import (
+	"context"
+	"time"
+
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/database"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// vmMembersMigration moves from one copy of a server per user to one document
+// per server with an embedded members array.
+var vmMembersMigration = Migration{
+	Version:     1,
+	Description: "store one document per virtual machine with a members array",
+	Up:          vmMembersUp,
+	Down:        vmMembersDown,
+}
+
+// legacyVirtualMachine is the old layout, one copy of the server per user.
+type legacyVirtualMachine struct {
+	ServerIp     string    `bson:"server_ip"`
+	ServerImage  string    `bson:"server_image"`
+	ServerName   string    `bson:"server_name"`
+	ServerStatus string    `bson:"server_status"`
+	UserId       string    `bson:"user_id"`
+	ServerId     string    `bson:"server_id"`
+	Created      time.Time `bson:"created"`
+	CourseCode   string    `bson:"course_code"`
+}
+
+// vmMembersUp converts every server still stored as copies. The first copy that was
+// inserted belongs to the owner. Servers that are already converted are left alone,
+// so it is safe to run again after a failure.
+func vmMembersUp(ctx context.Context) error {
+	vms := database.Collection(database.VmCollection)
+
+	pipeline := []bson.M{
+		{"$match": bson.M{"members": bson.M{"$exists": false}}},
+		{"$sort": bson.M{"_id": 1}},
+		{"$group": bson.M{
+			"_id":    "$server_id",
+			"copies": bson.M{"$push": "$$ROOT"},
+		}},
+	}
+
+	cursor, err := vms.Aggregate(ctx, pipeline)
+	if err != nil {
+		return err
+	}
+
+	var servers []struct {
+		ServerId string                 `bson:"_id"`
+		Copies   []legacyVirtualMachine `bson:"copies"`
+	}
+	if err := cursor.All(ctx, &servers); err != nil {
+		return err
+	}
+
+	for _, server := range servers {
+		first := server.Copies[0]
+
+		var userIds []string
+		// An earlier run may have written the new document before it was stopped, keep its members.
+		var existing database.VirtualMachine
+		err := vms.FindOne(ctx, bson.M{"server_id": server.ServerId, "members": bson.M{"$exists": true}}).Decode(&existing)
+		if err == nil {
+			for _, v := range existing.Members {
+				userIds = append(userIds, v.UserId)
+			}
+		} else if err != mongo.ErrNoDocuments {
+			return err
+		}
+
+		for _, v := range server.Copies {
+			if !contains(userIds, v.UserId) {
+				userIds = append(userIds, v.UserId)
+			}
+		}
+
+		document := bson.D{
+			{Key: "server_ip", Value: first.ServerIp},
+			{Key: "server_image", Value: first.ServerImage},
+			{Key: "server_name", Value: first.ServerName},
+			{Key: "server_status", Value: first.ServerStatus},
+			{Key: "server_id", Value: server.ServerId},
+			{Key: "created", Value: first.Created},
+			{Key: "course_code", Value: first.CourseCode},
+			{Key: "members", Value: database.MembersFromUserIds(userIds)},
+		}
+
+		filter := bson.M{"server_id": server.ServerId, "members": bson.M{"$exists": true}}
+		if _, err := vms.ReplaceOne(ctx, filter, document, options.Replace().SetUpsert(true)); err != nil {
+			return err
+		}
+
+		if _, err := vms.DeleteMany(ctx, bson.M{"server_id": server.ServerId, "members": bson.M{"$exists": false}}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// vmMembersDown writes one copy of every server per member again, owner first.
+func vmMembersDown(ctx context.Context) error {
+	vms := database.Collection(database.VmCollection)
+
+	cursor, err := vms.Find(ctx, bson.M{"members": bson.M{"$exists": true}})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var vm struct {
+			Id                      interface{} `bson:"_id"`
+			database.VirtualMachine `bson:",inline"`
+		}
+		if err := cursor.Decode(&vm); err != nil {
+			return err
+		}
+
+		var copies []interface{}
+		for _, member := range vm.Members {
+			copies = append(copies, legacyVirtualMachine{
+				ServerIp:     vm.ServerIp,
+				ServerImage:  vm.ServerImage,
+				ServerName:   vm.ServerName,
+				ServerStatus: vm.ServerStatus,
+				UserId:       member.UserId,
+				ServerId:     vm.ServerId,
+				Created:      vm.Created,
+				CourseCode:   vm.CourseCode,
+			})
+		}
+
+		if len(copies) > 0 {
+			if _, err := vms.InsertMany(ctx, copies); err != nil {
+				return err
+			}
+		}
+
+		if _, err := vms.DeleteOne(ctx, bson.M{"_id": vm.Id}); err != nil {
+			return err
+		}
+	}
+
+	return cursor.Err()
+}
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,30 @@
+#!/bin/sh
+# Example IKT_STACK_ACCESS_HOOK_COMMAND. Copies the sssd.conf and sudoers rendered by the
+# backend to a running virtual machine and reloads sssd, so new members can log in
+# without a respawn.
+#
+# Set SSH_USER and SSH_KEY to an account on the image that may use sudo without a password.
+set -eu
+
+SSH_USER="${SSH_USER:-ubuntu}"
+SSH_KEY="${SSH_KEY:-$HOME/.ssh/id_rsa}"
+SSH_OPTS="-i $SSH_KEY -o BatchMode=yes -o StrictHostKeyChecking=accept-new -o ConnectTimeout=10"
+
+if [ -z "$ICTSSS_SERVER_IP" ]; then
+    echo "$ICTSSS_SERVER_NAME has no floating ip" >&2
+    exit 1
+fi
+
+TARGET="$SSH_USER@$ICTSSS_SERVER_IP"
+
+scp $SSH_OPTS "$ICTSSS_SSSD_CONF" "$TARGET:/tmp/ictsss-sssd.conf"
+scp $SSH_OPTS "$ICTSSS_SUDOERS" "$TARGET:/tmp/ictsss-sudoers"
+
+ssh $SSH_OPTS "$TARGET" sh -s <<'REMOTE'
+set -eu
+sudo visudo -cf /tmp/ictsss-sudoers
+sudo install -m 0440 -o root -g root /tmp/ictsss-sudoers /etc/sudoers.d/00-root-ikt-stack
+sudo install -m 0600 -o root -g root /tmp/ictsss-sssd.conf /etc/sssd/sssd.conf
+rm -f /tmp/ictsss-sudoers /tmp/ictsss-sssd.conf
+sudo systemctl restart sssd
+REMOTE
@@ -0,0 +1,130 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/database"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/logging"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/storage"
+)
+
+const EventVmReady = "vm_ready"
+const EventVmProvisioningFailed = "vm_provisioning_failed"
+const EventVmRespawned = "vm_respawned"
+const EventVmDeleted = "vm_deleted"
+const EventVmDeletedByReconciler = "vm_deleted_by_reconciler"
+const EventVmLeaseExpiring = "vm_lease_expiring"
+const EventVmLeaseExpired = "vm_lease_expired"
+
+// Events lists every event a user can opt out of.
+var Events = []string{
+	EventVmReady,
+	EventVmProvisioningFailed,
+	EventVmRespawned,
+	EventVmDeleted,
+	EventVmDeletedByReconciler,
+	EventVmLeaseExpiring,
+	EventVmLeaseExpired,
+}
+
+var subjects = map[string]string{
+	EventVmReady:               "Your virtual machine %s is ready",
+	EventVmProvisioningFailed:  "Virtual machine %s could not be created",
+	EventVmRespawned:           "Your virtual machine %s has been respawned",
+	EventVmDeleted:             "Your virtual machine %s has been deleted",
+	EventVmDeletedByReconciler: "Your virtual machine %s no longer exists",
+	EventVmLeaseExpiring:       "The lease of your virtual machine %s ends soon",
+	EventVmLeaseExpired:        "Your virtual machine %s has been stopped, its lease ended",
+}
+
+// Data is passed to the html and text template of an event, LeaseExpires is already formatted.
+type Data struct {
+	UserId       string
+	ServerName   string
+	ServerIp     string
+	Reason       string
+	LeaseExpires string
+	FrontendUrl  string
+}
+
+// Notify renders the event for each user and queues it in the outbox.
+// Users that opted out of the event are skipped. Users is a list of user ids,
+// which are the users email addresses.
+func Notify(ctx context.Context, event string, users []string, data Data) {
+	if !viper.GetBool("IKT_STACK_NOTIFICATIONS_ENABLED") {
+		return
+	}
+
+	data.FrontendUrl = viper.GetString("IKT_STACK_FRONTEND_URL")
+
+	for _, user := range users {
+		user = strings.TrimSpace(user)
+		if len(user) == 0 || !wants(ctx, user, event) {
+			continue
+		}
+
+		data.UserId = user
+
+		text, html, err := render(event, data)
+		if err != nil {
+			logging.Error(ctx, "could not render notification", err, "event", event)
+			continue
+		}
+
+		now := time.Now()
+		notification := database.Notification{
+			UserId:      user,
+			Event:       event,
+			Subject:     fmt.Sprintf(subjects[event], data.ServerName),
+			Text:        text,
+			Html:        html,
+			Status:      database.NotificationStatusPending,
+			NextAttempt: now,
+			Created:     now,
+		}
+
+		if err := storage.Notifications().InsertNotification(ctx, notification); err != nil {
+			logging.Error(ctx, "could not queue notification", err, "event", event, "recipient", user)
+		}
+	}
+}
+
+func wants(ctx context.Context, userId string, event string) bool {
+	preferences, err := storage.Notifications().GetNotificationPreferences(ctx, userId)
+	if err != nil {
+		// Rather send one mail too many than silently drop it.
+		logging.Error(ctx, "could not read notification preferences", err, "recipient", userId)
+		return true
+	}
+
+	return allows(*preferences, event)
+}
+
+// allows reports whether the preferences let the event through.
+func allows(preferences database.NotificationPreferences, event string) bool {
+	if preferences.Disabled {
+		return false
+	}
+
+	for _, v := range preferences.OptOut {
+		if v == event {
+			return false
+		}
+	}
+
+	return true
+}
+
+// IsEvent reports whether event is a known notification event.
+func IsEvent(event string) bool {
+	for _, v := range Events {
+		if v == event {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,30 @@
+package notify
+
+import (
+	"testing"
+
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/database"
+)
+
+func TestAllows(t *testing.T) {
+	tests := []struct {
+		name        string
+		preferences database.NotificationPreferences
+		event       string
+		want        bool
+	}{
+		{"no preferences", database.NotificationPreferences{}, EventVmReady, true},
+		{"opted out of another event", database.NotificationPreferences{OptOut: []string{EventVmDeleted}}, EventVmReady, true},
+		{"opted out of the event", database.NotificationPreferences{OptOut: []string{EventVmDeleted, EventVmReady}}, EventVmReady, false},
+		{"disabled", database.NotificationPreferences{Disabled: true}, EventVmReady, false},
+		{"disabled with opt outs", database.NotificationPreferences{Disabled: true, OptOut: []string{EventVmDeleted}}, EventVmLeaseExpired, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := allows(tt.preferences, tt.event); got != tt.want {
+				t.Errorf("allows() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
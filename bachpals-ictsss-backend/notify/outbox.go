@@ -0,0 +1,91 @@
+package notify
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"github.com/spf13/viper"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/database"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/logging"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/storage"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/tracing"
+)
+
+const defaultMaxAttempts = 5
+const outboxInterval = 30 * time.Second
+const outboxBatchSize = 50
+
+// outboxLease is how long a claimed notification is left to one instance, longer than a
+// delivery takes so it is only sent again after that instance crashed.
+const outboxLease = 5 * time.Minute
+
+// Start delivers queued notifications with sender until ctx is cancelled.
+func Start(ctx context.Context, sender Sender) {
+	ticker := time.NewTicker(outboxInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			deliver(logging.Job(ctx, "notification_outbox"), sender, now)
+		}
+	}
+}
+
+func deliver(ctx context.Context, sender Sender, now time.Time) {
+	ctx, span := tracing.Start(ctx, "notify.deliver")
+	defer span.End()
+
+	maxAttempts := viper.GetInt("IKT_STACK_SMTP_MAX_ATTEMPTS")
+	if maxAttempts <= 0 {
+		maxAttempts = defaultMaxAttempts
+	}
+
+	for i := 0; i < outboxBatchSize; i++ {
+		notification, err := storage.Notifications().ClaimDueNotification(ctx, now, outboxLease)
+		if err == storage.ErrNotFound {
+			return
+		}
+		if err != nil {
+			logging.Error(ctx, "could not claim notification", err)
+			return
+		}
+
+		err = sender.Send(Message{
+			To:      notification.UserId,
+			Subject: notification.Subject,
+			Text:    notification.Text,
+			Html:    notification.Html,
+		})
+
+		notification.Attempts++
+
+		if err == nil {
+			notification.Status = database.NotificationStatusSent
+			notification.LastError = ""
+			notification.Sent = time.Now()
+		} else {
+			logging.Warn(ctx, "could not send notification", "error", err, "event", notification.Event, "recipient", notification.UserId, "attempts", notification.Attempts)
+
+			notification.LastError = err.Error()
+			if notification.Attempts >= maxAttempts {
+				notification.Status = database.NotificationStatusFailed
+			} else {
+				notification.Status = database.NotificationStatusPending
+				notification.NextAttempt = time.Now().Add(backoff(notification.Attempts))
+			}
+		}
+
+		if err := storage.Notifications().UpdateNotificationDelivery(ctx, *notification); err != nil {
+			logging.Error(ctx, "could not update notification", err, "event", notification.Event, "recipient", notification.UserId)
+		}
+	}
+}
+
+// backoff is the wait after the given number of failed attempts, 1, 2, 4, 8... minutes.
+func backoff(attempts int) time.Duration {
+	return time.Duration(math.Pow(2, float64(attempts-1))) * time.Minute
+}
@@ -0,0 +1,106 @@
+package notify
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Message is a single email with a text and a html alternative.
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+	Html    string
+}
+
+// Sender delivers a message, the outbox retries failed deliveries.
+type Sender interface {
+	Send(message Message) error
+}
+
+// SMTPSender sends mail through the server configured by IKT_STACK_SMTP_*.
+// Authentication is skipped when no username is set, which is how a local
+// SMTP sink such as MailHog is used during development.
+type SMTPSender struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+func NewSMTPSender() *SMTPSender {
+	return &SMTPSender{
+		Host:     viper.GetString("IKT_STACK_SMTP_HOST"),
+		Port:     viper.GetString("IKT_STACK_SMTP_PORT"),
+		Username: viper.GetString("IKT_STACK_SMTP_USERNAME"),
+		Password: viper.GetString("IKT_STACK_SMTP_PASSWORD"),
+		From:     viper.GetString("IKT_STACK_SMTP_FROM"),
+	}
+}
+
+func (s *SMTPSender) Send(message Message) error {
+	var auth smtp.Auth
+	if len(s.Username) > 0 {
+		auth = smtp.PlainAuth("", s.Username, s.Password, s.Host)
+	}
+
+	body, err := s.build(message)
+	if err != nil {
+		return err
+	}
+
+	return smtp.SendMail(net.JoinHostPort(s.Host, s.Port), auth, s.From, []string{message.To}, body)
+}
+
+// build creates a multipart/alternative message, text first so clients
+// without html support show the plain version.
+func (s *SMTPSender) build(message Message) ([]byte, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	boundary := hex.EncodeToString(b)
+
+	var buf bytes.Buffer
+	header := func(key string, value string) {
+		buf.WriteString(fmt.Sprintf("%s: %s\r\n", key, value))
+	}
+
+	header("From", s.From)
+	header("To", message.To)
+	header("Subject", mime.QEncoding.Encode("utf-8", message.Subject))
+	header("Date", time.Now().Format(time.RFC1123Z))
+	header("MIME-Version", "1.0")
+	header("Content-Type", fmt.Sprintf("multipart/alternative; boundary=%q", boundary))
+	buf.WriteString("\r\n")
+
+	for _, part := range []struct {
+		contentType string
+		content     string
+	}{
+		{"text/plain", message.Text},
+		{"text/html", message.Html},
+	} {
+		buf.WriteString("--" + boundary + "\r\n")
+		header("Content-Type", part.contentType+"; charset=utf-8")
+		header("Content-Transfer-Encoding", "8bit")
+		buf.WriteString("\r\n")
+		content := strings.ReplaceAll(part.content, "\r\n", "\n")
+		buf.WriteString(strings.ReplaceAll(content, "\n", "\r\n"))
+		buf.WriteString("\r\n")
+	}
+
+	buf.WriteString("--" + boundary + "--\r\n")
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,38 @@
+package notify
+
+import (
+	"bytes"
+	htmltemplate "html/template"
+	"path/filepath"
+	texttemplate "text/template"
+
+	"github.com/spf13/viper"
+)
+
+// render executes the <event>.txt and <event>.html templates found in
+// IKT_STACK_TEMPLATES_NOTIFICATIONS_DIR.
+func render(event string, data Data) (string, string, error) {
+	dir := viper.GetString("IKT_STACK_TEMPLATES_NOTIFICATIONS_DIR")
+
+	textTemplate, err := texttemplate.ParseFiles(filepath.Join(dir, event+".txt"))
+	if err != nil {
+		return "", "", err
+	}
+
+	htmlTemplate, err := htmltemplate.ParseFiles(filepath.Join(dir, event+".html"))
+	if err != nil {
+		return "", "", err
+	}
+
+	var text bytes.Buffer
+	if err := textTemplate.Execute(&text, data); err != nil {
+		return "", "", err
+	}
+
+	var html bytes.Buffer
+	if err := htmlTemplate.Execute(&html, data); err != nil {
+		return "", "", err
+	}
+
+	return text.String(), html.String(), nil
+}
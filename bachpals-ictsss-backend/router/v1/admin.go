@@ -1,34 +1,52 @@
 package v1
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
 	"strings"
 
 	"github.com/gin-gonic/gin"
-	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/database/repositories"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/apierror"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/database"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/gopher"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/health"
 	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/httputils"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/services"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/storage"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/webhooks"
 )
 
 type RequestBodyAdminCreate struct {
-	Name string `json:"name"`
+	Name string `json:"name" binding:"required,max=100"`
 	RequestBodyUserId
 }
 
+// RequestBodyAdoptServer assigns an untracked server, the first user is the owner.
+type RequestBodyAdoptServer struct {
+	ServerImage      string   `json:"server_image" binding:"required,uuid"`
+	Users            []string `json:"users" binding:"required,min=1,max=100,dive,user_id"`
+	CourseCode       string   `json:"course_code" binding:"omitempty,max=20,numeric"`
+	AssignFloatingIp bool     `json:"assign_floating_ip"`
+	// Target is the OpenStack target the server runs in, the first one when it is empty.
+	Target string `json:"target" binding:"omitempty,max=64"`
+}
+
 type RequestBodyAdminUpdate struct {
-	Name      string `json:"name"`
-	UserId    string `json:"user_id"`
-	UpdatedId string `json:"updated_id"`
+	Name      string `json:"name" binding:"required,max=100"`
+	UserId    string `json:"user_id" binding:"required,user_id"`
+	UpdatedId string `json:"updated_id" binding:"required,user_id"`
 }
 
+// errAdminRequired is returned to users that are not administrators.
+var errAdminRequired = apierror.New(apierror.AdminRequired, "Administrator credentials required!")
+
 func IsAdmin(c *gin.Context) bool {
 	id := c.MustGet("user_id")
 
 	// Check if user performing the request is an admin
-	admin := repositories.ReadAdminById(id.(string))
-	if admin == nil {
-		return false
-	}
-	return true
+	_, err := storage.Admins().ReadAdminById(c.Request.Context(), id.(string))
+	return err == nil
 }
 
 // GetAdministrator godoc
@@ -41,11 +59,12 @@ func IsAdmin(c *gin.Context) bool {
 // @Success     200 {object}	database.Admin
 // @Failure     400 {object}    nil
 // @Failure     401 {object}    nil
+// @Failure     403 {object}    nil
 // @Failure     500 {object}    nil
 // @Router      /admin/:id	[get]
 func GetAdministrator(c *gin.Context) {
 	if !IsAdmin(c) {
-		httputils.AbortWithStatusJSON(c, http.StatusUnauthorized, "Administrator credentials required!", nil)
+		httputils.AbortWithError(c, errAdminRequired)
 		return
 	}
 
@@ -55,14 +74,18 @@ func GetAdministrator(c *gin.Context) {
 	formattedUser := user[0] + "@uia.no"
 
 	if len(adminId) <= 0 {
-		httputils.AbortWithStatusJSON(c, http.StatusBadRequest, "Invalid id", nil)
+		httputils.AbortWithError(c, apierror.New(apierror.InvalidRequest, "Invalid id"))
 		return
 	}
 
-	adminUser := repositories.ReadAdminById(formattedUser)
+	adminUser, err := storage.Admins().ReadAdminById(c.Request.Context(), formattedUser)
 
-	if adminUser == nil {
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Something went wrong reading database!", nil)
+	if errors.Is(err, storage.ErrNotFound) {
+		httputils.AbortWithError(c, apierror.New(apierror.NotFound, "Administrator not found"))
+		return
+	}
+	if err != nil {
+		httputils.AbortWithError(c, apierror.Wrap(apierror.Internal, "Something went wrong reading database!", err))
 		return
 	}
 
@@ -78,18 +101,19 @@ func GetAdministrator(c *gin.Context) {
 // @Produce     json
 // @Success     200 {array}	[]database.Admin
 // @Failure     401 {object}    nil
+// @Failure     403 {object}    nil
 // @Failure     500 {object}    nil
 // @Router      /admin/	[get]
 func ListAdministrators(c *gin.Context) {
 	if !IsAdmin(c) {
-		httputils.AbortWithStatusJSON(c, http.StatusUnauthorized, "Administrator credentials required!", nil)
+		httputils.AbortWithError(c, errAdminRequired)
 		return
 	}
 
-	admins := repositories.ReadAdmins()
+	admins, err := storage.Admins().ReadAdmins(c.Request.Context())
 
-	if admins == nil {
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Something went wrong reading database!", nil)
+	if err != nil {
+		httputils.AbortWithError(c, apierror.Wrap(apierror.Internal, "Something went wrong reading database!", err))
 		return
 	}
 
@@ -107,33 +131,34 @@ func ListAdministrators(c *gin.Context) {
 // @Success     200 {array}	[]database.Admin
 // @Failure     400 {object}    nil
 // @Failure     401 {object}    nil
+// @Failure     403 {object}    nil
 // @Failure     500 {object}    nil
 // @Router		/admin/	[post]
 func AddAdministrator(c *gin.Context) {
 	if !IsAdmin(c) {
-		httputils.AbortWithStatusJSON(c, http.StatusUnauthorized, "Administrator credentials required!", nil)
+		httputils.AbortWithError(c, errAdminRequired)
 		return
 	}
 
 	var requestBody RequestBodyAdminCreate
 
-	err := c.BindJSON(&requestBody)
+	err := c.ShouldBindJSON(&requestBody)
 	if err != nil {
-		httputils.AbortWithStatusJSON(c, http.StatusBadRequest, "Invalid json body", nil)
+		httputils.AbortWithError(c, apierror.FromBind(err))
 		return
 	}
 
-	insertAdmin := repositories.InsertAdmin(requestBody.UserId, requestBody.Name)
+	err = services.AddAdmin(c.Request.Context(), requestBody.UserId, requestBody.Name)
 
-	if insertAdmin == nil {
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Something went wrong reading database!", nil)
+	if err != nil {
+		httputils.AbortWithError(c, serviceError(err, "Something went wrong reading database!"))
 		return
 	}
 
-	admins := repositories.ReadAdmins()
+	admins, err := storage.Admins().ReadAdmins(c.Request.Context())
 
-	if admins == nil {
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Something went wrong while reading data!", nil)
+	if err != nil {
+		httputils.AbortWithError(c, apierror.Wrap(apierror.Internal, "Something went wrong while reading data!", err))
 		return
 	}
 
@@ -151,45 +176,38 @@ func AddAdministrator(c *gin.Context) {
 // @Success     200 {array}	[]database.Admin
 // @Failure     400 {object}    nil
 // @Failure     401 {object}    nil
+// @Failure     403 {object}    nil
 // @Failure     500 {object}    nil
 // @Router		/admin/	[delete]
 func DelAdministrator(c *gin.Context) {
 	if !IsAdmin(c) {
-		httputils.AbortWithStatusJSON(c, http.StatusUnauthorized, "Administrator credentials required!", nil)
+		httputils.AbortWithError(c, errAdminRequired)
 		return
 	}
 
 	var requestBody RequestBodyUserId
 
-	err := c.BindJSON(&requestBody)
+	err := c.ShouldBindJSON(&requestBody)
 	if err != nil {
-		httputils.AbortWithStatusJSON(c, http.StatusBadRequest, "Invalid json body", nil)
+		httputils.AbortWithError(c, apierror.FromBind(err))
 		return
 	}
 
-	admins := repositories.ReadAdmins()
+	err = services.RemoveAdmin(c.Request.Context(), requestBody.UserId)
 
-	if admins == nil {
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Something went wrong reading database!", nil)
+	if errors.Is(err, storage.ErrNotFound) {
+		httputils.AbortWithError(c, apierror.Wrap(apierror.NotFound, services.Message(err, ""), err))
 		return
 	}
-
-	if len(admins) == 1 {
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Cannot delete last system administrator!", nil)
-		return
-	}
-
-	count := repositories.DeleteAdminById(requestBody.UserId)
-
-	if count == nil {
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Something went wrong reading database!", nil)
+	if err != nil {
+		httputils.AbortWithError(c, serviceError(err, "Something went wrong reading database!"))
 		return
 	}
 
-	admins = repositories.ReadAdmins()
+	admins, err := storage.Admins().ReadAdmins(c.Request.Context())
 
-	if admins == nil {
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Something went wrong reading database!", nil)
+	if err != nil {
+		httputils.AbortWithError(c, apierror.Wrap(apierror.Internal, "Something went wrong reading database!", err))
 		return
 	}
 
@@ -207,34 +225,300 @@ func DelAdministrator(c *gin.Context) {
 // @Success     200 {array}	[]database.Admin
 // @Failure     400 {object}    nil
 // @Failure     401 {object}    nil
+// @Failure     403 {object}    nil
 // @Failure     500 {object}    nil
 // @Router      /admin/	[put]
 func UpdateAdministrator(c *gin.Context) {
 	if !IsAdmin(c) {
-		httputils.AbortWithStatusJSON(c, http.StatusUnauthorized, "Administrator credentials required!", nil)
+		httputils.AbortWithError(c, errAdminRequired)
 		return
 	}
 
 	var requestBody RequestBodyAdminUpdate
-	err := c.BindJSON(&requestBody)
+	err := c.ShouldBindJSON(&requestBody)
 	if err != nil {
-		httputils.AbortWithStatusJSON(c, http.StatusBadRequest, "Invalid json body", nil)
+		httputils.AbortWithError(c, apierror.FromBind(err))
 		return
 	}
 
-	isUpdated := repositories.UpdateAdminById(requestBody.UserId, requestBody.UpdatedId, requestBody.Name)
-	if !isUpdated {
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Something went wrong while updating!", nil)
+	err = storage.Admins().UpdateAdminById(c.Request.Context(), requestBody.UserId, requestBody.UpdatedId, requestBody.Name)
+	if err != nil {
+		httputils.AbortWithError(c, apierror.Wrap(apierror.Internal, "Something went wrong while updating!", err))
 		return
 	}
 
-	admins := repositories.ReadAdmins()
+	webhooks.Publish(c.Request.Context(), webhooks.EventAdminUpdated, webhooks.AdminPayload{UserId: requestBody.UserId, Name: requestBody.Name, UpdatedId: requestBody.UpdatedId})
+
+	admins, err := storage.Admins().ReadAdmins(c.Request.Context())
 
-	if admins == nil {
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Something went wrong while reading data!", nil)
+	if err != nil {
+		httputils.AbortWithError(c, apierror.Wrap(apierror.Internal, "Something went wrong while reading data!", err))
 		return
 	}
 
 	httputils.ResponseJson(c, http.StatusOK, "", admins)
 	return
 }
+
+// ExportArchive godoc
+// @Summary		Exports all data
+// @Description	Downloads a versioned archive of administrators, images, virtual machines, templates and settings, it can be posted to /admin/import
+// @Tags        admin
+// @Produce     json
+// @Success     200 {object}	services.Archive
+// @Failure     401 {object}    nil
+// @Failure     403 {object}    nil
+// @Failure     500 {object}    nil
+// @Router      /admin/export	[get]
+func ExportArchive(c *gin.Context) {
+	if !IsAdmin(c) {
+		httputils.AbortWithError(c, errAdminRequired)
+		return
+	}
+
+	archive, err := services.Export(c.Request.Context())
+	if err != nil {
+		httputils.AbortWithError(c, serviceError(err, "Could not export data!"))
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"ictsss-export-%s.json\"", archive.Exported.UTC().Format("20060102T150405")))
+	c.JSON(http.StatusOK, archive)
+	return
+}
+
+// ImportArchive godoc
+// @Summary		Imports an archive
+// @Description	Validates an archive from /admin/export and adds the records that are not stored yet
+// @Tags        admin
+// @Accept      json
+// @Produce     json
+// @Param		requestBody	body	services.Archive	true	"Archive"
+// @Param		rebuild	query	bool	false	"Add missing virtual machines from OpenStack server metadata"
+// @Param		dry_run	query	bool	false	"Only validate the archive"
+// @Param		map_image	query	[]string	false	"Replace an OpenStack image id, OLD=NEW"
+// @Success     200 {object}	services.ImportResult
+// @Failure     400 {object}    []string
+// @Failure     401 {object}    nil
+// @Failure     403 {object}    nil
+// @Failure     500 {object}    nil
+// @Router      /admin/import	[post]
+func ImportArchive(c *gin.Context) {
+	if !IsAdmin(c) {
+		httputils.AbortWithError(c, errAdminRequired)
+		return
+	}
+
+	var archive services.Archive
+
+	err := c.ShouldBindJSON(&archive)
+	if err != nil {
+		httputils.AbortWithError(c, apierror.FromBind(err))
+		return
+	}
+
+	options := services.ImportOptions{
+		ImageIds: map[string]string{},
+		Rebuild:  c.Query("rebuild") == "true",
+		DryRun:   c.Query("dry_run") == "true",
+	}
+	for _, v := range c.QueryArray("map_image") {
+		pair := strings.SplitN(v, "=", 2)
+		if len(pair) != 2 || len(pair[0]) == 0 || len(pair[1]) == 0 {
+			httputils.AbortWithError(c, apierror.New(apierror.InvalidRequest, "map_image must be OLD=NEW"))
+			return
+		}
+		options.ImageIds[pair[0]] = pair[1]
+	}
+
+	result, err := services.Import(c.Request.Context(), archive, options)
+
+	var invalid *services.ValidationError
+	if errors.As(err, &invalid) {
+		httputils.AbortWithError(c, apierror.Wrap(apierror.ValidationFailed, services.Message(err, ""), err).WithData(invalid.Problems))
+		return
+	}
+	if err != nil {
+		httputils.AbortWithError(c, serviceError(err, "Could not import data!").WithData(result))
+		return
+	}
+
+	httputils.ResponseJson(c, http.StatusOK, "", result)
+	return
+}
+
+// GetStatus godoc
+// @Summary		Shows the status of the dependencies
+// @Description	Runs the health checks of Mongo, Keystone, Canvas and the template directories, results are cached for a few seconds
+// @Tags        admin
+// @Produce     json
+// @Success     200 {array}	[]health.Result
+// @Failure     401 {object}    nil
+// @Failure     403 {object}    nil
+// @Router      /admin/status	[get]
+func GetStatus(c *gin.Context) {
+	if !IsAdmin(c) {
+		httputils.AbortWithError(c, errAdminRequired)
+		return
+	}
+
+	httputils.ResponseJson(c, http.StatusOK, "", health.Results())
+	return
+}
+
+// GetCapacity godoc
+// @Summary		Shows the OpenStack capacity
+// @Description	Shows the instance, core, ram, volume and floating ip quota of every OpenStack target and how many virtual machines of an image still fit, the default flavor is used without an image
+// @Tags        admin
+// @Produce     json
+// @Param		image	query	string	false	"Image ID"
+// @Success     200 {array}	[]services.Capacity
+// @Failure     400 {object}    nil
+// @Failure     401 {object}    nil
+// @Failure     403 {object}    nil
+// @Failure     502 {object}    nil
+// @Router      /admin/capacity	[get]
+func GetCapacity(c *gin.Context) {
+	if !IsAdmin(c) {
+		httputils.AbortWithError(c, errAdminRequired)
+		return
+	}
+
+	imageId := c.Query("image")
+	if len(imageId) > 0 {
+		if err := requireImage(c.Request.Context(), "image", imageId); err != nil {
+			httputils.AbortWithError(c, err)
+			return
+		}
+	}
+
+	capacities, err := services.Capacities(c.Request.Context(), imageId)
+	if err != nil {
+		httputils.AbortWithError(c, serviceError(err, "Could not read the quota!"))
+		return
+	}
+
+	httputils.ResponseJson(c, http.StatusOK, "", capacities)
+	return
+}
+
+// ListFloatingIps godoc
+// @Summary		Lists floating ips
+// @Description	Lists the floating ips in the project of every OpenStack target as attached, claimed, allocated (free and kept for reuse), orphaned, untracked or missing
+// @Tags        admin
+// @Produce     json
+// @Success     200 {array}	[]services.FloatingIpStatus
+// @Failure     401 {object}    nil
+// @Failure     403 {object}    nil
+// @Failure     502 {object}    nil
+// @Router      /admin/floating-ips	[get]
+func ListFloatingIps(c *gin.Context) {
+	if !IsAdmin(c) {
+		httputils.AbortWithError(c, errAdminRequired)
+		return
+	}
+
+	statuses, err := services.FloatingIps(c.Request.Context())
+	if err != nil {
+		httputils.AbortWithError(c, serviceError(err, "Could not read floating ips!"))
+		return
+	}
+
+	httputils.ResponseJson(c, http.StatusOK, "", statuses)
+	return
+}
+
+// ListUntrackedServers godoc
+// @Summary		Lists untracked servers
+// @Description	Lists servers in the OpenStack project that have no virtual machine record, for example ones created in Horizon
+// @Tags        admin
+// @Produce     json
+// @Success     200 {array}	[]services.UntrackedServer
+// @Failure     401 {object}    nil
+// @Failure     403 {object}    nil
+// @Failure     500 {object}    nil
+// @Failure     502 {object}    nil
+// @Router      /admin/servers/untracked	[get]
+func ListUntrackedServers(c *gin.Context) {
+	if !IsAdmin(c) {
+		httputils.AbortWithError(c, errAdminRequired)
+		return
+	}
+
+	untracked, err := services.UntrackedServers(c.Request.Context())
+	if err != nil {
+		httputils.AbortWithError(c, serviceError(err, "Could not read servers!"))
+		return
+	}
+
+	httputils.ResponseJson(c, http.StatusOK, "", untracked)
+	return
+}
+
+// AdoptServer godoc
+// @Summary		Adopts an untracked server
+// @Description	Assigns owner, members and image to a server without a record, detects its floating ip and manages it like an ordered virtual machine
+// @Tags        admin
+// @Accept      json
+// @Produce     json
+// @Param		serverId	path	string	true	"Server ID"
+// @Param		requestBody	body	RequestBodyAdoptServer	true	"Request Body"
+// @Success     200 {object}	database.VirtualMachine
+// @Failure     400 {object}    nil
+// @Failure     401 {object}    nil
+// @Failure     403 {object}    nil
+// @Failure     404 {object}    nil
+// @Failure     409 {object}    nil
+// @Failure     500 {object}    nil
+// @Failure     502 {object}    nil
+// @Router      /admin/servers/:id/adopt	[post]
+func AdoptServer(c *gin.Context) {
+	if !IsAdmin(c) {
+		httputils.AbortWithError(c, errAdminRequired)
+		return
+	}
+
+	var requestBody RequestBodyAdoptServer
+
+	err := c.ShouldBindJSON(&requestBody)
+	if err != nil {
+		httputils.AbortWithError(c, apierror.FromBind(err))
+		return
+	}
+
+	if err := requireImage(c.Request.Context(), "server_image", requestBody.ServerImage); err != nil {
+		httputils.AbortWithError(c, err)
+		return
+	}
+
+	vm, err := services.AdoptServer(c.Request.Context(), services.AdoptRequest{
+		ServerId:         c.Param("id"),
+		ImageId:          requestBody.ServerImage,
+		CourseCode:       requestBody.CourseCode,
+		Members:          database.MembersFromUserIds(requestBody.Users),
+		AdoptedBy:        c.MustGet("user_id").(string),
+		AssignFloatingIp: requestBody.AssignFloatingIp,
+		Target:           requestBody.Target,
+	})
+
+	if errors.Is(err, gopher.ErrUnknownTarget) {
+		httputils.AbortWithError(c, apierror.Wrap(apierror.ValidationFailed, services.Message(err, ""), err).
+			WithField("target", "invalid", "is not a configured openstack target"))
+		return
+	}
+	if errors.Is(err, storage.ErrNotFound) {
+		httputils.AbortWithError(c, apierror.Wrap(apierror.NotFound, services.Message(err, "Server not found!"), err))
+		return
+	}
+	if errors.Is(err, services.ErrAlreadyTracked) {
+		httputils.AbortWithError(c, apierror.Wrap(apierror.AlreadyTracked, services.Message(err, ""), err))
+		return
+	}
+	if err != nil {
+		httputils.AbortWithError(c, serviceError(err, "Unable to adopt server!"))
+		return
+	}
+
+	httputils.ResponseJson(c, http.StatusOK, "Server adopted successfully!", vm)
+	return
+}
@@ -6,14 +6,15 @@ import (
 	b64 "encoding/base64"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
 	"net/url"
 
 	"github.com/gin-gonic/gin"
 	"github.com/rithujohn191/go-oidc"
 	"github.com/spf13/viper"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/apierror"
 	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/httputils"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/middleware"
 	"golang.org/x/oauth2"
 )
 
@@ -78,46 +79,10 @@ func HandleLogout(c *gin.Context) {
 // @Failure     500 {object}    nil
 // @Router      /oauth2/userdata    [post]
 func HandleUserdata(c *gin.Context) {
-	authorization := c.GetHeader("Authorization")
-
-	if len(authorization) <= 0 {
-		c.AbortWithStatus(http.StatusUnauthorized)
-		return
-	}
-
-	client := &http.Client{}
-
-	req, err := http.NewRequest("GET", viper.GetString("IKT_STACK_AUTH_MIDDLEWARE_URL"), nil)
+	jsonData, err := middleware.Userinfo(c.GetHeader("Authorization"))
 
 	if err != nil {
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Error while reaching endpoint", err)
-		return
-	}
-
-	req.Header.Add("Authorization", authorization)
-	resp, err := client.Do(req)
-
-	// Any status code that is not 200 OK should return false
-	if resp.StatusCode == http.StatusUnauthorized {
-		httputils.AbortWithStatusJSON(c, http.StatusUnauthorized, "Missing authorization header", err)
-		return
-	}
-
-	if resp.StatusCode != 200 {
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Something went wrong!", err)
-		return
-	}
-
-	bodyBytes, err := io.ReadAll(resp.Body)
-	if err != nil {
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Error while reading data", err)
-		return
-	}
-
-	var jsonData map[string]interface{}
-	err = json.Unmarshal(bodyBytes, &jsonData)
-	if err != nil {
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Error while encoding data", err)
+		httputils.AbortWithError(c, err)
 		return
 	}
 
@@ -152,12 +117,13 @@ func HandleCallback(c *gin.Context) {
 	ctx := context.Background()
 	provider, err := oidc.NewProvider(ctx, viper.GetString("IKT_STACK_AUTHORITY"))
 	if err != nil {
-		// handle error
+		httputils.AbortWithError(c, apierror.Wrap(apierror.AuthProvider, "Could not reach the identity provider!", err))
+		return
 	}
 
 	oauth2Token, err := oauth2Config.Exchange(ctx, c.Request.URL.Query().Get("code"))
 	if err != nil {
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Failed to exchange token", err.Error())
+		httputils.AbortWithError(c, apierror.Wrap(apierror.Unauthenticated, "Failed to exchange token!", err))
 		return
 	}
 
@@ -169,13 +135,13 @@ func HandleCallback(c *gin.Context) {
 
 	rawIDToken, ok := oauth2Token.Extra("id_token").(string)
 	if !ok {
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "No id_token field in oauth2 token.", err.Error())
+		httputils.AbortWithError(c, apierror.New(apierror.AuthProvider, "No id_token field in oauth2 token!"))
 		return
 	}
 
 	idToken, err := verifier.Verify(ctx, rawIDToken)
 	if err != nil {
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Failed to verify ID Token:", err.Error())
+		httputils.AbortWithError(c, apierror.Wrap(apierror.Unauthenticated, "Failed to verify ID token!", err))
 		return
 	}
 
@@ -185,7 +151,7 @@ func HandleCallback(c *gin.Context) {
 	}{oauth2Token, new(json.RawMessage)}
 
 	if err := idToken.Claims(&respIdToken.IDTokenClaims); err != nil {
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "", err.Error())
+		httputils.AbortWithError(c, apierror.Wrap(apierror.AuthProvider, "Could not read the ID token claims!", err))
 		return
 	}
 
@@ -1,16 +1,13 @@
 package v1
 
 import (
-	"encoding/json"
 	"fmt"
-	"io"
-	"log"
 	"net/http"
-	"reflect"
 
 	"github.com/gin-gonic/gin"
-	"github.com/spf13/viper"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/apierror"
 	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/httputils"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/services"
 )
 
 type CanvasCourse struct {
@@ -92,55 +89,6 @@ type BlueprintRestrictionsByObjectType struct {
 	WikiPage   WikiPage   `json:"wiki_page"`
 }
 
-func requestCanvasApi(method string, path string, body io.Reader, asArray bool) (interface{}, error) {
-	req, err := http.NewRequest(method, viper.GetString("IKT_STACK_CANVAS_API_URL")+path, body)
-	if err != nil {
-		log.Fatalln(err)
-	}
-
-	req.Header.Set("Accept", "application/json")
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", viper.GetString("IKT_STACK_CANVAS_API_KEY")))
-
-	client := &http.Client{}
-	resp, responseErr := client.Do(req)
-	if responseErr != nil {
-		return nil, responseErr
-	}
-
-	defer resp.Body.Close()
-
-	b, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-
-	if asArray {
-		if reflect.TypeOf(b).Kind() == reflect.Map {
-			var d map[string]interface{}
-			err = json.Unmarshal(b, &d)
-			if err != nil {
-				return nil, err
-			}
-		}
-
-		var d []interface{}
-		err = json.Unmarshal(b, &d)
-		if err != nil {
-			return nil, err
-		}
-
-		return d, nil
-	}
-
-	var d interface{}
-	err = json.Unmarshal(b, &d)
-	if err != nil {
-		return nil, err
-	}
-
-	return d, nil
-}
-
 // GetCourses godoc
 // @Summary		Fetches courses from Canvas
 // @Description	Fetches a course or an array of courses from the Canvas API
@@ -150,18 +98,19 @@ func requestCanvasApi(method string, path string, body io.Reader, asArray bool)
 // @Success     200 {object}	interface{}
 // @Failure     400 {object}    nil
 // @Failure     401 {object}    nil
+// @Failure     403 {object}    nil
 // @Failure     500 {object}    nil
 // @Router      /courses/	[get]
 func GetCourses(c *gin.Context) {
 	if !IsAdmin(c) {
-		httputils.AbortWithStatusJSON(c, http.StatusUnauthorized, "Administrator credentials required!", nil)
+		httputils.AbortWithError(c, errAdminRequired)
 		return
 	}
 
 	// https://community.canvaslms.com/t5/Canvas-Question-Forum/Getting-a-list-of-ALL-courses/m-p/185855/highlight/true#M89957
-	response, err := requestCanvasApi("GET", "/courses?per_page=100", nil, true)
+	response, err := services.RequestCanvasApi(c.Request.Context(), "GET", "/courses?per_page=100", nil, true)
 	if err != nil {
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Error reading canvas api", nil)
+		httputils.AbortWithError(c, apierror.Wrap(apierror.Canvas, "Error reading canvas api", err))
 		return
 	}
 
@@ -179,19 +128,20 @@ func GetCourses(c *gin.Context) {
 // @Success     200 {object}	interface{}
 // @Failure     400 {object}    nil
 // @Failure     401 {object}    nil
+// @Failure     403 {object}    nil
 // @Failure     500 {object}    nil
 // @Router      /courses/:id/users	[get]
 func GetCourseStudents(c *gin.Context) {
 	if !IsAdmin(c) {
-		httputils.AbortWithStatusJSON(c, http.StatusUnauthorized, "Administrator credentials required!", nil)
+		httputils.AbortWithError(c, errAdminRequired)
 		return
 	}
 
 	courseId := c.Param("id")
 
-	response, err := requestCanvasApi("GET", fmt.Sprintf("/courses/%s/users?per_page=1000", courseId), nil, true)
+	response, err := services.RequestCanvasApi(c.Request.Context(), "GET", fmt.Sprintf("/courses/%s/users?per_page=1000", courseId), nil, true)
 	if err != nil {
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Error reading canvas api", nil)
+		httputils.AbortWithError(c, apierror.Wrap(apierror.Canvas, "Error reading canvas api", err))
 		return
 	}
 
@@ -209,19 +159,20 @@ func GetCourseStudents(c *gin.Context) {
 // @Success     200 {object}	interface{}
 // @Failure     400 {object}    nil
 // @Failure     401 {object}    nil
+// @Failure     403 {object}    nil
 // @Failure     500 {object}    nil
 // @Router      /courses/:id/groups	[get]
 func GetCourseGroups(c *gin.Context) {
 	if !IsAdmin(c) {
-		httputils.AbortWithStatusJSON(c, http.StatusUnauthorized, "Administrator credentials required!", nil)
+		httputils.AbortWithError(c, errAdminRequired)
 		return
 	}
 
 	courseId := c.Param("id")
 
-	response, err := requestCanvasApi("GET", fmt.Sprintf("/courses/%s/groups?per_page=1000", courseId), nil, true)
+	response, err := services.RequestCanvasApi(c.Request.Context(), "GET", fmt.Sprintf("/courses/%s/groups?per_page=1000", courseId), nil, true)
 	if err != nil {
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Error reading canvas api", nil)
+		httputils.AbortWithError(c, apierror.Wrap(apierror.Canvas, "Error reading canvas api", err))
 		return
 	}
 
@@ -239,20 +190,21 @@ func GetCourseGroups(c *gin.Context) {
 // @Success     200 {object}	interface{}
 // @Failure     400 {object}    nil
 // @Failure     401 {object}    nil
+// @Failure     403 {object}    nil
 // @Failure     500 {object}    nil
 // @Router      /courses/groups/:id/users	[get]
 func GetGroupUsers(c *gin.Context) {
 	if !IsAdmin(c) {
-		httputils.AbortWithStatusJSON(c, http.StatusUnauthorized, "Administrator credentials required!", nil)
+		httputils.AbortWithError(c, errAdminRequired)
 		return
 	}
 
 	groupId := c.Param("id")
 
-	response, err := requestCanvasApi("GET", fmt.Sprintf("/groups/%s/users?per_page=1000", groupId), nil, false)
+	response, err := services.RequestCanvasApi(c.Request.Context(), "GET", fmt.Sprintf("/groups/%s/users?per_page=1000", groupId), nil, false)
 
 	if err != nil {
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Error reading canvas api", nil)
+		httputils.AbortWithError(c, apierror.Wrap(apierror.Canvas, "Error reading canvas api", err))
 		return
 	}
 
@@ -1,20 +1,23 @@
 package v1
 
 import (
-	"fmt"
+	"errors"
 	"io/ioutil"
 	"net/http"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
-	"github.com/gophercloud/gophercloud/openstack/imageservice/v2/images"
 	"github.com/spf13/viper"
-	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/database/repositories"
-	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/gopher"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/apierror"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/database"
 	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/httputils"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/services"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/storage"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/webhooks"
 )
 
 type ImageIdStruct struct {
-	Id string `json:"id"`
+	Id string `json:"id" binding:"required,record_id"`
 }
 
 type GetServerImagesMapping struct {
@@ -23,24 +26,18 @@ type GetServerImagesMapping struct {
 }
 
 type AddImageStruct struct {
-	Published             string `json:"published"`
-	ImageId               string `json:"image_id"`
-	ImageName             string `json:"image_name"`
-	ImageDescription      string `json:"image_description"`
-	ImageDisplayName      string `json:"image_display_name"`
-	ImageConfig           string `json:"image_config"`
+	Published             bool   `json:"published"`
+	ImageId               string `json:"image_id" binding:"required,uuid"`
+	ImageName             string `json:"image_name" binding:"required,max=255"`
+	ImageDescription      string `json:"image_description" binding:"max=1000"`
+	ImageDisplayName      string `json:"image_display_name" binding:"required,max=100"`
+	ImageConfig           string `json:"image_config" binding:"max=65536"`
 	ImageReadRootPassword bool   `json:"image_read_root_password"`
 }
 
 type UpdateImageStruct struct {
-	Id                    string `json:"id"`
-	Published             string `json:"published"`
-	ImageId               string `json:"image_id"`
-	ImageName             string `json:"image_name"`
-	ImageDescription      string `json:"image_description"`
-	ImageDisplayName      string `json:"image_display_name"`
-	ImageConfig           string `json:"image_config"`
-	ImageReadRootPassword bool   `json:"image_read_root_password"`
+	Id string `json:"id" binding:"required,record_id"`
+	AddImageStruct
 }
 
 type PublishedImagesStruct struct {
@@ -56,34 +53,27 @@ type PublishedImagesStruct struct {
 // @Produce     json
 // @Success     200 {object}    []database.Images
 // @Failure     401 {object}    nil
+// @Failure     403 {object}    nil
 // @Failure     500 {object}    nil
+// @Failure     502 {object}    nil
 // @Router      /image/server   [get]
 func GetServerImages(c *gin.Context) {
 	if !IsAdmin(c) {
-		httputils.AbortWithStatusJSON(c, http.StatusUnauthorized, "Administrator credentials required!", nil)
+		httputils.AbortWithError(c, errAdminRequired)
 		return
 	}
 
-	client := gopher.GetClient()
-
-	allPages, err := images.List(client, nil).AllPages()
+	serverImages, err := services.ServerImages(c.Request.Context())
 	if err != nil {
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Could not read images!", nil)
-		return
-	}
-
-	allImages, err := images.ExtractImages(allPages)
-	if err != nil {
-		fmt.Println("Could not load images from instance!", err)
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Could not extract information from server!", nil)
+		httputils.AbortWithError(c, serviceError(err, "Could not read images!"))
 		return
 	}
 
 	var imagesList []GetServerImagesMapping
-	for _, v := range allImages {
+	for _, v := range serverImages {
 		imagesList = append(imagesList, GetServerImagesMapping{
 			Name:    v.Name,
-			ImageId: v.ID,
+			ImageId: v.ImageId,
 		})
 	}
 
@@ -98,27 +88,43 @@ func GetServerImages(c *gin.Context) {
 // @Accept      json
 // @Produce     json
 // @Param       id  path    string  true    "Image ID"
+// @Success     200 {object}    database.Images
 // @Failure     400 {object}    nil
-// @Failure     401 {object}    nil
-// @Success     501 {object}    nil
+// @Failure     403 {object}    nil
+// @Failure     404 {object}    nil
+// @Failure     500 {object}    nil
 // @Router      /image/:id  [get]
 func GetImage(c *gin.Context) {
 	if !IsAdmin(c) {
-		httputils.AbortWithStatusJSON(c, http.StatusUnauthorized, "Administrator credentials required!", nil)
+		httputils.AbortWithError(c, errAdminRequired)
 		return
 	}
 
 	id := c.Param("id")
 
 	if len(id) == 0 {
-		httputils.AbortWithStatusJSON(c, http.StatusBadRequest, "Invalid id", nil)
+		httputils.AbortWithError(c, apierror.New(apierror.InvalidRequest, "Invalid id"))
 		return
 	}
 
-	httputils.ResponseJson(c, http.StatusNotImplemented, "", nil)
+	image, err := storage.Images().GetImageById(c.Request.Context(), id)
+	if err != nil {
+		httputils.AbortWithError(c, imageError(err, "Error while reading images!"))
+		return
+	}
+
+	httputils.ResponseJson(c, http.StatusOK, "", image)
 	return
 }
 
+// imageError reports a failed image lookup or write, an image that does not exist is a 404.
+func imageError(err error, message string) *apierror.Error {
+	if errors.Is(err, storage.ErrNotFound) {
+		return apierror.Wrap(apierror.NotFound, "Image not found!", err)
+	}
+	return apierror.Wrap(apierror.Internal, message, err)
+}
+
 // GetImages godoc
 // @Summary     Retrieve list of images
 // @Description Retrieves a list of images that can be used by admins
@@ -128,17 +134,18 @@ func GetImage(c *gin.Context) {
 // @Success     200 {object}    []database.Images
 // @Failure     400 {object}    nil
 // @Failure     401 {object}    nil
+// @Failure     403 {object}    nil
 // @Failure     500 {object}    nil
 // @Router      /image/ [get]
 func GetImages(c *gin.Context) {
 	if !IsAdmin(c) {
-		httputils.AbortWithStatusJSON(c, http.StatusUnauthorized, "Administrator credentials required!", nil)
+		httputils.AbortWithError(c, errAdminRequired)
 		return
 	}
 
-	imagesList := repositories.GetImages()
-	if imagesList == nil {
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Error while reading images!", nil)
+	imagesList, err := storage.Images().GetImages(c.Request.Context())
+	if err != nil {
+		httputils.AbortWithError(c, apierror.Wrap(apierror.Internal, "Error while reading images!", err))
 		return
 	}
 
@@ -156,39 +163,45 @@ func GetImages(c *gin.Context) {
 // @Success     200 {object}    []database.Images
 // @Failure     400 {object}    nil
 // @Failure     401 {object}    nil
+// @Failure     403 {object}    nil
 // @Failure     500 {object}    nil
 // @Router      /image/ [post]
 func AddImage(c *gin.Context) {
 	if !IsAdmin(c) {
-		httputils.AbortWithStatusJSON(c, http.StatusUnauthorized, "Administrator credentials required!", nil)
+		httputils.AbortWithError(c, errAdminRequired)
 		return
 	}
 
 	var image AddImageStruct
-	err := c.BindJSON(&image)
+	err := c.ShouldBindJSON(&image)
 	if err != nil {
-		httputils.AbortWithStatusJSON(c, http.StatusBadRequest, "Bad request, invalid json data!", nil)
+		httputils.AbortWithError(c, apierror.FromBind(err))
 		return
 	}
 
-	data := make(map[string]interface{})
-	data["ImageId"] = image.ImageId
-	data["ImageName"] = image.ImageName
-	data["ImageDescription"] = image.ImageDescription
-	data["ImageDisplayName"] = image.ImageDisplayName
-	data["Published"] = image.Published
-	data["ImageConfig"] = image.ImageConfig
-	data["ImageReadRootPassword"] = image.ImageReadRootPassword
-
-	inserted := repositories.InsertImage(data)
-	if inserted == nil {
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Error while inserting image!", nil)
+	err = storage.Images().InsertImage(c.Request.Context(), database.Images{
+		ImageId:               image.ImageId,
+		ImageName:             image.ImageName,
+		ImageDescription:      image.ImageDescription,
+		ImageDisplayName:      image.ImageDisplayName,
+		Published:             strconv.FormatBool(image.Published),
+		ImageConfig:           image.ImageConfig,
+		ImageReadRootPassword: image.ImageReadRootPassword,
+	})
+	if err != nil {
+		httputils.AbortWithError(c, apierror.Wrap(apierror.Internal, "Error while inserting image!", err))
 		return
 	}
 
-	imagesList := repositories.GetImages()
-	if imagesList == nil {
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Error while reading images!", nil)
+	webhooks.Publish(c.Request.Context(), webhooks.EventImageCreated, webhooks.ImagePayload{
+		ImageId:          image.ImageId,
+		ImageDisplayName: image.ImageDisplayName,
+		Published:        strconv.FormatBool(image.Published),
+	})
+
+	imagesList, err := storage.Images().GetImages(c.Request.Context())
+	if err != nil {
+		httputils.AbortWithError(c, apierror.Wrap(apierror.Internal, "Error while reading images!", err))
 		return
 	}
 
@@ -206,30 +219,33 @@ func AddImage(c *gin.Context) {
 // @Success     200 {object}    []database.Images
 // @Failure     400 {object}    nil
 // @Failure     401 {object}    nil
+// @Failure     403 {object}    nil
 // @Failure     500 {object}    nil
 // @Router      /image/ [delete]
 func DeleteImage(c *gin.Context) {
 	if !IsAdmin(c) {
-		httputils.AbortWithStatusJSON(c, http.StatusUnauthorized, "Administrator credentials required!", nil)
+		httputils.AbortWithError(c, errAdminRequired)
 		return
 	}
 
 	var body ImageIdStruct
-	err := c.BindJSON(&body)
+	err := c.ShouldBindJSON(&body)
 	if err != nil {
-		httputils.AbortWithStatusJSON(c, http.StatusBadRequest, "Bad request, invalid json data!", nil)
+		httputils.AbortWithError(c, apierror.FromBind(err))
 		return
 	}
 
-	deleted := repositories.DeleteImageById(body.Id)
-	if deleted == nil {
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Error while deleting images!", nil)
+	err = storage.Images().DeleteImageById(c.Request.Context(), body.Id)
+	if err != nil {
+		httputils.AbortWithError(c, imageError(err, "Error while deleting images!"))
 		return
 	}
 
-	imagesList := repositories.GetImages()
-	if imagesList == nil {
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Error while reading images!", nil)
+	webhooks.Publish(c.Request.Context(), webhooks.EventImageDeleted, webhooks.ImagePayload{Id: body.Id})
+
+	imagesList, err := storage.Images().GetImages(c.Request.Context())
+	if err != nil {
+		httputils.AbortWithError(c, apierror.Wrap(apierror.Internal, "Error while reading images!", err))
 		return
 	}
 
@@ -247,43 +263,50 @@ func DeleteImage(c *gin.Context) {
 // @Success     200 {object}    database.Images
 // @Failure     400 {object}    nil
 // @Failure     401 {object}    nil
+// @Failure     403 {object}    nil
 // @Failure     500 {object}    nil
 // @Router      /image/ [put]
 func UpdateImage(c *gin.Context) {
 	if !IsAdmin(c) {
-		httputils.AbortWithStatusJSON(c, http.StatusUnauthorized, "Administrator credentials required!", nil)
+		httputils.AbortWithError(c, errAdminRequired)
 		return
 	}
 
 	var image UpdateImageStruct
-	err := c.BindJSON(&image)
+	err := c.ShouldBindJSON(&image)
 	if err != nil {
-		httputils.AbortWithStatusJSON(c, http.StatusBadRequest, "Bad request, invalid json data!", nil)
+		httputils.AbortWithError(c, apierror.FromBind(err))
 		return
 	}
 
-	data := make(map[string]interface{})
-	data["Id"] = image.Id
-	data["ImageId"] = image.ImageId
-	data["ImageName"] = image.ImageName
-	data["ImageDescription"] = image.ImageDescription
-	data["ImageDisplayName"] = image.ImageDisplayName
-	data["Published"] = image.Published
-	data["ImageConfig"] = image.ImageConfig
-	data["ImageReadRootPassword"] = image.ImageReadRootPassword
-
-	updated := repositories.UpdateImageById(data)
-	if updated == nil {
-		httputils.AbortWithStatusJSON(c, http.StatusUnauthorized, "Could not update image!", nil)
+	err = storage.Images().UpdateImageById(c.Request.Context(), database.Images{
+		Id:                    image.Id,
+		ImageId:               image.ImageId,
+		ImageName:             image.ImageName,
+		ImageDescription:      image.ImageDescription,
+		ImageDisplayName:      image.ImageDisplayName,
+		Published:             strconv.FormatBool(image.Published),
+		ImageConfig:           image.ImageConfig,
+		ImageReadRootPassword: image.ImageReadRootPassword,
+	})
+	if err != nil {
+		httputils.AbortWithError(c, imageError(err, "Could not update image!"))
 		return
 	}
 
-	imageUpdated := repositories.GetImageById(data["Id"])
-	if imageUpdated == nil {
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Error while reading images!", nil)
+	imageUpdated, err := storage.Images().GetImageById(c.Request.Context(), image.Id)
+	if err != nil {
+		httputils.AbortWithError(c, apierror.Wrap(apierror.Internal, "Error while reading images!", err))
 		return
 	}
 
+	webhooks.Publish(c.Request.Context(), webhooks.EventImageUpdated, webhooks.ImagePayload{
+		Id:               imageUpdated.Id,
+		ImageId:          imageUpdated.ImageId,
+		ImageDisplayName: imageUpdated.ImageDisplayName,
+		Published:        imageUpdated.Published,
+	})
+
 	httputils.ResponseJson(c, http.StatusOK, "", imageUpdated)
 	return
 }
@@ -296,17 +319,18 @@ func UpdateImage(c *gin.Context) {
 // @Produce     json
 // @Success     200 {object}    []string
 // @Failure     401 {object}    nil
+// @Failure     403 {object}    nil
 // @Failure     500 {object}    nil
 // @Router      /image/config   [get]
 func GetImagesConfig(c *gin.Context) {
 	if !IsAdmin(c) {
-		httputils.AbortWithStatusJSON(c, http.StatusUnauthorized, "Administrator credentials required!", nil)
+		httputils.AbortWithError(c, errAdminRequired)
 		return
 	}
 
 	dir, err := ioutil.ReadDir(viper.GetString("IKT_STACK_TEMPLATES_USERDATA_DIR"))
 	if err != nil {
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Error while reading dir!", nil)
+		httputils.AbortWithError(c, apierror.Wrap(apierror.Internal, "Error while reading dir!", err))
 		return
 	}
 
@@ -329,9 +353,9 @@ func GetImagesConfig(c *gin.Context) {
 // @Failure     500 {object}    nil
 // @Router      /image/published    [get]
 func GetPublishedImages(c *gin.Context) {
-	imagesList := repositories.GetPublishedImages()
-	if imagesList == nil {
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Error while reading images!", nil)
+	imagesList, err := storage.Images().GetPublishedImages(c.Request.Context())
+	if err != nil {
+		httputils.AbortWithError(c, apierror.Wrap(apierror.Internal, "Error while reading images!", err))
 		return
 	}
 
@@ -0,0 +1,80 @@
+package v1
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/apierror"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/database"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/httputils"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/notify"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/storage"
+)
+
+type RequestBodyNotificationPreferences struct {
+	Disabled bool     `json:"disabled"`
+	OptOut   []string `json:"opt_out" binding:"max=50"`
+}
+
+// GetNotificationPreferences godoc
+// @Summary     Fetches notification preferences
+// @Description Fetches which email notifications the user has opted out of
+// @Tags        notifications
+// @Accept      json
+// @Produce     json
+// @Success     200 {object}    database.NotificationPreferences
+// @Failure     500 {object}    nil
+// @Router      /notifications/preferences  [get]
+func GetNotificationPreferences(c *gin.Context) {
+	preferences, err := storage.Notifications().GetNotificationPreferences(c.Request.Context(), c.MustGet("user_id").(string))
+	if err != nil {
+		httputils.AbortWithError(c, apierror.Wrap(apierror.Internal, "Error while reading notification preferences!", err))
+		return
+	}
+
+	httputils.ResponseJson(c, http.StatusOK, "", preferences)
+	return
+}
+
+// UpdateNotificationPreferences godoc
+// @Summary     Updates notification preferences
+// @Description Opts the user out of all or some email notifications
+// @Tags        notifications
+// @Accept      json
+// @Produce     json
+// @Param       body    body    RequestBodyNotificationPreferences  true    "Request Body"
+// @Success     200 {object}    database.NotificationPreferences
+// @Failure     400 {object}    nil
+// @Failure     500 {object}    nil
+// @Router      /notifications/preferences  [put]
+func UpdateNotificationPreferences(c *gin.Context) {
+	var requestBody RequestBodyNotificationPreferences
+	err := c.ShouldBindJSON(&requestBody)
+	if err != nil {
+		httputils.AbortWithError(c, apierror.FromBind(err))
+		return
+	}
+
+	optOut := []string{}
+	for _, v := range requestBody.OptOut {
+		if !notify.IsEvent(v) {
+			httputils.AbortWithError(c, apierror.New(apierror.ValidationFailed, "Unknown notification event!").WithField("opt_out", "unknown_event", v+" is not a notification event"))
+			return
+		}
+		optOut = append(optOut, v)
+	}
+
+	preferences := database.NotificationPreferences{
+		UserId:   c.MustGet("user_id").(string),
+		Disabled: requestBody.Disabled,
+		OptOut:   optOut,
+	}
+
+	if err := storage.Notifications().UpdateNotificationPreferences(c.Request.Context(), preferences); err != nil {
+		httputils.AbortWithError(c, apierror.Wrap(apierror.Internal, "Could not update notification preferences!", err))
+		return
+	}
+
+	httputils.ResponseJson(c, http.StatusOK, "", preferences)
+	return
+}
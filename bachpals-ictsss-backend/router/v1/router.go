@@ -1,16 +1,51 @@
 package v1
 
 import (
+    "fmt"
     "github.com/gin-gonic/gin"
+    "gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/apierror"
+    "gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/gopher"
+    "gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/health"
+    "gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/httputils"
+    "gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/logging"
+    "gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/metrics"
     "gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/middleware"
+    "gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/services"
+    "gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/storage"
 )
 
 func Router() *gin.Engine {
-    router := gin.Default()
+    registerValidations()
 
+    router := gin.New()
+
+    router.Use(middleware.RequestId())
+    router.Use(middleware.Tracing())
+    router.Use(middleware.TraceId())
+    router.Use(middleware.Metrics())
+    router.Use(middleware.Logger())
+    router.Use(gin.CustomRecovery(func(c *gin.Context, recovered interface{}) {
+        httputils.AbortWithError(c, apierror.Wrap(apierror.Internal, "Something went wrong!", fmt.Errorf("panic: %v", recovered)))
+    }))
     router.Use(middleware.Cors)
     router.Use(middleware.Json())
 
+    router.NoRoute(func(c *gin.Context) {
+        httputils.AbortWithError(c, apierror.New(apierror.NotFound, "No such endpoint!"))
+    })
+
+    metrics.Register(services.VmCollector{})
+    router.GET("/metrics", gin.WrapH(metrics.Handler()))
+
+    health.Register(
+        health.Check{Name: "storage", Critical: true, Run: storage.Ping},
+        health.Check{Name: "keystone", Critical: true, Run: gopher.CheckToken},
+        health.Check{Name: "canvas", Run: services.CheckCanvas},
+        health.Check{Name: "templates", Critical: true, Run: services.CheckTemplates},
+    )
+    router.GET("/healthz", gin.WrapH(health.LiveHandler()))
+    router.GET("/readyz", gin.WrapH(health.ReadyHandler()))
+
     router.GET("/oauth2/provider", HandleSignIn)
     router.GET("/oauth2/redirect", HandleCallback)
     router.POST("/oauth2/userdata", HandleUserdata)
@@ -25,6 +60,13 @@ func Router() *gin.Engine {
             admin.POST("/", middleware.Authenticate, AddAdministrator)
             admin.DELETE("/", middleware.Authenticate, DelAdministrator)
             admin.PUT("/", middleware.Authenticate, UpdateAdministrator)
+            admin.GET("/status", middleware.Authenticate, GetStatus)
+            admin.GET("/capacity", middleware.Authenticate, GetCapacity)
+            admin.GET("/floating-ips", middleware.Authenticate, ListFloatingIps)
+            admin.GET("/export", middleware.Authenticate, ExportArchive)
+            admin.POST("/import", middleware.Authenticate, ImportArchive)
+            admin.GET("/servers/untracked", middleware.Authenticate, ListUntrackedServers)
+            admin.POST("/servers/:id/adopt", middleware.Authenticate, middleware.LogParam(logging.VmId, "id"), AdoptServer)
         }
 
         images := v1.Group("/image")
@@ -40,6 +82,7 @@ func Router() *gin.Engine {
         }
 
         vms := v1.Group("/vms")
+        vms.Use(middleware.LogParam(logging.VmId, "id"))
         {
             vms.GET("/", middleware.Authenticate, GetVMs)
             vms.GET("/all", middleware.Authenticate, GetAllVms)
@@ -56,6 +99,36 @@ func Router() *gin.Engine {
             vms.DELETE("/:id", middleware.Authenticate, DeleteVM)
             vms.GET("/:id/console", middleware.Authenticate, GenerateConsoleUrl)
             vms.GET("/:id/password", middleware.Authenticate, GetPassword)
+            vms.POST("/:id/members", middleware.Authenticate, AddVmMember)
+            vms.DELETE("/:id/members/:user", middleware.Authenticate, RemoveVmMember)
+            vms.POST("/:id/owner", middleware.Authenticate, TransferVmOwnership)
+            vms.POST("/:id/lease", middleware.Authenticate, RenewVmLease)
+        }
+
+        schedules := v1.Group("/schedules")
+        {
+            schedules.GET("/", middleware.Authenticate, GetSchedules)
+            schedules.POST("/", middleware.Authenticate, AddSchedule)
+            schedules.PUT("/", middleware.Authenticate, UpdateSchedule)
+            schedules.DELETE("/", middleware.Authenticate, DeleteSchedule)
+            schedules.GET("/:id/runs", middleware.Authenticate, GetScheduleRuns)
+            schedules.POST("/:id/run", middleware.Authenticate, RunSchedule)
+        }
+
+        notifications := v1.Group("/notifications")
+        {
+            notifications.GET("/preferences", middleware.Authenticate, GetNotificationPreferences)
+            notifications.PUT("/preferences", middleware.Authenticate, UpdateNotificationPreferences)
+        }
+
+        hooks := v1.Group("/webhooks")
+        {
+            hooks.GET("/", middleware.Authenticate, GetWebhooks)
+            hooks.POST("/", middleware.Authenticate, AddWebhook)
+            hooks.PUT("/", middleware.Authenticate, UpdateWebhook)
+            hooks.DELETE("/", middleware.Authenticate, DeleteWebhook)
+            hooks.GET("/deliveries/dead", middleware.Authenticate, GetDeadWebhookDeliveries)
+            hooks.POST("/deliveries/:id/redeliver", middleware.Authenticate, RedeliverWebhook)
         }
 
         courses := v1.Group("/courses")
@@ -0,0 +1,308 @@
+package v1
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/apierror"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/database"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/httputils"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/scheduler"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/storage"
+)
+
+const scheduleRunsLimit = 20
+
+type RequestBodyScheduleWindow struct {
+	Start string `json:"start" binding:"required"`
+	End   string `json:"end" binding:"required"`
+}
+
+// RequestBodySchedule is checked for its shape when bound, the cron expressions, time
+// zone and windows are parsed by scheduler.Validate.
+type RequestBodySchedule struct {
+	Name       string                      `json:"name" binding:"required,max=100"`
+	TargetType string                      `json:"target_type" binding:"required,oneof=vm user course"`
+	TargetId   string                      `json:"target_id" binding:"required,max=255"`
+	TimeZone   string                      `json:"time_zone" binding:"max=64"`
+	StartCron  string                      `json:"start_cron" binding:"max=100"`
+	StopCron   string                      `json:"stop_cron" binding:"max=100"`
+	Windows    []RequestBodyScheduleWindow `json:"windows" binding:"max=50,dive"`
+	Enabled    bool                        `json:"enabled"`
+}
+
+type RequestBodyScheduleUpdate struct {
+	Id string `json:"id" binding:"required,record_id"`
+	RequestBodySchedule
+}
+
+type ScheduleIdStruct struct {
+	Id string `json:"id" binding:"required,record_id"`
+}
+
+type RequestBodyScheduleRun struct {
+	Action string `json:"action" binding:"required,oneof=start stop"`
+}
+
+// scheduleError reports a failed write of a schedule, one that does not exist is a 404.
+func scheduleError(err error, fallback string) *apierror.Error {
+	if errors.Is(err, storage.ErrNotFound) {
+		return apierror.Wrap(apierror.NotFound, "Schedule not found!", err)
+	}
+	return apierror.Wrap(apierror.Internal, fallback, err)
+}
+
+func (r RequestBodySchedule) toSchedule() database.Schedule {
+	windows := []database.ScheduleWindow{}
+	for _, v := range r.Windows {
+		windows = append(windows, database.ScheduleWindow{Start: v.Start, End: v.End})
+	}
+
+	return database.Schedule{
+		Name:       r.Name,
+		TargetType: r.TargetType,
+		TargetId:   r.TargetId,
+		TimeZone:   r.TimeZone,
+		StartCron:  r.StartCron,
+		StopCron:   r.StopCron,
+		Windows:    windows,
+		Enabled:    r.Enabled,
+	}
+}
+
+// GetSchedules godoc
+// @Summary     Retrieves list of power schedules
+// @Description Gets all schedules that start and stop virtual machines
+// @Tags        schedules
+// @Accept      json
+// @Produce     json
+// @Success     200 {object}    []database.Schedule
+// @Failure     401 {object}    nil
+// @Failure     403 {object}    nil
+// @Failure     500 {object}    nil
+// @Router      /schedules/ [get]
+func GetSchedules(c *gin.Context) {
+	if !IsAdmin(c) {
+		httputils.AbortWithError(c, errAdminRequired)
+		return
+	}
+
+	schedules, err := storage.Schedules().GetSchedules(c.Request.Context())
+	if err != nil {
+		httputils.AbortWithError(c, apierror.Wrap(apierror.Internal, "Error while reading schedules!", err))
+		return
+	}
+
+	httputils.ResponseJson(c, http.StatusOK, "", schedules)
+	return
+}
+
+// AddSchedule godoc
+// @Summary     Adds a power schedule
+// @Description Adds a schedule targeting a VM, a user's VMs or all VMs of a Canvas course
+// @Tags        schedules
+// @Accept      json
+// @Produce     json
+// @Param       schedule    body    RequestBodySchedule true    "Request Body"
+// @Success     200 {object}    []database.Schedule
+// @Failure     400 {object}    nil
+// @Failure     401 {object}    nil
+// @Failure     403 {object}    nil
+// @Failure     500 {object}    nil
+// @Router      /schedules/ [post]
+func AddSchedule(c *gin.Context) {
+	if !IsAdmin(c) {
+		httputils.AbortWithError(c, errAdminRequired)
+		return
+	}
+
+	var requestBody RequestBodySchedule
+	err := c.ShouldBindJSON(&requestBody)
+	if err != nil {
+		httputils.AbortWithError(c, apierror.FromBind(err))
+		return
+	}
+
+	schedule := requestBody.toSchedule()
+	schedule.CreatedBy = c.MustGet("user_id").(string)
+
+	if err := scheduler.Validate(schedule); err != nil {
+		httputils.AbortWithError(c, apierror.Wrap(apierror.ValidationFailed, "Invalid schedule: "+err.Error(), err))
+		return
+	}
+
+	if _, err := storage.Schedules().InsertSchedule(c.Request.Context(), schedule); err != nil {
+		httputils.AbortWithError(c, apierror.Wrap(apierror.Internal, "Error while inserting schedule!", err))
+		return
+	}
+
+	schedules, err := storage.Schedules().GetSchedules(c.Request.Context())
+	if err != nil {
+		httputils.AbortWithError(c, apierror.Wrap(apierror.Internal, "Error while reading schedules!", err))
+		return
+	}
+
+	httputils.ResponseJson(c, http.StatusOK, "", schedules)
+	return
+}
+
+// UpdateSchedule godoc
+// @Summary     Updates a power schedule
+// @Description Replaces the target, time zone, cron expressions and windows of a schedule
+// @Tags        schedules
+// @Accept      json
+// @Produce     json
+// @Param       schedule    body    RequestBodyScheduleUpdate   true    "Request Body"
+// @Success     200 {object}    database.Schedule
+// @Failure     400 {object}    nil
+// @Failure     401 {object}    nil
+// @Failure     403 {object}    nil
+// @Failure     404 {object}    nil
+// @Failure     500 {object}    nil
+// @Router      /schedules/ [put]
+func UpdateSchedule(c *gin.Context) {
+	if !IsAdmin(c) {
+		httputils.AbortWithError(c, errAdminRequired)
+		return
+	}
+
+	var requestBody RequestBodyScheduleUpdate
+	err := c.ShouldBindJSON(&requestBody)
+	if err != nil {
+		httputils.AbortWithError(c, apierror.FromBind(err))
+		return
+	}
+
+	schedule := requestBody.toSchedule()
+	schedule.Id = requestBody.Id
+
+	if err := scheduler.Validate(schedule); err != nil {
+		httputils.AbortWithError(c, apierror.Wrap(apierror.ValidationFailed, "Invalid schedule: "+err.Error(), err))
+		return
+	}
+
+	if err := storage.Schedules().UpdateScheduleById(c.Request.Context(), schedule); err != nil {
+		httputils.AbortWithError(c, scheduleError(err, "Could not update schedule!"))
+		return
+	}
+
+	updated, err := storage.Schedules().GetScheduleById(c.Request.Context(), schedule.Id)
+	if err != nil {
+		httputils.AbortWithError(c, scheduleError(err, "Error while reading schedule!"))
+		return
+	}
+
+	httputils.ResponseJson(c, http.StatusOK, "", updated)
+	return
+}
+
+// DeleteSchedule godoc
+// @Summary     Deletes a power schedule
+// @Description Deletes a schedule, its run reports are kept
+// @Tags        schedules
+// @Accept      json
+// @Produce     json
+// @Param       body    body    ScheduleIdStruct    true    "Request Body"
+// @Success     200 {object}    []database.Schedule
+// @Failure     400 {object}    nil
+// @Failure     401 {object}    nil
+// @Failure     403 {object}    nil
+// @Failure     404 {object}    nil
+// @Failure     500 {object}    nil
+// @Router      /schedules/ [delete]
+func DeleteSchedule(c *gin.Context) {
+	if !IsAdmin(c) {
+		httputils.AbortWithError(c, errAdminRequired)
+		return
+	}
+
+	var body ScheduleIdStruct
+	err := c.ShouldBindJSON(&body)
+	if err != nil {
+		httputils.AbortWithError(c, apierror.FromBind(err))
+		return
+	}
+
+	if err := storage.Schedules().DeleteScheduleById(c.Request.Context(), body.Id); err != nil {
+		httputils.AbortWithError(c, scheduleError(err, "Error while deleting schedule!"))
+		return
+	}
+
+	schedules, err := storage.Schedules().GetSchedules(c.Request.Context())
+	if err != nil {
+		httputils.AbortWithError(c, apierror.Wrap(apierror.Internal, "Error while reading schedules!", err))
+		return
+	}
+
+	httputils.ResponseJson(c, http.StatusOK, "Schedule deleted successfully!", schedules)
+	return
+}
+
+// GetScheduleRuns godoc
+// @Summary     Retrieves run reports of a schedule
+// @Description Gets the latest reports, with a result per virtual machine
+// @Tags        schedules
+// @Accept      json
+// @Produce     json
+// @Param       id  path    string  true    "Schedule ID"
+// @Success     200 {object}    []database.ScheduleRun
+// @Failure     401 {object}    nil
+// @Failure     403 {object}    nil
+// @Failure     500 {object}    nil
+// @Router      /schedules/:id/runs [get]
+func GetScheduleRuns(c *gin.Context) {
+	if !IsAdmin(c) {
+		httputils.AbortWithError(c, errAdminRequired)
+		return
+	}
+
+	runs, err := storage.Schedules().GetScheduleRuns(c.Request.Context(), c.Param("id"), scheduleRunsLimit)
+	if err != nil {
+		httputils.AbortWithError(c, apierror.Wrap(apierror.Internal, "Error while reading schedule runs!", err))
+		return
+	}
+
+	httputils.ResponseJson(c, http.StatusOK, "", runs)
+	return
+}
+
+// RunSchedule godoc
+// @Summary     Runs a power schedule now
+// @Description Starts or stops all virtual machines targeted by a schedule and returns the report
+// @Tags        schedules
+// @Accept      json
+// @Produce     json
+// @Param       id      path    string                  true    "Schedule ID"
+// @Param       body    body    RequestBodyScheduleRun  true    "Request Body"
+// @Success     200 {object}    database.ScheduleRun
+// @Failure     400 {object}    nil
+// @Failure     401 {object}    nil
+// @Failure     403 {object}    nil
+// @Failure     404 {object}    nil
+// @Failure     500 {object}    nil
+// @Router      /schedules/:id/run  [post]
+func RunSchedule(c *gin.Context) {
+	if !IsAdmin(c) {
+		httputils.AbortWithError(c, errAdminRequired)
+		return
+	}
+
+	var body RequestBodyScheduleRun
+	err := c.ShouldBindJSON(&body)
+	if err != nil {
+		httputils.AbortWithError(c, apierror.FromBind(err))
+		return
+	}
+
+	schedule, err := storage.Schedules().GetScheduleById(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		httputils.AbortWithError(c, scheduleError(err, "Error while reading schedule!"))
+		return
+	}
+
+	run := scheduler.Run(c.Request.Context(), *schedule, body.Action, scheduler.TriggerManual)
+
+	httputils.ResponseJson(c, http.StatusOK, "", run)
+	return
+}
@@ -0,0 +1,62 @@
+package v1
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/apierror"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/services"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/storage"
+)
+
+// serverNamePattern keeps server names usable as host names.
+var serverNamePattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9-]*$`)
+
+// registerValidations adds the custom tags used in the binding tags of the request bodies,
+// and names fields after their json keys in validation errors.
+func registerValidations() {
+	validate, ok := binding.Validator.Engine().(*validator.Validate)
+	if !ok {
+		return
+	}
+
+	validate.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+
+	validate.RegisterValidation("server_name", func(fl validator.FieldLevel) bool {
+		return serverNamePattern.MatchString(fl.Field().String())
+	})
+	apierror.RegisterMessage("server_name", "must start with a letter and contain only letters, digits and dashes")
+
+	validate.RegisterValidation("user_id", func(fl validator.FieldLevel) bool {
+		return services.ValidUserId(fl.Field().String())
+	})
+	apierror.RegisterMessage("user_id", "must be a uia.no or student.uia.no account")
+
+	// Records have 24 character hexadecimal ids in both storage backends.
+	validate.RegisterAlias("record_id", "len=24,hexadecimal")
+	apierror.RegisterMessage("record_id", "must be the id of a record")
+}
+
+// requireImage checks that an ordered image is in the image list, before anything is
+// created in OpenStack.
+func requireImage(ctx context.Context, field string, imageId string) error {
+	_, err := storage.Images().GetImageByImageId(ctx, imageId)
+	if errors.Is(err, storage.ErrNotFound) {
+		return apierror.New(apierror.ValidationFailed, "Image not found!").WithField(field, "not_found", "must be an image of the service")
+	}
+	if err != nil {
+		return apierror.Wrap(apierror.Internal, "Error while reading images!", err)
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package v1
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/apierror"
+)
+
+func TestValidations(t *testing.T) {
+	registerValidations()
+
+	type body struct {
+		UserId     string `json:"user_id" binding:"omitempty,user_id"`
+		RecordId   string `json:"record_id" binding:"omitempty,record_id"`
+		ServerName string `json:"server_name" binding:"omitempty,server_name"`
+	}
+
+	tests := []struct {
+		name       string
+		body       body
+		wantFields []apierror.FieldError
+	}{
+		{"empty", body{}, nil},
+		{"uia account", body{UserId: "ola.nordmann@uia.no"}, nil},
+		{"student account", body{UserId: "olan21@student.uia.no"}, nil},
+		{"other domain", body{UserId: "ola@uia.no.example.com"}, []apierror.FieldError{{Field: "user_id", Code: "user_id", Message: "must be a uia.no or student.uia.no account"}}},
+		{"no domain", body{UserId: "ola"}, []apierror.FieldError{{Field: "user_id", Code: "user_id", Message: "must be a uia.no or student.uia.no account"}}},
+		{"record id", body{RecordId: "62a1f0c2e4b0a1b2c3d4e5f6"}, nil},
+		{"short record id", body{RecordId: "62a1f0c2e4b0a1b2c3d4e5f"}, []apierror.FieldError{{Field: "record_id", Code: "record_id", Message: "must be the id of a record"}}},
+		{"record id that is not hexadecimal", body{RecordId: "62a1f0c2e4b0a1b2c3d4e5fz"}, []apierror.FieldError{{Field: "record_id", Code: "record_id", Message: "must be the id of a record"}}},
+		{"server name", body{ServerName: "web-1"}, nil},
+		{"server name with a leading digit", body{ServerName: "1web"}, []apierror.FieldError{{Field: "server_name", Code: "server_name", Message: "must start with a letter and contain only letters, digits and dashes"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := binding.Validator.ValidateStruct(tt.body)
+			if tt.wantFields == nil {
+				if err != nil {
+					t.Errorf("ValidateStruct() = %v, want nil", err)
+				}
+				return
+			}
+
+			if _, ok := err.(validator.ValidationErrors); !ok {
+				t.Fatalf("ValidateStruct() = %v, want validation errors", err)
+			}
+			got := apierror.FromBind(err).Fields
+			if !reflect.DeepEqual(got, tt.wantFields) {
+				t.Errorf("FromBind() fields = %+v, want %+v", got, tt.wantFields)
+			}
+		})
+	}
+}
@@ -2,49 +2,86 @@ package v1
 
 import (
 	"crypto/rsa"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
 	"net/http"
 	"reflect"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/bootfromvolume"
-	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/floatingips"
-	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/keypairs"
+	"github.com/gophercloud/gophercloud"
 	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/remoteconsoles"
-	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/secgroups"
 	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/startstop"
 	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
-	"github.com/spf13/viper"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/apierror"
 	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/database"
-	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/database/repositories"
 	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/gopher"
 	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/httputils"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/leases"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/logging"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/services"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/storage"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/tracing"
 	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/utils"
 )
 
 type RequestBodyUserId struct {
-	UserId string `json:"user_id"`
+	UserId string `json:"user_id" binding:"required,user_id"`
 }
 
+// RequestBodyVmOrder names the server after ServerName and the group, or the user without
+// a group, so both are kept short enough for a host name.
 type RequestBodyVmOrder struct {
-	ServerName  string   `json:"server_name"`
-	ServerImage string   `json:"server_image"`
-	Users       []string `json:"users"`
-	GroupName   string   `json:"group_name"`
+	ServerName  string   `json:"server_name" binding:"required,max=40,server_name"`
+	ServerImage string   `json:"server_image" binding:"required,uuid"`
+	Users       []string `json:"users" binding:"max=100,dive,user_id"`
+	GroupName   string   `json:"group_name" binding:"omitempty,max=20,server_name"`
+	CourseCode  string   `json:"course_code" binding:"omitempty,max=20,numeric"`
 }
 
 type RequestBodyVmOrderAll struct {
-	ServerName     string   `json:"server_name"`
-	ServerImage    string   `json:"server_image"`
-	Users          []string `json:"users"`
-	GroupName      string   `json:"group_name"`
-	Everyone       string   `json:"everyone"`
-	IncludeTa      string   `json:"include_ta"`
-	IncludeTeacher string   `json:"include_teacher"`
-	CourseCode     string   `json:"course_code"`
+	ServerName     string   `json:"server_name" binding:"required,max=40,server_name"`
+	ServerImage    string   `json:"server_image" binding:"required,uuid"`
+	Users          []string `json:"users" binding:"max=100,dive,user_id"`
+	GroupName      string   `json:"group_name" binding:"omitempty,max=20,server_name"`
+	Everyone       bool     `json:"everyone"`
+	IncludeTa      bool     `json:"include_ta"`
+	IncludeTeacher bool     `json:"include_teacher"`
+	CourseCode     string   `json:"course_code" binding:"required,max=20,numeric"`
+	// Trim creates as many virtual machines as the quota has room for instead of refusing the order.
+	Trim bool `json:"trim"`
+}
+
+// capacityError answers an order that could not be planned, with the capacity of the
+// target when its quota has no room for the order.
+func capacityError(err error, capacity services.Capacity) *apierror.Error {
+	if errors.Is(err, services.ErrInsufficientCapacity) {
+		return apierror.Wrap(apierror.InsufficientCapacity, services.Message(err, ""), err).WithData(capacity)
+	}
+	return serviceError(err, "Could not read the quota!")
+}
+
+// listVms returns every virtual machine for administrators and the user's own otherwise.
+func listVms(c *gin.Context) ([]database.VirtualMachine, error) {
+	if IsAdmin(c) {
+		return storage.Vms().GetVMS(c.Request.Context())
+	}
+	return storage.Vms().GetVMByUserId(c.Request.Context(), c.MustGet("user_id").(string))
+}
+
+// serverNameFor names a server after the group, or after the user when there is no group.
+// The name stays empty without a base name, provisioning rejects that.
+func serverNameFor(name string, groupName string, userId string) string {
+	if len(name) == 0 {
+		return ""
+	}
+
+	if len(groupName) > 0 {
+		return strings.ToUpper(name) + "-" + strings.ToUpper(groupName)
+	}
+	return strings.ToUpper(name) + "-" + services.UserName(userId)
 }
 
 type Response struct {
@@ -53,29 +90,128 @@ type Response struct {
 	Data       interface{} `json:"data"`
 }
 
+// errNotVmMember is returned to users acting on a virtual machine they are not a member of.
+var errNotVmMember = apierror.New(apierror.Forbidden, "You don't own this virtual machine!")
+
+// serviceError answers a failed service call, failures of OpenStack are a bad gateway.
+func serviceError(err error, fallback string) *apierror.Error {
+	if services.IsOpenStack(err) {
+		return apierror.Wrap(apierror.OpenStack, services.Message(err, fallback), err)
+	}
+	return apierror.Wrap(apierror.Internal, services.Message(err, fallback), err)
+}
+
+// vmError reports a failed read of a virtual machine, one that does not exist is a 404.
+func vmError(err error) *apierror.Error {
+	if errors.Is(err, storage.ErrNotFound) {
+		return apierror.Wrap(apierror.NotFound, "Virtual machine not found!", err)
+	}
+	return apierror.Wrap(apierror.Internal, "Error reading database!", err)
+}
+
+// vmCompute returns the compute client of the target the virtual machine runs in.
+func vmCompute(c *gin.Context, id string) (*gophercloud.ServiceClient, bool) {
+	vm, err := storage.Vms().GetVMById(c.Request.Context(), id)
+	if err != nil {
+		httputils.AbortWithError(c, vmError(err))
+		return nil, false
+	}
+
+	client, err := gopher.Compute(c.Request.Context(), vm.Target)
+	if err != nil {
+		httputils.AbortWithError(c, apierror.Wrap(apierror.OpenStack, "Could not connect to OpenStack!", err))
+		return nil, false
+	}
+
+	return client, true
+}
+
+func invalidQuery(field string, message string) error {
+	return apierror.New(apierror.ValidationFailed, "Invalid query!").WithField(field, "invalid", message)
+}
+
+// vmQuery reads the filters, sort order and page of the vm listing from the query string.
+func vmQuery(c *gin.Context) (storage.VmQuery, error) {
+	query := storage.VmQuery{
+		Status:     c.Query("status"),
+		ImageId:    c.Query("image"),
+		OwnerId:    c.Query("owner"),
+		CourseCode: c.Query("course"),
+		Target:     c.Query("target"),
+		Name:       c.Query("name"),
+		Sort:       strings.TrimPrefix(c.Query("sort"), "-"),
+		Descending: strings.HasPrefix(c.Query("sort"), "-"),
+		Cursor:     c.Query("cursor"),
+	}
+
+	var err error
+	if limit := c.Query("limit"); len(limit) > 0 {
+		if query.Limit, err = strconv.Atoi(limit); err != nil || query.Limit < 1 {
+			return query, invalidQuery("limit", "must be a positive number")
+		}
+	}
+	if from := c.Query("created_from"); len(from) > 0 {
+		if query.CreatedFrom, err = time.Parse(time.RFC3339, from); err != nil {
+			return query, invalidQuery("created_from", "must be an RFC 3339 time")
+		}
+	}
+	if to := c.Query("created_to"); len(to) > 0 {
+		if query.CreatedTo, err = time.Parse(time.RFC3339, to); err != nil {
+			return query, invalidQuery("created_to", "must be an RFC 3339 time")
+		}
+	}
+
+	return query, nil
+}
+
 // GetAllVMs godoc
-// @Summary     Retrieves list of all VMs from DB
-// @Description Gets all VMs
+// @Summary     Retrieves a page of all VMs from DB
+// @Description Gets the VMs matching the filters, sorted and paginated with a cursor
 // @Tags        vms
 // @Accept      json
 // @Produce     json
-// @Success     200 {object}    []database.VirtualMachine
+// @Param       status          query   string  false   "Server status, ACTIVE or SHUTOFF"
+// @Param       image           query   string  false   "OpenStack image id"
+// @Param       owner           query   string  false   "User id of the owner"
+// @Param       course          query   string  false   "Canvas course code"
+// @Param       target          query   string  false   "OpenStack target"
+// @Param       name            query   string  false   "Part of the server name, ignoring case"
+// @Param       created_from    query   string  false   "Created at or after, RFC 3339"
+// @Param       created_to      query   string  false   "Created before, RFC 3339"
+// @Param       sort            query   string  false   "created, server_name, server_status, course_code or server_image, prefixed with - for descending"
+// @Param       limit           query   int     false   "Page size, 50 by default and at most 500"
+// @Param       cursor          query   string  false   "next_cursor of the previous page"
+// @Success     200 {object}    storage.VmPage
+// @Failure     400 {object}    nil
+// @Failure     401 {object}    nil
+// @Failure     403 {object}    nil
+// @Failure     500 {object}    nil
 // @Router      /vms/all   [get]
 func GetAllVms(c *gin.Context) {
 	if !IsAdmin(c) {
-		httputils.AbortWithStatusJSON(c, http.StatusUnauthorized, "Administrator credentials required!", nil)
+		httputils.AbortWithError(c, errAdminRequired)
 		return
 	}
 
-	var virtualMachines []database.VirtualMachine
-	virtualMachines = repositories.GetVMS()
+	query, err := vmQuery(c)
+	if err != nil {
+		httputils.AbortWithError(c, err)
+		return
+	}
 
-	if virtualMachines == nil {
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Could not load virtual machines!", nil)
+	page, err := storage.Vms().ListVms(c.Request.Context(), query)
+
+	var invalid *storage.QueryError
+	if errors.As(err, &invalid) {
+		httputils.AbortWithError(c, apierror.Wrap(apierror.InvalidRequest, "Invalid query: "+invalid.Reason, err))
+		return
+	}
+	if err != nil {
+		httputils.AbortWithError(c, apierror.Wrap(apierror.Internal, "Could not load virtual machines!", err))
 		return
 	}
 
-	httputils.ResponseJson(c, http.StatusOK, "", virtualMachines)
+	httputils.ResponseJson(c, http.StatusOK, "", page)
 	return
 }
 
@@ -88,12 +224,10 @@ func GetAllVms(c *gin.Context) {
 // @Success     200 {object}    []database.VirtualMachine
 // @Router      /vms/   [get]
 func GetVMs(c *gin.Context) {
-	var virtualMachines interface{}
+	virtualMachines, err := storage.Vms().GetVMByUserId(c.Request.Context(), c.MustGet("user_id").(string))
 
-	virtualMachines = repositories.GetVMByUserId(c.MustGet("user_id"))
-
-	if virtualMachines == nil {
-		httputils.AbortWithStatusJSON(c, http.StatusNotAcceptable, "Could not load virtual machines!", nil)
+	if err != nil {
+		httputils.AbortWithError(c, apierror.Wrap(apierror.Internal, "Could not load virtual machines!", err))
 		return
 	}
 
@@ -112,69 +246,49 @@ func GetVMs(c *gin.Context) {
 // @Failure     400 {object}    nil
 // @Failure     406 {object}    nil
 // @Failure     500 {object}    nil
+// @Failure     502 {object}    nil
 // @Router      /vms/:id/status [get]
 func StatusVM(c *gin.Context) {
 	id := c.Param("id")
 
 	if len(id) <= 0 {
-		httputils.AbortWithStatusJSON(c, http.StatusBadRequest, "Missing id!", nil)
+		httputils.AbortWithError(c, apierror.New(apierror.InvalidRequest, "Missing id!"))
 		return
 	}
 
 	if !IsAdmin(c) {
-		if !repositories.CheckIfOwnsVm(id, c.MustGet("user_id").(string)) {
-			httputils.AbortWithStatusJSON(c, http.StatusUnauthorized, "You don't own this virtual machine!", nil)
+		if owns, err := storage.Vms().CheckIfOwnsVm(c.Request.Context(), id, c.MustGet("user_id").(string)); err != nil || !owns {
+			httputils.AbortWithError(c, errNotVmMember)
 			return
 		}
 	}
 
-	client := gopher.GetClient()
-
-	res := servers.Get(client, id)
-	r, err := res.Extract()
-
+	vm, err := storage.Vms().GetVMById(c.Request.Context(), id)
 	if err != nil {
-		var l map[string]interface{}
-		m, _ := json.Marshal(res.Err)
-		_ = json.Unmarshal(m, &l)
-		v := reflect.ValueOf(l["Actual"])
-
-		// Remove vm if it doesn't exists
-		if v.Float() == http.StatusNotFound {
-			_, err = repositories.DeleteVMById(id)
-			if err != nil {
-				httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Error deleting virtual machine from database!", nil)
-				return
-			}
-
-			var virtualMachines interface{}
-
-			if IsAdmin(c) {
-				virtualMachines = repositories.GetVMS()
-			} else {
-				virtualMachines = repositories.GetVMByUserId(c.MustGet("user_id"))
-			}
-
-			httputils.AbortWithStatusJSON(c, http.StatusOK, "", virtualMachines)
-			return
-		}
-
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Error reading virtual machine status!", nil)
+		httputils.AbortWithError(c, vmError(err))
 		return
 	}
 
-	isUpdated := repositories.UpdateVMStatusById(id, r.Status)
-	if !isUpdated {
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Error updating virtual machine status!", nil)
+	result, err := services.ReconcileVm(c.Request.Context(), vm)
+	if err != nil {
+		httputils.AbortWithError(c, serviceError(err, "Error reading virtual machine status!"))
 		return
 	}
 
-	vm, err := repositories.GetVMById(id)
-	if err != nil {
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Error reading database!", nil)
+	// Remove vm if it doesn't exists
+	if result.Action == services.ReconcileDeleted {
+		virtualMachines, err := listVms(c)
+		if err != nil {
+			httputils.AbortWithError(c, apierror.Wrap(apierror.Internal, "Unable to read virtual machines!", err))
+			return
+		}
+
+		httputils.ResponseJson(c, http.StatusOK, "Virtual machine no longer exists!", virtualMachines)
 		return
 	}
 
+	vm.ServerStatus = result.Status
+
 	httputils.ResponseJson(c, http.StatusOK, "!", vm)
 	return
 }
@@ -189,59 +303,75 @@ func StatusVM(c *gin.Context) {
 // @Success     200 {object}    database.VirtualMachine
 // @Failure     400 {object}    nil
 // @Failure     406 {object}    nil
+// @Failure     409 {object}    nil
 // @Failure     500 {object}    nil
 // @Router      /vms/:id/start  [post]
 func StartVM(c *gin.Context) {
 	id := c.Param("id")
 
 	if len(id) <= 0 {
-		httputils.AbortWithStatusJSON(c, http.StatusBadRequest, "Missing id!", nil)
+		httputils.AbortWithError(c, apierror.New(apierror.InvalidRequest, "Missing id!"))
 		return
 	}
 
 	if !IsAdmin(c) {
-		if !repositories.CheckIfOwnsVm(id, c.MustGet("user_id").(string)) {
-			httputils.AbortWithStatusJSON(c, http.StatusUnauthorized, "You don't own this virtual machine!", nil)
+		if owns, err := storage.Vms().CheckIfOwnsVm(c.Request.Context(), id, c.MustGet("user_id").(string)); err != nil || !owns {
+			httputils.AbortWithError(c, errNotVmMember)
+			return
+		}
+
+		// Administrators may start a virtual machine whose lease ended, members renew it first.
+		vm, err := storage.Vms().GetVMById(c.Request.Context(), id)
+		if err != nil {
+			httputils.AbortWithError(c, vmError(err))
+			return
+		}
+		if leases.Expired(vm, time.Now()) {
+			httputils.AbortWithError(c, apierror.New(apierror.LeaseExpired, "The lease of the virtual machine has ended, renew it first!"))
 			return
 		}
 	}
 
-	client := gopher.GetClient()
+	client, ok := vmCompute(c, id)
+	if !ok {
+		return
+	}
 
 	response := startstop.Start(client, id)
 
 	err := response.Err
 
 	if err != nil {
-		log.Println("Result: ", response.ExtractErr())
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Error while starting virtual machine!", nil)
+		httputils.AbortWithError(c, apierror.Wrap(apierror.OpenStack, "Error while starting virtual machine!", err))
 		return
 	}
 
 	// Wait until server status changes to "active"
 	if err := servers.WaitForStatus(client, id, database.VirtualMachineStatusActive, database.ServerStatusPollingTime); err != nil {
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Error while waiting for virtual machine to become active!", nil)
+		httputils.AbortWithError(c, apierror.Wrap(apierror.OpenStack, "Error while waiting for virtual machine to become active!", err))
 		return
 	}
 
 	r, err := servers.Get(client, id).Extract()
 	if err != nil {
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Error reading virtual machine status!", nil)
+		httputils.AbortWithError(c, apierror.Wrap(apierror.OpenStack, "Error reading virtual machine status!", err))
 		return
 	}
 
-	isUpdated := repositories.UpdateVMStatusById(id, r.Status)
-	if !isUpdated {
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Error updating virtual machine status!", nil)
+	err = storage.Vms().UpdateVMStatusById(c.Request.Context(), id, r.Status)
+	if err != nil {
+		httputils.AbortWithError(c, apierror.Wrap(apierror.Internal, "Error updating virtual machine status!", err))
 		return
 	}
 
-	vm, err := repositories.GetVMById(id)
+	vm, err := storage.Vms().GetVMById(c.Request.Context(), id)
 	if err != nil {
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Error reading database!", nil)
+		httputils.AbortWithError(c, vmError(err))
 		return
 	}
 
+	services.PublishStatusChanged(c.Request.Context(), vm, "")
+
 	httputils.ResponseJson(c, http.StatusOK, "", vm)
 	return
 }
@@ -262,54 +392,58 @@ func StopVM(c *gin.Context) {
 	id := c.Param("id")
 
 	if len(id) <= 0 {
-		httputils.AbortWithStatusJSON(c, http.StatusBadRequest, "Missing id!", nil)
+		httputils.AbortWithError(c, apierror.New(apierror.InvalidRequest, "Missing id!"))
 		return
 	}
 
 	if !IsAdmin(c) {
-		if !repositories.CheckIfOwnsVm(id, c.MustGet("user_id").(string)) {
-			httputils.AbortWithStatusJSON(c, http.StatusUnauthorized, "You don't own this virtual machine!", nil)
+		if owns, err := storage.Vms().CheckIfOwnsVm(c.Request.Context(), id, c.MustGet("user_id").(string)); err != nil || !owns {
+			httputils.AbortWithError(c, errNotVmMember)
 			return
 		}
 	}
 
-	client := gopher.GetClient()
+	client, ok := vmCompute(c, id)
+	if !ok {
+		return
+	}
 
 	response := startstop.Stop(client, id)
 
 	err := response.Err
 
 	if err != nil {
-		log.Println("Result: ", response.ExtractErr())
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Error while stopping virtual machine!", nil)
+		httputils.AbortWithError(c, apierror.Wrap(apierror.OpenStack, "Error while stopping virtual machine!", err))
 		return
 	}
 
 	// Wait until server status changes to "stopped"
 	if err := servers.WaitForStatus(client, id, database.VirtualMachineStatusInactive, database.ServerStatusPollingTime); err != nil {
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Error while waiting for virtual machine to shutdown!", nil)
+		httputils.AbortWithError(c, apierror.Wrap(apierror.OpenStack, "Error while waiting for virtual machine to shutdown!", err))
 		return
 	}
 
 	r, err := servers.Get(client, id).Extract()
 
 	if err != nil {
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Error reading virtual machine status!", nil)
+		httputils.AbortWithError(c, apierror.Wrap(apierror.OpenStack, "Error reading virtual machine status!", err))
 		return
 	}
 
-	isUpdated := repositories.UpdateVMStatusById(id, r.Status)
-	if !isUpdated {
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Error updating virtual machine status!", nil)
+	err = storage.Vms().UpdateVMStatusById(c.Request.Context(), id, r.Status)
+	if err != nil {
+		httputils.AbortWithError(c, apierror.Wrap(apierror.Internal, "Error updating virtual machine status!", err))
 		return
 	}
 
-	vm, err := repositories.GetVMById(id)
+	vm, err := storage.Vms().GetVMById(c.Request.Context(), id)
 	if err != nil {
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Error reading database!", nil)
+		httputils.AbortWithError(c, vmError(err))
 		return
 	}
 
+	services.PublishStatusChanged(c.Request.Context(), vm, "")
+
 	httputils.ResponseJson(c, http.StatusOK, "", vm)
 	return
 }
@@ -330,66 +464,70 @@ func RebootVM(c *gin.Context) {
 	id := c.Param("id")
 
 	if len(id) <= 0 {
-		httputils.AbortWithStatusJSON(c, http.StatusBadRequest, "Missing id!", nil)
+		httputils.AbortWithError(c, apierror.New(apierror.InvalidRequest, "Missing id!"))
 		return
 	}
 
 	if !IsAdmin(c) {
-		if !repositories.CheckIfOwnsVm(id, c.MustGet("user_id").(string)) {
-			httputils.AbortWithStatusJSON(c, http.StatusUnauthorized, "You don't own this virtual machine!", nil)
+		if owns, err := storage.Vms().CheckIfOwnsVm(c.Request.Context(), id, c.MustGet("user_id").(string)); err != nil || !owns {
+			httputils.AbortWithError(c, errNotVmMember)
 			return
 		}
 	}
 
-	client := gopher.GetClient()
+	client, ok := vmCompute(c, id)
+	if !ok {
+		return
+	}
 	response := servers.Reboot(client, id, servers.RebootOpts{Type: "soft"})
 
 	err := response.Err
 
 	if err != nil {
-		log.Println("Result: ", response.ExtractErr())
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Error while rebooting virtual machine!", nil)
+		httputils.AbortWithError(c, apierror.Wrap(apierror.OpenStack, "Error while rebooting virtual machine!", err))
 		return
 	}
 
 	r, err := servers.Get(client, id).Extract()
 
 	if err != nil {
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Error reading virtual machine status!", nil)
+		httputils.AbortWithError(c, apierror.Wrap(apierror.OpenStack, "Error reading virtual machine status!", err))
 		return
 	}
 
-	isUpdated := repositories.UpdateVMStatusById(id, r.Status)
-	if !isUpdated {
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Error updating virtual machine status!", nil)
+	err = storage.Vms().UpdateVMStatusById(c.Request.Context(), id, r.Status)
+	if err != nil {
+		httputils.AbortWithError(c, apierror.Wrap(apierror.Internal, "Error updating virtual machine status!", err))
 		return
 	}
 
 	// Wait until server status changes to "active"
 	if err := servers.WaitForStatus(client, id, database.VirtualMachineStatusActive, database.ServerStatusPollingTime); err != nil {
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Error while waiting for virtual machine to become active!", nil)
+		httputils.AbortWithError(c, apierror.Wrap(apierror.OpenStack, "Error while waiting for virtual machine to become active!", err))
 		return
 	}
 
 	r, err = servers.Get(client, id).Extract()
 
 	if err != nil {
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Error reading virtual machine status!", nil)
+		httputils.AbortWithError(c, apierror.Wrap(apierror.OpenStack, "Error reading virtual machine status!", err))
 		return
 	}
 
-	isUpdated = repositories.UpdateVMStatusById(id, r.Status)
-	if !isUpdated {
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Error updating virtual machine status!", nil)
+	err = storage.Vms().UpdateVMStatusById(c.Request.Context(), id, r.Status)
+	if err != nil {
+		httputils.AbortWithError(c, apierror.Wrap(apierror.Internal, "Error updating virtual machine status!", err))
 		return
 	}
 
-	vm, err := repositories.GetVMById(id)
+	vm, err := storage.Vms().GetVMById(c.Request.Context(), id)
 	if err != nil {
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Error reading database!", nil)
+		httputils.AbortWithError(c, vmError(err))
 		return
 	}
 
+	services.PublishStatusChanged(c.Request.Context(), vm, "")
+
 	httputils.ResponseJson(c, http.StatusOK, "", vm)
 	return
 }
@@ -405,222 +543,38 @@ func RebootVM(c *gin.Context) {
 // @Failure     400 {object}    nil
 // @Failure     406 {object}    nil
 // @Failure     500 {object}    nil
+// @Failure     502 {object}    nil
 // @Router      /vms/:id/respawn   [post]
 func RespawnVM(c *gin.Context) {
 	id := c.Param("id")
 
 	if len(id) <= 0 {
-		httputils.AbortWithStatusJSON(c, http.StatusBadRequest, "Missing id!", nil)
+		httputils.AbortWithError(c, apierror.New(apierror.InvalidRequest, "Missing id!"))
 		return
 	}
 
 	if !IsAdmin(c) {
-		if !repositories.CheckIfOwnsVm(id, c.MustGet("user_id").(string)) {
-			httputils.AbortWithStatusJSON(c, http.StatusUnauthorized, "You don't own this virtual machine!", nil)
+		if owns, err := storage.Vms().CheckIfOwnsVm(c.Request.Context(), id, c.MustGet("user_id").(string)); err != nil || !owns {
+			httputils.AbortWithError(c, errNotVmMember)
 			return
 		}
 	}
 
-	vm, err := repositories.GetVMById(id)
-	if err != nil {
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Error reading database!", nil)
-		return
-	}
-
-	// Extract Metadata from VM
-	//createOpts := vm.Metadata
-	// There is more data to get than just Metadata, remember to get all of it.
-	var requestStruct RequestBodyVmOrder
-	requestStruct.ServerName = vm.ServerName
-	requestStruct.ServerImage = vm.ServerImage
-
-	client := gopher.GetClient()
-
-	// Copied from DeleteVM
-	disassociateOpts := floatingips.DisassociateOpts{
-		FloatingIP: vm.ServerIp,
-	}
-
-	err = floatingips.DisassociateInstance(client, vm.ServerId, disassociateOpts).ExtractErr()
-	if err != nil {
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Error unassigning floating ip from virtual machine!", nil)
-		return
-	}
-
-	// Use RequestBodyVmOrder to get what is needed from existing VM,
-	//  then do as OrderVM.
-	if len(requestStruct.ServerImage) == 0 {
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Missing server image id!", nil)
-		return
-	}
-
-	imageInfo := repositories.GetImageByImageId(requestStruct.ServerImage)
-
-	if imageInfo == nil {
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Error while reading images!", nil)
-		return
-	}
-
-	vmUsers := repositories.GetVmGroupMembers(id)
-	var tmp []string
-	for _, vmUsers := range vmUsers {
-		tmp = append(tmp, vmUsers+"@uia.no")
-	}
-
-	users := strings.Join(tmp, ",")
-
-	var userData []byte
-	if len(imageInfo.ImageConfig) > 0 {
-		userData = utils.GenerateUserData(imageInfo.ImageConfig, users)
-	}
-
-	serverName := ""
-	if len(requestStruct.ServerName) > 0 {
-		serverName = strings.ToUpper(requestStruct.ServerName)
-	} else {
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Missing server name!", nil)
-		return
-	}
-
-	blockDevices := []bootfromvolume.BlockDevice{
-		bootfromvolume.BlockDevice{
-			DeleteOnTermination: true,
-			DestinationType:     bootfromvolume.DestinationVolume,
-			SourceType:          bootfromvolume.SourceImage,
-			UUID:                requestStruct.ServerImage,
-			VolumeSize:          viper.GetInt("IKT_STACK_VM_VOLUME_SIZE"),
-		},
-	}
-
-	serverCreateOpts := servers.CreateOpts{
-		Name:      serverName,
-		FlavorRef: viper.GetString("IKT_STACK_VM_FLAVOR_ID"),
-		UserData:  userData,
-		Networks: []servers.Network{
-			{
-				UUID: viper.GetString("IKT_STACK_VM_NETWORK_ID"),
-			},
-		},
-		Metadata: map[string]string{
-			"VM_IMAGE_ID":            requestStruct.ServerImage,
-			"VM_FLAVOR_ID":           viper.GetString("IKT_STACK_VM_FLAVOR_ID"),
-			"VM_KEY_NAME":            serverName,
-			"VM_VOLUME_SIZE":         viper.GetString("IKT_STACK_VM_VOLUME_SIZE"),
-			"VM_NETWORK_ID":          viper.GetString("IKT_STACK_VM_NETWORK_ID"),
-			"VM_FLOATING_NETWORK_ID": viper.GetString("IKT_STACK_VM_FLOATING_NETWORK_ID"),
-		},
-	}
-
-	serverCreateOptsExt := keypairs.CreateOptsExt{
-		CreateOptsBuilder: serverCreateOpts,
-		KeyName:           viper.GetString("IKT_STACK_VM_KEY_NAME"),
-	}
-
-	createOpts := bootfromvolume.CreateOptsExt{
-		CreateOptsBuilder: serverCreateOptsExt,
-		BlockDevice:       blockDevices,
-	}
-
-	server, err := bootfromvolume.Create(client, createOpts).Extract()
-
-	// Unable to create a virtual machine
-	if err != nil {
-		fmt.Println(err)
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Unable to create a virtual machine!", nil)
-		return
-	}
-
-	// Wait until server status changes to "active"
-	if err := servers.WaitForStatus(client, server.ID, database.VirtualMachineStatusActive, database.ServerStatusPollingTime); err != nil {
-		fmt.Println(err)
-
-		// If we can't get status in time, remove hanging virtual machine.
-		result := servers.Delete(client, server.ID)
-
-		// Do we want to print a response or fail silently?
-		// This should probably be logged into some kind of logging system.
-		if result.ExtractErr() != nil {
-			fmt.Println("This error occurred while deleting vm,"+
-				"after database failed insertion of a new vm.", result.ExtractErr())
-		}
-
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Error while waiting for virtual machine to become active!", nil)
-		return
-	}
-
-	createFloatingIpOpts := floatingips.CreateOpts{
-		Pool: viper.GetString("IKT_STACK_VM_FLOATING_NETWORK_ID"),
-	}
-
-	fip, err := floatingips.Create(client, createFloatingIpOpts).Extract()
-	if err != nil {
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Error while creating floating ip for virtual machine!", nil)
-		return
-	}
-
-	associateOpts := floatingips.AssociateOpts{
-		FloatingIP: fip.IP,
-		FixedIP:    fip.FixedIP,
-	}
-
-	err = floatingips.AssociateInstance(client, server.ID, associateOpts).ExtractErr()
+	vm, err := storage.Vms().GetVMById(c.Request.Context(), id)
 	if err != nil {
-
-		err = floatingips.Delete(client, fip.ID).ExtractErr()
-		if err != nil {
-			fmt.Println("This error occurred deleting floating ip," +
-				"after failed attempt to assign it to a vm.")
-		}
-
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Error while assigning floating ip to virtual machine!", nil)
+		httputils.AbortWithError(c, vmError(err))
 		return
 	}
 
-	err = secgroups.AddServer(client, server.ID, viper.GetString("IKT_STACK_VM_SECURITY_GROUP_ID")).ExtractErr()
+	_, err = services.Respawn(c.Request.Context(), vm)
 	if err != nil {
-		fmt.Println(err)
-
-		// If we can't add security group to a virtual machine.
-		result := servers.Delete(client, server.ID)
-
-		// Do we want to print a response or fail silently?
-		// This should probably be logged into some kind of logging system.
-		if result.ExtractErr() != nil {
-			fmt.Println("This error occurred while adding a security to a vm.", result.ExtractErr())
-		}
-
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Error while adding a network security group to a virtual machine!", nil)
-		return
-	}
-
-	insertResponse := repositories.InsertMultipleVms(server, fip.IP, serverName, users, imageInfo.ImageId)
-	if insertResponse == nil {
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Unable to save virtual machine!", nil)
+		httputils.AbortWithError(c, serviceError(err, "Unable to respawn virtual machine!"))
 		return
 	}
 
-	result := servers.Delete(client, id)
-
-	if result.ExtractErr() != nil {
-
-		forceDeleteResult := servers.ForceDelete(client, id)
-		forceDeleteResultError := forceDeleteResult.ExtractErr()
-
-		if forceDeleteResultError != nil {
-			httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Unable to delete virtual machine!", nil)
-			return
-		}
-	}
-
-	_, err = repositories.DeleteVMById(id)
+	usersVms, err := storage.Vms().GetVMByUserId(c.Request.Context(), c.MustGet("user_id").(string))
 	if err != nil {
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Error deleting virtual machine from database!", nil)
-		return
-	}
-
-	usersVms := repositories.GetVMByUserId(c.MustGet("user_id"))
-	if usersVms == nil {
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Unable to read virtual machines!", nil)
+		httputils.AbortWithError(c, apierror.Wrap(apierror.Internal, "Unable to read virtual machines!", err))
 		return
 	}
 
@@ -631,183 +585,41 @@ func RespawnVM(c *gin.Context) {
 func OrderVM(c *gin.Context) {
 	// Read request body
 	var requestStruct RequestBodyVmOrder
-	err := c.BindJSON(&requestStruct)
-	if err != nil {
-		httputils.AbortWithStatusJSON(c, http.StatusBadRequest, "Something is wrong with request body!", nil)
-		return
-	}
-
-	client := gopher.GetClient()
-
-	if len(requestStruct.ServerImage) == 0 {
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Missing server image id!", nil)
-		return
-	}
-
-	var groupMembers []string
-	users := c.MustGet("user_id").(string)
-	if len(requestStruct.Users) > 0 {
-		for _, v := range requestStruct.Users {
-			groupMembers = append(groupMembers, v)
-		}
-
-		users += "," + strings.Join(groupMembers, ",")
-	}
-
-	imageInfo := repositories.GetImageByImageId(requestStruct.ServerImage)
-
-	if imageInfo == nil {
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Error while reading images!", nil)
-		return
-	}
-
-	var userData []byte
-	if len(imageInfo.ImageConfig) > 0 {
-		userData = utils.GenerateUserData(imageInfo.ImageConfig, users)
-	}
-
-	serverName := ""
-	if len(requestStruct.ServerName) > 0 {
-		serverName = strings.ToUpper(requestStruct.ServerName)
-	} else {
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Missing server name!", nil)
-		return
-	}
-
-	if len(requestStruct.GroupName) > 0 {
-		serverName = serverName + "-" + strings.ToUpper(requestStruct.GroupName)
-	} else {
-		cleanUserName := ""
-
-		if strings.Contains(c.MustGet("user_id").(string), "@uia.no") {
-			cleanUserName = strings.Replace(c.MustGet("user_id").(string), "@uia.no", "", -1)
-		} else if strings.Contains(c.MustGet("user_id").(string), "@student.uia.no") {
-			cleanUserName = strings.Replace(c.MustGet("user_id").(string), "@student.uia.no", "", -1)
-		}
-
-		serverName = serverName + "-" + cleanUserName
-	}
-
-	blockDevices := []bootfromvolume.BlockDevice{
-		bootfromvolume.BlockDevice{
-			DeleteOnTermination: true,
-			DestinationType:     bootfromvolume.DestinationVolume,
-			SourceType:          bootfromvolume.SourceImage,
-			UUID:                requestStruct.ServerImage,
-			VolumeSize:          viper.GetInt("IKT_STACK_VM_VOLUME_SIZE"),
-		},
-	}
-
-	serverCreateOpts := servers.CreateOpts{
-		Name:      serverName,
-		FlavorRef: viper.GetString("IKT_STACK_VM_FLAVOR_ID"),
-		UserData:  userData,
-		Networks: []servers.Network{
-			{
-				UUID: viper.GetString("IKT_STACK_VM_NETWORK_ID"),
-			},
-		},
-		Metadata: map[string]string{
-			"VM_IMAGE_ID":            requestStruct.ServerImage,
-			"VM_FLAVOR_ID":           viper.GetString("IKT_STACK_VM_FLAVOR_ID"),
-			"VM_KEY_NAME":            serverName,
-			"VM_VOLUME_SIZE":         viper.GetString("IKT_STACK_VM_VOLUME_SIZE"),
-			"VM_NETWORK_ID":          viper.GetString("IKT_STACK_VM_NETWORK_ID"),
-			"VM_FLOATING_NETWORK_ID": viper.GetString("IKT_STACK_VM_FLOATING_NETWORK_ID"),
-		},
-	}
-
-	serverCreateOptsExt := keypairs.CreateOptsExt{
-		CreateOptsBuilder: serverCreateOpts,
-		KeyName:           viper.GetString("IKT_STACK_VM_KEY_NAME"),
-	}
-
-	createOpts := bootfromvolume.CreateOptsExt{
-		CreateOptsBuilder: serverCreateOptsExt,
-		BlockDevice:       blockDevices,
-	}
-
-	server, err := bootfromvolume.Create(client, createOpts).Extract()
-
-	// Unable to create a virtual machine
+	err := c.ShouldBindJSON(&requestStruct)
 	if err != nil {
-		fmt.Println(err)
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Unable to create a virtual machine!", nil)
+		httputils.AbortWithError(c, apierror.FromBind(err))
 		return
 	}
 
-	// Wait until server status changes to "active"
-	if err := servers.WaitForStatus(client, server.ID, database.VirtualMachineStatusActive, database.ServerStatusPollingTime); err != nil {
-		fmt.Println(err)
-
-		// If we can't get status in time, remove hanging virtual machine.
-		result := servers.Delete(client, server.ID)
-
-		// Do we want to print a response or fail silently?
-		// This should probably be logged into some kind of logging system.
-		if result.ExtractErr() != nil {
-			fmt.Println("This error occurred while deleting vm,"+
-				"after database failed insertion of a new vm.", result.ExtractErr())
-		}
-
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Error while waiting for virtual machine to become active!", nil)
+	if err := requireImage(c.Request.Context(), "server_image", requestStruct.ServerImage); err != nil {
+		httputils.AbortWithError(c, err)
 		return
 	}
 
-	createFloatingIpOpts := floatingips.CreateOpts{
-		Pool: viper.GetString("IKT_STACK_VM_FLOATING_NETWORK_ID"),
-	}
-
-	fip, err := floatingips.Create(client, createFloatingIpOpts).Extract()
+	capacity, err := services.PlanOrder(c.Request.Context(), "", requestStruct.ServerImage, 1)
 	if err != nil {
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Error while creating floating ip for virtual machine!", nil)
+		httputils.AbortWithError(c, capacityError(err, capacity))
 		return
 	}
 
-	associateOpts := floatingips.AssociateOpts{
-		FloatingIP: fip.IP,
-		FixedIP:    fip.FixedIP,
-	}
+	userId := c.MustGet("user_id").(string)
+	users := append([]string{userId}, requestStruct.Users...)
 
-	err = floatingips.AssociateInstance(client, server.ID, associateOpts).ExtractErr()
+	_, err = services.Order(c.Request.Context(), services.ProvisionRequest{
+		ServerName: serverNameFor(requestStruct.ServerName, requestStruct.GroupName, userId),
+		ImageId:    requestStruct.ServerImage,
+		Members:    database.MembersFromUserIds(users),
+		CreatedBy:  userId,
+		Target:     capacity.Target,
+	})
 	if err != nil {
-
-		err = floatingips.Delete(client, fip.ID).ExtractErr()
-		if err != nil {
-			fmt.Println("This error occurred deleting floating ip," +
-				"after failed attempt to assign it to a vm.")
-		}
-
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Error while assigning floating ip to virtual machine!", nil)
+		httputils.AbortWithError(c, serviceError(err, "Unable to create a virtual machine!"))
 		return
 	}
 
-	err = secgroups.AddServer(client, server.ID, viper.GetString("IKT_STACK_VM_SECURITY_GROUP_ID")).ExtractErr()
+	usersVms, err := storage.Vms().GetVMByUserId(c.Request.Context(), userId)
 	if err != nil {
-		fmt.Println(err)
-
-		// If we can't add security group to a virtual machine.
-		result := servers.Delete(client, server.ID)
-
-		// Do we want to print a response or fail silently?
-		// This should probably be logged into some kind of logging system.
-		if result.ExtractErr() != nil {
-			fmt.Println("This error occurred while adding a security to a vm.", result.ExtractErr())
-		}
-
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Error while adding a network security group to a virtual machine!", nil)
-		return
-	}
-
-	insertResponse := repositories.InsertMultipleVms(server, fip.IP, serverName, users, imageInfo.ImageId)
-	if insertResponse == nil {
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Unable to save virtual machine!", nil)
-		return
-	}
-
-	usersVms := repositories.GetVMByUserId(c.MustGet("user_id"))
-	if usersVms == nil {
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Unable to read virtual machines!", nil)
+		httputils.AbortWithError(c, apierror.Wrap(apierror.Internal, "Unable to read virtual machines!", err))
 		return
 	}
 
@@ -826,67 +638,40 @@ func OrderVM(c *gin.Context) {
 // @Failure     400 {object}    nil
 // @Failure     406 {object}    nil
 // @Failure     500 {object}    nil
+// @Failure     502 {object}    nil
 // @Router      /vms/:id    [delete]
 func DeleteVM(c *gin.Context) {
 	id := c.Param("id")
 
 	if len(id) <= 0 {
-		httputils.AbortWithStatusJSON(c, http.StatusBadRequest, "Missing id!", nil)
+		httputils.AbortWithError(c, apierror.New(apierror.InvalidRequest, "Missing id!"))
 		return
 	}
 
 	if !IsAdmin(c) {
-		if !repositories.CheckIfOwnsVm(id, c.MustGet("user_id").(string)) {
-			httputils.AbortWithStatusJSON(c, http.StatusUnauthorized, "You don't own this virtual machine!", nil)
+		if owns, err := storage.Vms().CheckIfOwnsVm(c.Request.Context(), id, c.MustGet("user_id").(string)); err != nil || !owns {
+			httputils.AbortWithError(c, errNotVmMember)
 			return
 		}
 	}
 
-	vm, err := repositories.GetVMById(id)
+	vm, err := storage.Vms().GetVMById(c.Request.Context(), id)
 	if err != nil {
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Error reading database!", nil)
+		httputils.AbortWithError(c, vmError(err))
 		return
 	}
 
-	client := gopher.GetClient()
-
-	disassociateOpts := floatingips.DisassociateOpts{
-		FloatingIP: vm.ServerIp,
-	}
-
-	err = floatingips.DisassociateInstance(client, vm.ServerId, disassociateOpts).ExtractErr()
-	if err != nil {
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Error unassigning floating ip from virtual machine!", nil)
+	if err := services.DeleteVm(c.Request.Context(), vm); err != nil {
+		httputils.AbortWithError(c, serviceError(err, "Unable to delete virtual machine!"))
 		return
 	}
 
-	result := servers.Delete(client, id)
-
-	if result.ExtractErr() != nil {
-
-		forceDeleteResult := servers.ForceDelete(client, id)
-		forceDeleteResultError := forceDeleteResult.ExtractErr()
-
-		if forceDeleteResultError != nil {
-			httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Unable to delete virtual machine!", nil)
-			return
-		}
-	}
-
-	_, err = repositories.DeleteVMById(id)
+	virtualMachines, err := listVms(c)
 	if err != nil {
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Error deleting virtual machine from database!", nil)
+		httputils.AbortWithError(c, apierror.Wrap(apierror.Internal, "Unable to read virtual machines!", err))
 		return
 	}
 
-	var virtualMachines interface{}
-
-	if IsAdmin(c) {
-		virtualMachines = repositories.GetVMS()
-	} else {
-		virtualMachines = repositories.GetVMByUserId(c.MustGet("user_id"))
-	}
-
 	httputils.ResponseJson(c, http.StatusOK, "Virtual machine deleted successfully!", virtualMachines)
 	return
 }
@@ -906,18 +691,21 @@ func DeleteVM(c *gin.Context) {
 func GenerateConsoleUrl(c *gin.Context) {
 	id := c.Param("id")
 	if len(id) <= 0 {
-		httputils.AbortWithStatusJSON(c, http.StatusBadRequest, "Missing id!", nil)
+		httputils.AbortWithError(c, apierror.New(apierror.InvalidRequest, "Missing id!"))
 		return
 	}
 
 	if !IsAdmin(c) {
-		if !repositories.CheckIfOwnsVm(id, c.MustGet("user_id").(string)) {
-			httputils.AbortWithStatusJSON(c, http.StatusUnauthorized, "You don't own this virtual machine!", nil)
+		if owns, err := storage.Vms().CheckIfOwnsVm(c.Request.Context(), id, c.MustGet("user_id").(string)); err != nil || !owns {
+			httputils.AbortWithError(c, errNotVmMember)
 			return
 		}
 	}
 
-	client := gopher.GetClient()
+	client, ok := vmCompute(c, id)
+	if !ok {
+		return
+	}
 	client.Microversion = "2.6"
 
 	createOpts := remoteconsoles.CreateOpts{
@@ -927,8 +715,7 @@ func GenerateConsoleUrl(c *gin.Context) {
 
 	remoteConsole, err := remoteconsoles.Create(client, id, createOpts).Extract()
 	if err != nil {
-		fmt.Println("This error occurred while adding a security to a vm.", err)
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Error while generating access link!", nil)
+		httputils.AbortWithError(c, apierror.Wrap(apierror.OpenStack, "Error while generating access link!", err))
 		return
 	}
 
@@ -946,229 +733,70 @@ func GenerateConsoleUrl(c *gin.Context) {
 // @Success     200 {object}    []database.VirtualMachine
 // @Failure     400 {object}    nil
 // @Failure     406 {object}    nil
+// @Failure     409 {object}    nil
 // @Failure     500 {object}    nil
+// @Failure     502 {object}    nil
 // @Router      /vms/canvas/all   [post]
 func OrderVMFromCanvasAllStudents(c *gin.Context) {
 	if !IsAdmin(c) {
-		httputils.AbortWithStatusJSON(c, http.StatusUnauthorized, "Administrator credentials required!", nil)
+		httputils.AbortWithError(c, errAdminRequired)
 		return
 	}
 
 	// Read request body
 	var requestStruct RequestBodyVmOrderAll
-	err := c.BindJSON(&requestStruct)
+	err := c.ShouldBindJSON(&requestStruct)
 	if err != nil {
-		httputils.AbortWithStatusJSON(c, http.StatusBadRequest, "Something is wrong with request body!", nil)
+		httputils.AbortWithError(c, apierror.FromBind(err))
 		return
 	}
 
-	courseId := requestStruct.CourseCode
-
-	response, canvasErr := requestCanvasApi("GET", fmt.Sprintf("/courses/%s/users?per_page=1000&enrollment_type=student", courseId), nil, true)
-	if canvasErr != nil {
-		fmt.Println(canvasErr)
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Error reading canvas api", nil)
+	if err := requireImage(c.Request.Context(), "server_image", requestStruct.ServerImage); err != nil {
+		httputils.AbortWithError(c, err)
 		return
 	}
 
-	data := response.([]interface{})
-
-	if requestStruct.IncludeTeacher == "true" {
-		response, canvasErr := requestCanvasApi("GET", fmt.Sprintf("/courses/%s/users?per_page=1000&enrollment_type=teacher", courseId), nil, true)
-		if canvasErr != nil {
-			fmt.Println(canvasErr)
-			httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Error reading canvas api", nil)
-			return
-		}
-
-		d := response.([]interface{})
-		data = append(data, d...)
+	order := services.CourseOrder{
+		CourseCode:     requestStruct.CourseCode,
+		ServerName:     requestStruct.ServerName,
+		ImageId:        requestStruct.ServerImage,
+		IncludeTeacher: requestStruct.IncludeTeacher,
+		IncludeTa:      requestStruct.IncludeTa,
+		OrderedBy:      c.MustGet("user_id").(string),
+		Trim:           requestStruct.Trim,
 	}
 
-	if requestStruct.IncludeTa == "true" {
-		response, canvasErr := requestCanvasApi("GET", fmt.Sprintf("/courses/%s/users?per_page=1000&enrollment_type=ta", courseId), nil, true)
-		if canvasErr != nil {
-			fmt.Println(canvasErr)
-			httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Error reading canvas api", nil)
-			return
-		}
-
-		d := response.([]interface{})
-		data = append(data, d...)
-	}
+	userIds, err := services.CourseUsers(c.Request.Context(), order)
+	if err != nil {
+		httputils.AbortWithError(c, apierror.Wrap(apierror.Canvas, services.Message(err, "Error reading canvas api"), err))
+		return
+	}
 
-	if len(data) == 0 {
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Error no users in response", nil)
-		return
+	// Refuse or trim the order before anything is created, instead of failing halfway through.
+	plan, err := services.PlanCourse(c.Request.Context(), &order, userIds)
+	if err != nil {
+		httputils.AbortWithError(c, capacityError(err, plan.Capacity))
+		return
 	}
 
-	client := gopher.GetClient()
+	// The response is sent before provisioning finishes, so the request context cannot be used here.
+	// The job keeps its log fields, the lines of every virtual machine can be found by job_id.
+	ctx := tracing.Continue(logging.Detach(c.Request.Context()), c.Request.Context())
+	go services.ProvisionCourse(logging.Job(ctx, "course_order"), order, plan.UserIds)
 
-	if len(requestStruct.ServerImage) == 0 {
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Missing server image id!", nil)
+	virtualMachines, err := storage.Vms().GetVMS(c.Request.Context())
+	if err != nil {
+		httputils.AbortWithError(c, apierror.Wrap(apierror.Internal, "Unable to read virtual machines!", err))
 		return
 	}
 
-	imageInfo := repositories.GetImageByImageId(requestStruct.ServerImage)
-
-	for _, v := range data {
-		val := v.(map[string]interface{})
-
-		go func(val map[string]interface{}) {
-
-			// If a user is invited but has not accepted invitation to the course, their login_id is nil by default
-			if val["login_id"] == nil {
-				return
-			}
-
-			if imageInfo == nil {
-				return
-			}
-
-			cleanUserName := ""
-			if strings.Contains(val["login_id"].(string), "@uia.no") {
-				cleanUserName = strings.Replace(val["login_id"].(string), "@uia.no", "", -1)
-			} else if strings.Contains(val["login_id"].(string), "@student.uia.no") {
-				cleanUserName = strings.Replace(val["login_id"].(string), "@student.uia.no", "", -1)
-			}
-
-			var groupMembers []string
-			groupMembers = append(groupMembers, val["login_id"].(string))
-			users := strings.Join(groupMembers, ",")
-
-			var userData []byte
-			if len(imageInfo.ImageConfig) > 0 {
-				userData = utils.GenerateUserData(imageInfo.ImageConfig, users)
-			}
-
-			serverName := ""
-			if len(requestStruct.ServerName) > 0 {
-				serverName = strings.ToUpper(requestStruct.ServerName)
-			} else {
-				return
-			}
-
-			serverName = serverName + "-" + cleanUserName
-
-			blockDevices := []bootfromvolume.BlockDevice{
-				bootfromvolume.BlockDevice{
-					DeleteOnTermination: true,
-					DestinationType:     bootfromvolume.DestinationVolume,
-					SourceType:          bootfromvolume.SourceImage,
-					UUID:                requestStruct.ServerImage,
-					VolumeSize:          viper.GetInt("IKT_STACK_VM_VOLUME_SIZE"),
-				},
-			}
-
-			serverCreateOpts := servers.CreateOpts{
-				Name:      serverName,
-				FlavorRef: viper.GetString("IKT_STACK_VM_FLAVOR_ID"),
-				UserData:  userData,
-				Networks: []servers.Network{
-					{
-						UUID: viper.GetString("IKT_STACK_VM_NETWORK_ID"),
-					},
-				},
-				Metadata: map[string]string{
-					"VM_IMAGE_ID":            requestStruct.ServerImage,
-					"VM_FLAVOR_ID":           viper.GetString("IKT_STACK_VM_FLAVOR_ID"),
-					"VM_KEY_NAME":            serverName,
-					"VM_VOLUME_SIZE":         viper.GetString("IKT_STACK_VM_VOLUME_SIZE"),
-					"VM_NETWORK_ID":          viper.GetString("IKT_STACK_VM_NETWORK_ID"),
-					"VM_FLOATING_NETWORK_ID": viper.GetString("IKT_STACK_VM_FLOATING_NETWORK_ID"),
-				},
-			}
-
-			serverCreateOptsExt := keypairs.CreateOptsExt{
-				CreateOptsBuilder: serverCreateOpts,
-				KeyName:           viper.GetString("IKT_STACK_VM_KEY_NAME"),
-			}
-
-			createOpts := bootfromvolume.CreateOptsExt{
-				CreateOptsBuilder: serverCreateOptsExt,
-				BlockDevice:       blockDevices,
-			}
-
-			server, err := bootfromvolume.Create(client, createOpts).Extract()
-
-			// Unable to create a virtual machine
-			if err != nil {
-				fmt.Println(err)
-				return
-			}
-
-			// Wait until server status changes to "active"
-			if err := servers.WaitForStatus(client, server.ID, database.VirtualMachineStatusActive, database.ServerStatusPollingTime); err != nil {
-				fmt.Println(err)
-
-				// If we can't get status in time, remove hanging virtual machine.
-				result := servers.Delete(client, server.ID)
-
-				// Do we want to print a response or fail silently?
-				// This should probably be logged into some kind of logging system.
-				if result.ExtractErr() != nil {
-					fmt.Println("This error occurred while deleting vm,"+
-						"after database failed insertion of a new vm.", result.ExtractErr())
-				}
-				return
-			}
-
-			createFloatingIpOpts := floatingips.CreateOpts{
-				Pool: viper.GetString("IKT_STACK_VM_FLOATING_NETWORK_ID"),
-			}
-
-			fip, err := floatingips.Create(client, createFloatingIpOpts).Extract()
-			if err != nil {
-				return
-			}
-
-			associateOpts := floatingips.AssociateOpts{
-				FloatingIP: fip.IP,
-				FixedIP:    fip.FixedIP,
-			}
-
-			err = floatingips.AssociateInstance(client, server.ID, associateOpts).ExtractErr()
-			if err != nil {
-
-				err = floatingips.Delete(client, fip.ID).ExtractErr()
-				if err != nil {
-					fmt.Println("This error occurred deleting floating ip," +
-						"after failed attempt to assign it to a vm.")
-				}
-				return
-			}
-
-			err = secgroups.AddServer(client, server.ID, viper.GetString("IKT_STACK_VM_SECURITY_GROUP_ID")).ExtractErr()
-			if err != nil {
-				fmt.Println(err)
-
-				// If we can't add security group to a virtual machine.
-				result := servers.Delete(client, server.ID)
-
-				// Do we want to print a response or fail silently?
-				// This should probably be logged into some kind of logging system.
-				if result.ExtractErr() != nil {
-					fmt.Println("This error occurred while adding a security to a vm.", result.ExtractErr())
-				}
-				return
-			}
-
-			insertResponse := repositories.InsertMultipleVms(server, fip.IP, serverName, users, imageInfo.ImageId)
-			if insertResponse == nil {
-				return
-			}
-			return
-		}(val)
-	}
-
-	var virtualMachines []database.VirtualMachine
-	virtualMachines = repositories.GetVMS()
-	if virtualMachines == nil {
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Unable to read virtual machines!", nil)
-		return
+	message := "Virtual machine created successfully!"
+	if len(plan.Skipped) > 0 {
+		message = fmt.Sprintf("Creating %d of %d virtual machines, OpenStack target %s has no room for: %s",
+			len(plan.UserIds), len(userIds), plan.Capacity.Target, strings.Join(plan.Skipped, ", "))
 	}
 
-	httputils.ResponseJson(c, http.StatusOK, "Virtual machine created successfully!", virtualMachines)
+	httputils.ResponseJson(c, http.StatusOK, message, virtualMachines)
 	return
 }
 
@@ -1182,244 +810,295 @@ func OrderVMFromCanvasAllStudents(c *gin.Context) {
 // @Success     200 {object}    database.VirtualMachine
 // @Failure     400 {object}    nil
 // @Failure     406 {object}    nil
+// @Failure     409 {object}    nil
 // @Failure     500 {object}    nil
+// @Failure     502 {object}    nil
 // @Router      /vms/canvas   [post]
 func OrderVMFromCanvas(c *gin.Context) {
 	if !IsAdmin(c) {
-		httputils.AbortWithStatusJSON(c, http.StatusUnauthorized, "Administrator credentials required!", nil)
+		httputils.AbortWithError(c, errAdminRequired)
 		return
 	}
 
 	// Read request body
 	var requestStruct RequestBodyVmOrder
-	err := c.BindJSON(&requestStruct)
+	err := c.ShouldBindJSON(&requestStruct)
 	if err != nil {
-		httputils.AbortWithStatusJSON(c, http.StatusBadRequest, "Something is wrong with request body!", nil)
+		httputils.AbortWithError(c, apierror.FromBind(err))
 		return
 	}
 
-	client := gopher.GetClient()
-
-	if len(requestStruct.ServerImage) == 0 {
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Missing server image id!", nil)
+	if len(requestStruct.Users) == 0 {
+		httputils.AbortWithError(c, apierror.New(apierror.ValidationFailed, "Invalid request: users is required!").
+			WithField("users", "required", "is required"))
 		return
 	}
 
-	var groupMembers []string
-	for _, v := range requestStruct.Users {
-		groupMembers = append(groupMembers, v)
+	if err := requireImage(c.Request.Context(), "server_image", requestStruct.ServerImage); err != nil {
+		httputils.AbortWithError(c, err)
+		return
 	}
-	users := strings.Join(groupMembers, ",")
 
-	imageInfo := repositories.GetImageByImageId(requestStruct.ServerImage)
-
-	if imageInfo == nil {
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Error while reading images!", nil)
+	capacity, err := services.PlanOrder(c.Request.Context(), requestStruct.CourseCode, requestStruct.ServerImage, 1)
+	if err != nil {
+		httputils.AbortWithError(c, capacityError(err, capacity))
 		return
 	}
 
-	var userData []byte
-	if len(imageInfo.ImageConfig) > 0 {
-		userData = utils.GenerateUserData(imageInfo.ImageConfig, users)
+	_, err = services.Order(c.Request.Context(), services.ProvisionRequest{
+		ServerName: serverNameFor(requestStruct.ServerName, requestStruct.GroupName, strings.Join(requestStruct.Users, ",")),
+		ImageId:    requestStruct.ServerImage,
+		CourseCode: requestStruct.CourseCode,
+		Members:    database.MembersFromUserIds(requestStruct.Users),
+		CreatedBy:  c.MustGet("user_id").(string),
+		Target:     capacity.Target,
+	})
+	if err != nil {
+		httputils.AbortWithError(c, serviceError(err, "Unable to create a virtual machine!"))
+		return
 	}
 
-	serverName := ""
-	if len(requestStruct.ServerName) > 0 {
-		serverName = strings.ToUpper(requestStruct.ServerName)
-	} else {
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Missing server name!", nil)
+	virtualMachines, err := storage.Vms().GetVMS(c.Request.Context())
+	if err != nil {
+		httputils.AbortWithError(c, apierror.Wrap(apierror.Internal, "Unable to read virtual machines!", err))
 		return
 	}
 
-	if len(requestStruct.GroupName) > 0 {
-		serverName = serverName + "-" + strings.ToUpper(requestStruct.GroupName)
-	} else {
-		cleanUserName := ""
-
-		if strings.Contains(users, "@uia.no") {
-			cleanUserName = strings.Replace(users, "@uia.no", "", -1)
-		} else if strings.Contains(users, "@student.uia.no") {
-			cleanUserName = strings.Replace(users, "@student.uia.no", "", -1)
-		}
-
-		serverName = serverName + "-" + cleanUserName
-	}
+	httputils.ResponseJson(c, http.StatusOK, "Virtual machine created successfully!", virtualMachines)
+	return
+}
 
-	blockDevices := []bootfromvolume.BlockDevice{
-		bootfromvolume.BlockDevice{
-			DeleteOnTermination: true,
-			DestinationType:     bootfromvolume.DestinationVolume,
-			SourceType:          bootfromvolume.SourceImage,
-			UUID:                requestStruct.ServerImage,
-			VolumeSize:          viper.GetInt("IKT_STACK_VM_VOLUME_SIZE"),
-		},
+// GetPassword godoc
+// @Summary     Fetches VM password
+// @Description Fetches the password for a VM
+// @Tags        vms
+// @Accept      json
+// @Produce     json
+// @Param       id  path    string  true    "Server ID"
+// @Success     200 {object}    interface{}
+// @Failure     400 {object}    nil
+// @Failure     406 {object}    nil
+// @Failure     500 {object}    nil
+// @Router      /vms/:id/password    [get]
+func GetPassword(c *gin.Context) {
+	id := c.Param("id")
+	if len(id) <= 0 {
+		httputils.AbortWithError(c, apierror.New(apierror.InvalidRequest, "Missing id!"))
+		return
 	}
 
-	serverCreateOpts := servers.CreateOpts{
-		Name:      serverName,
-		FlavorRef: viper.GetString("IKT_STACK_VM_FLAVOR_ID"),
-		UserData:  userData,
-		Networks: []servers.Network{
-			{
-				UUID: viper.GetString("IKT_STACK_VM_NETWORK_ID"),
-			},
-		},
-		Metadata: map[string]string{
-			"VM_IMAGE_ID":            requestStruct.ServerImage,
-			"VM_FLAVOR_ID":           viper.GetString("IKT_STACK_VM_FLAVOR_ID"),
-			"VM_KEY_NAME":            serverName,
-			"VM_VOLUME_SIZE":         viper.GetString("IKT_STACK_VM_VOLUME_SIZE"),
-			"VM_NETWORK_ID":          viper.GetString("IKT_STACK_VM_NETWORK_ID"),
-			"VM_FLOATING_NETWORK_ID": viper.GetString("IKT_STACK_VM_FLOATING_NETWORK_ID"),
-		},
+	if !IsAdmin(c) {
+		if owns, err := storage.Vms().CheckIfOwnsVm(c.Request.Context(), id, c.MustGet("user_id").(string)); err != nil || !owns {
+			httputils.AbortWithError(c, errNotVmMember)
+			return
+		}
 	}
 
-	serverCreateOptsExt := keypairs.CreateOptsExt{
-		CreateOptsBuilder: serverCreateOpts,
-		KeyName:           viper.GetString("IKT_STACK_VM_KEY_NAME"),
+	client, ok := vmCompute(c, id)
+	if !ok {
+		return
 	}
 
-	createOpts := bootfromvolume.CreateOptsExt{
-		CreateOptsBuilder: serverCreateOptsExt,
-		BlockDevice:       blockDevices,
+	key := utils.ReadPrivateKey()
+	if reflect.TypeOf(key).Kind() == reflect.String {
+		httputils.AbortWithError(c, apierror.New(apierror.Internal, key.(string)))
+		return
 	}
 
-	server, err := bootfromvolume.Create(client, createOpts).Extract()
+	password, err := servers.GetPassword(client, id).ExtractPassword(key.(*rsa.PrivateKey))
 
-	// Unable to create a virtual machine
 	if err != nil {
-		fmt.Println(err)
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Unable to create a virtual machine!", nil)
+		httputils.AbortWithError(c, apierror.Wrap(apierror.OpenStack, "Error while generating access link!", err))
 		return
 	}
 
-	// Wait until server status changes to "active"
-	if err := servers.WaitForStatus(client, server.ID, database.VirtualMachineStatusActive, database.ServerStatusPollingTime); err != nil {
-		fmt.Println(err)
+	if len(password) == 0 {
+		httputils.AbortWithError(c, apierror.New(apierror.NotFound, "Password is not set!"))
+		return
+	}
 
-		// If we can't get status in time, remove hanging virtual machine.
-		result := servers.Delete(client, server.ID)
+	httputils.ResponseJson(c, http.StatusOK, "", password)
+	return
+}
 
-		// Do we want to print a response or fail silently?
-		// This should probably be logged into some kind of logging system.
-		if result.ExtractErr() != nil {
-			fmt.Println("This error occurred while deleting vm,"+
-				"after database failed insertion of a new vm.", result.ExtractErr())
-		}
+// RenewVmLease godoc
+// @Summary     Renews the lease of a VM
+// @Description Gives the VM a full lease from now, members are warned again before it ends
+// @Tags        vms
+// @Accept      json
+// @Produce     json
+// @Param       id  path    string  true    "Server ID"
+// @Success     200 {object}    database.VirtualMachine
+// @Failure     400 {object}    nil
+// @Failure     404 {object}    nil
+// @Failure     406 {object}    nil
+// @Failure     409 {object}    nil
+// @Failure     500 {object}    nil
+// @Router      /vms/:id/lease  [post]
+func RenewVmLease(c *gin.Context) {
+	id := c.Param("id")
 
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Error while waiting for virtual machine to become active!", nil)
+	if len(id) <= 0 {
+		httputils.AbortWithError(c, apierror.New(apierror.InvalidRequest, "Missing id!"))
 		return
 	}
 
-	createFloatingIpOpts := floatingips.CreateOpts{
-		Pool: viper.GetString("IKT_STACK_VM_FLOATING_NETWORK_ID"),
+	if !IsAdmin(c) {
+		if owns, err := storage.Vms().CheckIfOwnsVm(c.Request.Context(), id, c.MustGet("user_id").(string)); err != nil || !owns {
+			httputils.AbortWithError(c, errNotVmMember)
+			return
+		}
 	}
 
-	fip, err := floatingips.Create(client, createFloatingIpOpts).Extract()
+	vm, err := storage.Vms().GetVMById(c.Request.Context(), id)
 	if err != nil {
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Error while creating floating ip for virtual machine!", nil)
+		httputils.AbortWithError(c, vmError(err))
 		return
 	}
 
-	associateOpts := floatingips.AssociateOpts{
-		FloatingIP: fip.IP,
-		FixedIP:    fip.FixedIP,
+	vm, err = leases.Renew(c.Request.Context(), vm, time.Now())
+	if errors.Is(err, leases.ErrNoLease) {
+		httputils.AbortWithError(c, apierror.Wrap(apierror.Conflict, "The virtual machine has no lease!", err))
+		return
 	}
-
-	err = floatingips.AssociateInstance(client, server.ID, associateOpts).ExtractErr()
 	if err != nil {
-
-		err = floatingips.Delete(client, fip.ID).ExtractErr()
-		if err != nil {
-			fmt.Println("This error occurred deleting floating ip," +
-				"after failed attempt to assign it to a vm.")
-		}
-
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Error while assigning floating ip to virtual machine!", nil)
+		httputils.AbortWithError(c, vmError(err))
 		return
 	}
 
-	err = secgroups.AddServer(client, server.ID, viper.GetString("IKT_STACK_VM_SECURITY_GROUP_ID")).ExtractErr()
-	if err != nil {
-		fmt.Println(err)
-
-		// If we can't add security group to a virtual machine.
-		result := servers.Delete(client, server.ID)
-
-		// Do we want to print a response or fail silently?
-		// This should probably be logged into some kind of logging system.
-		if result.ExtractErr() != nil {
-			fmt.Println("This error occurred while adding a security to a vm.", result.ExtractErr())
-		}
+	httputils.ResponseJson(c, http.StatusOK, "Lease renewed!", vm)
+	return
+}
 
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Error while adding a network security group to a virtual machine!", nil)
-		return
+// authorizeMembership lets administrators and the owner of a virtual machine change its members.
+func authorizeMembership(c *gin.Context, id string) bool {
+	if IsAdmin(c) {
+		return true
 	}
 
-	insertResponse := repositories.InsertMultipleVms(server, fip.IP, serverName, users, imageInfo.ImageId)
-	if insertResponse == nil {
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Unable to save virtual machine!", nil)
-		return
+	vm, err := storage.Vms().GetVMById(c.Request.Context(), id)
+	if err != nil || vm.UserId != c.MustGet("user_id").(string) {
+		httputils.AbortWithError(c, apierror.New(apierror.Forbidden, "Only the owner can change the members of a virtual machine!"))
+		return false
 	}
 
-	var virtualMachines []database.VirtualMachine
-	virtualMachines = repositories.GetVMS()
-	if virtualMachines == nil {
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Unable to read virtual machines!", nil)
-		return
-	}
+	return true
+}
 
-	httputils.ResponseJson(c, http.StatusOK, "Virtual machine created successfully!", virtualMachines)
-	return
+func respondMembership(c *gin.Context, result services.MembershipResult, err error) {
+	switch {
+	case errors.Is(err, services.ErrInvalidUser):
+		httputils.AbortWithError(c, apierror.Wrap(apierror.ValidationFailed, services.Message(err, ""), err).
+			WithField("user_id", "invalid", "must be a uia.no or student.uia.no address"))
+	case errors.Is(err, services.ErrRemoveOwner):
+		httputils.AbortWithError(c, apierror.Wrap(apierror.OwnerNotRemovable, services.Message(err, ""), err))
+	case errors.Is(err, services.ErrNotMember):
+		httputils.AbortWithError(c, apierror.Wrap(apierror.NotMember, services.Message(err, ""), err))
+	case errors.Is(err, storage.ErrNotFound):
+		httputils.AbortWithError(c, apierror.Wrap(apierror.NotFound, services.Message(err, ""), err))
+	case errors.Is(err, services.ErrAlreadyMember):
+		httputils.AbortWithError(c, apierror.Wrap(apierror.AlreadyMember, services.Message(err, ""), err))
+	case errors.Is(err, storage.ErrConflict):
+		httputils.AbortWithError(c, apierror.Wrap(apierror.Conflict, "Members were changed at the same time, try again!", err))
+	case err != nil:
+		httputils.AbortWithError(c, serviceError(err, "Error updating virtual machine members!"))
+	case !result.AccessPushed:
+		httputils.ResponseJson(c, http.StatusOK, "Members updated, the access list on the virtual machine was not: "+result.AccessError, result)
+	default:
+		httputils.ResponseJson(c, http.StatusOK, "Members updated successfully!", result)
+	}
 }
 
-// GetPassword godoc
-// @Summary     Fetches VM password
-// @Description Fetches the password for a VM
+// AddVmMember godoc
+// @Summary     Adds a member to a VM
+// @Description Gives a user access to a VM and pushes the new access list to it, without a respawn
 // @Tags        vms
 // @Accept      json
 // @Produce     json
-// @Param       id  path    string  true    "Server ID"
-// @Success     200 {object}    interface{}
+// @Param       vmId            path    string              true    "VM ID"
+// @Param       requestBody     body    RequestBodyUserId   true    "Request Body"
+// @Success     200 {object}    services.MembershipResult
 // @Failure     400 {object}    nil
-// @Failure     406 {object}    nil
+// @Failure     401 {object}    nil
+// @Failure     403 {object}    nil
+// @Failure     404 {object}    nil
+// @Failure     409 {object}    nil
 // @Failure     500 {object}    nil
-// @Router      /vms/:id/password    [get]
-func GetPassword(c *gin.Context) {
+// @Router      /vms/:id/members [post]
+func AddVmMember(c *gin.Context) {
 	id := c.Param("id")
-	if len(id) <= 0 {
-		httputils.AbortWithStatusJSON(c, http.StatusBadRequest, "Missing id!", nil)
+
+	if !authorizeMembership(c, id) {
 		return
 	}
 
-	if !IsAdmin(c) {
-		if !repositories.CheckIfOwnsVm(id, c.MustGet("user_id").(string)) {
-			httputils.AbortWithStatusJSON(c, http.StatusUnauthorized, "You don't own this virtual machine!", nil)
-			return
-		}
+	var requestBody RequestBodyUserId
+	if err := c.ShouldBindJSON(&requestBody); err != nil {
+		httputils.AbortWithError(c, apierror.FromBind(err))
+		return
 	}
 
-	client := gopher.GetClient()
+	result, err := services.AddMember(c.Request.Context(), id, requestBody.UserId)
+	respondMembership(c, result, err)
+	return
+}
 
-	key := utils.ReadPrivateKey()
-	if reflect.TypeOf(key).Kind() == reflect.String {
-		httputils.ResponseJson(c, http.StatusInternalServerError, key.(string), nil)
+// RemoveVmMember godoc
+// @Summary     Removes a member from a VM
+// @Description Takes access to a VM away from a member and pushes the new access list to it
+// @Tags        vms
+// @Produce     json
+// @Param       vmId    path    string  true    "VM ID"
+// @Param       userId  path    string  true    "User ID"
+// @Success     200 {object}    services.MembershipResult
+// @Failure     400 {object}    nil
+// @Failure     401 {object}    nil
+// @Failure     403 {object}    nil
+// @Failure     404 {object}    nil
+// @Failure     500 {object}    nil
+// @Router      /vms/:id/members/:user [delete]
+func RemoveVmMember(c *gin.Context) {
+	id := c.Param("id")
+
+	if !authorizeMembership(c, id) {
 		return
 	}
 
-	password, err := servers.GetPassword(client, id).ExtractPassword(key.(*rsa.PrivateKey))
+	result, err := services.RemoveMember(c.Request.Context(), id, c.Param("user"))
+	respondMembership(c, result, err)
+	return
+}
 
-	if err != nil {
-		fmt.Println("This error occurred while adding security to a vm.", err)
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Error while generating access link!", nil)
+// TransferVmOwnership godoc
+// @Summary     Transfers the ownership of a VM
+// @Description Makes another user the owner of a VM, the previous owner stays on as a member
+// @Tags        vms
+// @Accept      json
+// @Produce     json
+// @Param       vmId            path    string              true    "VM ID"
+// @Param       requestBody     body    RequestBodyUserId   true    "Request Body"
+// @Success     200 {object}    services.MembershipResult
+// @Failure     400 {object}    nil
+// @Failure     401 {object}    nil
+// @Failure     403 {object}    nil
+// @Failure     404 {object}    nil
+// @Failure     409 {object}    nil
+// @Failure     500 {object}    nil
+// @Router      /vms/:id/owner [post]
+func TransferVmOwnership(c *gin.Context) {
+	id := c.Param("id")
+
+	if !authorizeMembership(c, id) {
 		return
 	}
 
-	if len(password) == 0 {
-		httputils.AbortWithStatusJSON(c, http.StatusInternalServerError, "Password is not set", nil)
+	var requestBody RequestBodyUserId
+	if err := c.ShouldBindJSON(&requestBody); err != nil {
+		httputils.AbortWithError(c, apierror.FromBind(err))
 		return
 	}
 
-	httputils.ResponseJson(c, http.StatusOK, "", password)
+	result, err := services.TransferOwnership(c.Request.Context(), id, requestBody.UserId)
+	respondMembership(c, result, err)
 	return
 }
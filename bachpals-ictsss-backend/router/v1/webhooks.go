@@ -0,0 +1,380 @@
+package v1
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/apierror"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/database"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/httputils"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/storage"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/webhooks"
+)
+
+const deadDeliveriesLimit = 100
+
+type RequestBodyWebhook struct {
+	Url         string   `json:"url" binding:"required,url,max=2048"`
+	Secret      string   `json:"secret" binding:"max=256"`
+	Description string   `json:"description" binding:"max=1000"`
+	Events      []string `json:"events" binding:"max=50"`
+	Enabled     bool     `json:"enabled"`
+}
+
+type RequestBodyWebhookUpdate struct {
+	Id string `json:"id" binding:"required,record_id"`
+	RequestBodyWebhook
+}
+
+type WebhookIdStruct struct {
+	Id string `json:"id" binding:"required,record_id"`
+}
+
+// WebhookResponse is a subscription as the api lists it. The secret is left out, it is
+// only shown once when the subscription is added.
+type WebhookResponse struct {
+	Id          string
+	Url         string
+	Description string
+	Events      []string
+	Enabled     bool
+	CreatedBy   string
+	Created     time.Time
+}
+
+// CreatedWebhookResponse is an added subscription with the secret its deliveries are signed with.
+type CreatedWebhookResponse struct {
+	WebhookResponse
+	Secret string
+}
+
+// webhookError reports a failed read or write of a subscription, one that does not exist is a 404.
+func webhookError(err error, fallback string) *apierror.Error {
+	if errors.Is(err, storage.ErrNotFound) {
+		return apierror.Wrap(apierror.NotFound, "Webhook not found!", err)
+	}
+	return apierror.Wrap(apierror.Internal, fallback, err)
+}
+
+func webhookResponse(subscription database.WebhookSubscription) WebhookResponse {
+	return WebhookResponse{
+		Id:          subscription.Id,
+		Url:         subscription.Url,
+		Description: subscription.Description,
+		Events:      subscription.Events,
+		Enabled:     subscription.Enabled,
+		CreatedBy:   subscription.CreatedBy,
+		Created:     subscription.Created,
+	}
+}
+
+func webhookResponses(subscriptions []*database.WebhookSubscription) []WebhookResponse {
+	responses := []WebhookResponse{}
+	for _, v := range subscriptions {
+		responses = append(responses, webhookResponse(*v))
+	}
+	return responses
+}
+
+// validate returns a message describing the first problem with the body, or an empty string.
+func (r RequestBodyWebhook) validate() string {
+	u, err := url.Parse(r.Url)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+		return "Webhook url must be an absolute http or https url!"
+	}
+
+	for _, v := range r.Events {
+		if !webhooks.IsEvent(v) {
+			return "Unknown webhook event " + v + "!"
+		}
+	}
+
+	return ""
+}
+
+func (r RequestBodyWebhook) toSubscription() database.WebhookSubscription {
+	events := r.Events
+	if events == nil {
+		events = []string{}
+	}
+
+	secret := r.Secret
+	if len(secret) == 0 {
+		secret = webhooks.GenerateSecret()
+	}
+
+	return database.WebhookSubscription{
+		Url:         r.Url,
+		Secret:      secret,
+		Description: r.Description,
+		Events:      events,
+		Enabled:     r.Enabled,
+	}
+}
+
+// GetWebhooks godoc
+// @Summary     Retrieves list of webhook subscriptions
+// @Description Gets all subscriptions that receive VM, admin and image events
+// @Tags        webhooks
+// @Accept      json
+// @Produce     json
+// @Success     200 {object}    []WebhookResponse
+// @Failure     401 {object}    nil
+// @Failure     403 {object}    nil
+// @Failure     500 {object}    nil
+// @Router      /webhooks/  [get]
+func GetWebhooks(c *gin.Context) {
+	if !IsAdmin(c) {
+		httputils.AbortWithError(c, errAdminRequired)
+		return
+	}
+
+	subscriptions, err := storage.Webhooks().GetWebhooks(c.Request.Context())
+	if err != nil {
+		httputils.AbortWithError(c, apierror.Wrap(apierror.Internal, "Error while reading webhooks!", err))
+		return
+	}
+
+	httputils.ResponseJson(c, http.StatusOK, "", webhookResponses(subscriptions))
+	return
+}
+
+// AddWebhook godoc
+// @Summary     Adds a webhook subscription
+// @Description Adds a subscription, a secret is generated when none is given. An empty event list receives every event.
+// @Description The response is the only one that shows the secret.
+// @Tags        webhooks
+// @Accept      json
+// @Produce     json
+// @Param       body    body    RequestBodyWebhook  true    "Request Body"
+// @Success     200 {object}    CreatedWebhookResponse
+// @Failure     400 {object}    nil
+// @Failure     401 {object}    nil
+// @Failure     403 {object}    nil
+// @Failure     500 {object}    nil
+// @Router      /webhooks/  [post]
+func AddWebhook(c *gin.Context) {
+	if !IsAdmin(c) {
+		httputils.AbortWithError(c, errAdminRequired)
+		return
+	}
+
+	var requestBody RequestBodyWebhook
+	err := c.ShouldBindJSON(&requestBody)
+	if err != nil {
+		httputils.AbortWithError(c, apierror.FromBind(err))
+		return
+	}
+
+	if message := requestBody.validate(); len(message) > 0 {
+		httputils.AbortWithError(c, apierror.New(apierror.ValidationFailed, message))
+		return
+	}
+
+	subscription := requestBody.toSubscription()
+	subscription.CreatedBy = c.MustGet("user_id").(string)
+
+	id, err := storage.Webhooks().InsertWebhook(c.Request.Context(), subscription)
+	if err != nil {
+		httputils.AbortWithError(c, apierror.Wrap(apierror.Internal, "Error while inserting webhook!", err))
+		return
+	}
+
+	created, err := storage.Webhooks().GetWebhookById(c.Request.Context(), id)
+	if err != nil {
+		httputils.AbortWithError(c, apierror.Wrap(apierror.Internal, "Error while reading webhook!", err))
+		return
+	}
+
+	httputils.ResponseJson(c, http.StatusOK, "Webhook added, store the secret now, it is not shown again!", CreatedWebhookResponse{
+		WebhookResponse: webhookResponse(*created),
+		Secret:          created.Secret,
+	})
+	return
+}
+
+// UpdateWebhook godoc
+// @Summary     Updates a webhook subscription
+// @Description Replaces url, secret, description, event filter and enabled state of a subscription
+// @Tags        webhooks
+// @Accept      json
+// @Produce     json
+// @Param       body    body    RequestBodyWebhookUpdate    true    "Request Body"
+// @Success     200 {object}    WebhookResponse
+// @Failure     400 {object}    nil
+// @Failure     401 {object}    nil
+// @Failure     403 {object}    nil
+// @Failure     404 {object}    nil
+// @Failure     500 {object}    nil
+// @Router      /webhooks/  [put]
+func UpdateWebhook(c *gin.Context) {
+	if !IsAdmin(c) {
+		httputils.AbortWithError(c, errAdminRequired)
+		return
+	}
+
+	var requestBody RequestBodyWebhookUpdate
+	err := c.ShouldBindJSON(&requestBody)
+	if err != nil {
+		httputils.AbortWithError(c, apierror.FromBind(err))
+		return
+	}
+
+	if message := requestBody.validate(); len(message) > 0 {
+		httputils.AbortWithError(c, apierror.New(apierror.ValidationFailed, message))
+		return
+	}
+
+	existing, err := storage.Webhooks().GetWebhookById(c.Request.Context(), requestBody.Id)
+	if err != nil {
+		httputils.AbortWithError(c, webhookError(err, "Error while reading webhook!"))
+		return
+	}
+
+	subscription := requestBody.toSubscription()
+	subscription.Id = existing.Id
+	if len(requestBody.Secret) == 0 {
+		// Keep the current secret unless a new one is given.
+		subscription.Secret = existing.Secret
+	}
+
+	if err := storage.Webhooks().UpdateWebhookById(c.Request.Context(), subscription); err != nil {
+		httputils.AbortWithError(c, webhookError(err, "Could not update webhook!"))
+		return
+	}
+
+	updated, err := storage.Webhooks().GetWebhookById(c.Request.Context(), subscription.Id)
+	if err != nil {
+		httputils.AbortWithError(c, webhookError(err, "Error while reading webhook!"))
+		return
+	}
+
+	httputils.ResponseJson(c, http.StatusOK, "", webhookResponse(*updated))
+	return
+}
+
+// DeleteWebhook godoc
+// @Summary     Deletes a webhook subscription
+// @Description Deletes a subscription, deliveries still queued for it end up in the dead-letter list
+// @Tags        webhooks
+// @Accept      json
+// @Produce     json
+// @Param       body    body    WebhookIdStruct true    "Request Body"
+// @Success     200 {object}    []WebhookResponse
+// @Failure     400 {object}    nil
+// @Failure     401 {object}    nil
+// @Failure     403 {object}    nil
+// @Failure     404 {object}    nil
+// @Failure     500 {object}    nil
+// @Router      /webhooks/  [delete]
+func DeleteWebhook(c *gin.Context) {
+	if !IsAdmin(c) {
+		httputils.AbortWithError(c, errAdminRequired)
+		return
+	}
+
+	var body WebhookIdStruct
+	err := c.ShouldBindJSON(&body)
+	if err != nil {
+		httputils.AbortWithError(c, apierror.FromBind(err))
+		return
+	}
+
+	if err := storage.Webhooks().DeleteWebhookById(c.Request.Context(), body.Id); err != nil {
+		httputils.AbortWithError(c, webhookError(err, "Error while deleting webhook!"))
+		return
+	}
+
+	subscriptions, err := storage.Webhooks().GetWebhooks(c.Request.Context())
+	if err != nil {
+		httputils.AbortWithError(c, apierror.Wrap(apierror.Internal, "Error while reading webhooks!", err))
+		return
+	}
+
+	httputils.ResponseJson(c, http.StatusOK, "Webhook deleted successfully!", webhookResponses(subscriptions))
+	return
+}
+
+// GetDeadWebhookDeliveries godoc
+// @Summary     Retrieves the webhook dead-letter list
+// @Description Gets deliveries that failed on every attempt
+// @Tags        webhooks
+// @Accept      json
+// @Produce     json
+// @Success     200 {object}    []database.WebhookDelivery
+// @Failure     401 {object}    nil
+// @Failure     403 {object}    nil
+// @Failure     500 {object}    nil
+// @Router      /webhooks/deliveries/dead   [get]
+func GetDeadWebhookDeliveries(c *gin.Context) {
+	if !IsAdmin(c) {
+		httputils.AbortWithError(c, errAdminRequired)
+		return
+	}
+
+	deliveries, err := storage.Webhooks().GetWebhookDeliveries(c.Request.Context(), database.WebhookDeliveryDead, deadDeliveriesLimit)
+	if err != nil {
+		httputils.AbortWithError(c, apierror.Wrap(apierror.Internal, "Error while reading webhook deliveries!", err))
+		return
+	}
+
+	httputils.ResponseJson(c, http.StatusOK, "", deliveries)
+	return
+}
+
+// RedeliverWebhook godoc
+// @Summary     Redelivers a dead webhook delivery
+// @Description Queues a delivery from the dead-letter list again with a fresh set of attempts
+// @Tags        webhooks
+// @Accept      json
+// @Produce     json
+// @Param       id  path    string  true    "Delivery ID"
+// @Success     200 {object}    []database.WebhookDelivery
+// @Failure     401 {object}    nil
+// @Failure     403 {object}    nil
+// @Failure     404 {object}    nil
+// @Failure     500 {object}    nil
+// @Router      /webhooks/deliveries/:id/redeliver  [post]
+func RedeliverWebhook(c *gin.Context) {
+	if !IsAdmin(c) {
+		httputils.AbortWithError(c, errAdminRequired)
+		return
+	}
+
+	delivery, err := storage.Webhooks().GetWebhookDeliveryById(c.Request.Context(), c.Param("id"))
+	if errors.Is(err, storage.ErrNotFound) {
+		httputils.AbortWithError(c, apierror.Wrap(apierror.NotFound, "Webhook delivery not found!", err))
+		return
+	}
+	if err != nil {
+		httputils.AbortWithError(c, apierror.Wrap(apierror.Internal, "Error while reading webhook delivery!", err))
+		return
+	}
+
+	_, err = storage.Webhooks().GetWebhookById(c.Request.Context(), delivery.SubscriptionId)
+	if errors.Is(err, storage.ErrNotFound) {
+		httputils.AbortWithError(c, apierror.Wrap(apierror.NotFound, "Webhook no longer exists!", err))
+		return
+	}
+	if err != nil {
+		httputils.AbortWithError(c, apierror.Wrap(apierror.Internal, "Error while reading webhook!", err))
+		return
+	}
+
+	if err := webhooks.Redeliver(c.Request.Context(), *delivery); err != nil {
+		httputils.AbortWithError(c, apierror.Wrap(apierror.Internal, "Could not queue webhook delivery!", err))
+		return
+	}
+
+	deliveries, err := storage.Webhooks().GetWebhookDeliveries(c.Request.Context(), database.WebhookDeliveryDead, deadDeliveriesLimit)
+	if err != nil {
+		httputils.AbortWithError(c, apierror.Wrap(apierror.Internal, "Error while reading webhook deliveries!", err))
+		return
+	}
+
+	httputils.ResponseJson(c, http.StatusOK, "Webhook queued for delivery!", deliveries)
+	return
+}
@@ -0,0 +1,353 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/startstop"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
+	"github.com/robfig/cron/v3"
+	"github.com/spf13/viper"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/database"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/gopher"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/logging"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/storage"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/tracing"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/webhooks"
+)
+
+const TriggerSchedule = "schedule"
+const TriggerManual = "manual"
+
+const defaultConcurrency = 5
+const tickInterval = time.Minute
+
+// Start evaluates all enabled schedules once a minute until ctx is cancelled.
+func Start(ctx context.Context) {
+	ticker := time.NewTicker(tickInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			tick(logging.Job(ctx, "scheduler"), now)
+		}
+	}
+}
+
+func tick(ctx context.Context, now time.Time) {
+	ctx, span := tracing.Start(ctx, "scheduler.tick")
+	defer span.End()
+
+	schedules, err := storage.Schedules().GetSchedules(ctx)
+	if err != nil {
+		logging.Error(ctx, "could not read schedules", err)
+		return
+	}
+
+	for _, schedule := range schedules {
+		if !schedule.Enabled {
+			continue
+		}
+
+		from := schedule.LastRun
+		if from.IsZero() || now.Sub(from) > 24*time.Hour {
+			// Never catch up on more than a day of missed actions.
+			from = now.Add(-tickInterval)
+		}
+
+		action, err := DueAction(*schedule, from, now)
+		if err != nil {
+			logging.Error(ctx, "could not evaluate schedule", err, "schedule_id", schedule.Id)
+			continue
+		}
+
+		// Every instance ticks, the one that moves last_run runs the action.
+		claimed, err := storage.Schedules().ClaimScheduleRun(ctx, schedule.Id, schedule.LastRun, now)
+		if err != nil {
+			logging.Error(ctx, "could not claim schedule run", err, "schedule_id", schedule.Id)
+			continue
+		}
+		if !claimed {
+			continue
+		}
+
+		if len(action) > 0 {
+			go Run(ctx, *schedule, action, TriggerSchedule)
+		}
+	}
+}
+
+// Validate checks that the schedule has a known target, a valid time zone and
+// at least one parsable cron expression or calendar window.
+func Validate(schedule database.Schedule) error {
+	switch schedule.TargetType {
+	case database.ScheduleTargetVm, database.ScheduleTargetUser, database.ScheduleTargetCourse:
+	default:
+		return fmt.Errorf("unknown target type %q", schedule.TargetType)
+	}
+
+	if len(schedule.TargetId) == 0 {
+		return errors.New("missing target id")
+	}
+
+	loc, err := time.LoadLocation(schedule.TimeZone)
+	if err != nil {
+		return fmt.Errorf("unknown time zone %q", schedule.TimeZone)
+	}
+
+	if len(schedule.StartCron) == 0 && len(schedule.StopCron) == 0 && len(schedule.Windows) == 0 {
+		return errors.New("schedule needs a cron expression or a calendar window")
+	}
+
+	for _, expr := range []string{schedule.StartCron, schedule.StopCron} {
+		if len(expr) == 0 {
+			continue
+		}
+		if _, err := cron.ParseStandard(expr); err != nil {
+			return fmt.Errorf("invalid cron expression %q", expr)
+		}
+	}
+
+	for _, window := range schedule.Windows {
+		start, end, err := parseWindow(window, loc)
+		if err != nil {
+			return err
+		}
+		if !end.After(start) {
+			return fmt.Errorf("window %s - %s ends before it starts", window.Start, window.End)
+		}
+	}
+
+	return nil
+}
+
+// DueAction returns the action that became due in the interval (from, now],
+// or an empty string if nothing happened. When both a start and a stop fall
+// into the same interval, the latest one wins.
+func DueAction(schedule database.Schedule, from time.Time, now time.Time) (string, error) {
+	loc, err := time.LoadLocation(schedule.TimeZone)
+	if err != nil {
+		return "", err
+	}
+
+	from = from.In(loc)
+	now = now.In(loc)
+
+	action := ""
+	var actionTime time.Time
+
+	due := func(a string, at time.Time) {
+		if at.After(from) && !at.After(now) && !at.Before(actionTime) {
+			action = a
+			actionTime = at
+		}
+	}
+
+	for a, expr := range map[string]string{
+		database.ScheduleActionStart: schedule.StartCron,
+		database.ScheduleActionStop:  schedule.StopCron,
+	} {
+		if len(expr) == 0 {
+			continue
+		}
+
+		s, err := cron.ParseStandard(expr)
+		if err != nil {
+			return "", err
+		}
+
+		// Next returns the first activation strictly after from, walk forward
+		// so the latest activation inside the interval is used.
+		for at := s.Next(from); !at.IsZero() && !at.After(now); at = s.Next(at) {
+			due(a, at)
+		}
+	}
+
+	for _, window := range schedule.Windows {
+		start, end, err := parseWindow(window, loc)
+		if err != nil {
+			return "", err
+		}
+
+		due(database.ScheduleActionStart, start)
+		due(database.ScheduleActionStop, end)
+	}
+
+	return action, nil
+}
+
+func parseWindow(window database.ScheduleWindow, loc *time.Location) (time.Time, time.Time, error) {
+	start, err := time.ParseInLocation(database.ScheduleWindowLayout, window.Start, loc)
+	if err != nil {
+		return start, start, fmt.Errorf("invalid window start %q", window.Start)
+	}
+
+	end, err := time.ParseInLocation(database.ScheduleWindowLayout, window.End, loc)
+	if err != nil {
+		return start, end, fmt.Errorf("invalid window end %q", window.End)
+	}
+
+	return start, end, nil
+}
+
+// Targets resolves the virtual machines a schedule points at.
+func Targets(ctx context.Context, schedule database.Schedule) []database.VirtualMachine {
+	switch schedule.TargetType {
+	case database.ScheduleTargetVm:
+		vm, err := storage.Vms().GetVMById(ctx, schedule.TargetId)
+		if err != nil {
+			return nil
+		}
+		return []database.VirtualMachine{vm}
+	case database.ScheduleTargetUser:
+		vms, _ := storage.Vms().GetVMByUserId(ctx, schedule.TargetId)
+		return vms
+	case database.ScheduleTargetCourse:
+		vms, _ := storage.Vms().GetVMByCourseCode(ctx, schedule.TargetId)
+		return vms
+	}
+
+	return nil
+}
+
+// Run issues the power action against every target of the schedule, at most
+// IKT_STACK_SCHEDULER_CONCURRENCY at a time, and stores a report of the run.
+func Run(ctx context.Context, schedule database.Schedule, action string, trigger string) database.ScheduleRun {
+	run := database.ScheduleRun{
+		ScheduleId: schedule.Id,
+		Action:     action,
+		Trigger:    trigger,
+		Started:    time.Now(),
+		Results:    []database.ScheduleRunResult{},
+	}
+
+	concurrency := viper.GetInt("IKT_STACK_SCHEDULER_CONCURRENCY")
+	if concurrency <= 0 {
+		concurrency = defaultConcurrency
+	}
+
+	// The virtual machines may run in different OpenStack targets, each gets the client of its own.
+	clients := map[string]*gophercloud.ServiceClient{}
+	clientErrors := map[string]error{}
+
+	targets := Targets(ctx, schedule)
+	results := make([]database.ScheduleRunResult, len(targets))
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, concurrency)
+
+	for i, vm := range targets {
+		client, ok := clients[vm.Target]
+		err := clientErrors[vm.Target]
+		if !ok && err == nil {
+			client, err = gopher.Compute(ctx, vm.Target)
+			if err != nil {
+				logging.Error(ctx, "could not connect to openstack", err, "schedule_id", schedule.Id, "target", vm.Target)
+				clientErrors[vm.Target] = err
+			} else {
+				clients[vm.Target] = client
+			}
+		}
+
+		// Without a client the virtual machine fails with the reason, the run is still reported.
+		if err != nil {
+			results[i] = database.ScheduleRunResult{ServerId: vm.ServerId, ServerName: vm.ServerName, Result: database.ScheduleResultFailed, Error: err.Error()}
+			continue
+		}
+
+		wg.Add(1)
+		sem <- struct{}{}
+
+		go func(i int, vm database.VirtualMachine, client *gophercloud.ServiceClient) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			results[i] = powerAction(ctx, client, vm, action)
+		}(i, vm, client)
+	}
+
+	wg.Wait()
+
+	for _, result := range results {
+		switch result.Result {
+		case database.ScheduleResultOk:
+			run.Succeeded++
+		case database.ScheduleResultSkipped:
+			run.Skipped++
+		default:
+			run.Failed++
+		}
+	}
+
+	run.Results = append(run.Results, results...)
+	run.Finished = time.Now()
+
+	if err := storage.Schedules().InsertScheduleRun(ctx, run); err != nil {
+		logging.Error(ctx, "could not store schedule run", err, "schedule_id", schedule.Id)
+	}
+
+	return run
+}
+
+func powerAction(ctx context.Context, client *gophercloud.ServiceClient, vm database.VirtualMachine, action string) database.ScheduleRunResult {
+	result := database.ScheduleRunResult{
+		ServerId:   vm.ServerId,
+		ServerName: vm.ServerName,
+		Result:     database.ScheduleResultFailed,
+	}
+
+	status := database.VirtualMachineStatusActive
+	if action == database.ScheduleActionStop {
+		status = database.VirtualMachineStatusInactive
+	}
+
+	server, err := servers.Get(client, vm.ServerId).Extract()
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	if server.Status == status {
+		result.Result = database.ScheduleResultSkipped
+		return result
+	}
+
+	if action == database.ScheduleActionStop {
+		err = startstop.Stop(client, vm.ServerId).ExtractErr()
+	} else {
+		err = startstop.Start(client, vm.ServerId).ExtractErr()
+	}
+
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	if err := servers.WaitForStatus(client, vm.ServerId, status, database.ServerStatusPollingTime); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	if err := storage.Vms().UpdateVMStatusById(ctx, vm.ServerId, status); err != nil {
+		result.Error = "could not update virtual machine status: " + err.Error()
+		return result
+	}
+
+	webhooks.Publish(ctx, webhooks.EventVmStatusChanged, webhooks.VmPayload{
+		ServerId:       vm.ServerId,
+		ServerName:     vm.ServerName,
+		ServerIp:       vm.ServerIp,
+		ServerStatus:   status,
+		PreviousStatus: server.Status,
+	})
+
+	result.Result = database.ScheduleResultOk
+	return result
+}
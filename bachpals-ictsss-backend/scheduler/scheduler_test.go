@@ -0,0 +1,101 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/database"
+)
+
+func TestValidate(t *testing.T) {
+	valid := database.Schedule{TargetType: database.ScheduleTargetVm, TargetId: "server", TimeZone: "Europe/Oslo", StartCron: "0 8 * * 1-5"}
+
+	tests := []struct {
+		name    string
+		change  func(s *database.Schedule)
+		wantErr bool
+	}{
+		{"cron expressions", func(s *database.Schedule) { s.StopCron = "0 18 * * *" }, false},
+		{"window only", func(s *database.Schedule) {
+			s.StartCron = ""
+			s.Windows = []database.ScheduleWindow{{Start: "2022-03-02T10:00", End: "2022-03-02T12:00"}}
+		}, false},
+		{"utc without a time zone", func(s *database.Schedule) { s.TimeZone = "" }, false},
+		{"unknown target type", func(s *database.Schedule) { s.TargetType = "group" }, true},
+		{"missing target id", func(s *database.Schedule) { s.TargetId = "" }, true},
+		{"unknown time zone", func(s *database.Schedule) { s.TimeZone = "Mars/Olympus" }, true},
+		{"no cron expression or window", func(s *database.Schedule) { s.StartCron = "" }, true},
+		{"invalid cron expression", func(s *database.Schedule) { s.StopCron = "61 * * * *" }, true},
+		{"window in another layout", func(s *database.Schedule) {
+			s.Windows = []database.ScheduleWindow{{Start: "2022-03-02 10:00", End: "2022-03-02T12:00"}}
+		}, true},
+		{"window ending before it starts", func(s *database.Schedule) {
+			s.Windows = []database.ScheduleWindow{{Start: "2022-03-02T12:00", End: "2022-03-02T10:00"}}
+		}, true},
+		{"empty window", func(s *database.Schedule) {
+			s.Windows = []database.ScheduleWindow{{Start: "2022-03-02T12:00", End: "2022-03-02T12:00"}}
+		}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule := valid
+			tt.change(&schedule)
+			if err := Validate(schedule); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDueAction(t *testing.T) {
+	oslo, err := time.LoadLocation("Europe/Oslo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := func(day int, hour int, min int) time.Time {
+		return time.Date(2022, 3, day, hour, min, 0, 0, oslo)
+	}
+
+	weekdays := database.Schedule{TimeZone: "Europe/Oslo", StartCron: "0 8 * * 1-5", StopCron: "0 18 * * *"}
+	window := database.Schedule{TimeZone: "Europe/Oslo", Windows: []database.ScheduleWindow{{Start: "2022-03-02T10:00", End: "2022-03-02T12:00"}}}
+
+	tests := []struct {
+		name     string
+		schedule database.Schedule
+		from     time.Time
+		now      time.Time
+		want     string
+	}{
+		{"start on a weekday", weekdays, at(1, 7, 59), at(1, 8, 0), database.ScheduleActionStart},
+		{"interval excludes from", weekdays, at(1, 8, 0), at(1, 8, 1), ""},
+		{"stop in the evening", weekdays, at(1, 17, 59), at(1, 18, 0), database.ScheduleActionStop},
+		{"no start on a saturday", weekdays, at(5, 7, 59), at(5, 8, 0), ""},
+		{"latest action wins", weekdays, at(1, 7, 0), at(1, 18, 30), database.ScheduleActionStop},
+		{"times in utc", weekdays, time.Date(2022, 3, 1, 6, 59, 0, 0, time.UTC), time.Date(2022, 3, 1, 7, 0, 0, 0, time.UTC), database.ScheduleActionStart},
+		{"local time after the switch to summer time", weekdays, at(28, 7, 59), at(28, 8, 0), database.ScheduleActionStart},
+		{"window starts", window, at(2, 9, 59), at(2, 10, 0), database.ScheduleActionStart},
+		{"window ends", window, at(2, 11, 59), at(2, 12, 0), database.ScheduleActionStop},
+		{"inside the window", window, at(2, 10, 30), at(2, 11, 30), ""},
+		{"window on another day", window, at(3, 9, 59), at(3, 10, 0), ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DueAction(tt.schedule, tt.from, tt.now)
+			if err != nil {
+				t.Fatalf("DueAction() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("DueAction() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDueActionUnknownTimeZone(t *testing.T) {
+	schedule := database.Schedule{TimeZone: "Mars/Olympus", StartCron: "0 8 * * *"}
+	if _, err := DueAction(schedule, time.Now().Add(-time.Hour), time.Now()); err == nil {
+		t.Error("DueAction() accepted an unknown time zone")
+	}
+}
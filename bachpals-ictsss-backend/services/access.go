@@ -0,0 +1,82 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/database"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/utils"
+)
+
+const defaultAccessHookTimeout = 120 * time.Second
+
+// AccessHook updates who can log in to a running virtual machine, the sssd
+// simple_allow_users list and sudoers, without rebuilding it.
+type AccessHook interface {
+	Push(ctx context.Context, vm database.VirtualMachine) error
+}
+
+// Access is the hook called after the members of a virtual machine change. It runs
+// IKT_STACK_ACCESS_HOOK_COMMAND, when that is empty the new members get access on the next respawn.
+var Access AccessHook = commandHook{}
+
+// ErrNoAccessHook is returned by Push when no hook is configured.
+var ErrNoAccessHook = errors.New("no access hook configured, the members get access when the virtual machine is respawned")
+
+// commandHook runs an executable with the rendered files, see misc/hooks/push_access.sh.
+type commandHook struct{}
+
+func (commandHook) Push(ctx context.Context, vm database.VirtualMachine) error {
+	command := viper.GetString("IKT_STACK_ACCESS_HOOK_COMMAND")
+	if len(command) == 0 {
+		return ErrNoAccessHook
+	}
+
+	timeout := defaultAccessHookTimeout
+	if seconds := viper.GetInt("IKT_STACK_ACCESS_HOOK_TIMEOUT"); seconds > 0 {
+		timeout = time.Duration(seconds) * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	dir, err := os.MkdirTemp("", "ictsss-access-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	users := strings.Join(MemberIds(vm), ",")
+	sssd, sudoers := utils.GenerateAccessConfig(users)
+
+	sssdPath := filepath.Join(dir, "sssd.conf")
+	sudoersPath := filepath.Join(dir, "sudoers")
+	if err := os.WriteFile(sssdPath, sssd, 0o600); err != nil {
+		return err
+	}
+	if err := os.WriteFile(sudoersPath, sudoers, 0o600); err != nil {
+		return err
+	}
+
+	cmd := exec.CommandContext(ctx, command)
+	cmd.Env = append(os.Environ(),
+		"ICTSSS_SERVER_ID="+vm.ServerId,
+		"ICTSSS_SERVER_NAME="+vm.ServerName,
+		"ICTSSS_SERVER_IP="+vm.ServerIp,
+		"ICTSSS_ALLOW_USERS="+utils.CleanUserNames(users),
+		"ICTSSS_SSSD_CONF="+sssdPath,
+		"ICTSSS_SUDOERS="+sudoersPath,
+	)
+
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	return nil
+}
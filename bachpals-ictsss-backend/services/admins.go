@@ -0,0 +1,48 @@
+package services
+
+import (
+	"context"
+	"errors"
+
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/storage"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/webhooks"
+)
+
+// ErrLastAdmin keeps the service from ending up without administrators.
+var ErrLastAdmin = errors.New("last administrator")
+
+func AddAdmin(ctx context.Context, userId string, name string) error {
+	if len(userId) == 0 {
+		return fail("Missing user id!", nil)
+	}
+
+	if err := storage.Admins().InsertAdmin(ctx, userId, name); err != nil {
+		return fail("Something went wrong reading database!", err)
+	}
+
+	webhooks.Publish(ctx, webhooks.EventAdminCreated, webhooks.AdminPayload{UserId: userId, Name: name})
+	return nil
+}
+
+// RemoveAdmin deletes an administrator, it fails with ErrLastAdmin for the only one left.
+func RemoveAdmin(ctx context.Context, userId string) error {
+	admins, err := storage.Admins().ReadAdmins(ctx)
+	if err != nil {
+		return fail("Something went wrong reading database!", err)
+	}
+
+	if len(admins) == 1 {
+		return fail("Cannot delete last system administrator!", ErrLastAdmin)
+	}
+
+	deleted, err := storage.Admins().DeleteAdminById(ctx, userId)
+	if err != nil {
+		return fail("Something went wrong reading database!", err)
+	}
+	if deleted == 0 {
+		return fail("Administrator not found!", storage.ErrNotFound)
+	}
+
+	webhooks.Publish(ctx, webhooks.EventAdminDeleted, webhooks.AdminPayload{UserId: userId})
+	return nil
+}
@@ -0,0 +1,395 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/floatingips"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/database"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/gopher"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/logging"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/storage"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/webhooks"
+)
+
+const AdoptCreated = "created"
+const AdoptRepaired = "repaired"
+const AdoptTagged = "tagged"
+const AdoptUnchanged = "unchanged"
+const AdoptFailed = "failed"
+
+type AdoptOptions struct {
+	// Repair updates existing records that differ from their server, and writes the
+	// owner and members of the record onto servers created before they were written.
+	Repair bool
+	// DryRun reports what would change without writing anything.
+	DryRun bool
+}
+
+type AdoptResult struct {
+	ServerId   string   `json:"server_id"`
+	ServerName string   `json:"server_name"`
+	Action     string   `json:"action"`
+	Changes    []string `json:"changes,omitempty"`
+	Error      string   `json:"error,omitempty"`
+}
+
+// serverFloatingIp returns the floating ip in the addresses of a server.
+func serverFloatingIp(server servers.Server) string {
+	for _, network := range server.Addresses {
+		addresses, _ := network.([]interface{})
+		for _, v := range addresses {
+			address, _ := v.(map[string]interface{})
+			if address["OS-EXT-IPS:type"] == "floating" {
+				ip, _ := address["addr"].(string)
+				return ip
+			}
+		}
+	}
+	return ""
+}
+
+// vmFromServer builds the record of a server from its metadata.
+func vmFromServer(server servers.Server) database.VirtualMachine {
+	vm := database.VirtualMachine{
+		ServerId:     server.ID,
+		ServerName:   server.Name,
+		ServerIp:     serverFloatingIp(server),
+		ServerImage:  server.Metadata[MetadataImageId],
+		ServerStatus: server.Status,
+		Created:      server.Created,
+		CourseCode:   server.Metadata[MetadataCourse],
+		Members:      membersFromMetadata(server.Metadata),
+	}
+	database.FillMembers(&vm)
+	return vm
+}
+
+// repair copies what the server knows better into the record and lists the changed fields.
+func repair(vm *database.VirtualMachine, server database.VirtualMachine, hasMembers bool) []string {
+	var changes []string
+	set := func(field string, stored *string, actual string) {
+		if len(actual) > 0 && *stored != actual {
+			*stored = actual
+			changes = append(changes, field)
+		}
+	}
+
+	set("server_name", &vm.ServerName, server.ServerName)
+	set("server_ip", &vm.ServerIp, server.ServerIp)
+	set("server_image", &vm.ServerImage, server.ServerImage)
+	set("server_status", &vm.ServerStatus, server.ServerStatus)
+
+	if hasMembers {
+		set("course_code", &vm.CourseCode, server.CourseCode)
+
+		same := len(vm.Members) == len(server.Members)
+		for i := 0; same && i < len(vm.Members); i++ {
+			same = vm.Members[i] == server.Members[i]
+		}
+		if !same {
+			vm.Members = server.Members
+			changes = append(changes, "members")
+		}
+	}
+
+	return changes
+}
+
+// retarget moves a record to the target its server was found in. Records without a
+// target belong to the first one.
+func retarget(vm *database.VirtualMachine, target string, first bool) []string {
+	if vm.Target == target || (len(vm.Target) == 0 && first) {
+		return nil
+	}
+	vm.Target = target
+	return []string{"target"}
+}
+
+// AdoptVms scans the project of every target for servers this service created, recognized
+// by their VM_IMAGE_ID metadata, and recreates the records missing from the database.
+func AdoptVms(ctx context.Context, options AdoptOptions) ([]AdoptResult, error) {
+	targets, err := gopher.Targets()
+	if err != nil {
+		return nil, failOpenStack("Could not connect to OpenStack!", err)
+	}
+
+	results := []AdoptResult{}
+	for i, v := range targets {
+		adopted, err := adoptVms(ctx, v.Name, i == 0, options)
+		results = append(results, adopted...)
+		if err != nil {
+			return results, err
+		}
+	}
+
+	return results, nil
+}
+
+func adoptVms(ctx context.Context, target string, first bool, options AdoptOptions) ([]AdoptResult, error) {
+	client, err := gopher.Compute(ctx, target)
+	if err != nil {
+		return nil, failOpenStack("Could not connect to OpenStack!", err)
+	}
+
+	allPages, err := servers.List(client, servers.ListOpts{}).AllPages()
+	if err != nil {
+		return nil, failOpenStack("Could not read servers!", err)
+	}
+
+	allServers, err := servers.ExtractServers(allPages)
+	if err != nil {
+		return nil, failOpenStack("Could not extract information from server!", err)
+	}
+
+	results := []AdoptResult{}
+	for _, v := range allServers {
+		if len(v.Metadata[MetadataImageId]) == 0 {
+			continue
+		}
+
+		server := vmFromServer(v)
+		server.Target = target
+		result := AdoptResult{ServerId: v.ID, ServerName: v.Name, Action: AdoptUnchanged}
+
+		stored, err := storage.Vms().GetVMById(ctx, v.ID)
+		add, err := missing(err)
+		if err != nil {
+			return results, fail("Error reading database!", err)
+		}
+
+		switch {
+		case add:
+			result.Action = AdoptCreated
+			if !options.DryRun {
+				err = storage.Vms().InsertVm(ctx, server)
+			}
+
+		case options.Repair && server.Members == nil && len(stored.Members) > 0:
+			// The server predates owner metadata, the database is the only place that knows the members.
+			result.Action = AdoptTagged
+			result.Changes = append(repair(&stored, server, false), retarget(&stored, target, first)...)
+			if !options.DryRun {
+				err = writeMembershipMetadata(client, stored)
+				if err == nil && len(result.Changes) > 0 {
+					err = storage.Vms().UpdateVm(ctx, stored)
+				}
+			}
+
+		case options.Repair:
+			result.Changes = append(repair(&stored, server, server.Members != nil), retarget(&stored, target, first)...)
+			if len(result.Changes) > 0 {
+				result.Action = AdoptRepaired
+				if !options.DryRun {
+					err = storage.Vms().UpdateVm(ctx, stored)
+				}
+			}
+		}
+
+		if err != nil {
+			result.Action = AdoptFailed
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+// ErrAlreadyTracked is returned when adopting a server that already has a record.
+var ErrAlreadyTracked = errors.New("already tracked")
+
+// UntrackedServer is a server in the project without a virtual machine record,
+// usually one created by hand in Horizon.
+type UntrackedServer struct {
+	ServerId   string            `json:"server_id"`
+	ServerName string            `json:"server_name"`
+	Status     string            `json:"status"`
+	ServerIp   string            `json:"server_ip"`
+	ImageId    string            `json:"image_id"`
+	Created    time.Time         `json:"created"`
+	Metadata   map[string]string `json:"metadata"`
+	Target     string            `json:"target"`
+}
+
+// AdoptRequest brings an untracked server under management, the first member is the owner.
+type AdoptRequest struct {
+	ServerId   string
+	ImageId    string
+	CourseCode string
+	Members    []database.VirtualMachineMember
+	AdoptedBy  string
+	// Target is where the server runs, the first target when it is empty.
+	Target string
+	// AssignFloatingIp allocates a floating ip for servers that have none.
+	AssignFloatingIp bool
+}
+
+// serverImageId returns the image a server was booted from. Servers booted from a
+// volume have no image, their VM_IMAGE_ID metadata is used when present.
+func serverImageId(server servers.Server) string {
+	if id, ok := server.Image["id"].(string); ok && len(id) > 0 {
+		return id
+	}
+	return server.Metadata[MetadataImageId]
+}
+
+// UntrackedServers lists the servers of every target that have no record.
+func UntrackedServers(ctx context.Context) ([]UntrackedServer, error) {
+	targets, err := gopher.Targets()
+	if err != nil {
+		return nil, failOpenStack("Could not connect to OpenStack!", err)
+	}
+
+	untracked := []UntrackedServer{}
+	for _, v := range targets {
+		list, err := untrackedServers(ctx, v.Name)
+		if err != nil {
+			return nil, err
+		}
+		untracked = append(untracked, list...)
+	}
+
+	return untracked, nil
+}
+
+func untrackedServers(ctx context.Context, target string) ([]UntrackedServer, error) {
+	client, err := gopher.Compute(ctx, target)
+	if err != nil {
+		return nil, failOpenStack("Could not connect to OpenStack!", err)
+	}
+
+	allPages, err := servers.List(client, servers.ListOpts{}).AllPages()
+	if err != nil {
+		return nil, failOpenStack("Could not read servers!", err)
+	}
+
+	allServers, err := servers.ExtractServers(allPages)
+	if err != nil {
+		return nil, failOpenStack("Could not extract information from server!", err)
+	}
+
+	untracked := []UntrackedServer{}
+	for _, v := range allServers {
+		_, err := storage.Vms().GetVMById(ctx, v.ID)
+		add, err := missing(err)
+		if err != nil {
+			return nil, fail("Error reading database!", err)
+		}
+		if !add {
+			continue
+		}
+
+		untracked = append(untracked, UntrackedServer{
+			ServerId:   v.ID,
+			ServerName: v.Name,
+			Status:     v.Status,
+			ServerIp:   serverFloatingIp(v),
+			ImageId:    serverImageId(v),
+			Created:    v.Created,
+			Metadata:   v.Metadata,
+			Target:     target,
+		})
+	}
+
+	return untracked, nil
+}
+
+// AdoptServer stores a record for an untracked server and writes the membership
+// metadata onto it, from then on it is managed like an ordered virtual machine.
+func AdoptServer(ctx context.Context, request AdoptRequest) (database.VirtualMachine, error) {
+	if len(request.Members) == 0 {
+		return database.VirtualMachine{}, fail("Missing owner!", nil)
+	}
+	if len(request.ImageId) == 0 {
+		return database.VirtualMachine{}, fail("Missing server image id!", nil)
+	}
+
+	if _, err := storage.Vms().GetVMById(ctx, request.ServerId); err == nil {
+		return database.VirtualMachine{}, fail("Virtual machine is already tracked!", ErrAlreadyTracked)
+	} else if !errors.Is(err, storage.ErrNotFound) {
+		return database.VirtualMachine{}, fail("Error reading database!", err)
+	}
+
+	if _, err := storage.Images().GetImageByImageId(ctx, request.ImageId); errors.Is(err, storage.ErrNotFound) {
+		return database.VirtualMachine{}, fail("Image not found!", err)
+	} else if err != nil {
+		return database.VirtualMachine{}, fail("Error while reading images!", err)
+	}
+
+	target, err := gopher.TargetByName(request.Target)
+	if err != nil {
+		return database.VirtualMachine{}, fail("Unknown OpenStack target!", err)
+	}
+
+	client, err := gopher.Compute(ctx, target.Name)
+	if err != nil {
+		return database.VirtualMachine{}, failOpenStack("Could not connect to OpenStack!", err)
+	}
+
+	server, err := servers.Get(client, request.ServerId).Extract()
+	if _, ok := err.(gophercloud.ErrDefault404); ok {
+		return database.VirtualMachine{}, fail("Server not found!", storage.ErrNotFound)
+	}
+	if err != nil {
+		return database.VirtualMachine{}, failOpenStack("Error reading virtual machine status!", err)
+	}
+
+	vm := database.VirtualMachine{
+		ServerId:     server.ID,
+		ServerName:   server.Name,
+		ServerIp:     serverFloatingIp(*server),
+		ServerImage:  request.ImageId,
+		ServerStatus: server.Status,
+		Created:      server.Created,
+		CourseCode:   request.CourseCode,
+		Target:       target.Name,
+		Members:      request.Members,
+	}
+
+	if len(vm.ServerIp) == 0 && request.AssignFloatingIp {
+		fip, err := allocateFloatingIp(ctx, client, target, server.ID)
+		if err != nil {
+			return vm, failOpenStack("Error while creating floating ip for virtual machine!", err)
+		}
+
+		if err := floatingips.AssociateInstance(client, server.ID, floatingips.AssociateOpts{FloatingIP: fip.IP}).ExtractErr(); err != nil {
+			if _, releaseErr := releaseFloatingIp(ctx, client, target.Name, *fip); releaseErr != nil {
+				logging.Error(ctx, "could not release floating ip", releaseErr, "floating_ip", fip.IP)
+			}
+			return vm, failOpenStack("Error while assigning floating ip to virtual machine!", err)
+		}
+		vm.ServerIp = fip.IP
+	}
+
+	if err := writeMembershipMetadata(client, vm); err != nil {
+		return vm, failOpenStack("Error while writing server metadata!", err)
+	}
+
+	metadata := map[string]string{MetadataImageId: request.ImageId, MetadataTarget: target.Name}
+	if len(request.AdoptedBy) > 0 {
+		metadata[MetadataCreatedBy] = request.AdoptedBy
+	}
+	if err := servers.UpdateMetadata(client, server.ID, servers.MetadataOpts(metadata)).Err; err != nil {
+		return vm, failOpenStack("Error while writing server metadata!", err)
+	}
+
+	if err := storage.Vms().InsertVm(ctx, vm); err != nil {
+		return vm, fail("Unable to save virtual machine!", err)
+	}
+
+	database.FillMembers(&vm)
+	webhooks.Publish(ctx, webhooks.EventVmCreated, webhooks.VmPayload{
+		ServerId:    vm.ServerId,
+		ServerName:  vm.ServerName,
+		ServerIp:    vm.ServerIp,
+		ServerImage: vm.ServerImage,
+		CourseCode:  vm.CourseCode,
+		Users:       MemberIds(vm),
+	})
+
+	return vm, nil
+}
@@ -0,0 +1,479 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/database"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/storage"
+)
+
+// ArchiveFormat identifies an export of this service, ArchiveVersion is increased
+// whenever a field changes meaning. Archives without a format are the unversioned
+// backups written before, they are read as version 0.
+const ArchiveFormat = "ictsss-archive"
+const ArchiveVersion = 1
+
+// templateDirs are the template directories included in an archive, by the name used in it.
+var templateDirs = map[string]string{
+	"userdata":      "IKT_STACK_TEMPLATES_USERDATA_DIR",
+	"configs":       "IKT_STACK_TEMPLATES_CONFIGS_DIR",
+	"notifications": "IKT_STACK_TEMPLATES_NOTIFICATIONS_DIR",
+}
+
+// Archive is everything needed to rebuild the service, it is written by export and read by import.
+type Archive struct {
+	Format   string                    `json:"format"`
+	Version  int                       `json:"version"`
+	Exported time.Time                 `json:"exported"`
+	Source   ArchiveSource             `json:"source"`
+	Admins   []*database.Admin         `json:"admins"`
+	Images   []*database.Images        `json:"images"`
+	Vms      []database.VirtualMachine `json:"vms"`
+	// Templates are the files user data, sssd configs and emails are rendered from.
+	Templates []ArchiveTemplate `json:"templates"`
+	Settings  ArchiveSettings   `json:"settings"`
+}
+
+// ArchiveSource describes where an archive was exported from.
+type ArchiveSource struct {
+	StorageBackend string `json:"storage_backend"`
+	ApiVersion     string `json:"api_version"`
+}
+
+type ArchiveTemplate struct {
+	Dir     string `json:"dir"`
+	Name    string `json:"name"`
+	Content string `json:"content"`
+}
+
+// ArchiveSettings holds the configuration made through the api. Webhooks include their secrets.
+type ArchiveSettings struct {
+	Initialized bool                            `json:"initialized"`
+	Schedules   []*database.Schedule            `json:"schedules"`
+	Webhooks    []*database.WebhookSubscription `json:"webhooks"`
+}
+
+// ImportOptions changes how an archive is imported.
+type ImportOptions struct {
+	// ImageIds replaces OpenStack image ids, for moving to a cloud where the images were uploaded again.
+	ImageIds map[string]string
+	// Rebuild adds records for servers in OpenStack that were created by this service but are in neither the database nor the archive, see AdoptVms.
+	Rebuild bool
+	// DryRun only validates the archive.
+	DryRun bool
+}
+
+type ImportResult struct {
+	Admins    int `json:"admins"`
+	Images    int `json:"images"`
+	Vms       int `json:"vms"`
+	Rebuilt   int `json:"rebuilt"`
+	Templates int `json:"templates"`
+	Schedules int `json:"schedules"`
+	Webhooks  int `json:"webhooks"`
+	Skipped   int `json:"skipped"`
+	// Ids maps the database ids in the archive to the ids the records got here.
+	Ids map[string]string `json:"ids"`
+}
+
+// ValidationError lists everything wrong with an archive.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return strings.Join(e.Problems, "; ")
+}
+
+func Export(ctx context.Context) (Archive, error) {
+	archive := Archive{
+		Format:   ArchiveFormat,
+		Version:  ArchiveVersion,
+		Exported: time.Now(),
+		Source: ArchiveSource{
+			StorageBackend: viper.GetString("IKT_STACK_STORAGE_BACKEND"),
+			ApiVersion:     viper.GetString("IKT_STACK_API_VERSION"),
+		},
+	}
+	if len(archive.Source.StorageBackend) == 0 {
+		archive.Source.StorageBackend = storage.DefaultBackend
+	}
+
+	var err error
+	if archive.Admins, err = storage.Admins().ReadAdmins(ctx); err != nil {
+		return archive, fail("Something went wrong reading database!", err)
+	}
+	if archive.Images, err = storage.Images().GetImages(ctx); err != nil {
+		return archive, fail("Error while reading images!", err)
+	}
+	if archive.Vms, err = storage.Vms().GetVMS(ctx); err != nil {
+		return archive, fail("Could not load virtual machines!", err)
+	}
+	if archive.Settings.Initialized, err = storage.AppState().IsInitialized(ctx); err != nil {
+		return archive, fail("Something went wrong reading database!", err)
+	}
+
+	if archive.Settings.Schedules, err = storage.Schedules().GetSchedules(ctx); err != nil {
+		return archive, fail("Error while reading schedules!", err)
+	}
+	if archive.Settings.Webhooks, err = storage.Webhooks().GetWebhooks(ctx); err != nil {
+		return archive, fail("Error while reading webhooks!", err)
+	}
+
+	for dir, key := range templateDirs {
+		templates, err := readTemplates(dir, viper.GetString(key))
+		if err != nil {
+			return archive, fail("Error while reading templates!", err)
+		}
+		archive.Templates = append(archive.Templates, templates...)
+	}
+
+	return archive, nil
+}
+
+func readTemplates(dir string, path string) ([]ArchiveTemplate, error) {
+	if len(path) == 0 {
+		return nil, nil
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var templates []ArchiveTemplate
+	for _, v := range entries {
+		if v.IsDir() {
+			continue
+		}
+
+		content, err := os.ReadFile(filepath.Join(path, v.Name()))
+		if err != nil {
+			return nil, err
+		}
+		templates = append(templates, ArchiveTemplate{Dir: dir, Name: v.Name(), Content: string(content)})
+	}
+
+	return templates, nil
+}
+
+// WriteArchive writes archive as indented json.
+func WriteArchive(w io.Writer, archive Archive) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(archive)
+}
+
+func ReadArchive(r io.Reader) (Archive, error) {
+	var archive Archive
+	if err := json.NewDecoder(r).Decode(&archive); err != nil {
+		return archive, fail("Could not read archive!", err)
+	}
+	return archive, nil
+}
+
+// Validate checks the archive can be imported without leaving half of it behind.
+func (archive Archive) Validate() error {
+	var problems []string
+	problem := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if len(archive.Format) > 0 && archive.Format != ArchiveFormat {
+		problem("format %q is not %q", archive.Format, ArchiveFormat)
+	}
+	if len(archive.Format) == 0 && archive.Version != 0 {
+		problem("version %d archive has no format", archive.Version)
+	}
+	if archive.Version > ArchiveVersion {
+		problem("version %d is newer than the supported version %d", archive.Version, ArchiveVersion)
+	}
+
+	seen := map[string]bool{}
+	unique := func(kind string, id string) {
+		if seen[kind+id] {
+			problem("%s %q is in the archive twice", kind, id)
+		}
+		seen[kind+id] = true
+	}
+
+	for i, v := range archive.Admins {
+		if v == nil || len(v.UserId) == 0 {
+			problem("admins[%d] has no user id", i)
+			continue
+		}
+		unique("administrator", v.UserId)
+	}
+
+	for i, v := range archive.Images {
+		if v == nil || len(v.ImageId) == 0 {
+			problem("images[%d] has no image id", i)
+			continue
+		}
+		unique("image", v.ImageId)
+	}
+
+	for i, v := range archive.Vms {
+		if len(v.ServerId) == 0 || len(v.ServerName) == 0 {
+			problem("vms[%d] needs a server id and name", i)
+			continue
+		}
+		unique("virtual machine", v.ServerId)
+
+		for _, m := range v.Members {
+			if len(m.UserId) == 0 || (m.Role != database.VirtualMachineRoleOwner && m.Role != database.VirtualMachineRoleMember) {
+				problem("virtual machine %q has an invalid member", v.ServerId)
+			}
+		}
+	}
+
+	for i, v := range archive.Templates {
+		if _, ok := templateDirs[v.Dir]; !ok {
+			problem("templates[%d] has unknown dir %q", i, v.Dir)
+		}
+		if len(v.Name) == 0 || v.Name != filepath.Base(v.Name) || strings.HasPrefix(v.Name, ".") {
+			problem("templates[%d] has invalid name %q", i, v.Name)
+		}
+	}
+
+	for i, v := range archive.Settings.Schedules {
+		if v == nil || len(v.Name) == 0 || len(v.TargetId) == 0 {
+			problem("settings.schedules[%d] needs a name and target", i)
+		}
+	}
+
+	for i, v := range archive.Settings.Webhooks {
+		if v == nil || len(v.Url) == 0 {
+			problem("settings.webhooks[%d] has no url", i)
+		}
+	}
+
+	if len(problems) > 0 {
+		return fail("Archive is invalid!", &ValidationError{Problems: problems})
+	}
+	return nil
+}
+
+// missing is true when lookup reports storage.ErrNotFound, any other error is returned.
+func missing(err error) (bool, error) {
+	if err == nil {
+		return false, nil
+	}
+	if errors.Is(err, storage.ErrNotFound) {
+		return true, nil
+	}
+	return false, err
+}
+
+// Import validates the archive and adds what is not stored yet, existing records,
+// templates and settings are left untouched.
+func Import(ctx context.Context, archive Archive, options ImportOptions) (ImportResult, error) {
+	result := ImportResult{Ids: map[string]string{}}
+
+	if err := archive.Validate(); err != nil {
+		return result, err
+	}
+	if options.DryRun {
+		return result, nil
+	}
+
+	imageId := func(id string) string {
+		if mapped, ok := options.ImageIds[id]; ok {
+			return mapped
+		}
+		return id
+	}
+
+	for _, v := range archive.Admins {
+		_, err := storage.Admins().ReadAdminById(ctx, v.UserId)
+		add, err := missing(err)
+		if err != nil {
+			return result, fail("Something went wrong reading database!", err)
+		}
+		if !add {
+			result.Skipped++
+			continue
+		}
+
+		if err := storage.Admins().InsertAdmin(ctx, v.UserId, v.Name); err != nil {
+			return result, fail("Something went wrong reading database!", err)
+		}
+		result.Admins++
+	}
+
+	for _, v := range archive.Images {
+		image := *v
+		image.ImageId = imageId(v.ImageId)
+
+		_, err := storage.Images().GetImageByImageId(ctx, image.ImageId)
+		add, err := missing(err)
+		if err != nil {
+			return result, fail("Error while reading images!", err)
+		}
+
+		if add {
+			if err := storage.Images().InsertImage(ctx, image); err != nil {
+				return result, fail("Error while inserting image!", err)
+			}
+			result.Images++
+		} else {
+			result.Skipped++
+		}
+
+		// Images get a new database id when inserted, report which one so links can be updated.
+		stored, err := storage.Images().GetImageByImageId(ctx, image.ImageId)
+		if err != nil {
+			return result, fail("Error while reading images!", err)
+		}
+		if len(v.Id) > 0 {
+			result.Ids[v.Id] = stored.Id
+		}
+	}
+
+	for _, v := range archive.Vms {
+		_, err := storage.Vms().GetVMById(ctx, v.ServerId)
+		add, err := missing(err)
+		if err != nil {
+			return result, fail("Error reading database!", err)
+		}
+		if !add {
+			result.Skipped++
+			continue
+		}
+
+		v.ServerImage = imageId(v.ServerImage)
+		if err := storage.Vms().InsertVm(ctx, v); err != nil {
+			return result, fail("Unable to save virtual machine!", err)
+		}
+		result.Vms++
+	}
+
+	if err := importTemplates(archive.Templates, &result); err != nil {
+		return result, err
+	}
+	if err := importSettings(ctx, archive.Settings, &result); err != nil {
+		return result, err
+	}
+
+	if options.Rebuild {
+		adopted, err := AdoptVms(ctx, AdoptOptions{})
+		for _, v := range adopted {
+			if v.Action == AdoptCreated {
+				result.Rebuilt++
+			}
+		}
+		if err != nil {
+			return result, err
+		}
+	}
+
+	return result, nil
+}
+
+// importTemplates writes the templates that do not exist yet, changed local templates are kept.
+func importTemplates(templates []ArchiveTemplate, result *ImportResult) error {
+	for _, v := range templates {
+		dir := viper.GetString(templateDirs[v.Dir])
+		if len(dir) == 0 {
+			result.Skipped++
+			continue
+		}
+
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return fail("Error while writing templates!", err)
+		}
+
+		f, err := os.OpenFile(filepath.Join(dir, v.Name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if errors.Is(err, os.ErrExist) {
+			result.Skipped++
+			continue
+		}
+		if err != nil {
+			return fail("Error while writing templates!", err)
+		}
+
+		_, err = f.WriteString(v.Content)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return fail("Error while writing templates!", err)
+		}
+		result.Templates++
+	}
+
+	return nil
+}
+
+// importSettings adds schedules and webhooks, a schedule matches an existing one with
+// the same name and target, a webhook one with the same url.
+func importSettings(ctx context.Context, settings ArchiveSettings, result *ImportResult) error {
+	if settings.Initialized {
+		if err := storage.AppState().SetInitialized(ctx); err != nil {
+			return fail("Failed updating initialization state!", err)
+		}
+	}
+
+	schedules, err := storage.Schedules().GetSchedules(ctx)
+	if err != nil {
+		return fail("Error while reading schedules!", err)
+	}
+
+	for _, v := range settings.Schedules {
+		exists := false
+		for _, s := range schedules {
+			if s.Name == v.Name && s.TargetType == v.TargetType && s.TargetId == v.TargetId {
+				exists = true
+				result.Ids[v.Id] = s.Id
+			}
+		}
+		if exists {
+			result.Skipped++
+			continue
+		}
+
+		id, err := storage.Schedules().InsertSchedule(ctx, *v)
+		if err != nil {
+			return fail("Error while inserting schedule!", err)
+		}
+		result.Ids[v.Id] = id
+		result.Schedules++
+	}
+
+	webhooks, err := storage.Webhooks().GetWebhooks(ctx)
+	if err != nil {
+		return fail("Error while reading webhooks!", err)
+	}
+
+	for _, v := range settings.Webhooks {
+		exists := false
+		for _, w := range webhooks {
+			if w.Url == v.Url {
+				exists = true
+				result.Ids[v.Id] = w.Id
+			}
+		}
+		if exists {
+			result.Skipped++
+			continue
+		}
+
+		id, err := storage.Webhooks().InsertWebhook(ctx, *v)
+		if err != nil {
+			return fail("Error while inserting webhook!", err)
+		}
+		result.Ids[v.Id] = id
+		result.Webhooks++
+	}
+
+	return nil
+}
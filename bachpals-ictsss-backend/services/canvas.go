@@ -0,0 +1,249 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/spf13/viper"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/database"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/logging"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/metrics"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/notify"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/storage"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// courseOrderConcurrency is how many virtual machines of a course are provisioned at the same time.
+const courseOrderConcurrency = 10
+
+// CourseOrder asks for one virtual machine per user in a Canvas course.
+type CourseOrder struct {
+	CourseCode     string
+	ServerName     string
+	ImageId        string
+	IncludeTeacher bool
+	IncludeTa      bool
+	// OrderedBy is told by email when a virtual machine could not be created.
+	OrderedBy string
+	// Trim leaves out the users the quota has no room for instead of refusing the order, see PlanCourse.
+	Trim bool
+	// Target is the OpenStack target every virtual machine of the order is created in, PlanCourse sets it.
+	Target string
+}
+
+// CoursePlan is a course order checked against the quota of its target.
+type CoursePlan struct {
+	Capacity Capacity
+	// UserIds are the users a virtual machine is created for, Skipped the ones that did not fit.
+	UserIds []string
+	Skipped []string
+}
+
+type CourseOrderResult struct {
+	UserId     string `json:"user_id"`
+	ServerName string `json:"server_name"`
+	ServerId   string `json:"server_id"`
+	ServerIp   string `json:"server_ip"`
+	Error      string `json:"error,omitempty"`
+}
+
+// canvasClient is shared by the Canvas calls, it is traced and counted in the metrics.
+var canvasClient = &http.Client{Transport: tracing.CanvasTransport(metrics.CanvasTransport(nil))}
+
+func newCanvasRequest(ctx context.Context, method string, path string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, viper.GetString("IKT_STACK_CANVAS_API_URL")+path, body)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", viper.GetString("IKT_STACK_CANVAS_API_KEY")))
+	return req, nil
+}
+
+// RequestCanvasApi calls the Canvas api and decodes the json response, an array when asArray is set.
+func RequestCanvasApi(ctx context.Context, method string, path string, body io.Reader, asArray bool) (result interface{}, err error) {
+	ctx, span := tracing.Start(ctx, "services.RequestCanvasApi", attribute.String("canvas.path", path))
+	defer func() { tracing.End(span, err) }()
+
+	req, err := newCanvasRequest(ctx, method, path, body)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, responseErr := canvasClient.Do(req)
+	if responseErr != nil {
+		return nil, responseErr
+	}
+
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if asArray {
+		if reflect.TypeOf(b).Kind() == reflect.Map {
+			var d map[string]interface{}
+			err = json.Unmarshal(b, &d)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		var d []interface{}
+		err = json.Unmarshal(b, &d)
+		if err != nil {
+			return nil, err
+		}
+
+		return d, nil
+	}
+
+	var d interface{}
+	err = json.Unmarshal(b, &d)
+	if err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+// CourseUsers validates the order and reads the login ids of the users it is for from Canvas.
+// Users who have not accepted the course invitation have no login id yet and are left out.
+func CourseUsers(ctx context.Context, order CourseOrder) ([]string, error) {
+	if len(order.ImageId) == 0 {
+		return nil, fail("Missing server image id!", nil)
+	}
+	if len(order.ServerName) == 0 {
+		return nil, fail("Missing server name!", nil)
+	}
+
+	if _, err := storage.Images().GetImageByImageId(ctx, order.ImageId); err != nil {
+		return nil, fail("Error while reading images!", err)
+	}
+
+	enrollments := []string{"student"}
+	if order.IncludeTeacher {
+		enrollments = append(enrollments, "teacher")
+	}
+	if order.IncludeTa {
+		enrollments = append(enrollments, "ta")
+	}
+
+	var userIds []string
+	for _, v := range enrollments {
+		response, err := RequestCanvasApi(ctx, "GET", fmt.Sprintf("/courses/%s/users?per_page=1000&enrollment_type=%s", order.CourseCode, v), nil, true)
+		if err != nil {
+			return nil, fail("Error reading canvas api", err)
+		}
+
+		for _, user := range response.([]interface{}) {
+			val, _ := user.(map[string]interface{})
+			if loginId, ok := val["login_id"].(string); ok {
+				userIds = append(userIds, loginId)
+			}
+		}
+	}
+
+	if len(userIds) == 0 {
+		return nil, fail("Error no users in response", nil)
+	}
+
+	return userIds, nil
+}
+
+// PlanCourse places a course order in one target and checks that its quota has room
+// for a virtual machine for every user. When it has not the order is refused with
+// ErrInsufficientCapacity, or with Trim the users that come last are left out, teachers
+// and teaching assistants before students. A target without room for any is always refused.
+func PlanCourse(ctx context.Context, order *CourseOrder, userIds []string) (CoursePlan, error) {
+	capacity, err := PlanOrder(ctx, order.CourseCode, order.ImageId, len(userIds))
+	plan, err := planUsers(userIds, capacity, order.Trim, err)
+	if err != nil {
+		return plan, err
+	}
+
+	order.Target = capacity.Target
+	if len(plan.Skipped) > 0 {
+		logging.Warn(ctx, "course order trimmed to the capacity of the target", "course_code", order.CourseCode, "target", capacity.Target,
+			"available", capacity.Available, "limited_by", strings.Join(capacity.LimitedBy, ","), "skipped_users", strings.Join(plan.Skipped, ","))
+	}
+	return plan, nil
+}
+
+// planUsers splits the users of a course over the capacity PlanOrder found, err is what
+// it returned. Only a lack of capacity is trimmed, and only when there is room for some.
+func planUsers(userIds []string, capacity Capacity, trim bool, err error) (CoursePlan, error) {
+	plan := CoursePlan{Capacity: capacity, UserIds: userIds}
+	if err == nil {
+		return plan, nil
+	}
+	if !trim || !errors.Is(err, ErrInsufficientCapacity) || capacity.Available <= 0 || capacity.Available >= len(userIds) {
+		return plan, err
+	}
+
+	plan.UserIds, plan.Skipped = userIds[:capacity.Available], userIds[capacity.Available:]
+	return plan, nil
+}
+
+// ProvisionCourse creates a virtual machine for each user and reports how every one of them went.
+func ProvisionCourse(ctx context.Context, order CourseOrder, userIds []string) []CourseOrderResult {
+	ctx, span := tracing.Start(ctx, "services.ProvisionCourse", attribute.String("course_code", order.CourseCode), attribute.Int("users", len(userIds)))
+	defer span.End()
+
+	logging.Info(ctx, "provisioning course", "course_code", order.CourseCode, "users", len(userIds))
+
+	results := make([]CourseOrderResult, len(userIds))
+	slots := make(chan struct{}, courseOrderConcurrency)
+
+	var wg sync.WaitGroup
+	for i, userId := range userIds {
+		wg.Add(1)
+		slots <- struct{}{}
+
+		go func(i int, userId string) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			serverName := strings.ToUpper(order.ServerName) + "-" + UserName(userId)
+			results[i] = CourseOrderResult{UserId: userId, ServerName: serverName}
+
+			// The user of the line is the one the virtual machine is for, the order keeps the administrator.
+			ctx := logging.With(ctx, "ordered_by", order.OrderedBy, logging.UserId, userId)
+			vm, err := Order(ctx, ProvisionRequest{
+				ServerName: serverName,
+				ImageId:    order.ImageId,
+				CourseCode: order.CourseCode,
+				Members:    database.MembersFromUserIds([]string{userId}),
+				CreatedBy:  order.OrderedBy,
+				Target:     order.Target,
+			})
+			if err != nil {
+				logging.Error(ctx, "could not provision virtual machine", err, "server_name", serverName)
+				results[i].Error = err.Error()
+
+				// The order has usually been answered long ago, let the administrator know by email.
+				if len(order.OrderedBy) > 0 {
+					notify.Notify(ctx, notify.EventVmProvisioningFailed, []string{order.OrderedBy}, notify.Data{ServerName: serverName, Reason: Message(err, err.Error())})
+				}
+				return
+			}
+
+			results[i].ServerId = vm.ServerId
+			results[i].ServerIp = vm.ServerIp
+		}(i, userId)
+	}
+
+	wg.Wait()
+	return results
+}
@@ -0,0 +1,44 @@
+package services
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestPlanUsers(t *testing.T) {
+	users := []string{"student1@student.uia.no", "student2@student.uia.no", "teacher@uia.no"}
+	short := fail("Only 2 of 3 virtual machines can be created!", ErrInsufficientCapacity)
+	other := failOpenStack("Could not read quotas!", errors.New("timeout"))
+
+	tests := []struct {
+		name        string
+		available   int
+		trim        bool
+		err         error
+		wantUsers   []string
+		wantSkipped []string
+		wantErr     error
+	}{
+		{"fits", 3, false, nil, users, nil, nil},
+		{"unlimited", Unlimited, true, nil, users, nil, nil},
+		{"short and refused", 2, false, short, users, nil, short},
+		{"short and trimmed", 2, true, short, users[:2], users[2:], nil},
+		{"room for one", 1, true, short, users[:1], users[1:], nil},
+		{"no room", 0, true, short, users, nil, short},
+		{"over quota", -2, true, short, users, nil, short},
+		{"other failures are not trimmed", 2, true, other, users, nil, other},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan, err := planUsers(users, Capacity{Available: tt.available}, tt.trim, tt.err)
+			if err != tt.wantErr {
+				t.Errorf("planUsers() error = %v, want %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(plan.UserIds, tt.wantUsers) || !reflect.DeepEqual(plan.Skipped, tt.wantSkipped) {
+				t.Errorf("planUsers() = %v skipping %v, want %v skipping %v", plan.UserIds, plan.Skipped, tt.wantUsers, tt.wantSkipped)
+			}
+		})
+	}
+}
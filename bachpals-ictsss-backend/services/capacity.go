@@ -0,0 +1,225 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	volumelimits "github.com/gophercloud/gophercloud/openstack/blockstorage/extensions/limits"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/limits"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/flavors"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/quotas"
+	"github.com/spf13/viper"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/gopher"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// The resources of a project a virtual machine takes from the quota.
+const (
+	ResourceInstances       = "instances"
+	ResourceCores           = "cores"
+	ResourceRam             = "ram_mb"
+	ResourceVolumes         = "volumes"
+	ResourceVolumeGigabytes = "volume_gigabytes"
+	ResourceFloatingIps     = "floating_ips"
+)
+
+// Unlimited is the limit, free amount and capacity of a resource without a quota.
+const Unlimited = -1
+
+// ErrInsufficientCapacity is returned when the quota of a target has room for fewer
+// virtual machines than were ordered.
+var ErrInsufficientCapacity = errors.New("insufficient capacity")
+
+// Quota is one resource of the project of a target. Nova and Cinder count what is in
+// use, Neutron also counts floating ips that are reserved but not created yet. Floating
+// ips kept for reuse are counted as free.
+type Quota struct {
+	Resource string `json:"resource"`
+	Limit    int    `json:"limit"`
+	Used     int    `json:"used"`
+	Free     int    `json:"free"`
+	// PerVm is how much of the resource one virtual machine of the image takes.
+	PerVm int `json:"per_vm"`
+}
+
+// fits is how many virtual machines the free amount of the resource has room for.
+func (q Quota) fits() int {
+	if q.Free == Unlimited || q.PerVm <= 0 {
+		return Unlimited
+	}
+	return q.Free / q.PerVm
+}
+
+func quota(resource string, limit int, used int, perVm int) Quota {
+	q := Quota{Resource: resource, Limit: limit, Used: used, Free: Unlimited, PerVm: perVm}
+	if limit >= 0 {
+		q.Free = limit - used
+		if q.Free < 0 {
+			q.Free = 0
+		}
+	}
+	return q
+}
+
+// Capacity is the quota of the project of a target and how many more virtual machines
+// of an image it has room for.
+type Capacity struct {
+	Target   string  `json:"target"`
+	ImageId  string  `json:"image_id,omitempty"`
+	FlavorId string  `json:"flavor_id"`
+	Quotas   []Quota `json:"quotas"`
+	// Available is how many virtual machines can still be created, Unlimited when no quota limits it.
+	Available int `json:"available"`
+	// LimitedBy are the resources that run out first.
+	LimitedBy []string `json:"limited_by,omitempty"`
+	// Error is set when the quota of the target could not be read, the rest is then empty.
+	Error string `json:"error,omitempty"`
+}
+
+// Fits is whether the target has room for count more virtual machines.
+func (c Capacity) Fits(count int) bool {
+	return c.Available == Unlimited || c.Available >= count
+}
+
+// TargetCapacity reads the Nova limits, the Cinder limits and the Neutron floating ip
+// quota of the project of a target, and works out how many virtual machines of an image
+// it has room for. Each one takes an instance, the cores and ram of its flavor, a boot
+// volume of IKT_STACK_VM_VOLUME_SIZE gigabytes and a floating ip.
+func TargetCapacity(ctx context.Context, target gopher.Target, imageId string) (capacity Capacity, err error) {
+	ctx, span := tracing.Start(ctx, "services.TargetCapacity", attribute.String("openstack.target", target.Name))
+	defer func() {
+		span.SetAttributes(attribute.Int("capacity.available", capacity.Available))
+		tracing.End(span, err)
+	}()
+
+	capacity = Capacity{Target: target.Name, ImageId: imageId, FlavorId: target.Flavor(imageId)}
+
+	compute, err := gopher.Compute(ctx, target.Name)
+	if err != nil {
+		return capacity, failOpenStack("Could not connect to OpenStack!", err)
+	}
+
+	flavor, err := flavors.Get(compute, capacity.FlavorId).Extract()
+	if err != nil {
+		return capacity, failOpenStack("Could not read the flavor of the virtual machines!", err)
+	}
+
+	computeLimits, err := limits.Get(compute, nil).Extract()
+	if err != nil {
+		return capacity, failOpenStack("Could not read the compute quota!", err)
+	}
+
+	volumeClient, err := gopher.BlockStorage(ctx, target.Name)
+	if err != nil {
+		return capacity, failOpenStack("Could not connect to OpenStack!", err)
+	}
+
+	volumeLimits, err := volumelimits.Get(volumeClient).Extract()
+	if err != nil {
+		return capacity, failOpenStack("Could not read the volume quota!", err)
+	}
+
+	networkClient, err := gopher.Network(ctx, target.Name)
+	if err != nil {
+		return capacity, failOpenStack("Could not connect to OpenStack!", err)
+	}
+
+	projectId, err := gopher.ProjectId(target.Name)
+	if err != nil {
+		return capacity, failOpenStack("Could not connect to OpenStack!", err)
+	}
+
+	networkQuotas, err := quotas.GetDetail(networkClient, projectId).Extract()
+	if err != nil {
+		return capacity, failOpenStack("Could not read the floating ip quota!", err)
+	}
+
+	// The floating ips kept for reuse count as used in Neutron, but new virtual machines get them.
+	kept, err := keptFloatingIps(ctx, target.Name)
+	if err != nil {
+		return capacity, fail("Could not load floating ips!", err)
+	}
+
+	nova, cinder, neutron := computeLimits.Absolute, volumeLimits.Absolute, networkQuotas.FloatingIP
+	capacity.Quotas = []Quota{
+		quota(ResourceInstances, nova.MaxTotalInstances, nova.TotalInstancesUsed, 1),
+		quota(ResourceCores, nova.MaxTotalCores, nova.TotalCoresUsed, flavor.VCPUs),
+		quota(ResourceRam, nova.MaxTotalRAMSize, nova.TotalRAMUsed, flavor.RAM),
+		quota(ResourceVolumes, cinder.MaxTotalVolumes, cinder.TotalVolumesUsed, 1),
+		quota(ResourceVolumeGigabytes, cinder.MaxTotalVolumeGigabytes, cinder.TotalGigabytesUsed, viper.GetInt("IKT_STACK_VM_VOLUME_SIZE")),
+		quota(ResourceFloatingIps, neutron.Limit, neutron.Used+neutron.Reserved-len(kept), 1),
+	}
+
+	capacity.Available = Unlimited
+	for _, v := range capacity.Quotas {
+		fits := v.fits()
+		if fits == Unlimited {
+			continue
+		}
+
+		switch {
+		case capacity.Available == Unlimited || fits < capacity.Available:
+			capacity.Available = fits
+			capacity.LimitedBy = []string{v.Resource}
+		case fits == capacity.Available:
+			capacity.LimitedBy = append(capacity.LimitedBy, v.Resource)
+		}
+	}
+
+	return capacity, nil
+}
+
+// Capacities returns the capacity of every target for virtual machines of an image, the
+// default flavor of the targets is used when imageId is empty. A target whose quota
+// could not be read has its Error set.
+func Capacities(ctx context.Context, imageId string) ([]Capacity, error) {
+	targets, err := gopher.Targets()
+	if err != nil {
+		return nil, failOpenStack("Could not connect to OpenStack!", err)
+	}
+
+	capacities := make([]Capacity, 0, len(targets))
+	for _, v := range targets {
+		capacity, err := TargetCapacity(ctx, v, imageId)
+		if err != nil {
+			capacity.Error = err.Error()
+		}
+		capacities = append(capacities, capacity)
+	}
+	return capacities, nil
+}
+
+// PlanOrder places an order of count virtual machines of an image in one target, see
+// Place, and checks that its quota has room for all of them. The capacity of the target
+// is returned with ErrInsufficientCapacity when it has not.
+func PlanOrder(ctx context.Context, courseCode string, imageId string, count int) (Capacity, error) {
+	target, err := Place(ctx, "", courseCode, imageId)
+	if err != nil {
+		return Capacity{}, err
+	}
+
+	capacity, err := TargetCapacity(ctx, target, imageId)
+	if err != nil {
+		return capacity, err
+	}
+
+	if !capacity.Fits(count) {
+		return capacity, fail(fmt.Sprintf("Only %d of %d virtual machines can be created, OpenStack target %s is out of %s!",
+			capacity.Available, count, capacity.Target, joinResources(capacity.LimitedBy)), ErrInsufficientCapacity)
+	}
+	return capacity, nil
+}
+
+// joinResources lists resources for messages, like "cores and floating_ips".
+func joinResources(resources []string) string {
+	switch len(resources) {
+	case 0:
+		return "quota"
+	case 1:
+		return resources[0]
+	}
+	return strings.Join(resources[:len(resources)-1], ", ") + " and " + resources[len(resources)-1]
+}
@@ -0,0 +1,460 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/floatingips"
+	"github.com/spf13/viper"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/database"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/gopher"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/logging"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/storage"
+)
+
+// The states of a floating ip in the project of a target.
+const (
+	// FloatingIpAttached is associated with a server.
+	FloatingIpAttached = "attached"
+	// FloatingIpClaimed is on no server but taken for one, either a server that is being
+	// created or a virtual machine that is being respawned.
+	FloatingIpClaimed = "claimed"
+	// FloatingIpAllocated is free and kept for the next virtual machine.
+	FloatingIpAllocated = "allocated"
+	// FloatingIpOrphaned is tracked, on no server and not kept, it only uses up the quota.
+	FloatingIpOrphaned = "orphaned"
+	// FloatingIpUntracked is on no server and was not allocated by the service, like ones
+	// allocated by hand. The cleanup leaves it alone.
+	FloatingIpUntracked = "untracked"
+	// FloatingIpMissing is stored but no longer exists in OpenStack.
+	FloatingIpMissing = "missing"
+)
+
+// What the cleanup did with a floating ip.
+const (
+	FloatingIpKept      = "kept"
+	FloatingIpDeleted   = "deleted"
+	FloatingIpForgotten = "forgotten"
+	FloatingIpTracked   = "tracked"
+	FloatingIpFailed    = "failed"
+)
+
+// defaultFloatingIpPoolSize is how many free floating ips a target keeps when
+// IKT_STACK_FLOATING_IP_POOL_SIZE is not set.
+const defaultFloatingIpPoolSize = 5
+
+// claimGrace is how long a claimed floating ip may stay on no server while the server
+// it was claimed for is created, it counts as orphaned afterwards.
+const claimGrace = 15 * time.Minute
+
+func floatingIpPoolSize() int {
+	if viper.IsSet("IKT_STACK_FLOATING_IP_POOL_SIZE") {
+		return viper.GetInt("IKT_STACK_FLOATING_IP_POOL_SIZE")
+	}
+	return defaultFloatingIpPoolSize
+}
+
+// FloatingIpStatus is a floating ip of a target compared with what is stored about it.
+type FloatingIpStatus struct {
+	Id         string `json:"id"`
+	Ip         string `json:"ip"`
+	Target     string `json:"target"`
+	Pool       string `json:"pool"`
+	State      string `json:"state"`
+	ServerId   string `json:"server_id,omitempty"`
+	ServerName string `json:"server_name,omitempty"`
+	// Tracked is whether the service stores the floating ip, ones from before it did are not.
+	Tracked bool `json:"tracked"`
+}
+
+// FloatingIpCleanupResult is what the cleanup did, or would do, with a floating ip.
+type FloatingIpCleanupResult struct {
+	FloatingIpStatus
+	Action string `json:"action"`
+	Error  string `json:"error,omitempty"`
+}
+
+// allocateFloatingIp returns a floating ip for a new server of a target. A free one that
+// was kept is claimed when there is one, a new one is allocated otherwise. Either way it
+// is stored as the ip of the server.
+func allocateFloatingIp(ctx context.Context, client *gophercloud.ServiceClient, target gopher.Target, serverId string) (*floatingips.FloatingIP, error) {
+	for {
+		record, err := storage.FloatingIps().ClaimFloatingIp(ctx, target.Name, serverId)
+		if errors.Is(err, storage.ErrNotFound) {
+			break
+		}
+		if err != nil {
+			logging.Warn(ctx, "could not claim a free floating ip, allocating a new one", "target", target.Name, "error", err)
+			break
+		}
+
+		fip, err := floatingips.Get(client, record.Id).Extract()
+		if _, ok := err.(gophercloud.ErrDefault404); ok {
+			logging.Warn(ctx, "free floating ip no longer exists", "floating_ip", record.Ip)
+			if err := storage.FloatingIps().DeleteFloatingIp(ctx, record.Id); err != nil {
+				return nil, fail("Could not forget floating ip!", err)
+			}
+			continue
+		}
+		if err != nil {
+			// Give the ip back, it was not used.
+			record.ServerId = ""
+			if err := storage.FloatingIps().SaveFloatingIp(ctx, record); err != nil {
+				logging.Error(ctx, "could not free floating ip", err, "floating_ip", record.Ip)
+			}
+			return nil, err
+		}
+
+		if len(fip.InstanceID) > 0 && fip.InstanceID != serverId {
+			// It was attached outside the service and is no longer free.
+			record.ServerId = fip.InstanceID
+			if err := storage.FloatingIps().SaveFloatingIp(ctx, record); err != nil {
+				return nil, fail("Could not save floating ip!", err)
+			}
+			continue
+		}
+
+		logging.Info(ctx, "reusing floating ip", "floating_ip", fip.IP, "target", target.Name)
+		return fip, nil
+	}
+
+	fip, err := floatingips.Create(client, floatingips.CreateOpts{Pool: target.FloatingNetworkId}).Extract()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	record := database.FloatingIp{Id: fip.ID, Ip: fip.IP, Target: target.Name, Pool: fip.Pool, ServerId: serverId, Allocated: now, Updated: now}
+	if err := storage.FloatingIps().SaveFloatingIp(ctx, record); err != nil {
+		// The ip works without its record, once released it shows up as orphaned.
+		logging.Error(ctx, "could not store floating ip", err, "floating_ip", fip.IP)
+	}
+	return fip, nil
+}
+
+// keepFloatingIp stores a floating ip as free for the next virtual machine.
+func keepFloatingIp(ctx context.Context, target string, fip floatingips.FloatingIP, allocated time.Time) error {
+	now := time.Now()
+	if allocated.IsZero() {
+		allocated = now
+	}
+	return storage.FloatingIps().SaveFloatingIp(ctx, database.FloatingIp{Id: fip.ID, Ip: fip.IP, Target: target, Pool: fip.Pool, Allocated: allocated, Updated: now})
+}
+
+// keptFloatingIps returns the free floating ips of a target, of every target when target is empty.
+func keptFloatingIps(ctx context.Context, target string) ([]database.FloatingIp, error) {
+	records, err := storage.FloatingIps().GetFloatingIps(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var kept []database.FloatingIp
+	for _, v := range records {
+		if len(v.ServerId) == 0 && (len(target) == 0 || v.Target == target) {
+			kept = append(kept, v)
+		}
+	}
+	return kept, nil
+}
+
+// deleteKeptFloatingIps gives every floating ip kept for reuse back to OpenStack.
+func deleteKeptFloatingIps(ctx context.Context) error {
+	kept, err := keptFloatingIps(ctx, "")
+	if err != nil {
+		return err
+	}
+
+	for _, v := range kept {
+		client, err := gopher.Compute(ctx, v.Target)
+		if err != nil {
+			return err
+		}
+		if err := deleteFloatingIp(ctx, client, floatingips.FloatingIP{ID: v.Id, IP: v.Ip}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteFloatingIp gives a floating ip back to OpenStack and forgets it.
+func deleteFloatingIp(ctx context.Context, client *gophercloud.ServiceClient, fip floatingips.FloatingIP) error {
+	err := floatingips.Delete(client, fip.ID).ExtractErr()
+	if _, ok := err.(gophercloud.ErrDefault404); err != nil && !ok {
+		return err
+	}
+	return storage.FloatingIps().DeleteFloatingIp(ctx, fip.ID)
+}
+
+// releaseFloatingIp frees a floating ip that is on no server any more. It is kept for the
+// next virtual machine while the target keeps fewer than IKT_STACK_FLOATING_IP_POOL_SIZE
+// free ones, and given back to OpenStack otherwise. It returns whether it was kept.
+func releaseFloatingIp(ctx context.Context, client *gophercloud.ServiceClient, target string, fip floatingips.FloatingIP) (bool, error) {
+	records, err := storage.FloatingIps().GetFloatingIps(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	free := 0
+	var allocated time.Time
+	for _, v := range records {
+		if v.Id == fip.ID {
+			allocated = v.Allocated
+		} else if v.Target == target && len(v.ServerId) == 0 {
+			free++
+		}
+	}
+
+	if free < floatingIpPoolSize() {
+		if err := keepFloatingIp(ctx, target, fip, allocated); err != nil {
+			return false, err
+		}
+		logging.Info(ctx, "kept floating ip for reuse", "floating_ip", fip.IP, "target", target)
+		return true, nil
+	}
+
+	if err := deleteFloatingIp(ctx, client, fip); err != nil {
+		return false, err
+	}
+	logging.Info(ctx, "deleted floating ip", "floating_ip", fip.IP, "target", target)
+	return false, nil
+}
+
+// findFloatingIp looks up a floating ip of the project by address.
+func findFloatingIp(client *gophercloud.ServiceClient, ip string) (*floatingips.FloatingIP, error) {
+	pages, err := floatingips.List(client).AllPages()
+	if err != nil {
+		return nil, err
+	}
+
+	list, err := floatingips.ExtractFloatingIPs(pages)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, v := range list {
+		if v.IP == ip {
+			return &v, nil
+		}
+	}
+	return nil, storage.ErrNotFound
+}
+
+// releaseVmFloatingIp releases the floating ip of a virtual machine whose server is gone
+// or has been disassociated from it. A failure is only logged, the virtual machine is
+// deleted regardless and the cleanup takes care of the ip.
+func releaseVmFloatingIp(ctx context.Context, client *gophercloud.ServiceClient, vm database.VirtualMachine) {
+	// Adopted servers may have no floating ip.
+	if len(vm.ServerIp) == 0 {
+		return
+	}
+
+	target, err := gopher.TargetByName(vm.Target)
+	if err != nil {
+		logging.Error(ctx, "could not release floating ip", err, "floating_ip", vm.ServerIp)
+		return
+	}
+
+	fip, err := findFloatingIp(client, vm.ServerIp)
+	if err != nil {
+		logging.Error(ctx, "could not release floating ip", err, "floating_ip", vm.ServerIp)
+		return
+	}
+	if len(fip.InstanceID) > 0 && fip.InstanceID != vm.ServerId {
+		logging.Warn(ctx, "floating ip moved to another server, not releasing it", "floating_ip", vm.ServerIp, "server_id", fip.InstanceID)
+		return
+	}
+
+	if _, err := releaseFloatingIp(ctx, client, target.Name, *fip); err != nil {
+		logging.Error(ctx, "could not release floating ip", err, "floating_ip", vm.ServerIp)
+	}
+}
+
+// floatingIpState decides the state of a floating ip of OpenStack from its record, if any.
+// onVm is whether the server of the record is a stored virtual machine, a respawn keeps
+// the ip of the old server off it until the new one exists, however long that takes.
+func floatingIpState(fip floatingips.FloatingIP, record database.FloatingIp, tracked bool, onVm bool, now time.Time) string {
+	switch {
+	case len(fip.InstanceID) > 0:
+		return FloatingIpAttached
+	case !tracked:
+		return FloatingIpUntracked
+	case len(record.ServerId) == 0:
+		return FloatingIpAllocated
+	case onVm, now.Sub(record.Updated) < claimGrace:
+		return FloatingIpClaimed
+	}
+	return FloatingIpOrphaned
+}
+
+// FloatingIps lists the floating ips in the project of every target with their state,
+// and the stored ones that no longer exist as missing.
+func FloatingIps(ctx context.Context) ([]FloatingIpStatus, error) {
+	targets, err := gopher.Targets()
+	if err != nil {
+		return nil, failOpenStack("Could not connect to OpenStack!", err)
+	}
+
+	records, err := storage.FloatingIps().GetFloatingIps(ctx)
+	if err != nil {
+		return nil, fail("Could not load floating ips!", err)
+	}
+
+	vms, err := storage.Vms().GetVMS(ctx)
+	if err != nil {
+		return nil, fail("Could not load virtual machines!", err)
+	}
+
+	stored := map[string]database.FloatingIp{}
+	for _, v := range records {
+		stored[v.Id] = v
+	}
+	names := map[string]string{}
+	for _, v := range vms {
+		names[v.ServerId] = v.ServerName
+	}
+
+	now := time.Now()
+	seen := map[string]bool{}
+	statuses := []FloatingIpStatus{}
+	for _, target := range targets {
+		client, err := gopher.Compute(ctx, target.Name)
+		if err != nil {
+			return nil, failOpenStack("Could not connect to OpenStack!", err)
+		}
+
+		pages, err := floatingips.List(client).AllPages()
+		if err != nil {
+			return nil, failOpenStack("Could not read the floating ips of target "+target.Name+"!", err)
+		}
+		list, err := floatingips.ExtractFloatingIPs(pages)
+		if err != nil {
+			return nil, failOpenStack("Could not read the floating ips of target "+target.Name+"!", err)
+		}
+
+		for _, v := range list {
+			record, tracked := stored[v.ID]
+			_, onVm := names[record.ServerId]
+			seen[v.ID] = true
+
+			status := FloatingIpStatus{
+				Id:       v.ID,
+				Ip:       v.IP,
+				Target:   target.Name,
+				Pool:     v.Pool,
+				State:    floatingIpState(v, record, tracked, onVm, now),
+				ServerId: v.InstanceID,
+				Tracked:  tracked,
+			}
+			if status.State == FloatingIpClaimed {
+				status.ServerId = record.ServerId
+			}
+			status.ServerName = names[status.ServerId]
+			statuses = append(statuses, status)
+		}
+	}
+
+	for _, v := range records {
+		if seen[v.Id] {
+			continue
+		}
+		statuses = append(statuses, FloatingIpStatus{
+			Id:         v.Id,
+			Ip:         v.Ip,
+			Target:     v.Target,
+			Pool:       v.Pool,
+			State:      FloatingIpMissing,
+			ServerId:   v.ServerId,
+			ServerName: names[v.ServerId],
+			Tracked:    true,
+		})
+	}
+
+	return statuses, nil
+}
+
+// CleanupFloatingIps brings the floating ips in order. Orphaned ones are kept for reuse
+// up to IKT_STACK_FLOATING_IP_POOL_SIZE per target and given back to OpenStack beyond
+// that, the records of missing ones are removed and the ones on virtual machines the
+// service does not track yet are stored. Floating ips the service never tracked are only
+// stored when they are on one of its virtual machines, and never deleted. With dryRun
+// only the results are returned.
+func CleanupFloatingIps(ctx context.Context, dryRun bool) ([]FloatingIpCleanupResult, error) {
+	statuses, err := FloatingIps(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	records, err := storage.FloatingIps().GetFloatingIps(ctx)
+	if err != nil {
+		return nil, fail("Could not load floating ips!", err)
+	}
+
+	stored := map[string]database.FloatingIp{}
+	for _, v := range records {
+		stored[v.Id] = v
+	}
+
+	// Records of floating ips that are gone from OpenStack do not take up the pool.
+	free := map[string]int{}
+	for _, v := range statuses {
+		if v.State == FloatingIpAllocated {
+			free[v.Target]++
+		}
+	}
+
+	results := []FloatingIpCleanupResult{}
+	for _, v := range statuses {
+		var err error
+		result := FloatingIpCleanupResult{FloatingIpStatus: v}
+		fip := floatingips.FloatingIP{ID: v.Id, IP: v.Ip, Pool: v.Pool, InstanceID: v.ServerId}
+		record := stored[v.Id]
+
+		switch {
+		case v.State == FloatingIpOrphaned:
+			result.Action = FloatingIpDeleted
+			if free[v.Target] < floatingIpPoolSize() {
+				result.Action = FloatingIpKept
+				free[v.Target]++
+			}
+			if dryRun {
+				break
+			}
+
+			if result.Action == FloatingIpKept {
+				err = keepFloatingIp(ctx, v.Target, fip, record.Allocated)
+			} else if client, clientErr := gopher.Compute(ctx, v.Target); clientErr != nil {
+				err = clientErr
+			} else {
+				err = deleteFloatingIp(ctx, client, fip)
+			}
+		case v.State == FloatingIpMissing:
+			result.Action = FloatingIpForgotten
+			if !dryRun {
+				err = storage.FloatingIps().DeleteFloatingIp(ctx, v.Id)
+			}
+		case v.State == FloatingIpAttached && v.Tracked && record.ServerId != v.ServerId,
+			v.State == FloatingIpAttached && !v.Tracked && len(v.ServerName) > 0:
+			result.Action = FloatingIpTracked
+			if !dryRun {
+				allocated, now := record.Allocated, time.Now()
+				if allocated.IsZero() {
+					allocated = now
+				}
+				err = storage.FloatingIps().SaveFloatingIp(ctx, database.FloatingIp{Id: v.Id, Ip: v.Ip, Target: v.Target, Pool: v.Pool, ServerId: v.ServerId, Allocated: allocated, Updated: now})
+			}
+		default:
+			continue
+		}
+
+		if err != nil {
+			logging.Error(ctx, "could not clean up floating ip", err, "floating_ip", v.Ip, "action", result.Action)
+			result.Action = FloatingIpFailed
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+
+	return results, nil
+}
@@ -0,0 +1,42 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/floatingips"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/database"
+)
+
+func TestFloatingIpState(t *testing.T) {
+	now := time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC)
+	free := floatingips.FloatingIP{ID: "fip", IP: "10.0.0.1"}
+	attached := floatingips.FloatingIP{ID: "fip", IP: "10.0.0.1", InstanceID: "server"}
+
+	tests := []struct {
+		name    string
+		fip     floatingips.FloatingIP
+		record  database.FloatingIp
+		tracked bool
+		onVm    bool
+		want    string
+	}{
+		{"attached and tracked", attached, database.FloatingIp{ServerId: "server"}, true, true, FloatingIpAttached},
+		{"attached by hand", attached, database.FloatingIp{}, false, false, FloatingIpAttached},
+		{"allocated by hand", free, database.FloatingIp{}, false, false, FloatingIpUntracked},
+		{"kept for reuse", free, database.FloatingIp{Updated: now.Add(-48 * time.Hour)}, true, false, FloatingIpAllocated},
+		{"claimed for a new server", free, database.FloatingIp{ServerId: "new", Updated: now.Add(-time.Minute)}, true, false, FloatingIpClaimed},
+		{"claim at the end of the grace", free, database.FloatingIp{ServerId: "new", Updated: now.Add(-claimGrace + time.Second)}, true, false, FloatingIpClaimed},
+		{"claim past the grace", free, database.FloatingIp{ServerId: "new", Updated: now.Add(-claimGrace)}, true, false, FloatingIpOrphaned},
+		{"respawn running past the grace", free, database.FloatingIp{ServerId: "old", Updated: now.Add(-time.Hour)}, true, true, FloatingIpClaimed},
+		{"server gone without a virtual machine", free, database.FloatingIp{ServerId: "gone", Updated: now.Add(-time.Hour)}, true, false, FloatingIpOrphaned},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := floatingIpState(tt.fip, tt.record, tt.tracked, tt.onVm, now); got != tt.want {
+				t.Errorf("floatingIpState() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/spf13/viper"
+)
+
+// CheckCanvas asks Canvas for the account of the api key, which fails when Canvas is
+// down or the key is no longer accepted.
+func CheckCanvas(ctx context.Context) error {
+	req, err := newCanvasRequest(ctx, "GET", "/users/self", nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := canvasClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("canvas answered %s", resp.Status)
+	}
+	return nil
+}
+
+// CheckTemplates checks that the template directories that virtual machines are created
+// from are there, with the sssd config in the configs directory. The notification templates
+// are only needed while notifications are enabled.
+func CheckTemplates(ctx context.Context) error {
+	dirs := []string{"IKT_STACK_TEMPLATES_USERDATA_DIR", "IKT_STACK_TEMPLATES_CONFIGS_DIR"}
+	if viper.GetBool("IKT_STACK_NOTIFICATIONS_ENABLED") {
+		dirs = append(dirs, "IKT_STACK_TEMPLATES_NOTIFICATIONS_DIR")
+	}
+
+	for _, key := range dirs {
+		dir := viper.GetString(key)
+		info, err := os.Stat(dir)
+		if err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("%s: %s is not a directory", key, dir)
+		}
+	}
+
+	// The sssd template is read by joining the strings, like utils.GenerateUserData does.
+	sssd := viper.GetString("IKT_STACK_TEMPLATES_CONFIGS_DIR") + viper.GetString("IKT_STACK_SSSD_TEMPLATE_NAME")
+	if _, err := os.Stat(sssd); err != nil {
+		return fmt.Errorf("IKT_STACK_SSSD_TEMPLATE_NAME: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package services
+
+import (
+	"context"
+	"errors"
+
+	"github.com/gophercloud/gophercloud/openstack/imageservice/v2/images"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/database"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/gopher"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/storage"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/webhooks"
+)
+
+// ServerImage is an image available in OpenStack.
+type ServerImage struct {
+	ImageId string `json:"image_id"`
+	Name    string `json:"name"`
+}
+
+// ServerImages lists the images of the first target, the targets are expected to share their images.
+func ServerImages(ctx context.Context) ([]ServerImage, error) {
+	client, err := gopher.Compute(ctx, "")
+	if err != nil {
+		return nil, failOpenStack("Could not connect to OpenStack!", err)
+	}
+
+	allPages, err := images.List(client, nil).AllPages()
+	if err != nil {
+		return nil, failOpenStack("Could not read images!", err)
+	}
+
+	allImages, err := images.ExtractImages(allPages)
+	if err != nil {
+		return nil, failOpenStack("Could not extract information from server!", err)
+	}
+
+	var serverImages []ServerImage
+	for _, v := range allImages {
+		serverImages = append(serverImages, ServerImage{ImageId: v.ID, Name: v.Name})
+	}
+
+	return serverImages, nil
+}
+
+// SyncImages adds the OpenStack images that are not in the database yet. They are
+// not published, an administrator has to review the config and description first.
+func SyncImages(ctx context.Context) ([]database.Images, error) {
+	serverImages, err := ServerImages(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	added := []database.Images{}
+	for _, v := range serverImages {
+		_, err := storage.Images().GetImageByImageId(ctx, v.ImageId)
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, storage.ErrNotFound) {
+			return added, fail("Error while reading images!", err)
+		}
+
+		image := database.Images{
+			ImageId:          v.ImageId,
+			ImageName:        v.Name,
+			ImageDisplayName: v.Name,
+			Published:        "false",
+		}
+
+		if err := storage.Images().InsertImage(ctx, image); err != nil {
+			return added, fail("Error while inserting image!", err)
+		}
+
+		webhooks.Publish(ctx, webhooks.EventImageCreated, webhooks.ImagePayload{
+			ImageId:          image.ImageId,
+			ImageDisplayName: image.ImageDisplayName,
+			Published:        image.Published,
+		})
+		added = append(added, image)
+	}
+
+	return added, nil
+}
@@ -0,0 +1,140 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/database"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/gopher"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/logging"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/storage"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/webhooks"
+)
+
+var ErrInvalidUser = errors.New("invalid user id")
+var ErrAlreadyMember = errors.New("already a member")
+var ErrNotMember = errors.New("not a member")
+var ErrRemoveOwner = errors.New("owner cannot be removed")
+
+// MembershipResult is a virtual machine after its members changed. The database and
+// server metadata are always updated, AccessError tells why the machine itself was not.
+type MembershipResult struct {
+	Vm           database.VirtualMachine `json:"vm"`
+	AccessPushed bool                    `json:"access_pushed"`
+	AccessError  string                  `json:"access_error,omitempty"`
+}
+
+func validUserId(userId string) error {
+	// Only uia accounts can log in, sssd is given the user name without the domain.
+	if !ValidUserId(userId) {
+		return fail(fmt.Sprintf("Invalid user id %q!", userId), ErrInvalidUser)
+	}
+	return nil
+}
+
+// changeMembers updates the members in the database and then brings the server
+// metadata and the access list on the machine in line with them.
+func changeMembers(ctx context.Context, serverId string, update storage.MembersUpdate) (MembershipResult, error) {
+	var result MembershipResult
+
+	_, err := storage.Vms().UpdateVmMembers(ctx, serverId, update)
+	var refused *Error
+	if errors.As(err, &refused) {
+		return result, err
+	}
+	if errors.Is(err, storage.ErrNotFound) {
+		return result, fail("Virtual machine not found!", err)
+	}
+	if err != nil {
+		return result, fail("Error updating virtual machine members!", err)
+	}
+
+	vm, err := storage.Vms().GetVMById(ctx, serverId)
+	if err != nil {
+		return result, fail("Error reading database!", err)
+	}
+	result.Vm = vm
+
+	webhooks.Publish(ctx, webhooks.EventVmMembersChanged, webhooks.VmPayload{
+		ServerId:   vm.ServerId,
+		ServerName: vm.ServerName,
+		ServerIp:   vm.ServerIp,
+		CourseCode: vm.CourseCode,
+		Users:      MemberIds(vm),
+	})
+
+	client, err := gopher.Compute(ctx, vm.Target)
+	if err == nil {
+		err = writeMembershipMetadata(client, vm)
+	}
+	if err != nil {
+		logging.Error(ctx, "could not write server metadata", err, logging.VmId, vm.ServerId)
+	}
+
+	if err := Access.Push(ctx, vm); err != nil {
+		result.AccessError = err.Error()
+		return result, nil
+	}
+
+	result.AccessPushed = true
+	return result, nil
+}
+
+// AddMember gives a user access to a virtual machine.
+func AddMember(ctx context.Context, serverId string, userId string) (MembershipResult, error) {
+	if err := validUserId(userId); err != nil {
+		return MembershipResult{}, err
+	}
+
+	return changeMembers(ctx, serverId, func(members []database.VirtualMachineMember) ([]database.VirtualMachineMember, error) {
+		for _, v := range members {
+			if v.UserId == userId {
+				return nil, fail("User is already a member!", ErrAlreadyMember)
+			}
+		}
+		return append(members, database.VirtualMachineMember{UserId: userId, Role: database.VirtualMachineRoleMember}), nil
+	})
+}
+
+// RemoveMember takes access away from a member, the owner has to transfer ownership first.
+func RemoveMember(ctx context.Context, serverId string, userId string) (MembershipResult, error) {
+	return changeMembers(ctx, serverId, func(members []database.VirtualMachineMember) ([]database.VirtualMachineMember, error) {
+		var kept []database.VirtualMachineMember
+		for _, v := range members {
+			if v.UserId != userId {
+				kept = append(kept, v)
+				continue
+			}
+			if v.Role == database.VirtualMachineRoleOwner {
+				return nil, fail("The owner cannot be removed, transfer the ownership first!", ErrRemoveOwner)
+			}
+		}
+
+		if len(kept) == len(members) {
+			return nil, fail("User is not a member!", ErrNotMember)
+		}
+		return kept, nil
+	})
+}
+
+// TransferOwnership makes userId the owner, the previous owner stays on as a member.
+func TransferOwnership(ctx context.Context, serverId string, userId string) (MembershipResult, error) {
+	if err := validUserId(userId); err != nil {
+		return MembershipResult{}, err
+	}
+
+	return changeMembers(ctx, serverId, func(members []database.VirtualMachineMember) ([]database.VirtualMachineMember, error) {
+		transferred := []database.VirtualMachineMember{{UserId: userId, Role: database.VirtualMachineRoleOwner}}
+		for _, v := range members {
+			if v.UserId == userId {
+				if v.Role == database.VirtualMachineRoleOwner {
+					return nil, fail("User already owns the virtual machine!", ErrAlreadyMember)
+				}
+				continue
+			}
+			transferred = append(transferred, database.VirtualMachineMember{UserId: v.UserId, Role: database.VirtualMachineRoleMember})
+		}
+		return transferred, nil
+	})
+}
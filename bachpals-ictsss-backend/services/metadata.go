@@ -0,0 +1,110 @@
+package services
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/database"
+)
+
+// Server metadata written when a virtual machine is provisioned, it lets the
+// records be rebuilt from OpenStack when the database is lost.
+const (
+	MetadataImageId   = "VM_IMAGE_ID"
+	MetadataOwner     = "VM_OWNER"
+	MetadataMembers   = "VM_MEMBERS"
+	MetadataCourse    = "VM_COURSE_CODE"
+	MetadataCreatedBy = "VM_CREATED_BY"
+	MetadataTarget    = "VM_TARGET"
+)
+
+// metadataValueLimit is the longest metadata value nova accepts, longer member
+// lists continue in VM_MEMBERS_2, VM_MEMBERS_3 and so on.
+const metadataValueLimit = 255
+
+func membersKey(n int) string {
+	if n == 1 {
+		return MetadataMembers
+	}
+	return MetadataMembers + "_" + strconv.Itoa(n)
+}
+
+// membershipMetadata returns the owner, members and course of vm as server metadata.
+func membershipMetadata(vm database.VirtualMachine) map[string]string {
+	metadata := map[string]string{}
+	if len(vm.CourseCode) > 0 {
+		metadata[MetadataCourse] = vm.CourseCode
+	}
+
+	n := 1
+	for _, v := range vm.Members {
+		if v.Role == database.VirtualMachineRoleOwner {
+			metadata[MetadataOwner] = v.UserId
+			continue
+		}
+
+		key := membersKey(n)
+		switch {
+		case len(metadata[key]) == 0:
+			metadata[key] = v.UserId
+		case len(metadata[key])+1+len(v.UserId) <= metadataValueLimit:
+			metadata[key] += "," + v.UserId
+		default:
+			n++
+			metadata[membersKey(n)] = v.UserId
+		}
+	}
+
+	return metadata
+}
+
+// membersFromMetadata reads the members written by membershipMetadata, owner first.
+// It returns nil for servers created before owners were written.
+func membersFromMetadata(metadata map[string]string) []database.VirtualMachineMember {
+	owner := metadata[MetadataOwner]
+	if len(owner) == 0 {
+		return nil
+	}
+
+	members := []database.VirtualMachineMember{{UserId: owner, Role: database.VirtualMachineRoleOwner}}
+	for n := 1; ; n++ {
+		value, ok := metadata[membersKey(n)]
+		if !ok {
+			break
+		}
+		for _, v := range strings.Split(value, ",") {
+			if len(v) > 0 {
+				members = append(members, database.VirtualMachineMember{UserId: v, Role: database.VirtualMachineRoleMember})
+			}
+		}
+	}
+
+	return members
+}
+
+// writeMembershipMetadata sets the owner, members and course of vm on its server and
+// removes member keys left over from a longer member list.
+func writeMembershipMetadata(client *gophercloud.ServiceClient, vm database.VirtualMachine) error {
+	current, err := servers.Metadata(client, vm.ServerId).Extract()
+	if err != nil {
+		return err
+	}
+
+	metadata := membershipMetadata(vm)
+	for key := range current {
+		_, keep := metadata[key]
+		stale := key == MetadataOwner || key == MetadataCourse || strings.HasPrefix(key, MetadataMembers)
+		if stale && !keep {
+			if err := servers.DeleteMetadatum(client, vm.ServerId, key).ExtractErr(); err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(metadata) == 0 {
+		return nil
+	}
+	return servers.UpdateMetadata(client, vm.ServerId, servers.MetadataOpts(metadata)).Err
+}
@@ -0,0 +1,42 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/storage"
+)
+
+// vmCollectTimeout bounds the database read of a scrape.
+const vmCollectTimeout = 5 * time.Second
+
+var vmsDesc = prometheus.NewDesc("ictsss_vms", "Virtual machines by status and image.", []string{"status", "image"}, nil)
+
+// VmCollector reports the number of virtual machines by status and image, counted in
+// the database on every scrape.
+type VmCollector struct{}
+
+func (VmCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- vmsDesc
+}
+
+func (VmCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), vmCollectTimeout)
+	defer cancel()
+
+	vms, err := storage.Vms().GetVMS(ctx)
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(vmsDesc, err)
+		return
+	}
+
+	counts := map[[2]string]int{}
+	for _, v := range vms {
+		counts[[2]string{v.ServerStatus, v.ServerImage}]++
+	}
+
+	for labels, count := range counts {
+		ch <- prometheus.MustNewConstMetric(vmsDesc, prometheus.GaugeValue, float64(count), labels[0], labels[1])
+	}
+}
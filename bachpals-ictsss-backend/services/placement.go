@@ -0,0 +1,96 @@
+package services
+
+import (
+	"context"
+
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/limits"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/gopher"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/logging"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Place chooses the target of a new virtual machine with the placement policy, see
+// gopher.PlacementPolicy. A target that is named is used as is.
+func Place(ctx context.Context, target string, courseCode string, imageId string) (t gopher.Target, err error) {
+	ctx, span := tracing.Start(ctx, "services.Place")
+	defer func() {
+		span.SetAttributes(attribute.String("openstack.target", t.Name))
+		tracing.End(span, err)
+	}()
+
+	if len(target) == 0 {
+		policy, err := gopher.Policy()
+		if err != nil {
+			return t, failOpenStack("Could not connect to OpenStack!", err)
+		}
+
+		if name, ok := policy.Courses[courseCode]; ok && len(courseCode) > 0 {
+			target = name
+		} else if name, ok := policy.Images[imageId]; ok {
+			target = name
+		} else if policy.LeastLoaded {
+			return leastLoaded(ctx)
+		}
+	}
+
+	// Without a rule that matches the first target is used.
+	t, err = gopher.TargetByName(target)
+	if err != nil {
+		return t, fail("Unknown OpenStack target!", err)
+	}
+	return t, nil
+}
+
+// load is the largest share of the instance, core and ram quota of a project that is in
+// use, from 0 to 1. Unlimited quotas are left out.
+func load(absolute limits.Absolute) float64 {
+	var highest float64
+	for _, v := range [][2]int{
+		{absolute.TotalInstancesUsed, absolute.MaxTotalInstances},
+		{absolute.TotalCoresUsed, absolute.MaxTotalCores},
+		{absolute.TotalRAMUsed, absolute.MaxTotalRAMSize},
+	} {
+		if v[1] > 0 && float64(v[0])/float64(v[1]) > highest {
+			highest = float64(v[0]) / float64(v[1])
+		}
+	}
+	return highest
+}
+
+// leastLoaded returns the target whose project has the most of its compute quota left.
+// Targets that can not be reached are passed over.
+func leastLoaded(ctx context.Context) (gopher.Target, error) {
+	targets, err := gopher.Targets()
+	if err != nil {
+		return gopher.Target{}, failOpenStack("Could not connect to OpenStack!", err)
+	}
+
+	var chosen gopher.Target
+	var lowest float64
+	var lastErr error
+	for _, v := range targets {
+		client, err := gopher.Compute(ctx, v.Name)
+		if err != nil {
+			logging.Warn(ctx, "could not read the quota of a target", "target", v.Name, "error", err)
+			lastErr = err
+			continue
+		}
+
+		result, err := limits.Get(client, nil).Extract()
+		if err != nil {
+			logging.Warn(ctx, "could not read the quota of a target", "target", v.Name, "error", err)
+			lastErr = err
+			continue
+		}
+
+		if current := load(result.Absolute); len(chosen.Name) == 0 || current < lowest {
+			chosen, lowest = v, current
+		}
+	}
+
+	if len(chosen.Name) == 0 {
+		return chosen, failOpenStack("Could not read the quota of any OpenStack target!", lastErr)
+	}
+	return chosen, nil
+}
@@ -0,0 +1,80 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/storage"
+)
+
+// backupLayout keeps backups sorted by when they were taken.
+const backupLayout = "20060102T150405"
+
+// Backup exports everything into a new file in dir and returns its path.
+func Backup(ctx context.Context, dir string) (string, error) {
+	archive, err := Export(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", fail("Could not create backup directory!", err)
+	}
+
+	path := filepath.Join(dir, fmt.Sprintf("ictsss-backup-%s.json", archive.Exported.UTC().Format(backupLayout)))
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return "", fail("Could not create backup file!", err)
+	}
+
+	if err := WriteArchive(f, archive); err != nil {
+		f.Close()
+		return "", fail("Could not write backup file!", err)
+	}
+	if err := f.Close(); err != nil {
+		return "", fail("Could not write backup file!", err)
+	}
+
+	return path, nil
+}
+
+// Teardown deletes the server and floating ip of every tracked virtual machine, and the
+// floating ips kept for reuse. It stops at the first failure, the virtual machines not
+// deleted yet are left as they are.
+func Teardown(ctx context.Context) (int, error) {
+	vms, err := storage.Vms().GetVMS(ctx)
+	if err != nil {
+		return 0, fail("Could not load virtual machines!", err)
+	}
+
+	for i, v := range vms {
+		if err := DeleteVm(ctx, v); err != nil {
+			return i, fail(fmt.Sprintf("Could not delete %s (%s)!", v.ServerName, v.ServerId), err)
+		}
+	}
+
+	if err := deleteKeptFloatingIps(ctx); err != nil {
+		return len(vms), fail("Could not delete the free floating ips!", err)
+	}
+
+	return len(vms), nil
+}
+
+// Restore loads an archive into a reset database. The application counts as
+// initialized again when the archive has administrators.
+func Restore(ctx context.Context, archive Archive, options ImportOptions) (ImportResult, error) {
+	result, err := Import(ctx, archive, options)
+	if err != nil {
+		return result, err
+	}
+
+	if len(archive.Admins) > 0 && !options.DryRun {
+		if err := storage.AppState().SetInitialized(ctx); err != nil {
+			return result, fail("Failed updating initialization state!", err)
+		}
+	}
+
+	return result, nil
+}
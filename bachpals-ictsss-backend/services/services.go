@@ -0,0 +1,55 @@
+// Package services holds the operations shared by the http handlers and the
+// commandline, so both provision, delete and reconcile virtual machines the same way.
+package services
+
+import (
+	"errors"
+)
+
+// Error is a failure with a message that can be shown to the user, Err holds the cause.
+type Error struct {
+	Message string
+	Err     error
+	// OpenStack is set when the cause is a call to OpenStack rather than to the database.
+	OpenStack bool
+}
+
+func (e *Error) Error() string {
+	if e.Err == nil {
+		return e.Message
+	}
+	return e.Message + " " + e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func fail(message string, err error) error {
+	return &Error{Message: message, Err: err}
+}
+
+func failOpenStack(message string, err error) error {
+	return &Error{Message: message, Err: err, OpenStack: true}
+}
+
+// Message returns the user facing message of err, or fallback when it has none.
+func Message(err error, fallback string) string {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Message
+	}
+	return fallback
+}
+
+// IsOpenStack reports whether err was caused by a call to OpenStack. The innermost
+// Error decides, it is the one closest to the cause.
+func IsOpenStack(err error) bool {
+	openStack := false
+	for ; err != nil; err = errors.Unwrap(err) {
+		if e, ok := err.(*Error); ok {
+			openStack = e.OpenStack
+		}
+	}
+	return openStack
+}
@@ -0,0 +1,33 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestIsOpenStack(t *testing.T) {
+	cause := errors.New("cause")
+
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"plain error", cause, false},
+		{"database failure", fail("Error reading database!", cause), false},
+		{"openstack failure", failOpenStack("Could not connect to OpenStack!", cause), true},
+		{"wrapped openstack failure", fmt.Errorf("respawn: %w", failOpenStack("Unable to delete virtual machine!", cause)), true},
+		{"openstack failure inside a service failure", fail("Could not delete VM-1!", failOpenStack("Unable to delete virtual machine!", cause)), true},
+		{"database failure inside an openstack failure", failOpenStack("Error while creating floating ip for virtual machine!", fail("Could not save floating ip!", cause)), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsOpenStack(tt.err); got != tt.want {
+				t.Errorf("IsOpenStack(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,460 @@
+package services
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/bootfromvolume"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/floatingips"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/keypairs"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/secgroups"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
+	"github.com/spf13/viper"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/database"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/gopher"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/logging"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/metrics"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/notify"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/storage"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/utils"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/webhooks"
+)
+
+const ReconcileDeleted = "deleted"
+const ReconcileUpdated = "updated"
+const ReconcileUnchanged = "unchanged"
+const ReconcileFailed = "failed"
+
+// ProvisionRequest describes a virtual machine to create, the first member is the owner.
+type ProvisionRequest struct {
+	ServerName string
+	ImageId    string
+	CourseCode string
+	Members    []database.VirtualMachineMember
+	// CreatedBy is the user who ordered the virtual machine, it is only kept in the server metadata.
+	CreatedBy string
+	// Target is the OpenStack target to create the server in, the placement policy chooses one when it is empty.
+	Target string
+	// LeaseExpires is kept by a respawn, when it is zero a new lease of LeaseDuration starts.
+	LeaseExpires time.Time
+}
+
+type ReconcileResult struct {
+	ServerId       string `json:"server_id"`
+	ServerName     string `json:"server_name"`
+	Action         string `json:"action"`
+	Status         string `json:"status"`
+	PreviousStatus string `json:"previous_status"`
+	Error          string `json:"error,omitempty"`
+}
+
+// LeaseDuration is the length of the lease of a new virtual machine, zero when
+// IKT_STACK_VM_LEASE_DAYS is not set and virtual machines have no lease.
+func LeaseDuration() time.Duration {
+	return time.Duration(viper.GetInt("IKT_STACK_VM_LEASE_DAYS")) * 24 * time.Hour
+}
+
+// MemberIds returns the user ids of everyone with access to the virtual machine, owner first.
+func MemberIds(vm database.VirtualMachine) []string {
+	var ids []string
+	for _, v := range vm.Members {
+		ids = append(ids, v.UserId)
+	}
+	return ids
+}
+
+var userIdPattern = regexp.MustCompile(`^[A-Za-z0-9._-]+@(student\.)?uia\.no$`)
+
+// ValidUserId reports whether userId is a uia or student account, the only ones that can log in.
+func ValidUserId(userId string) bool {
+	return userIdPattern.MatchString(userId)
+}
+
+// UserName strips the uia domain from a user id, it is used in server names.
+func UserName(userId string) string {
+	if strings.Contains(userId, "@uia.no") {
+		return strings.Replace(userId, "@uia.no", "", -1)
+	} else if strings.Contains(userId, "@student.uia.no") {
+		return strings.Replace(userId, "@student.uia.no", "", -1)
+	}
+	return ""
+}
+
+// PublishStatusChanged sends a vm.status_changed webhook, previousStatus may be empty when it is unknown.
+func PublishStatusChanged(ctx context.Context, vm database.VirtualMachine, previousStatus string) {
+	webhooks.Publish(ctx, webhooks.EventVmStatusChanged, webhooks.VmPayload{
+		ServerId:       vm.ServerId,
+		ServerName:     vm.ServerName,
+		ServerIp:       vm.ServerIp,
+		ServerStatus:   vm.ServerStatus,
+		PreviousStatus: previousStatus,
+	})
+}
+
+// discard removes a half provisioned server and releases its floating ip, fip may be nil.
+func discard(ctx context.Context, client *gophercloud.ServiceClient, target string, serverId string, fip *floatingips.FloatingIP) {
+	if fip != nil {
+		// The ip may not have been associated yet, then there is nothing to undo.
+		floatingips.DisassociateInstance(client, serverId, floatingips.DisassociateOpts{FloatingIP: fip.IP})
+		if _, err := releaseFloatingIp(ctx, client, target, *fip); err != nil {
+			logging.Error(ctx, "could not release floating ip of failed virtual machine", err, "floating_ip", fip.IP)
+		}
+	}
+
+	if err := servers.Delete(client, serverId).ExtractErr(); err != nil {
+		logging.Error(ctx, "could not delete failed virtual machine", err)
+	}
+}
+
+// Provision creates the server in OpenStack, assigns a floating ip and the security
+// group and stores it. Whatever was created is removed again when a step fails.
+func Provision(ctx context.Context, request ProvisionRequest) (database.VirtualMachine, error) {
+	if len(request.ImageId) == 0 {
+		return database.VirtualMachine{}, fail("Missing server image id!", nil)
+	}
+	if len(request.ServerName) == 0 {
+		return database.VirtualMachine{}, fail("Missing server name!", nil)
+	}
+
+	imageInfo, err := storage.Images().GetImageByImageId(ctx, request.ImageId)
+	if err != nil {
+		return database.VirtualMachine{}, fail("Error while reading images!", err)
+	}
+
+	target, err := Place(ctx, request.Target, request.CourseCode, imageInfo.ImageId)
+	if err != nil {
+		return database.VirtualMachine{}, err
+	}
+
+	vm := database.VirtualMachine{
+		ServerImage:  imageInfo.ImageId,
+		ServerName:   request.ServerName,
+		ServerStatus: database.VirtualMachineStatusActive,
+		CourseCode:   request.CourseCode,
+		Target:       target.Name,
+		Members:      request.Members,
+		LeaseExpires: request.LeaseExpires,
+	}
+	if duration := LeaseDuration(); vm.LeaseExpires.IsZero() && duration > 0 {
+		vm.LeaseExpires = time.Now().Add(duration)
+	}
+
+	var userData []byte
+	if len(imageInfo.ImageConfig) > 0 {
+		userData = utils.GenerateUserData(imageInfo.ImageConfig, strings.Join(MemberIds(vm), ","))
+	}
+
+	metadata := membershipMetadata(vm)
+	metadata[MetadataImageId] = imageInfo.ImageId
+	metadata["VM_FLAVOR_ID"] = target.Flavor(imageInfo.ImageId)
+	metadata["VM_KEY_NAME"] = vm.ServerName
+	metadata["VM_VOLUME_SIZE"] = viper.GetString("IKT_STACK_VM_VOLUME_SIZE")
+	metadata["VM_NETWORK_ID"] = target.NetworkId
+	metadata["VM_FLOATING_NETWORK_ID"] = target.FloatingNetworkId
+	metadata[MetadataTarget] = target.Name
+	if len(request.CreatedBy) > 0 {
+		metadata[MetadataCreatedBy] = request.CreatedBy
+	}
+
+	blockDevices := []bootfromvolume.BlockDevice{
+		{
+			DeleteOnTermination: true,
+			DestinationType:     bootfromvolume.DestinationVolume,
+			SourceType:          bootfromvolume.SourceImage,
+			UUID:                imageInfo.ImageId,
+			VolumeSize:          viper.GetInt("IKT_STACK_VM_VOLUME_SIZE"),
+		},
+	}
+
+	serverCreateOpts := servers.CreateOpts{
+		Name:      vm.ServerName,
+		FlavorRef: target.Flavor(imageInfo.ImageId),
+		UserData:  userData,
+		Networks: []servers.Network{
+			{
+				UUID: target.NetworkId,
+			},
+		},
+		Metadata: metadata,
+	}
+
+	serverCreateOptsExt := keypairs.CreateOptsExt{
+		CreateOptsBuilder: serverCreateOpts,
+		KeyName:           target.KeyName,
+	}
+
+	createOpts := bootfromvolume.CreateOptsExt{
+		CreateOptsBuilder: serverCreateOptsExt,
+		BlockDevice:       blockDevices,
+	}
+
+	client, err := gopher.Compute(ctx, target.Name)
+	if err != nil {
+		return vm, failOpenStack("Could not connect to OpenStack!", err)
+	}
+
+	step := metrics.StartStep("create_server")
+	server, err := bootfromvolume.Create(client, createOpts).Extract()
+	step.Done(err)
+	if err != nil {
+		return vm, failOpenStack("Unable to create a virtual machine!", err)
+	}
+	ctx = logging.With(ctx, logging.VmId, server.ID)
+	logging.Info(ctx, "created server", "server_name", vm.ServerName, "image_id", vm.ServerImage, "target", target.Name)
+
+	// Wait until server status changes to "active"
+	step = metrics.StartStep("wait_active")
+	err = servers.WaitForStatus(client, server.ID, database.VirtualMachineStatusActive, database.ServerStatusPollingTime)
+	step.Done(err)
+	if err != nil {
+		discard(ctx, client, target.Name, server.ID, nil)
+		return vm, failOpenStack("Error while waiting for virtual machine to become active!", err)
+	}
+
+	// A free floating ip of the target is reused before a new one is allocated.
+	step = metrics.StartStep("create_floating_ip")
+	fip, err := allocateFloatingIp(ctx, client, target, server.ID)
+	step.Done(err)
+	if err != nil {
+		discard(ctx, client, target.Name, server.ID, nil)
+		return vm, failOpenStack("Error while creating floating ip for virtual machine!", err)
+	}
+
+	associateOpts := floatingips.AssociateOpts{
+		FloatingIP: fip.IP,
+		FixedIP:    fip.FixedIP,
+	}
+
+	step = metrics.StartStep("associate_floating_ip")
+	err = floatingips.AssociateInstance(client, server.ID, associateOpts).ExtractErr()
+	step.Done(err)
+	if err != nil {
+		discard(ctx, client, target.Name, server.ID, fip)
+		return vm, failOpenStack("Error while assigning floating ip to virtual machine!", err)
+	}
+
+	step = metrics.StartStep("add_security_group")
+	err = secgroups.AddServer(client, server.ID, target.SecurityGroupId).ExtractErr()
+	step.Done(err)
+	if err != nil {
+		discard(ctx, client, target.Name, server.ID, fip)
+		return vm, failOpenStack("Error while adding a network security group to a virtual machine!", err)
+	}
+
+	vm.ServerId = server.ID
+	vm.ServerIp = fip.IP
+
+	// If for any reason a server could not be stored in the database, remove it from bare metal.
+	step = metrics.StartStep("save")
+	err = storage.Vms().InsertVm(ctx, vm)
+	step.Done(err)
+	if err != nil {
+		discard(ctx, client, target.Name, server.ID, fip)
+		return vm, fail("Unable to save virtual machine!", err)
+	}
+
+	database.FillMembers(&vm)
+	return vm, nil
+}
+
+// Order provisions a virtual machine and tells the members it is ready.
+func Order(ctx context.Context, request ProvisionRequest) (database.VirtualMachine, error) {
+	vm, err := Provision(ctx, request)
+	if err != nil {
+		return vm, err
+	}
+
+	notify.Notify(ctx, notify.EventVmReady, MemberIds(vm), notify.Data{ServerName: vm.ServerName, ServerIp: vm.ServerIp})
+	webhooks.Publish(ctx, webhooks.EventVmCreated, webhooks.VmPayload{
+		ServerId:    vm.ServerId,
+		ServerName:  vm.ServerName,
+		ServerIp:    vm.ServerIp,
+		ServerImage: vm.ServerImage,
+		CourseCode:  vm.CourseCode,
+		Users:       MemberIds(vm),
+	})
+
+	return vm, nil
+}
+
+// deleteServer deletes a server, and force deletes it when a normal delete is refused.
+func deleteServer(client *gophercloud.ServiceClient, serverId string) error {
+	if err := servers.Delete(client, serverId).ExtractErr(); err != nil {
+		return servers.ForceDelete(client, serverId).ExtractErr()
+	}
+	return nil
+}
+
+// DeleteVm removes the server from OpenStack and the database and tells the members.
+func DeleteVm(ctx context.Context, vm database.VirtualMachine) error {
+	ctx = logging.With(ctx, logging.VmId, vm.ServerId)
+	client, err := gopher.Compute(ctx, vm.Target)
+	if err != nil {
+		return failOpenStack("Could not connect to OpenStack!", err)
+	}
+
+	// Adopted servers may have no floating ip.
+	if len(vm.ServerIp) > 0 {
+		disassociateOpts := floatingips.DisassociateOpts{
+			FloatingIP: vm.ServerIp,
+		}
+
+		if err := floatingips.DisassociateInstance(client, vm.ServerId, disassociateOpts).ExtractErr(); err != nil {
+			return failOpenStack("Error unassigning floating ip from virtual machine!", err)
+		}
+	}
+
+	if err := deleteServer(client, vm.ServerId); err != nil {
+		return failOpenStack("Unable to delete virtual machine!", err)
+	}
+	releaseVmFloatingIp(ctx, client, vm)
+
+	if _, err := storage.Vms().DeleteVMById(ctx, vm.ServerId); err != nil {
+		return fail("Error deleting virtual machine from database!", err)
+	}
+
+	logging.Info(ctx, "deleted virtual machine", "server_name", vm.ServerName)
+	notify.Notify(ctx, notify.EventVmDeleted, MemberIds(vm), notify.Data{ServerName: vm.ServerName, ServerIp: vm.ServerIp})
+	webhooks.Publish(ctx, webhooks.EventVmDeleted, webhooks.VmPayload{ServerId: vm.ServerId, ServerName: vm.ServerName, ServerIp: vm.ServerIp})
+
+	return nil
+}
+
+// Respawn replaces the server of a virtual machine with a new one built from the same image for the same members.
+func Respawn(ctx context.Context, vm database.VirtualMachine) (database.VirtualMachine, error) {
+	client, err := gopher.Compute(ctx, vm.Target)
+	if err != nil {
+		return vm, failOpenStack("Could not connect to OpenStack!", err)
+	}
+
+	// Adopted servers may have no floating ip.
+	if len(vm.ServerIp) > 0 {
+		disassociateOpts := floatingips.DisassociateOpts{
+			FloatingIP: vm.ServerIp,
+		}
+
+		if err := floatingips.DisassociateInstance(client, vm.ServerId, disassociateOpts).ExtractErr(); err != nil {
+			return vm, failOpenStack("Error unassigning floating ip from virtual machine!", err)
+		}
+	}
+
+	// The new server keeps the creator of the old one, it is only known from its metadata.
+	var createdBy string
+	if metadata, err := servers.Metadata(client, vm.ServerId).Extract(); err == nil {
+		createdBy = metadata[MetadataCreatedBy]
+	}
+
+	created, err := Provision(ctx, ProvisionRequest{
+		ServerName:   strings.ToUpper(vm.ServerName),
+		ImageId:      vm.ServerImage,
+		CourseCode:   vm.CourseCode,
+		Members:      vm.Members,
+		CreatedBy:    createdBy,
+		Target:       vm.Target,
+		LeaseExpires: vm.LeaseExpires,
+	})
+	if err != nil {
+		// The old server stays, it gets its floating ip back.
+		if len(vm.ServerIp) > 0 {
+			associateOpts := floatingips.AssociateOpts{
+				FloatingIP: vm.ServerIp,
+			}
+
+			if err := floatingips.AssociateInstance(client, vm.ServerId, associateOpts).ExtractErr(); err != nil {
+				logging.Error(ctx, "could not give floating ip back to virtual machine", err, "floating_ip", vm.ServerIp)
+			}
+		}
+		return vm, err
+	}
+
+	if err := deleteServer(client, vm.ServerId); err != nil {
+		return created, failOpenStack("Unable to delete virtual machine!", err)
+	}
+	releaseVmFloatingIp(ctx, client, vm)
+
+	if _, err := storage.Vms().DeleteVMById(ctx, vm.ServerId); err != nil {
+		return created, fail("Error deleting virtual machine from database!", err)
+	}
+
+	notify.Notify(ctx, notify.EventVmRespawned, MemberIds(vm), notify.Data{ServerName: created.ServerName, ServerIp: created.ServerIp})
+	webhooks.Publish(ctx, webhooks.EventVmRespawned, webhooks.VmPayload{
+		ServerId:    created.ServerId,
+		PreviousId:  vm.ServerId,
+		ServerName:  created.ServerName,
+		ServerIp:    created.ServerIp,
+		ServerImage: created.ServerImage,
+		CourseCode:  created.CourseCode,
+		Users:       MemberIds(vm),
+	})
+
+	return created, nil
+}
+
+// ReconcileVm compares a virtual machine with its server in OpenStack. The record is
+// removed when the server no longer exists, otherwise the stored status is updated.
+func ReconcileVm(ctx context.Context, vm database.VirtualMachine) (ReconcileResult, error) {
+	result := ReconcileResult{ServerId: vm.ServerId, ServerName: vm.ServerName, PreviousStatus: vm.ServerStatus}
+
+	client, err := gopher.Compute(ctx, vm.Target)
+	if err != nil {
+		return result, failOpenStack("Could not connect to OpenStack!", err)
+	}
+
+	server, err := servers.Get(client, vm.ServerId).Extract()
+	if _, ok := err.(gophercloud.ErrDefault404); ok {
+		// OpenStack disassociates the floating ip of a deleted server, but keeps it allocated.
+		releaseVmFloatingIp(ctx, client, vm)
+
+		if _, err := storage.Vms().DeleteVMById(ctx, vm.ServerId); err != nil {
+			return result, fail("Error deleting virtual machine from database!", err)
+		}
+
+		notify.Notify(ctx, notify.EventVmDeletedByReconciler, MemberIds(vm), notify.Data{ServerName: vm.ServerName})
+		webhooks.Publish(ctx, webhooks.EventVmDeleted, webhooks.VmPayload{ServerId: vm.ServerId, ServerName: vm.ServerName, ServerIp: vm.ServerIp})
+
+		result.Action = ReconcileDeleted
+		return result, nil
+	}
+	if err != nil {
+		return result, failOpenStack("Error reading virtual machine status!", err)
+	}
+
+	result.Status = server.Status
+	if server.Status == vm.ServerStatus {
+		result.Action = ReconcileUnchanged
+		return result, nil
+	}
+
+	if err := storage.Vms().UpdateVMStatusById(ctx, vm.ServerId, server.Status); err != nil {
+		return result, fail("Error updating virtual machine status!", err)
+	}
+
+	vm.ServerStatus = server.Status
+	PublishStatusChanged(ctx, vm, result.PreviousStatus)
+
+	result.Action = ReconcileUpdated
+	return result, nil
+}
+
+// ReconcileVms reconciles every stored virtual machine, a failure is reported in its result.
+func ReconcileVms(ctx context.Context) ([]ReconcileResult, error) {
+	vms, err := storage.Vms().GetVMS(ctx)
+	if err != nil {
+		return nil, fail("Could not load virtual machines!", err)
+	}
+
+	results := []ReconcileResult{}
+	for _, v := range vms {
+		result, err := ReconcileVm(ctx, v)
+		if err != nil {
+			result.Action = ReconcileFailed
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+
+	return results, nil
+}
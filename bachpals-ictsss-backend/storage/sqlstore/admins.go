@@ -0,0 +1,70 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/database"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/storage"
+)
+
+type adminStore struct {
+	s *Store
+}
+
+func (a adminStore) InsertAdmin(ctx context.Context, userId string, name string) error {
+	_, err := a.s.exec(ctx, a.s.db, "INSERT INTO administrators (user_id, name) VALUES (?, ?)", userId, name)
+	return err
+}
+
+func (a adminStore) ReadAdmins(ctx context.Context) ([]*database.Admin, error) {
+	rows, err := a.s.query(ctx, "SELECT user_id, name FROM administrators ORDER BY user_id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	admins := []*database.Admin{}
+	for rows.Next() {
+		var admin database.Admin
+		if err := rows.Scan(&admin.UserId, &admin.Name); err != nil {
+			return nil, err
+		}
+
+		admins = append(admins, &admin)
+	}
+
+	return admins, rows.Err()
+}
+
+func (a adminStore) ReadAdminById(ctx context.Context, userId string) (*database.Admin, error) {
+	var admin database.Admin
+	err := a.s.queryRow(ctx, "SELECT user_id, name FROM administrators WHERE user_id = ?", userId).Scan(&admin.UserId, &admin.Name)
+	if err == sql.ErrNoRows {
+		return nil, storage.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &admin, nil
+}
+
+func (a adminStore) DeleteAdminById(ctx context.Context, userId string) (int, error) {
+	res, err := a.s.exec(ctx, a.s.db, "DELETE FROM administrators WHERE user_id = ?", userId)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+func (a adminStore) UpdateAdminById(ctx context.Context, userId string, newUserId string, name string) error {
+	res, err := a.s.exec(ctx, a.s.db, "UPDATE administrators SET user_id = ?, name = ? WHERE user_id = ?", newUserId, name, userId)
+	if err != nil {
+		return err
+	}
+
+	return notFoundIfNone(res)
+}
@@ -0,0 +1,50 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+)
+
+const initializedKey = "initialized"
+
+type appStateStore struct {
+	s *Store
+}
+
+func (a appStateStore) IsInitialized(ctx context.Context) (bool, error) {
+	var value string
+	err := a.s.queryRow(ctx, "SELECT value FROM app_state WHERE name = ?", initializedKey).Scan(&value)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return value == "true", nil
+}
+
+func (a appStateStore) SetInitialized(ctx context.Context) error {
+	return a.s.withTx(ctx, func(tx *sql.Tx) error {
+		if _, err := a.s.exec(ctx, tx, "DELETE FROM app_state WHERE name = ?", initializedKey); err != nil {
+			return err
+		}
+
+		_, err := a.s.exec(ctx, tx, "INSERT INTO app_state (name, value) VALUES (?, ?)", initializedKey, "true")
+		return err
+	})
+}
+
+// Reset empties the tables, unlike the mongo backend the schema is kept so no new migration run is needed.
+func (a appStateStore) Reset(ctx context.Context) error {
+	tables := []string{"virtual_machine_members", "virtual_machines", "floating_ips", "administrators", "app_state"}
+
+	return a.s.withTx(ctx, func(tx *sql.Tx) error {
+		for _, v := range tables {
+			if _, err := tx.ExecContext(ctx, "DELETE FROM "+v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
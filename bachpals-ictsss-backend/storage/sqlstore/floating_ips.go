@@ -0,0 +1,75 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/database"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/storage"
+)
+
+type floatingIpStore struct {
+	s *Store
+}
+
+func (f floatingIpStore) SaveFloatingIp(ctx context.Context, ip database.FloatingIp) error {
+	return f.s.withTx(ctx, func(tx *sql.Tx) error {
+		if _, err := f.s.exec(ctx, tx, "DELETE FROM floating_ips WHERE id = ?", ip.Id); err != nil {
+			return err
+		}
+
+		_, err := f.s.exec(ctx, tx, "INSERT INTO floating_ips (id, ip, target, pool, server_id, allocated, updated) VALUES (?, ?, ?, ?, ?, ?, ?)",
+			ip.Id, ip.Ip, ip.Target, ip.Pool, ip.ServerId, ip.Allocated, ip.Updated)
+		return err
+	})
+}
+
+func (f floatingIpStore) GetFloatingIps(ctx context.Context) ([]database.FloatingIp, error) {
+	rows, err := f.s.query(ctx, "SELECT id, ip, target, pool, server_id, allocated, updated FROM floating_ips ORDER BY target, ip")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ips := []database.FloatingIp{}
+	for rows.Next() {
+		var ip database.FloatingIp
+		if err := rows.Scan(&ip.Id, &ip.Ip, &ip.Target, &ip.Pool, &ip.ServerId, &ip.Allocated, &ip.Updated); err != nil {
+			return nil, err
+		}
+
+		ips = append(ips, ip)
+	}
+
+	return ips, rows.Err()
+}
+
+func (f floatingIpStore) ClaimFloatingIp(ctx context.Context, target string, serverId string) (database.FloatingIp, error) {
+	var ip database.FloatingIp
+
+	err := f.s.withTx(ctx, func(tx *sql.Tx) error {
+		row := tx.QueryRowContext(ctx, f.s.rebind(`SELECT id, ip, target, pool, allocated FROM floating_ips
+			WHERE target = ? AND server_id = '' ORDER BY updated, id LIMIT 1`+f.s.skipLocked()), target)
+		if err := row.Scan(&ip.Id, &ip.Ip, &ip.Target, &ip.Pool, &ip.Allocated); err != nil {
+			return err
+		}
+
+		ip.ServerId, ip.Updated = serverId, time.Now()
+		res, err := f.s.exec(ctx, tx, "UPDATE floating_ips SET server_id = ?, updated = ? WHERE id = ? AND server_id = ''", ip.ServerId, ip.Updated, ip.Id)
+		if err != nil {
+			return err
+		}
+		return notFoundIfNone(res)
+	})
+	if err == sql.ErrNoRows {
+		return ip, storage.ErrNotFound
+	}
+
+	return ip, err
+}
+
+func (f floatingIpStore) DeleteFloatingIp(ctx context.Context, id string) error {
+	_, err := f.s.exec(ctx, f.s.db, "DELETE FROM floating_ips WHERE id = ?", id)
+	return err
+}
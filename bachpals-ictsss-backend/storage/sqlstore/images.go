@@ -0,0 +1,120 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/database"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/storage"
+)
+
+const imageColumns = "id, image_id, image_name, image_display_name, image_description, published, image_config, image_read_root_password"
+
+type imageStore struct {
+	s *Store
+}
+
+func (i imageStore) InsertImage(ctx context.Context, image database.Images) error {
+	_, err := i.s.exec(ctx, i.s.db, "INSERT INTO images ("+imageColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		newId(), image.ImageId, image.ImageName, image.ImageDisplayName, image.ImageDescription, image.Published, image.ImageConfig, image.ImageReadRootPassword)
+	return err
+}
+
+func scanImage(row interface{ Scan(...interface{}) error }) (*database.Images, error) {
+	var image database.Images
+	err := row.Scan(&image.Id, &image.ImageId, &image.ImageName, &image.ImageDisplayName, &image.ImageDescription,
+		&image.Published, &image.ImageConfig, &image.ImageReadRootPassword)
+	if err != nil {
+		return nil, err
+	}
+
+	return &image, nil
+}
+
+func (i imageStore) findImages(ctx context.Context, where string, args ...interface{}) ([]*database.Images, error) {
+	rows, err := i.s.query(ctx, "SELECT "+imageColumns+" FROM images "+where+" ORDER BY id", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	images := []*database.Images{}
+	for rows.Next() {
+		image, err := scanImage(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		images = append(images, image)
+	}
+
+	return images, rows.Err()
+}
+
+func (i imageStore) findImage(ctx context.Context, where string, args ...interface{}) (*database.Images, error) {
+	image, err := scanImage(i.s.queryRow(ctx, "SELECT "+imageColumns+" FROM images "+where+" ORDER BY id LIMIT 1", args...))
+	if err == sql.ErrNoRows {
+		return nil, storage.ErrNotFound
+	}
+
+	return image, err
+}
+
+func (i imageStore) GetImages(ctx context.Context) ([]*database.Images, error) {
+	return i.findImages(ctx, "")
+}
+
+// GetPublishedImages leaves out the same fields as the mongo projection, users only see the display fields.
+func (i imageStore) GetPublishedImages(ctx context.Context) ([]*database.Images, error) {
+	images, err := i.findImages(ctx, "WHERE published = ?", "true")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, v := range images {
+		v.Id = ""
+		v.ImageName = ""
+		v.ImageDescription = ""
+		v.ImageConfig = ""
+	}
+
+	return images, nil
+}
+
+func (i imageStore) GetImageByImageId(ctx context.Context, imageId string) (*database.Images, error) {
+	return i.findImage(ctx, "WHERE image_id = ?", imageId)
+}
+
+func (i imageStore) GetImageById(ctx context.Context, id string) (*database.Images, error) {
+	return i.findImage(ctx, "WHERE id = ?", id)
+}
+
+func (i imageStore) UpdateImageById(ctx context.Context, image database.Images) error {
+	res, err := i.s.exec(ctx, i.s.db, `UPDATE images SET image_id = ?, image_name = ?, image_display_name = ?,
+		image_description = ?, published = ?, image_config = ?, image_read_root_password = ? WHERE id = ?`,
+		image.ImageId, image.ImageName, image.ImageDisplayName, image.ImageDescription, image.Published, image.ImageConfig, image.ImageReadRootPassword, image.Id)
+	if err != nil {
+		return err
+	}
+
+	return notFoundIfNone(res)
+}
+
+func (i imageStore) DeleteImageById(ctx context.Context, id string) error {
+	_, err := i.s.exec(ctx, i.s.db, "DELETE FROM images WHERE id = ?", id)
+	return err
+}
+
+// notFoundIfNone returns storage.ErrNotFound when an update matched no rows.
+func notFoundIfNone(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return storage.ErrNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,360 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/storage"
+)
+
+// advisoryLockId keeps two postgres instances from migrating at the same time.
+const advisoryLockId = 7262431
+
+type migration struct {
+	version     int
+	description string
+	// up and down are run in one transaction, {timestamp} is replaced by the dialect's type.
+	up   []string
+	down []string
+}
+
+var migrations = []migration{
+	{
+		version:     1,
+		description: "create virtual machine, image, administrator and application state tables",
+		up: []string{
+			`CREATE TABLE virtual_machines (
+				server_id TEXT PRIMARY KEY,
+				server_ip TEXT NOT NULL DEFAULT '',
+				server_image TEXT NOT NULL DEFAULT '',
+				server_name TEXT NOT NULL DEFAULT '',
+				server_status TEXT NOT NULL DEFAULT '',
+				created {timestamp} NOT NULL,
+				course_code TEXT NOT NULL DEFAULT ''
+			)`,
+			`CREATE INDEX virtual_machines_course_code ON virtual_machines (course_code)`,
+			`CREATE TABLE virtual_machine_members (
+				server_id TEXT NOT NULL REFERENCES virtual_machines (server_id) ON DELETE CASCADE,
+				user_id TEXT NOT NULL,
+				role TEXT NOT NULL,
+				position INTEGER NOT NULL,
+				PRIMARY KEY (server_id, user_id)
+			)`,
+			`CREATE INDEX virtual_machine_members_user_id ON virtual_machine_members (user_id)`,
+			`CREATE TABLE images (
+				id TEXT PRIMARY KEY,
+				image_id TEXT NOT NULL,
+				image_name TEXT NOT NULL DEFAULT '',
+				image_display_name TEXT NOT NULL DEFAULT '',
+				image_description TEXT NOT NULL DEFAULT '',
+				published TEXT NOT NULL DEFAULT '',
+				image_config TEXT NOT NULL DEFAULT '',
+				image_read_root_password BOOLEAN NOT NULL DEFAULT FALSE
+			)`,
+			`CREATE INDEX images_image_id ON images (image_id)`,
+			`CREATE TABLE administrators (
+				user_id TEXT PRIMARY KEY,
+				name TEXT NOT NULL DEFAULT ''
+			)`,
+			`CREATE TABLE app_state (
+				name TEXT PRIMARY KEY,
+				value TEXT NOT NULL
+			)`,
+		},
+		down: []string{
+			`DROP TABLE app_state`,
+			`DROP TABLE administrators`,
+			`DROP TABLE images`,
+			`DROP TABLE virtual_machine_members`,
+			`DROP TABLE virtual_machines`,
+		},
+	},
+	{
+		version:     2,
+		description: "add the openstack target of virtual machines",
+		up: []string{
+			`ALTER TABLE virtual_machines ADD COLUMN target TEXT NOT NULL DEFAULT ''`,
+		},
+		down: []string{
+			`ALTER TABLE virtual_machines DROP COLUMN target`,
+		},
+	},
+	{
+		version:     3,
+		description: "create the floating ip table",
+		up: []string{
+			`CREATE TABLE floating_ips (
+				id TEXT PRIMARY KEY,
+				ip TEXT NOT NULL,
+				target TEXT NOT NULL,
+				pool TEXT NOT NULL DEFAULT '',
+				server_id TEXT NOT NULL DEFAULT '',
+				allocated {timestamp} NOT NULL,
+				updated {timestamp} NOT NULL
+			)`,
+			`CREATE INDEX floating_ips_target_server_id ON floating_ips (target, server_id, updated)`,
+		},
+		down: []string{
+			`DROP TABLE floating_ips`,
+		},
+	},
+	{
+		version:     4,
+		description: "add the lease of virtual machines",
+		up: []string{
+			`ALTER TABLE virtual_machines ADD COLUMN lease_expires {timestamp}`,
+			`ALTER TABLE virtual_machines ADD COLUMN lease_notice TEXT NOT NULL DEFAULT ''`,
+		},
+		down: []string{
+			`ALTER TABLE virtual_machines DROP COLUMN lease_notice`,
+			`ALTER TABLE virtual_machines DROP COLUMN lease_expires`,
+		},
+	},
+	{
+		version:     5,
+		description: "create the schedule, notification and webhook tables",
+		up: []string{
+			`CREATE TABLE schedules (
+				id TEXT PRIMARY KEY,
+				name TEXT NOT NULL DEFAULT '',
+				target_type TEXT NOT NULL,
+				target_id TEXT NOT NULL,
+				time_zone TEXT NOT NULL DEFAULT '',
+				start_cron TEXT NOT NULL DEFAULT '',
+				stop_cron TEXT NOT NULL DEFAULT '',
+				windows TEXT NOT NULL DEFAULT '[]',
+				enabled BOOLEAN NOT NULL DEFAULT FALSE,
+				created_by TEXT NOT NULL DEFAULT '',
+				last_run {timestamp}
+			)`,
+			`CREATE TABLE schedule_runs (
+				id TEXT PRIMARY KEY,
+				schedule_id TEXT NOT NULL,
+				action TEXT NOT NULL,
+				run_trigger TEXT NOT NULL DEFAULT '',
+				started {timestamp} NOT NULL,
+				finished {timestamp},
+				succeeded INTEGER NOT NULL DEFAULT 0,
+				skipped INTEGER NOT NULL DEFAULT 0,
+				failed INTEGER NOT NULL DEFAULT 0,
+				results TEXT NOT NULL DEFAULT '[]'
+			)`,
+			`CREATE INDEX schedule_runs_schedule_id ON schedule_runs (schedule_id, started)`,
+			`CREATE TABLE notification_outbox (
+				id TEXT PRIMARY KEY,
+				user_id TEXT NOT NULL,
+				event TEXT NOT NULL,
+				subject TEXT NOT NULL DEFAULT '',
+				text_body TEXT NOT NULL DEFAULT '',
+				html_body TEXT NOT NULL DEFAULT '',
+				status TEXT NOT NULL,
+				attempts INTEGER NOT NULL DEFAULT 0,
+				last_error TEXT NOT NULL DEFAULT '',
+				next_attempt {timestamp} NOT NULL,
+				created {timestamp} NOT NULL,
+				sent {timestamp}
+			)`,
+			`CREATE INDEX notification_outbox_status ON notification_outbox (status, next_attempt)`,
+			`CREATE TABLE notification_preferences (
+				user_id TEXT PRIMARY KEY,
+				disabled BOOLEAN NOT NULL DEFAULT FALSE,
+				opt_out TEXT NOT NULL DEFAULT '[]'
+			)`,
+			`CREATE TABLE webhooks (
+				id TEXT PRIMARY KEY,
+				url TEXT NOT NULL,
+				secret TEXT NOT NULL DEFAULT '',
+				description TEXT NOT NULL DEFAULT '',
+				events TEXT NOT NULL DEFAULT '[]',
+				enabled BOOLEAN NOT NULL DEFAULT FALSE,
+				created_by TEXT NOT NULL DEFAULT '',
+				created {timestamp} NOT NULL
+			)`,
+			`CREATE TABLE webhook_deliveries (
+				id TEXT PRIMARY KEY,
+				subscription_id TEXT NOT NULL,
+				event TEXT NOT NULL,
+				payload TEXT NOT NULL DEFAULT '',
+				status TEXT NOT NULL,
+				attempts INTEGER NOT NULL DEFAULT 0,
+				last_error TEXT NOT NULL DEFAULT '',
+				last_status_code INTEGER NOT NULL DEFAULT 0,
+				next_attempt {timestamp} NOT NULL,
+				created {timestamp} NOT NULL,
+				delivered {timestamp}
+			)`,
+			`CREATE INDEX webhook_deliveries_status ON webhook_deliveries (status, next_attempt)`,
+		},
+		down: []string{
+			`DROP TABLE webhook_deliveries`,
+			`DROP TABLE webhooks`,
+			`DROP TABLE notification_preferences`,
+			`DROP TABLE notification_outbox`,
+			`DROP TABLE schedule_runs`,
+			`DROP TABLE schedules`,
+		},
+	},
+}
+
+type migrator struct {
+	s *Store
+}
+
+func (m migrator) ensureTable(ctx context.Context) error {
+	_, err := m.s.db.ExecContext(ctx, m.s.sql(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		description TEXT NOT NULL,
+		applied {timestamp} NOT NULL
+	)`))
+	return err
+}
+
+// sql fills in the dialect specific column types.
+func (s *Store) sql(statement string) string {
+	return strings.ReplaceAll(statement, "{timestamp}", s.dialect.timestamp)
+}
+
+// lock takes a postgres advisory lock on a dedicated connection. SQLite needs no
+// lock, every migration starts by writing its schema_migrations row which makes a
+// second runner wait for, and then fail on, the first one.
+func (m migrator) lock(ctx context.Context) (func(), error) {
+	if m.s.dialect.name != postgres.name {
+		return func() {}, nil
+	}
+
+	conn, err := m.s.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", advisoryLockId); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return func() {
+		conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", advisoryLockId)
+		conn.Close()
+	}, nil
+}
+
+func (m migrator) applied(ctx context.Context) (map[int]time.Time, error) {
+	if err := m.ensureTable(ctx); err != nil {
+		return nil, err
+	}
+
+	rows, err := m.s.query(ctx, "SELECT version, applied FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		applied[version] = at
+	}
+
+	return applied, rows.Err()
+}
+
+func (m migrator) Up(ctx context.Context) ([]storage.MigrationState, error) {
+	release, err := m.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var done []storage.MigrationState
+	for _, v := range migrations {
+		if _, ok := applied[v.version]; ok {
+			continue
+		}
+
+		now := time.Now()
+		err := m.s.withTx(ctx, func(tx *sql.Tx) error {
+			_, err := m.s.exec(ctx, tx, "INSERT INTO schema_migrations (version, description, applied) VALUES (?, ?, ?)", v.version, v.description, now)
+			if err != nil {
+				return err
+			}
+
+			for _, statement := range v.up {
+				if _, err := tx.ExecContext(ctx, m.s.sql(statement)); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return done, fmt.Errorf("migration %d (%s): %w", v.version, v.description, err)
+		}
+
+		done = append(done, storage.MigrationState{Version: v.version, Description: v.description, Applied: now})
+	}
+
+	return done, nil
+}
+
+func (m migrator) Down(ctx context.Context) (*storage.MigrationState, error) {
+	release, err := m.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := len(migrations) - 1; i >= 0; i-- {
+		v := migrations[i]
+		if _, ok := applied[v.version]; !ok {
+			continue
+		}
+
+		err := m.s.withTx(ctx, func(tx *sql.Tx) error {
+			for _, statement := range v.down {
+				if _, err := tx.ExecContext(ctx, m.s.sql(statement)); err != nil {
+					return err
+				}
+			}
+
+			_, err := m.s.exec(ctx, tx, "DELETE FROM schema_migrations WHERE version = ?", v.version)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("migration %d (%s): %w", v.version, v.description, err)
+		}
+
+		return &storage.MigrationState{Version: v.version, Description: v.description}, nil
+	}
+
+	return nil, nil
+}
+
+func (m migrator) Status(ctx context.Context) ([]storage.MigrationState, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var states []storage.MigrationState
+	for _, v := range migrations {
+		states = append(states, storage.MigrationState{Version: v.version, Description: v.description, Applied: applied[v.version]})
+	}
+
+	return states, nil
+}
@@ -0,0 +1,114 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/database"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/storage"
+)
+
+type notificationStore struct {
+	s *Store
+}
+
+func (n notificationStore) InsertNotification(ctx context.Context, notification database.Notification) error {
+	id := notification.Id
+	if len(id) == 0 {
+		id = newId()
+	}
+
+	created := notification.Created
+	if created.IsZero() {
+		created = time.Now()
+	}
+
+	_, err := n.s.exec(ctx, n.s.db, `INSERT INTO notification_outbox
+		(id, user_id, event, subject, text_body, html_body, status, attempts, last_error, next_attempt, created, sent)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		id, notification.UserId, notification.Event, notification.Subject, notification.Text, notification.Html, notification.Status,
+		notification.Attempts, notification.LastError, notification.NextAttempt.UTC(), created.UTC(), nullTime(notification.Sent))
+	return err
+}
+
+func (n notificationStore) ClaimDueNotification(ctx context.Context, now time.Time, lease time.Duration) (*database.Notification, error) {
+	var notification database.Notification
+
+	err := n.s.withTx(ctx, func(tx *sql.Tx) error {
+		var sent sql.NullTime
+		row := tx.QueryRowContext(ctx, n.s.rebind(`SELECT id, user_id, event, subject, text_body, html_body, status, attempts, last_error, next_attempt, created, sent
+			FROM notification_outbox WHERE status IN (?, ?) AND next_attempt <= ? ORDER BY next_attempt, id LIMIT 1`+n.s.skipLocked()),
+			database.NotificationStatusPending, database.NotificationStatusSending, now.UTC())
+		err := row.Scan(&notification.Id, &notification.UserId, &notification.Event, &notification.Subject, &notification.Text, &notification.Html,
+			&notification.Status, &notification.Attempts, &notification.LastError, &notification.NextAttempt, &notification.Created, &sent)
+		if err != nil {
+			return err
+		}
+		if sent.Valid {
+			notification.Sent = sent.Time
+		}
+
+		notification.Status, notification.NextAttempt = database.NotificationStatusSending, now.Add(lease).UTC()
+		res, err := n.s.exec(ctx, tx, "UPDATE notification_outbox SET status = ?, next_attempt = ? WHERE id = ? AND status IN (?, ?) AND next_attempt <= ?",
+			notification.Status, notification.NextAttempt, notification.Id, database.NotificationStatusPending, database.NotificationStatusSending, now.UTC())
+		if err != nil {
+			return err
+		}
+		return notFoundIfNone(res)
+	})
+	if err == sql.ErrNoRows {
+		return nil, storage.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &notification, nil
+}
+
+func (n notificationStore) UpdateNotificationDelivery(ctx context.Context, notification database.Notification) error {
+	res, err := n.s.exec(ctx, n.s.db, "UPDATE notification_outbox SET status = ?, attempts = ?, last_error = ?, next_attempt = ?, sent = ? WHERE id = ?",
+		notification.Status, notification.Attempts, notification.LastError, notification.NextAttempt.UTC(), nullTime(notification.Sent), notification.Id)
+	if err != nil {
+		return err
+	}
+
+	return notFoundIfNone(res)
+}
+
+func (n notificationStore) GetNotificationPreferences(ctx context.Context, userId string) (*database.NotificationPreferences, error) {
+	preferences := database.NotificationPreferences{UserId: userId, OptOut: []string{}}
+
+	var optOut string
+	err := n.s.queryRow(ctx, "SELECT disabled, opt_out FROM notification_preferences WHERE user_id = ?", userId).Scan(&preferences.Disabled, &optOut)
+	if err == sql.ErrNoRows {
+		return &preferences, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := fromJson(optOut, &preferences.OptOut); err != nil {
+		return nil, err
+	}
+
+	return &preferences, nil
+}
+
+func (n notificationStore) UpdateNotificationPreferences(ctx context.Context, preferences database.NotificationPreferences) error {
+	optOut, err := toJson(preferences.OptOut)
+	if err != nil {
+		return err
+	}
+
+	return n.s.withTx(ctx, func(tx *sql.Tx) error {
+		if _, err := n.s.exec(ctx, tx, "DELETE FROM notification_preferences WHERE user_id = ?", preferences.UserId); err != nil {
+			return err
+		}
+
+		_, err := n.s.exec(ctx, tx, "INSERT INTO notification_preferences (user_id, disabled, opt_out) VALUES (?, ?, ?)",
+			preferences.UserId, preferences.Disabled, optOut)
+		return err
+	})
+}
@@ -0,0 +1,179 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/database"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/storage"
+)
+
+type scheduleStore struct {
+	s *Store
+}
+
+const scheduleColumns = "id, name, target_type, target_id, time_zone, start_cron, stop_cron, windows, enabled, created_by, last_run"
+
+func (sc scheduleStore) InsertSchedule(ctx context.Context, schedule database.Schedule) (string, error) {
+	windows, err := toJson(schedule.Windows)
+	if err != nil {
+		return "", err
+	}
+
+	id := newId()
+	_, err = sc.s.exec(ctx, sc.s.db, "INSERT INTO schedules ("+scheduleColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		id, schedule.Name, schedule.TargetType, schedule.TargetId, schedule.TimeZone, schedule.StartCron, schedule.StopCron,
+		windows, schedule.Enabled, schedule.CreatedBy, nullTime(time.Now()))
+	if err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanSchedule(row scanner) (*database.Schedule, error) {
+	var schedule database.Schedule
+	var windows string
+	var lastRun sql.NullTime
+
+	err := row.Scan(&schedule.Id, &schedule.Name, &schedule.TargetType, &schedule.TargetId, &schedule.TimeZone,
+		&schedule.StartCron, &schedule.StopCron, &windows, &schedule.Enabled, &schedule.CreatedBy, &lastRun)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := fromJson(windows, &schedule.Windows); err != nil {
+		return nil, err
+	}
+	if lastRun.Valid {
+		schedule.LastRun = lastRun.Time
+	}
+
+	return &schedule, nil
+}
+
+func (sc scheduleStore) GetSchedules(ctx context.Context) ([]*database.Schedule, error) {
+	rows, err := sc.s.query(ctx, "SELECT "+scheduleColumns+" FROM schedules ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	schedules := []*database.Schedule{}
+	for rows.Next() {
+		schedule, err := scanSchedule(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		schedules = append(schedules, schedule)
+	}
+
+	return schedules, rows.Err()
+}
+
+func (sc scheduleStore) GetScheduleById(ctx context.Context, id string) (*database.Schedule, error) {
+	schedule, err := scanSchedule(sc.s.queryRow(ctx, "SELECT "+scheduleColumns+" FROM schedules WHERE id = ?", id))
+	if err == sql.ErrNoRows {
+		return nil, storage.ErrNotFound
+	}
+
+	return schedule, err
+}
+
+func (sc scheduleStore) UpdateScheduleById(ctx context.Context, schedule database.Schedule) error {
+	windows, err := toJson(schedule.Windows)
+	if err != nil {
+		return err
+	}
+
+	res, err := sc.s.exec(ctx, sc.s.db, `UPDATE schedules SET name = ?, target_type = ?, target_id = ?, time_zone = ?,
+		start_cron = ?, stop_cron = ?, windows = ?, enabled = ? WHERE id = ?`,
+		schedule.Name, schedule.TargetType, schedule.TargetId, schedule.TimeZone, schedule.StartCron, schedule.StopCron,
+		windows, schedule.Enabled, schedule.Id)
+	if err != nil {
+		return err
+	}
+
+	return notFoundIfNone(res)
+}
+
+func (sc scheduleStore) ClaimScheduleRun(ctx context.Context, id string, lastRun time.Time, now time.Time) (bool, error) {
+	query, args := "UPDATE schedules SET last_run = ? WHERE id = ? AND last_run = ?", []interface{}{nullTime(now), id, nullTime(lastRun)}
+	if lastRun.IsZero() {
+		query, args = "UPDATE schedules SET last_run = ? WHERE id = ? AND last_run IS NULL", args[:2]
+	}
+
+	res, err := sc.s.exec(ctx, sc.s.db, query, args...)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+func (sc scheduleStore) DeleteScheduleById(ctx context.Context, id string) error {
+	res, err := sc.s.exec(ctx, sc.s.db, "DELETE FROM schedules WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+
+	return notFoundIfNone(res)
+}
+
+func (sc scheduleStore) InsertScheduleRun(ctx context.Context, run database.ScheduleRun) error {
+	results, err := toJson(run.Results)
+	if err != nil {
+		return err
+	}
+
+	id := run.Id
+	if len(id) == 0 {
+		id = newId()
+	}
+
+	_, err = sc.s.exec(ctx, sc.s.db, `INSERT INTO schedule_runs
+		(id, schedule_id, action, run_trigger, started, finished, succeeded, skipped, failed, results)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		id, run.ScheduleId, run.Action, run.Trigger, run.Started.UTC(), nullTime(run.Finished), run.Succeeded, run.Skipped, run.Failed, results)
+	return err
+}
+
+func (sc scheduleStore) GetScheduleRuns(ctx context.Context, scheduleId string, limit int) ([]*database.ScheduleRun, error) {
+	rows, err := sc.s.query(ctx, `SELECT id, schedule_id, action, run_trigger, started, finished, succeeded, skipped, failed, results
+		FROM schedule_runs WHERE schedule_id = ? ORDER BY started DESC, id DESC LIMIT ?`, scheduleId, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	runs := []*database.ScheduleRun{}
+	for rows.Next() {
+		var run database.ScheduleRun
+		var finished sql.NullTime
+		var results string
+
+		err := rows.Scan(&run.Id, &run.ScheduleId, &run.Action, &run.Trigger, &run.Started, &finished,
+			&run.Succeeded, &run.Skipped, &run.Failed, &results)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := fromJson(results, &run.Results); err != nil {
+			return nil, err
+		}
+		if finished.Valid {
+			run.Finished = finished.Time
+		}
+
+		runs = append(runs, &run)
+	}
+
+	return runs, rows.Err()
+}
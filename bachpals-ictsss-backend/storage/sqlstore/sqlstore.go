@@ -0,0 +1,173 @@
+// Package sqlstore is a storage backend on database/sql. It registers the
+// "sqlite" and "postgres" backends, IKT_STACK_STORAGE_DSN is passed to the driver.
+package sqlstore
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/storage"
+)
+
+const defaultSqliteDsn = "file:ictsss.db?_busy_timeout=5000"
+
+// dialect holds what differs between the supported databases.
+type dialect struct {
+	name       string
+	driver     string
+	timestamp  string
+	positional bool
+	// forUpdate locks selected rows until the transaction ends, SQLite has a single writer and needs no lock.
+	forUpdate string
+}
+
+var sqlite = dialect{name: "sqlite", driver: "sqlite3", timestamp: "TIMESTAMP"}
+var postgres = dialect{name: "postgres", driver: "postgres", timestamp: "TIMESTAMPTZ", positional: true, forUpdate: " FOR UPDATE"}
+
+func init() {
+	storage.Register(sqlite.name, func(ctx context.Context, dsn string) (storage.Store, error) {
+		if len(dsn) == 0 {
+			dsn = defaultSqliteDsn
+		}
+		return Open(ctx, sqlite, dsn)
+	})
+	storage.Register(postgres.name, func(ctx context.Context, dsn string) (storage.Store, error) {
+		if len(dsn) == 0 {
+			return nil, fmt.Errorf("IKT_STACK_STORAGE_DSN is required for postgres")
+		}
+		return Open(ctx, postgres, dsn)
+	})
+}
+
+// Store implements storage.Store on a sql database.
+type Store struct {
+	db      *sql.DB
+	dialect dialect
+}
+
+func Open(ctx context.Context, d dialect, dsn string) (*Store, error) {
+	db, err := sql.Open(d.driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	if d.name == sqlite.name {
+		// SQLite allows one writer at a time, and every connection to :memory: is a new database.
+		db.SetMaxOpenConns(1)
+	}
+
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &Store{db: db, dialect: d}, nil
+}
+
+func (s *Store) Vms() storage.VmStore                     { return vmStore{s} }
+func (s *Store) Images() storage.ImageStore               { return imageStore{s} }
+func (s *Store) Admins() storage.AdminStore               { return adminStore{s} }
+func (s *Store) AppState() storage.AppStateStore          { return appStateStore{s} }
+func (s *Store) FloatingIps() storage.FloatingIpStore     { return floatingIpStore{s} }
+func (s *Store) Schedules() storage.ScheduleStore         { return scheduleStore{s} }
+func (s *Store) Notifications() storage.NotificationStore { return notificationStore{s} }
+func (s *Store) Webhooks() storage.WebhookStore           { return webhookStore{s} }
+func (s *Store) Migrator() storage.Migrator               { return migrator{s} }
+func (s *Store) Ping(ctx context.Context) error           { return s.db.PingContext(ctx) }
+func (s *Store) Close(ctx context.Context) error          { return s.db.Close() }
+
+// rebind turns the ? placeholders used in this package into $1, $2... for postgres.
+func (s *Store) rebind(query string) string {
+	if !s.dialect.positional {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			fmt.Fprintf(&b, "$%d", n)
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+func (s *Store) exec(ctx context.Context, e execer, query string, args ...interface{}) (sql.Result, error) {
+	return e.ExecContext(ctx, s.rebind(query), args...)
+}
+
+func (s *Store) query(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return s.db.QueryContext(ctx, s.rebind(query), args...)
+}
+
+func (s *Store) queryRow(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return s.db.QueryRowContext(ctx, s.rebind(query), args...)
+}
+
+// withTx runs fn in a transaction, it is rolled back when fn returns an error.
+func (s *Store) withTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// skipLocked makes a claim skip the rows other claims have locked, so concurrent claims
+// get different rows on postgres.
+func (s *Store) skipLocked() string {
+	if len(s.dialect.forUpdate) == 0 {
+		return ""
+	}
+	return s.dialect.forUpdate + " SKIP LOCKED"
+}
+
+// nullTime stores a zero time as NULL. Times are kept in UTC so SQLite, which compares
+// them as text, orders them right.
+func nullTime(t time.Time) sql.NullTime {
+	if t.IsZero() {
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: t.UTC(), Valid: true}
+}
+
+// toJson and fromJson keep the lists of a row in a TEXT column.
+func toJson(v interface{}) (string, error) {
+	b, err := json.Marshal(v)
+	return string(b), err
+}
+
+func fromJson(s string, v interface{}) error {
+	if len(s) == 0 {
+		return nil
+	}
+	return json.Unmarshal([]byte(s), v)
+}
+
+// newId returns an id in the same format as a mongo ObjectID, the frontend passes them around as strings.
+func newId() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,378 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/database"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/storage"
+)
+
+type vmStore struct {
+	s *Store
+}
+
+func (v vmStore) InsertVm(ctx context.Context, vm database.VirtualMachine) error {
+	created := vm.Created
+	if created.IsZero() {
+		created = time.Now()
+	}
+
+	// Without a lease the column stays NULL.
+	var leaseExpires sql.NullTime
+	if !vm.LeaseExpires.IsZero() {
+		leaseExpires = sql.NullTime{Time: vm.LeaseExpires, Valid: true}
+	}
+
+	return v.s.withTx(ctx, func(tx *sql.Tx) error {
+		_, err := v.s.exec(ctx, tx, `INSERT INTO virtual_machines
+			(server_id, server_ip, server_image, server_name, server_status, created, course_code, target, lease_expires, lease_notice)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			vm.ServerId, vm.ServerIp, vm.ServerImage, vm.ServerName, vm.ServerStatus, created, vm.CourseCode, vm.Target, leaseExpires, vm.LeaseNotice)
+		if err != nil {
+			return err
+		}
+
+		for i, member := range vm.Members {
+			_, err := v.s.exec(ctx, tx, "INSERT INTO virtual_machine_members (server_id, user_id, role, position) VALUES (?, ?, ?, ?)",
+				vm.ServerId, member.UserId, member.Role, i)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// findVms reads the virtual machines matching where with their members in a single query,
+// the image display name and root password flag are joined in from the images table.
+func (v vmStore) findVms(ctx context.Context, where string, args ...interface{}) ([]database.VirtualMachine, error) {
+	return v.findVmsOrdered(ctx, where, "v.created, v.server_id", args...)
+}
+
+func (v vmStore) findVmsOrdered(ctx context.Context, where string, order string, args ...interface{}) ([]database.VirtualMachine, error) {
+	rows, err := v.s.query(ctx, `SELECT v.server_id, v.server_ip, v.server_image, v.server_name, v.server_status, v.created, v.course_code, v.target,
+			v.lease_expires, v.lease_notice,
+			COALESCE(i.image_display_name, ''), COALESCE(i.image_read_root_password, FALSE),
+			COALESCE(m.user_id, ''), COALESCE(m.role, '')
+		FROM virtual_machines v
+		LEFT JOIN images i ON i.id = (SELECT id FROM images WHERE image_id = v.server_image ORDER BY id LIMIT 1)
+		LEFT JOIN virtual_machine_members m ON m.server_id = v.server_id
+		`+where+`
+		ORDER BY `+order+`, m.position`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	virtualMachines := []database.VirtualMachine{}
+	for rows.Next() {
+		var vm database.VirtualMachine
+		var member database.VirtualMachineMember
+		var leaseExpires sql.NullTime
+		err := rows.Scan(&vm.ServerId, &vm.ServerIp, &vm.ServerImage, &vm.ServerName, &vm.ServerStatus, &vm.Created, &vm.CourseCode, &vm.Target,
+			&leaseExpires, &vm.LeaseNotice, &vm.ImageDisplayName, &vm.ImageReadRootPassword, &member.UserId, &member.Role)
+		if err != nil {
+			return nil, err
+		}
+		vm.LeaseExpires = leaseExpires.Time
+
+		last := len(virtualMachines) - 1
+		if last < 0 || virtualMachines[last].ServerId != vm.ServerId {
+			virtualMachines = append(virtualMachines, vm)
+			last++
+		}
+
+		if len(member.UserId) > 0 {
+			virtualMachines[last].Members = append(virtualMachines[last].Members, member)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range virtualMachines {
+		database.FillMembers(&virtualMachines[i])
+	}
+
+	return virtualMachines, nil
+}
+
+func (v vmStore) GetVMS(ctx context.Context) ([]database.VirtualMachine, error) {
+	return v.findVms(ctx, "")
+}
+
+// vmQueryWhere returns the conditions for the filters in query and their arguments.
+func vmQueryWhere(query storage.VmQuery) ([]string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+	add := func(condition string, values ...interface{}) {
+		conditions = append(conditions, condition)
+		args = append(args, values...)
+	}
+
+	if len(query.Status) > 0 {
+		add("v.server_status = ?", query.Status)
+	}
+	if len(query.ImageId) > 0 {
+		add("v.server_image = ?", query.ImageId)
+	}
+	if len(query.CourseCode) > 0 {
+		add("v.course_code = ?", query.CourseCode)
+	}
+	if len(query.Target) > 0 {
+		add("v.target = ?", query.Target)
+	}
+	if len(query.OwnerId) > 0 {
+		add("v.server_id IN (SELECT server_id FROM virtual_machine_members WHERE user_id = ? AND role = ?)", query.OwnerId, database.VirtualMachineRoleOwner)
+	}
+	if len(query.Name) > 0 {
+		pattern := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(strings.ToLower(query.Name))
+		add(`LOWER(v.server_name) LIKE ? ESCAPE '\'`, "%"+pattern+"%")
+	}
+	if !query.CreatedFrom.IsZero() {
+		add("v.created >= ?", query.CreatedFrom)
+	}
+	if !query.CreatedTo.IsZero() {
+		add("v.created < ?", query.CreatedTo)
+	}
+
+	return conditions, args
+}
+
+func whereClause(conditions []string) string {
+	if len(conditions) == 0 {
+		return ""
+	}
+	return "WHERE " + strings.Join(conditions, " AND ")
+}
+
+// ListVms counts the matches and then reads one page of them, the page continues after
+// the cursor by comparing the sort column and then the server id.
+func (v vmStore) ListVms(ctx context.Context, query storage.VmQuery) (storage.VmPage, error) {
+	cursor, err := query.Normalize()
+	if err != nil {
+		return storage.VmPage{}, err
+	}
+
+	conditions, args := vmQueryWhere(query)
+
+	var page storage.VmPage
+	if err := v.s.queryRow(ctx, "SELECT COUNT(*) FROM virtual_machines v "+whereClause(conditions), args...).Scan(&page.Total); err != nil {
+		return storage.VmPage{}, err
+	}
+
+	// The sort key is one of storage.VmSortKeys, which are all column names.
+	column := "v." + query.Sort
+	direction, after := "ASC", ">"
+	if query.Descending {
+		direction, after = "DESC", "<"
+	}
+
+	if cursor != nil {
+		var value interface{} = cursor.Value
+		if query.Sort == storage.VmSortCreated {
+			value = cursor.Created
+		}
+		conditions = append(conditions, "("+column+" "+after+" ? OR ("+column+" = ? AND v.server_id "+after+" ?))")
+		args = append(args, value, value, cursor.ServerId)
+	}
+
+	order := column + " " + direction + ", v.server_id " + direction
+	args = append(args, query.Limit+1)
+	page.Vms, err = v.findVmsOrdered(ctx,
+		"WHERE v.server_id IN (SELECT v.server_id FROM virtual_machines v "+whereClause(conditions)+" ORDER BY "+order+" LIMIT ?)",
+		order, args...)
+	if err != nil {
+		return storage.VmPage{}, err
+	}
+
+	if len(page.Vms) > query.Limit {
+		page.Vms = page.Vms[:query.Limit]
+		page.NextCursor = query.NextCursor(page.Vms[query.Limit-1])
+	}
+
+	return page, nil
+}
+
+func (v vmStore) GetVMByUserId(ctx context.Context, userId string) ([]database.VirtualMachine, error) {
+	return v.findVms(ctx, "WHERE v.server_id IN (SELECT server_id FROM virtual_machine_members WHERE user_id = ?)", userId)
+}
+
+func (v vmStore) GetVMByCourseCode(ctx context.Context, courseCode string) ([]database.VirtualMachine, error) {
+	return v.findVms(ctx, "WHERE v.course_code = ?", courseCode)
+}
+
+func (v vmStore) GetVMById(ctx context.Context, serverId string) (database.VirtualMachine, error) {
+	virtualMachines, err := v.findVms(ctx, "WHERE v.server_id = ?", serverId)
+	if err != nil {
+		return database.VirtualMachine{}, err
+	}
+
+	if len(virtualMachines) == 0 {
+		return database.VirtualMachine{}, storage.ErrNotFound
+	}
+
+	return virtualMachines[0], nil
+}
+
+func (v vmStore) UpdateVMStatusById(ctx context.Context, serverId string, status string) error {
+	_, err := v.s.exec(ctx, v.s.db, "UPDATE virtual_machines SET server_status = ? WHERE server_id = ?", status, serverId)
+	return err
+}
+
+func (v vmStore) UpdateVm(ctx context.Context, vm database.VirtualMachine) error {
+	return v.s.withTx(ctx, func(tx *sql.Tx) error {
+		res, err := v.s.exec(ctx, tx, `UPDATE virtual_machines
+			SET server_ip = ?, server_image = ?, server_name = ?, server_status = ?, course_code = ?, target = ?
+			WHERE server_id = ?`,
+			vm.ServerIp, vm.ServerImage, vm.ServerName, vm.ServerStatus, vm.CourseCode, vm.Target, vm.ServerId)
+		if err != nil {
+			return err
+		}
+
+		updated, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if updated == 0 {
+			return storage.ErrNotFound
+		}
+
+		if _, err := v.s.exec(ctx, tx, "DELETE FROM virtual_machine_members WHERE server_id = ?", vm.ServerId); err != nil {
+			return err
+		}
+
+		for i, member := range vm.Members {
+			_, err := v.s.exec(ctx, tx, "INSERT INTO virtual_machine_members (server_id, user_id, role, position) VALUES (?, ?, ?, ?)",
+				vm.ServerId, member.UserId, member.Role, i)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (v vmStore) RenewVmLease(ctx context.Context, serverId string, expires time.Time) error {
+	res, err := v.s.exec(ctx, v.s.db, "UPDATE virtual_machines SET lease_expires = ?, lease_notice = '' WHERE server_id = ?", expires, serverId)
+	if err != nil {
+		return err
+	}
+
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return storage.ErrNotFound
+	}
+
+	return nil
+}
+
+func (v vmStore) ClaimVmLeaseNotice(ctx context.Context, serverId string, from string, to string) (bool, error) {
+	res, err := v.s.exec(ctx, v.s.db, "UPDATE virtual_machines SET lease_notice = ? WHERE server_id = ? AND lease_notice = ?", to, serverId, from)
+	if err != nil {
+		return false, err
+	}
+
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return updated == 1, nil
+}
+
+func (v vmStore) UpdateVmMembers(ctx context.Context, serverId string, update storage.MembersUpdate) ([]database.VirtualMachineMember, error) {
+	var members []database.VirtualMachineMember
+
+	err := v.s.withTx(ctx, func(tx *sql.Tx) error {
+		var id string
+		err := tx.QueryRowContext(ctx, v.s.rebind("SELECT server_id FROM virtual_machines WHERE server_id = ?"+v.s.dialect.forUpdate), serverId).Scan(&id)
+		if err == sql.ErrNoRows {
+			return storage.ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		rows, err := tx.QueryContext(ctx, v.s.rebind("SELECT user_id, role FROM virtual_machine_members WHERE server_id = ? ORDER BY position"), serverId)
+		if err != nil {
+			return err
+		}
+
+		var current []database.VirtualMachineMember
+		for rows.Next() {
+			var member database.VirtualMachineMember
+			if err := rows.Scan(&member.UserId, &member.Role); err != nil {
+				rows.Close()
+				return err
+			}
+			current = append(current, member)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		members, err = update(current)
+		if err != nil {
+			return err
+		}
+
+		if _, err := v.s.exec(ctx, tx, "DELETE FROM virtual_machine_members WHERE server_id = ?", serverId); err != nil {
+			return err
+		}
+
+		for i, member := range members {
+			_, err := v.s.exec(ctx, tx, "INSERT INTO virtual_machine_members (server_id, user_id, role, position) VALUES (?, ?, ?, ?)",
+				serverId, member.UserId, member.Role, i)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return members, nil
+}
+
+func (v vmStore) DeleteVMById(ctx context.Context, serverId string) (int, error) {
+	var deleted int64
+	err := v.s.withTx(ctx, func(tx *sql.Tx) error {
+		// SQLite only enforces ON DELETE CASCADE with foreign keys switched on, delete the members explicitly.
+		if _, err := v.s.exec(ctx, tx, "DELETE FROM virtual_machine_members WHERE server_id = ?", serverId); err != nil {
+			return err
+		}
+
+		res, err := v.s.exec(ctx, tx, "DELETE FROM virtual_machines WHERE server_id = ?", serverId)
+		if err != nil {
+			return err
+		}
+
+		deleted, err = res.RowsAffected()
+		return err
+	})
+
+	return int(deleted), err
+}
+
+func (v vmStore) CheckIfOwnsVm(ctx context.Context, serverId string, userId string) (bool, error) {
+	var count int
+	err := v.s.queryRow(ctx, "SELECT COUNT(*) FROM virtual_machine_members WHERE server_id = ? AND user_id = ?", serverId, userId).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
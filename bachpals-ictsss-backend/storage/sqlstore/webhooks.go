@@ -0,0 +1,238 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/database"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/storage"
+)
+
+type webhookStore struct {
+	s *Store
+}
+
+const webhookColumns = "id, url, secret, description, events, enabled, created_by, created"
+
+const deliveryColumns = "id, subscription_id, event, payload, status, attempts, last_error, last_status_code, next_attempt, created, delivered"
+
+func (w webhookStore) InsertWebhook(ctx context.Context, webhook database.WebhookSubscription) (string, error) {
+	events, err := toJson(webhook.Events)
+	if err != nil {
+		return "", err
+	}
+
+	created := webhook.Created
+	if created.IsZero() {
+		created = time.Now()
+	}
+
+	id := newId()
+	_, err = w.s.exec(ctx, w.s.db, "INSERT INTO webhooks ("+webhookColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		id, webhook.Url, webhook.Secret, webhook.Description, events, webhook.Enabled, webhook.CreatedBy, created.UTC())
+	if err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
+
+func scanWebhook(row scanner) (*database.WebhookSubscription, error) {
+	var webhook database.WebhookSubscription
+	var events string
+
+	err := row.Scan(&webhook.Id, &webhook.Url, &webhook.Secret, &webhook.Description, &events, &webhook.Enabled, &webhook.CreatedBy, &webhook.Created)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := fromJson(events, &webhook.Events); err != nil {
+		return nil, err
+	}
+
+	return &webhook, nil
+}
+
+func (w webhookStore) GetWebhooks(ctx context.Context) ([]*database.WebhookSubscription, error) {
+	rows, err := w.s.query(ctx, "SELECT "+webhookColumns+" FROM webhooks ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	webhooks := []*database.WebhookSubscription{}
+	for rows.Next() {
+		webhook, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		webhooks = append(webhooks, webhook)
+	}
+
+	return webhooks, rows.Err()
+}
+
+// GetWebhooksForEvent filters the events here, they are kept as a JSON list.
+func (w webhookStore) GetWebhooksForEvent(ctx context.Context, event string) ([]*database.WebhookSubscription, error) {
+	webhooks, err := w.GetWebhooks(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	subscribed := []*database.WebhookSubscription{}
+	for _, webhook := range webhooks {
+		if webhook.Enabled && (len(webhook.Events) == 0 || contains(webhook.Events, event)) {
+			subscribed = append(subscribed, webhook)
+		}
+	}
+
+	return subscribed, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func (w webhookStore) GetWebhookById(ctx context.Context, id string) (*database.WebhookSubscription, error) {
+	webhook, err := scanWebhook(w.s.queryRow(ctx, "SELECT "+webhookColumns+" FROM webhooks WHERE id = ?", id))
+	if err == sql.ErrNoRows {
+		return nil, storage.ErrNotFound
+	}
+
+	return webhook, err
+}
+
+func (w webhookStore) UpdateWebhookById(ctx context.Context, webhook database.WebhookSubscription) error {
+	events, err := toJson(webhook.Events)
+	if err != nil {
+		return err
+	}
+
+	res, err := w.s.exec(ctx, w.s.db, "UPDATE webhooks SET url = ?, secret = ?, description = ?, events = ?, enabled = ? WHERE id = ?",
+		webhook.Url, webhook.Secret, webhook.Description, events, webhook.Enabled, webhook.Id)
+	if err != nil {
+		return err
+	}
+
+	return notFoundIfNone(res)
+}
+
+func (w webhookStore) DeleteWebhookById(ctx context.Context, id string) error {
+	res, err := w.s.exec(ctx, w.s.db, "DELETE FROM webhooks WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+
+	return notFoundIfNone(res)
+}
+
+func (w webhookStore) InsertWebhookDelivery(ctx context.Context, delivery database.WebhookDelivery) error {
+	id := delivery.Id
+	if len(id) == 0 {
+		id = newId()
+	}
+
+	created := delivery.Created
+	if created.IsZero() {
+		created = time.Now()
+	}
+
+	_, err := w.s.exec(ctx, w.s.db, "INSERT INTO webhook_deliveries ("+deliveryColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		id, delivery.SubscriptionId, delivery.Event, delivery.Payload, delivery.Status, delivery.Attempts, delivery.LastError,
+		delivery.LastStatusCode, delivery.NextAttempt.UTC(), created.UTC(), nullTime(delivery.Delivered))
+	return err
+}
+
+func scanDelivery(row scanner) (*database.WebhookDelivery, error) {
+	var delivery database.WebhookDelivery
+	var delivered sql.NullTime
+
+	err := row.Scan(&delivery.Id, &delivery.SubscriptionId, &delivery.Event, &delivery.Payload, &delivery.Status, &delivery.Attempts,
+		&delivery.LastError, &delivery.LastStatusCode, &delivery.NextAttempt, &delivery.Created, &delivered)
+	if err != nil {
+		return nil, err
+	}
+
+	if delivered.Valid {
+		delivery.Delivered = delivered.Time
+	}
+
+	return &delivery, nil
+}
+
+func (w webhookStore) GetWebhookDeliveries(ctx context.Context, status string, limit int) ([]*database.WebhookDelivery, error) {
+	rows, err := w.s.query(ctx, "SELECT "+deliveryColumns+" FROM webhook_deliveries WHERE status = ? ORDER BY created DESC, id DESC LIMIT ?", status, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []*database.WebhookDelivery{}
+	for rows.Next() {
+		delivery, err := scanDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		deliveries = append(deliveries, delivery)
+	}
+
+	return deliveries, rows.Err()
+}
+
+func (w webhookStore) ClaimDueWebhookDelivery(ctx context.Context, now time.Time, lease time.Duration) (*database.WebhookDelivery, error) {
+	var delivery *database.WebhookDelivery
+
+	err := w.s.withTx(ctx, func(tx *sql.Tx) error {
+		var err error
+		delivery, err = scanDelivery(tx.QueryRowContext(ctx, w.s.rebind("SELECT "+deliveryColumns+` FROM webhook_deliveries
+			WHERE status IN (?, ?) AND next_attempt <= ? ORDER BY next_attempt, id LIMIT 1`+w.s.skipLocked()),
+			database.WebhookDeliveryPending, database.WebhookDeliverySending, now.UTC()))
+		if err != nil {
+			return err
+		}
+
+		delivery.Status, delivery.NextAttempt = database.WebhookDeliverySending, now.Add(lease).UTC()
+		res, err := w.s.exec(ctx, tx, "UPDATE webhook_deliveries SET status = ?, next_attempt = ? WHERE id = ? AND status IN (?, ?) AND next_attempt <= ?",
+			delivery.Status, delivery.NextAttempt, delivery.Id, database.WebhookDeliveryPending, database.WebhookDeliverySending, now.UTC())
+		if err != nil {
+			return err
+		}
+		return notFoundIfNone(res)
+	})
+	if err == sql.ErrNoRows {
+		return nil, storage.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return delivery, nil
+}
+
+func (w webhookStore) GetWebhookDeliveryById(ctx context.Context, id string) (*database.WebhookDelivery, error) {
+	delivery, err := scanDelivery(w.s.queryRow(ctx, "SELECT "+deliveryColumns+" FROM webhook_deliveries WHERE id = ?", id))
+	if err == sql.ErrNoRows {
+		return nil, storage.ErrNotFound
+	}
+
+	return delivery, err
+}
+
+func (w webhookStore) UpdateWebhookDelivery(ctx context.Context, delivery database.WebhookDelivery) error {
+	res, err := w.s.exec(ctx, w.s.db, `UPDATE webhook_deliveries SET status = ?, attempts = ?, last_error = ?, last_status_code = ?,
+		next_attempt = ?, delivered = ? WHERE id = ?`,
+		delivery.Status, delivery.Attempts, delivery.LastError, delivery.LastStatusCode, delivery.NextAttempt.UTC(), nullTime(delivery.Delivered), delivery.Id)
+	if err != nil {
+		return err
+	}
+
+	return notFoundIfNone(res)
+}
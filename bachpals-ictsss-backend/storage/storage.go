@@ -0,0 +1,275 @@
+// Package storage defines the repositories the api needs for virtual machines,
+// images, administrators, application state, power schedules, the notification
+// outbox and webhooks. Backends register themselves
+// by name, like database/sql drivers, and one of them is opened at startup
+// based on IKT_STACK_STORAGE_BACKEND.
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/database"
+)
+
+// DefaultBackend is used when IKT_STACK_STORAGE_BACKEND is empty.
+const DefaultBackend = "mongo"
+
+// ErrNotFound is returned when a lookup by id matches nothing.
+var ErrNotFound = errors.New("not found")
+
+// ErrConflict is returned when a record kept changing while it was being updated.
+var ErrConflict = errors.New("concurrent update")
+
+// MembersUpdate returns the new members of a virtual machine from the current ones, owner first.
+type MembersUpdate func(members []database.VirtualMachineMember) ([]database.VirtualMachineMember, error)
+
+type VmStore interface {
+	// InsertVm stores a new virtual machine, Members holds everyone with access, owner first.
+	InsertVm(ctx context.Context, vm database.VirtualMachine) error
+	GetVMS(ctx context.Context) ([]database.VirtualMachine, error)
+	// ListVms returns one page of the virtual machines matching query, see VmQuery.Normalize.
+	ListVms(ctx context.Context, query VmQuery) (VmPage, error)
+	GetVMByUserId(ctx context.Context, userId string) ([]database.VirtualMachine, error)
+	GetVMByCourseCode(ctx context.Context, courseCode string) ([]database.VirtualMachine, error)
+	GetVMById(ctx context.Context, serverId string) (database.VirtualMachine, error)
+	UpdateVMStatusById(ctx context.Context, serverId string, status string) error
+	// UpdateVm replaces the stored fields and members of a virtual machine, the creation time and lease are kept.
+	UpdateVm(ctx context.Context, vm database.VirtualMachine) error
+	// RenewVmLease moves the end of the lease to expires and clears the lease notice.
+	RenewVmLease(ctx context.Context, serverId string, expires time.Time) error
+	// ClaimVmLeaseNotice changes the lease notice from from to to and is false when it was
+	// no longer from, so of several instances only one sends a notice.
+	ClaimVmLeaseNotice(ctx context.Context, serverId string, from string, to string) (bool, error)
+	// UpdateVmMembers replaces the members with what update returns for the current ones, as one
+	// atomic change. An error from update is returned as is and nothing is changed.
+	UpdateVmMembers(ctx context.Context, serverId string, update MembersUpdate) ([]database.VirtualMachineMember, error)
+	DeleteVMById(ctx context.Context, serverId string) (int, error)
+	// CheckIfOwnsVm is true when the user is the owner or a member of the virtual machine.
+	CheckIfOwnsVm(ctx context.Context, serverId string, userId string) (bool, error)
+}
+
+// FloatingIpStore tracks the floating ips the service allocated, so free ones are reused
+// instead of allocating new ones.
+type FloatingIpStore interface {
+	// SaveFloatingIp stores a floating ip, replacing the record with the same id.
+	SaveFloatingIp(ctx context.Context, ip database.FloatingIp) error
+	GetFloatingIps(ctx context.Context) ([]database.FloatingIp, error)
+	// ClaimFloatingIp assigns a free floating ip of a target to a server as one atomic
+	// change, two servers never get the same one. ErrNotFound when none is free.
+	ClaimFloatingIp(ctx context.Context, target string, serverId string) (database.FloatingIp, error)
+	// DeleteFloatingIp forgets a floating ip, one that is not stored is no error.
+	DeleteFloatingIp(ctx context.Context, id string) error
+}
+
+// ScheduleStore keeps power schedules and the reports of their runs.
+type ScheduleStore interface {
+	// InsertSchedule stores a new schedule and returns its id, last_run starts at now.
+	InsertSchedule(ctx context.Context, schedule database.Schedule) (string, error)
+	GetSchedules(ctx context.Context) ([]*database.Schedule, error)
+	GetScheduleById(ctx context.Context, id string) (*database.Schedule, error)
+	// UpdateScheduleById replaces the settings of a schedule, its creator and last run are kept.
+	UpdateScheduleById(ctx context.Context, schedule database.Schedule) error
+	// ClaimScheduleRun moves last_run from lastRun to now and is false when it was no longer
+	// lastRun, so of several instances evaluating a schedule only one runs it.
+	ClaimScheduleRun(ctx context.Context, id string, lastRun time.Time, now time.Time) (bool, error)
+	DeleteScheduleById(ctx context.Context, id string) error
+	InsertScheduleRun(ctx context.Context, run database.ScheduleRun) error
+	// GetScheduleRuns returns the latest reports of a schedule, newest first.
+	GetScheduleRuns(ctx context.Context, scheduleId string, limit int) ([]*database.ScheduleRun, error)
+}
+
+// NotificationStore is the outbox of emails and the preferences of their recipients.
+type NotificationStore interface {
+	InsertNotification(ctx context.Context, notification database.Notification) error
+	// ClaimDueNotification marks the oldest due notification as sending until now plus lease
+	// and returns it, ErrNotFound when nothing is due. A notification left sending by a
+	// crashed instance is due again once its lease ends.
+	ClaimDueNotification(ctx context.Context, now time.Time, lease time.Duration) (*database.Notification, error)
+	// UpdateNotificationDelivery stores the outcome of a delivery attempt.
+	UpdateNotificationDelivery(ctx context.Context, notification database.Notification) error
+	// GetNotificationPreferences returns the preferences of a user, users without stored
+	// preferences get every notification.
+	GetNotificationPreferences(ctx context.Context, userId string) (*database.NotificationPreferences, error)
+	UpdateNotificationPreferences(ctx context.Context, preferences database.NotificationPreferences) error
+}
+
+// WebhookStore keeps webhook subscriptions and the deliveries queued for them.
+type WebhookStore interface {
+	// InsertWebhook stores a new subscription and returns its id.
+	InsertWebhook(ctx context.Context, webhook database.WebhookSubscription) (string, error)
+	GetWebhooks(ctx context.Context) ([]*database.WebhookSubscription, error)
+	// GetWebhooksForEvent returns the enabled subscriptions that want the event.
+	GetWebhooksForEvent(ctx context.Context, event string) ([]*database.WebhookSubscription, error)
+	GetWebhookById(ctx context.Context, id string) (*database.WebhookSubscription, error)
+	// UpdateWebhookById replaces the settings of a subscription, its creator and creation time are kept.
+	UpdateWebhookById(ctx context.Context, webhook database.WebhookSubscription) error
+	DeleteWebhookById(ctx context.Context, id string) error
+	InsertWebhookDelivery(ctx context.Context, delivery database.WebhookDelivery) error
+	// GetWebhookDeliveries returns deliveries with the given status, newest first.
+	GetWebhookDeliveries(ctx context.Context, status string, limit int) ([]*database.WebhookDelivery, error)
+	// ClaimDueWebhookDelivery marks the oldest due delivery as sending until now plus lease and
+	// returns it, ErrNotFound when nothing is due. A delivery left sending by a crashed
+	// instance is due again once its lease ends.
+	ClaimDueWebhookDelivery(ctx context.Context, now time.Time, lease time.Duration) (*database.WebhookDelivery, error)
+	GetWebhookDeliveryById(ctx context.Context, id string) (*database.WebhookDelivery, error)
+	// UpdateWebhookDelivery stores the outcome of a delivery attempt.
+	UpdateWebhookDelivery(ctx context.Context, delivery database.WebhookDelivery) error
+}
+
+type ImageStore interface {
+	InsertImage(ctx context.Context, image database.Images) error
+	GetImages(ctx context.Context) ([]*database.Images, error)
+	GetPublishedImages(ctx context.Context) ([]*database.Images, error)
+	GetImageByImageId(ctx context.Context, imageId string) (*database.Images, error)
+	GetImageById(ctx context.Context, id string) (*database.Images, error)
+	UpdateImageById(ctx context.Context, image database.Images) error
+	DeleteImageById(ctx context.Context, id string) error
+}
+
+type AdminStore interface {
+	InsertAdmin(ctx context.Context, userId string, name string) error
+	ReadAdmins(ctx context.Context) ([]*database.Admin, error)
+	ReadAdminById(ctx context.Context, userId string) (*database.Admin, error)
+	DeleteAdminById(ctx context.Context, userId string) (int, error)
+	UpdateAdminById(ctx context.Context, userId string, newUserId string, name string) error
+}
+
+type AppStateStore interface {
+	IsInitialized(ctx context.Context) (bool, error)
+	SetInitialized(ctx context.Context) error
+	// Reset removes all virtual machines, floating ips, administrators and the initialization state.
+	Reset(ctx context.Context) error
+}
+
+// MigrationState is a schema migration of a backend, Applied is zero while pending.
+type MigrationState struct {
+	Version     int
+	Description string
+	Applied     time.Time
+}
+
+type Migrator interface {
+	Up(ctx context.Context) ([]MigrationState, error)
+	Down(ctx context.Context) (*MigrationState, error)
+	Status(ctx context.Context) ([]MigrationState, error)
+}
+
+type Store interface {
+	Vms() VmStore
+	Images() ImageStore
+	Admins() AdminStore
+	AppState() AppStateStore
+	FloatingIps() FloatingIpStore
+	Schedules() ScheduleStore
+	Notifications() NotificationStore
+	Webhooks() WebhookStore
+	Migrator() Migrator
+	// Ping checks that the database answers, for the health checks.
+	Ping(ctx context.Context) error
+	Close(ctx context.Context) error
+}
+
+// Factory opens a backend, dsn is IKT_STACK_STORAGE_DSN and may be empty.
+type Factory func(ctx context.Context, dsn string) (Store, error)
+
+var (
+	mu        sync.RWMutex
+	factories = map[string]Factory{}
+	current   Store
+)
+
+// Register makes a backend available under name, it is called from init functions.
+func Register(name string, factory Factory) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if _, ok := factories[name]; ok {
+		panic("storage: backend registered twice: " + name)
+	}
+	factories[name] = factory
+}
+
+// Backends lists the registered backend names.
+func Backends() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	var names []string
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// New opens a backend without making it the current one.
+func New(ctx context.Context, name string, dsn string) (Store, error) {
+	if len(name) == 0 {
+		name = DefaultBackend
+	}
+
+	mu.RLock()
+	factory, ok := factories[name]
+	mu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown storage backend %q, available: %v", name, Backends())
+	}
+
+	return factory(ctx, dsn)
+}
+
+// Open opens a backend and makes it the one returned by Vms, Images, Schedules and the others.
+func Open(ctx context.Context, name string, dsn string) error {
+	store, err := New(ctx, name, dsn)
+	if err != nil {
+		return err
+	}
+
+	mu.Lock()
+	current = store
+	mu.Unlock()
+	return nil
+}
+
+// Close closes the current backend.
+func Close(ctx context.Context) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if current == nil {
+		return nil
+	}
+	err := current.Close(ctx)
+	current = nil
+	return err
+}
+
+// Current returns the opened backend, Open must have been called first.
+func Current() Store {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	if current == nil {
+		panic("storage: used before storage.Open was called")
+	}
+	return current
+}
+
+func Vms() VmStore                     { return Current().Vms() }
+func Images() ImageStore               { return Current().Images() }
+func Admins() AdminStore               { return Current().Admins() }
+func AppState() AppStateStore          { return Current().AppState() }
+func FloatingIps() FloatingIpStore     { return Current().FloatingIps() }
+func Schedules() ScheduleStore         { return Current().Schedules() }
+func Notifications() NotificationStore { return Current().Notifications() }
+func Webhooks() WebhookStore           { return Current().Webhooks() }
+func Migrations() Migrator             { return Current().Migrator() }
+
+// Ping checks the current backend.
+func Ping(ctx context.Context) error { return Current().Ping(ctx) }
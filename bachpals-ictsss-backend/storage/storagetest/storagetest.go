@@ -0,0 +1,1166 @@
+// Package storagetest is the contract every storage backend has to satisfy.
+// go test runs it against sqlite, and against postgres and mongo when their
+// test databases are configured. It writes real data and therefore refuses to
+// run against a store that is in use.
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/database"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/storage"
+)
+
+// ErrNotEmpty is returned when the store already holds data.
+var ErrNotEmpty = errors.New("storage is not empty, run the contract against an unused database")
+
+type check struct {
+	name string
+	run  func(ctx context.Context, store storage.Store) error
+}
+
+var checks = []check{
+	{"admins", admins},
+	{"images", images},
+	{"vm listing", vmListing},
+	{"vms", vms},
+	{"floating ips", floatingIps},
+	{"schedules", schedules},
+	{"notifications", notifications},
+	{"webhooks", webhooks},
+	{"application state", appState},
+}
+
+// missingId is a well formed id that no record has.
+const missingId = "000000000000000000000000"
+
+// Run runs every check against store and returns the failures, the store is emptied again afterwards.
+func Run(ctx context.Context, store storage.Store) []error {
+	if err := ensureEmpty(ctx, store); err != nil {
+		return []error{err}
+	}
+
+	var failures []error
+	for _, v := range checks {
+		if err := v.run(ctx, store); err != nil {
+			failures = append(failures, fmt.Errorf("%s: %w", v.name, err))
+		}
+	}
+
+	if err := cleanup(ctx, store); err != nil {
+		failures = append(failures, fmt.Errorf("cleanup: %w", err))
+	}
+
+	return failures
+}
+
+func ensureEmpty(ctx context.Context, store storage.Store) error {
+	vms, err := store.Vms().GetVMS(ctx)
+	if err != nil {
+		return err
+	}
+	images, err := store.Images().GetImages(ctx)
+	if err != nil {
+		return err
+	}
+	admins, err := store.Admins().ReadAdmins(ctx)
+	if err != nil {
+		return err
+	}
+	ips, err := store.FloatingIps().GetFloatingIps(ctx)
+	if err != nil {
+		return err
+	}
+	schedules, err := store.Schedules().GetSchedules(ctx)
+	if err != nil {
+		return err
+	}
+	webhooks, err := store.Webhooks().GetWebhooks(ctx)
+	if err != nil {
+		return err
+	}
+	initialized, err := store.AppState().IsInitialized(ctx)
+	if err != nil {
+		return err
+	}
+
+	if len(vms) > 0 || len(images) > 0 || len(admins) > 0 || len(ips) > 0 || len(schedules) > 0 || len(webhooks) > 0 || initialized {
+		return ErrNotEmpty
+	}
+
+	return nil
+}
+
+func cleanup(ctx context.Context, store storage.Store) error {
+	images, err := store.Images().GetImages(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, v := range images {
+		if err := store.Images().DeleteImageById(ctx, v.Id); err != nil {
+			return err
+		}
+	}
+
+	// A check that failed half way leaves these behind, Reset keeps them.
+	schedules, err := store.Schedules().GetSchedules(ctx)
+	if err != nil {
+		return err
+	}
+	for _, v := range schedules {
+		if err := store.Schedules().DeleteScheduleById(ctx, v.Id); err != nil {
+			return err
+		}
+	}
+
+	webhooks, err := store.Webhooks().GetWebhooks(ctx)
+	if err != nil {
+		return err
+	}
+	for _, v := range webhooks {
+		if err := store.Webhooks().DeleteWebhookById(ctx, v.Id); err != nil {
+			return err
+		}
+	}
+
+	return store.AppState().Reset(ctx)
+}
+
+func expect(condition bool, format string, args ...interface{}) error {
+	if condition {
+		return nil
+	}
+	return fmt.Errorf(format, args...)
+}
+
+func expectNotFound(err error, what string) error {
+	return expect(errors.Is(err, storage.ErrNotFound), "%s: expected storage.ErrNotFound, got %v", what, err)
+}
+
+func admins(ctx context.Context, store storage.Store) error {
+	a := store.Admins()
+
+	if err := a.InsertAdmin(ctx, "admin@uia.no", "Admin"); err != nil {
+		return err
+	}
+	if err := a.InsertAdmin(ctx, "other@uia.no", "Other"); err != nil {
+		return err
+	}
+
+	list, err := a.ReadAdmins(ctx)
+	if err != nil {
+		return err
+	}
+	if err := expect(len(list) == 2, "ReadAdmins returned %d admins, expected 2", len(list)); err != nil {
+		return err
+	}
+
+	admin, err := a.ReadAdminById(ctx, "admin@uia.no")
+	if err != nil {
+		return err
+	}
+	if err := expect(admin.Name == "Admin", "ReadAdminById returned name %q", admin.Name); err != nil {
+		return err
+	}
+
+	_, err = a.ReadAdminById(ctx, "missing@uia.no")
+	if err := expectNotFound(err, "ReadAdminById"); err != nil {
+		return err
+	}
+
+	if err := a.UpdateAdminById(ctx, "other@uia.no", "renamed@uia.no", "Renamed"); err != nil {
+		return err
+	}
+	admin, err = a.ReadAdminById(ctx, "renamed@uia.no")
+	if err != nil {
+		return err
+	}
+	if err := expect(admin.Name == "Renamed", "UpdateAdminById did not update the name, got %q", admin.Name); err != nil {
+		return err
+	}
+
+	err = a.UpdateAdminById(ctx, "missing@uia.no", "missing@uia.no", "")
+	if err := expectNotFound(err, "UpdateAdminById"); err != nil {
+		return err
+	}
+
+	deleted, err := a.DeleteAdminById(ctx, "renamed@uia.no")
+	if err != nil {
+		return err
+	}
+	if err := expect(deleted == 1, "DeleteAdminById deleted %d admins, expected 1", deleted); err != nil {
+		return err
+	}
+
+	deleted, err = a.DeleteAdminById(ctx, "renamed@uia.no")
+	if err != nil {
+		return err
+	}
+	if err := expect(deleted == 0, "DeleteAdminById of a missing admin deleted %d", deleted); err != nil {
+		return err
+	}
+
+	_, err = a.DeleteAdminById(ctx, "admin@uia.no")
+	return err
+}
+
+func images(ctx context.Context, store storage.Store) error {
+	i := store.Images()
+
+	published := database.Images{
+		ImageId:               "image-published",
+		ImageName:             "ubuntu",
+		ImageDisplayName:      "Ubuntu",
+		ImageDescription:      "Published image",
+		Published:             "true",
+		ImageConfig:           "#cloud-config",
+		ImageReadRootPassword: true,
+	}
+	hidden := database.Images{ImageId: "image-hidden", ImageDisplayName: "Hidden", Published: "false"}
+
+	if err := i.InsertImage(ctx, published); err != nil {
+		return err
+	}
+	if err := i.InsertImage(ctx, hidden); err != nil {
+		return err
+	}
+
+	list, err := i.GetImages(ctx)
+	if err != nil {
+		return err
+	}
+	if err := expect(len(list) == 2, "GetImages returned %d images, expected 2", len(list)); err != nil {
+		return err
+	}
+
+	list, err = i.GetPublishedImages(ctx)
+	if err != nil {
+		return err
+	}
+	if err := expect(len(list) == 1 && list[0].ImageId == "image-published", "GetPublishedImages returned %v", list); err != nil {
+		return err
+	}
+	if err := expect(len(list[0].ImageConfig) == 0, "GetPublishedImages exposed the image config"); err != nil {
+		return err
+	}
+
+	image, err := i.GetImageByImageId(ctx, "image-published")
+	if err != nil {
+		return err
+	}
+	if err := expect(image.ImageReadRootPassword && image.ImageConfig == "#cloud-config", "GetImageByImageId returned %+v", image); err != nil {
+		return err
+	}
+
+	byId, err := i.GetImageById(ctx, image.Id)
+	if err != nil {
+		return err
+	}
+	if err := expect(byId.ImageId == image.ImageId, "GetImageById returned %+v", byId); err != nil {
+		return err
+	}
+
+	_, err = i.GetImageByImageId(ctx, "missing")
+	if err := expectNotFound(err, "GetImageByImageId"); err != nil {
+		return err
+	}
+	_, err = i.GetImageById(ctx, "000000000000000000000000")
+	if err := expectNotFound(err, "GetImageById"); err != nil {
+		return err
+	}
+
+	image.ImageDisplayName = "Ubuntu LTS"
+	image.Published = "false"
+	if err := i.UpdateImageById(ctx, *image); err != nil {
+		return err
+	}
+	image, err = i.GetImageById(ctx, image.Id)
+	if err != nil {
+		return err
+	}
+	if err := expect(image.ImageDisplayName == "Ubuntu LTS" && image.Published == "false", "UpdateImageById did not update, got %+v", image); err != nil {
+		return err
+	}
+
+	err = i.UpdateImageById(ctx, database.Images{Id: "000000000000000000000000"})
+	if err := expectNotFound(err, "UpdateImageById"); err != nil {
+		return err
+	}
+
+	hiddenImage, err := i.GetImageByImageId(ctx, "image-hidden")
+	if err != nil {
+		return err
+	}
+	if err := i.DeleteImageById(ctx, hiddenImage.Id); err != nil {
+		return err
+	}
+	_, err = i.GetImageById(ctx, hiddenImage.Id)
+	return expectNotFound(err, "GetImageById after DeleteImageById")
+}
+
+func vms(ctx context.Context, store storage.Store) error {
+	v := store.Vms()
+
+	if err := store.Images().InsertImage(ctx, database.Images{ImageId: "image-vm", ImageDisplayName: "VM image", ImageReadRootPassword: true}); err != nil {
+		return err
+	}
+
+	group := database.VirtualMachine{
+		ServerId:     "server-group",
+		ServerIp:     "10.0.0.1",
+		ServerImage:  "image-vm",
+		ServerName:   "group",
+		ServerStatus: database.VirtualMachineStatusActive,
+		Created:      time.Now().Add(-time.Minute),
+		CourseCode:   "IKT100",
+		Target:       "target-a",
+		LeaseExpires: time.Now().Add(24 * time.Hour).Truncate(time.Second),
+		Members:      database.MembersFromUserIds([]string{"owner@uia.no", "member@student.uia.no"}),
+	}
+	single := database.VirtualMachine{
+		ServerId:     "server-single",
+		ServerImage:  "image-missing",
+		ServerName:   "single",
+		ServerStatus: database.VirtualMachineStatusActive,
+		Members:      database.MembersFromUserIds([]string{"member@student.uia.no"}),
+	}
+
+	if err := v.InsertVm(ctx, group); err != nil {
+		return err
+	}
+	if err := v.InsertVm(ctx, single); err != nil {
+		return err
+	}
+	if err := expect(v.InsertVm(ctx, group) != nil, "InsertVm accepted a duplicate server id"); err != nil {
+		return err
+	}
+
+	all, err := v.GetVMS(ctx)
+	if err != nil {
+		return err
+	}
+	if err := expect(len(all) == 2 && all[0].ServerId == "server-group", "GetVMS returned %d virtual machines, expected 2 ordered by creation", len(all)); err != nil {
+		return err
+	}
+
+	vm, err := v.GetVMById(ctx, "server-group")
+	if err != nil {
+		return err
+	}
+	if err := expect(vm.UserId == "owner@uia.no", "GetVMById returned owner %q", vm.UserId); err != nil {
+		return err
+	}
+	if err := expect(vm.Target == "target-a", "GetVMById returned target %q", vm.Target); err != nil {
+		return err
+	}
+	if err := expect(len(vm.Members) == 2 && vm.Members[1].Role == database.VirtualMachineRoleMember, "GetVMById returned members %v", vm.Members); err != nil {
+		return err
+	}
+	if err := expect(len(vm.GroupMembers) == 2 && vm.GroupMembers[0] == "owner" && vm.GroupMembers[1] == "member", "GetVMById returned group members %v", vm.GroupMembers); err != nil {
+		return err
+	}
+	if err := expect(vm.ImageDisplayName == "VM image" && vm.ImageReadRootPassword, "GetVMById did not join the image, got %+v", vm.VirtualMachineImageMeta); err != nil {
+		return err
+	}
+	if err := expect(vm.LeaseExpires.Equal(group.LeaseExpires) && len(vm.LeaseNotice) == 0, "GetVMById returned lease %v %q", vm.LeaseExpires, vm.LeaseNotice); err != nil {
+		return err
+	}
+
+	vm, err = v.GetVMById(ctx, "server-single")
+	if err != nil {
+		return err
+	}
+	if err := expect(!vm.Created.IsZero(), "InsertVm did not set the creation time"); err != nil {
+		return err
+	}
+	if err := expect(len(vm.ImageDisplayName) == 0, "GetVMById joined an image that does not exist"); err != nil {
+		return err
+	}
+	if err := expect(vm.LeaseExpires.IsZero(), "GetVMById returned a lease for a virtual machine without one, got %v", vm.LeaseExpires); err != nil {
+		return err
+	}
+
+	_, err = v.GetVMById(ctx, "missing")
+	if err := expectNotFound(err, "GetVMById"); err != nil {
+		return err
+	}
+
+	list, err := v.GetVMByUserId(ctx, "member@student.uia.no")
+	if err != nil {
+		return err
+	}
+	if err := expect(len(list) == 2, "GetVMByUserId returned %d virtual machines for a member of two, expected 2", len(list)); err != nil {
+		return err
+	}
+	if err := expect(len(list[0].Members) == 2, "GetVMByUserId returned only the matching member, got %v", list[0].Members); err != nil {
+		return err
+	}
+
+	list, err = v.GetVMByCourseCode(ctx, "IKT100")
+	if err != nil {
+		return err
+	}
+	if err := expect(len(list) == 1 && list[0].ServerId == "server-group", "GetVMByCourseCode returned %d virtual machines, expected 1", len(list)); err != nil {
+		return err
+	}
+
+	owns, err := v.CheckIfOwnsVm(ctx, "server-group", "member@student.uia.no")
+	if err != nil {
+		return err
+	}
+	if err := expect(owns, "CheckIfOwnsVm is false for a member"); err != nil {
+		return err
+	}
+	owns, err = v.CheckIfOwnsVm(ctx, "server-group", "stranger@uia.no")
+	if err != nil {
+		return err
+	}
+	if err := expect(!owns, "CheckIfOwnsVm is true for a stranger"); err != nil {
+		return err
+	}
+
+	if err := v.UpdateVMStatusById(ctx, "server-group", database.VirtualMachineStatusInactive); err != nil {
+		return err
+	}
+	vm, err = v.GetVMById(ctx, "server-group")
+	if err != nil {
+		return err
+	}
+	if err := expect(vm.ServerStatus == database.VirtualMachineStatusInactive, "UpdateVMStatusById did not update, got %q", vm.ServerStatus); err != nil {
+		return err
+	}
+
+	created := vm.Created
+	vm.ServerIp = "10.0.0.9"
+	vm.CourseCode = "IKT200"
+	vm.Target = "target-b"
+	vm.Members = database.MembersFromUserIds([]string{"member@student.uia.no"})
+	if err := v.UpdateVm(ctx, vm); err != nil {
+		return err
+	}
+	vm, err = v.GetVMById(ctx, "server-group")
+	if err != nil {
+		return err
+	}
+	if err := expect(vm.ServerIp == "10.0.0.9" && vm.CourseCode == "IKT200" && vm.Target == "target-b", "UpdateVm did not update the fields, got %+v", vm); err != nil {
+		return err
+	}
+	if err := expect(len(vm.Members) == 1 && vm.UserId == "member@student.uia.no", "UpdateVm did not replace the members, got %v", vm.Members); err != nil {
+		return err
+	}
+	if err := expect(vm.Created.Equal(created), "UpdateVm changed the creation time"); err != nil {
+		return err
+	}
+	if err := expect(vm.LeaseExpires.Equal(group.LeaseExpires), "UpdateVm changed the lease"); err != nil {
+		return err
+	}
+
+	claimed, err := v.ClaimVmLeaseNotice(ctx, "server-group", "", database.VirtualMachineLeaseExpiring)
+	if err != nil {
+		return err
+	}
+	if err := expect(claimed, "ClaimVmLeaseNotice did not claim a virtual machine without a notice"); err != nil {
+		return err
+	}
+	claimed, err = v.ClaimVmLeaseNotice(ctx, "server-group", "", database.VirtualMachineLeaseExpiring)
+	if err != nil {
+		return err
+	}
+	if err := expect(!claimed, "ClaimVmLeaseNotice claimed a notice twice"); err != nil {
+		return err
+	}
+
+	renewed := group.LeaseExpires.Add(24 * time.Hour)
+	if err := v.RenewVmLease(ctx, "server-group", renewed); err != nil {
+		return err
+	}
+	vm, err = v.GetVMById(ctx, "server-group")
+	if err != nil {
+		return err
+	}
+	if err := expect(vm.LeaseExpires.Equal(renewed) && len(vm.LeaseNotice) == 0, "RenewVmLease stored %v %q", vm.LeaseExpires, vm.LeaseNotice); err != nil {
+		return err
+	}
+
+	err = v.RenewVmLease(ctx, "missing", renewed)
+	if err := expectNotFound(err, "RenewVmLease"); err != nil {
+		return err
+	}
+
+	err = v.UpdateVm(ctx, database.VirtualMachine{ServerId: "missing"})
+	if err := expectNotFound(err, "UpdateVm"); err != nil {
+		return err
+	}
+
+	members, err := v.UpdateVmMembers(ctx, "server-single", func(members []database.VirtualMachineMember) ([]database.VirtualMachineMember, error) {
+		if len(members) != 1 || members[0].Role != database.VirtualMachineRoleOwner {
+			return nil, fmt.Errorf("UpdateVmMembers was called with %v", members)
+		}
+		return append(members, database.VirtualMachineMember{UserId: "teammate@student.uia.no", Role: database.VirtualMachineRoleMember}), nil
+	})
+	if err != nil {
+		return err
+	}
+	vm, err = v.GetVMById(ctx, "server-single")
+	if err != nil {
+		return err
+	}
+	if err := expect(len(members) == 2 && len(vm.Members) == 2 && vm.Members[1].UserId == "teammate@student.uia.no", "UpdateVmMembers stored %v", vm.Members); err != nil {
+		return err
+	}
+
+	refused := errors.New("refused")
+	_, err = v.UpdateVmMembers(ctx, "server-single", func(members []database.VirtualMachineMember) ([]database.VirtualMachineMember, error) {
+		return nil, refused
+	})
+	if err := expect(errors.Is(err, refused), "UpdateVmMembers did not return the error of update, got %v", err); err != nil {
+		return err
+	}
+	vm, err = v.GetVMById(ctx, "server-single")
+	if err != nil {
+		return err
+	}
+	if err := expect(len(vm.Members) == 2, "UpdateVmMembers changed the members when update failed, got %v", vm.Members); err != nil {
+		return err
+	}
+
+	_, err = v.UpdateVmMembers(ctx, "missing", func(members []database.VirtualMachineMember) ([]database.VirtualMachineMember, error) {
+		return members, nil
+	})
+	if err := expectNotFound(err, "UpdateVmMembers"); err != nil {
+		return err
+	}
+
+	deleted, err := v.DeleteVMById(ctx, "server-group")
+	if err != nil {
+		return err
+	}
+	if err := expect(deleted == 1, "DeleteVMById deleted %d, expected 1", deleted); err != nil {
+		return err
+	}
+
+	owns, err = v.CheckIfOwnsVm(ctx, "server-group", "owner@uia.no")
+	if err != nil {
+		return err
+	}
+	return expect(!owns, "the members of a deleted virtual machine still have access")
+}
+
+// listAll follows the cursors of query until the last page and returns the server ids in order.
+func listAll(ctx context.Context, v storage.VmStore, query storage.VmQuery) ([]string, int, error) {
+	limit := query.Limit
+	if limit <= 0 {
+		limit = storage.DefaultVmPageSize
+	}
+
+	var ids []string
+	var total int
+	for pages := 0; pages < 10; pages++ {
+		page, err := v.ListVms(ctx, query)
+		if err != nil {
+			return nil, 0, err
+		}
+		if len(page.Vms) > limit {
+			return nil, 0, fmt.Errorf("ListVms returned %d virtual machines for limit %d", len(page.Vms), limit)
+		}
+
+		total = page.Total
+		for _, vm := range page.Vms {
+			ids = append(ids, vm.ServerId)
+		}
+		if len(page.NextCursor) == 0 {
+			return ids, total, nil
+		}
+		query.Cursor = page.NextCursor
+	}
+	return nil, 0, errors.New("ListVms kept returning a next cursor")
+}
+
+func vmListing(ctx context.Context, store storage.Store) error {
+	v := store.Vms()
+
+	if err := store.Images().InsertImage(ctx, database.Images{ImageId: "image-list", ImageDisplayName: "List image"}); err != nil {
+		return err
+	}
+
+	now := time.Now().Truncate(time.Second)
+	fixtures := []database.VirtualMachine{
+		{ServerId: "list-a", ServerName: "Alpha", ServerStatus: database.VirtualMachineStatusActive, ServerImage: "image-list", CourseCode: "IKT100",
+			Created: now.Add(-5 * time.Hour), Members: database.MembersFromUserIds([]string{"owner@uia.no", "member@student.uia.no"})},
+		{ServerId: "list-b", ServerName: "bravo_50%", ServerStatus: database.VirtualMachineStatusInactive, ServerImage: "image-other",
+			Created: now.Add(-4 * time.Hour), Members: database.MembersFromUserIds([]string{"member@student.uia.no"})},
+		{ServerId: "list-c", ServerName: "charlie", ServerStatus: database.VirtualMachineStatusActive, ServerImage: "image-list", CourseCode: "IKT100", Target: "target-a",
+			Created: now.Add(-3 * time.Hour), Members: database.MembersFromUserIds([]string{"owner@uia.no"})},
+		{ServerId: "list-d", ServerName: "ALPHA-2", ServerStatus: database.VirtualMachineStatusActive, ServerImage: "image-list",
+			Created: now.Add(-2 * time.Hour), Members: database.MembersFromUserIds([]string{"other@uia.no"})},
+		{ServerId: "list-e", ServerName: "bravo", ServerStatus: database.VirtualMachineStatusInactive, ServerImage: "image-list",
+			Created: now.Add(-2 * time.Hour), Members: database.MembersFromUserIds([]string{"other@uia.no"})},
+	}
+	// The fixtures are removed again even when a check fails, the vms check expects an empty store.
+	defer func() {
+		for _, vm := range fixtures {
+			v.DeleteVMById(ctx, vm.ServerId)
+		}
+	}()
+	for _, vm := range fixtures {
+		if err := v.InsertVm(ctx, vm); err != nil {
+			return err
+		}
+	}
+
+	ids, total, err := listAll(ctx, v, storage.VmQuery{Limit: 2})
+	if err != nil {
+		return err
+	}
+	if err := expect(total == 5 && fmt.Sprint(ids) == "[list-a list-b list-c list-d list-e]", "ListVms by creation returned %v of %d", ids, total); err != nil {
+		return err
+	}
+
+	ids, _, err = listAll(ctx, v, storage.VmQuery{Sort: storage.VmSortStatus, Descending: true, Limit: 2})
+	if err != nil {
+		return err
+	}
+	if err := expect(fmt.Sprint(ids) == "[list-e list-b list-d list-c list-a]", "ListVms by status descending returned %v", ids); err != nil {
+		return err
+	}
+
+	page, err := v.ListVms(ctx, storage.VmQuery{Name: "alpha"})
+	if err != nil {
+		return err
+	}
+	if err := expect(page.Total == 2 && len(page.Vms) == 2 && len(page.NextCursor) == 0, "ListVms by name returned %d of %d", len(page.Vms), page.Total); err != nil {
+		return err
+	}
+	if err := expect(page.Vms[0].ImageDisplayName == "List image" && page.Vms[0].UserId == "owner@uia.no", "ListVms did not join the image and members, got %+v", page.Vms[0]); err != nil {
+		return err
+	}
+
+	page, err = v.ListVms(ctx, storage.VmQuery{Name: "o_5"})
+	if err != nil {
+		return err
+	}
+	if err := expect(page.Total == 1 && page.Vms[0].ServerId == "list-b", "ListVms treated the name as a pattern, got %d matches", page.Total); err != nil {
+		return err
+	}
+
+	filters := []struct {
+		query storage.VmQuery
+		ids   string
+	}{
+		{storage.VmQuery{OwnerId: "owner@uia.no"}, "[list-a list-c]"},
+		{storage.VmQuery{OwnerId: "member@student.uia.no"}, "[list-b]"},
+		{storage.VmQuery{Status: database.VirtualMachineStatusInactive, ImageId: "image-list"}, "[list-e]"},
+		{storage.VmQuery{CourseCode: "IKT100", CreatedFrom: now.Add(-4 * time.Hour)}, "[list-c]"},
+		{storage.VmQuery{CreatedFrom: now.Add(-4 * time.Hour), CreatedTo: now.Add(-2 * time.Hour)}, "[list-b list-c]"},
+		{storage.VmQuery{Target: "target-a"}, "[list-c]"},
+	}
+	for _, f := range filters {
+		ids, total, err := listAll(ctx, v, f.query)
+		if err != nil {
+			return err
+		}
+		if err := expect(fmt.Sprint(ids) == f.ids && total == len(ids), "ListVms(%+v) returned %v of %d, expected %s", f.query, ids, total, f.ids); err != nil {
+			return err
+		}
+	}
+
+	_, err = v.ListVms(ctx, storage.VmQuery{Sort: "members"})
+	if err := expect(errors.Is(err, storage.ErrInvalidQuery), "ListVms accepted an unknown sort key, got %v", err); err != nil {
+		return err
+	}
+
+	page, err = v.ListVms(ctx, storage.VmQuery{Limit: 1})
+	if err != nil {
+		return err
+	}
+	_, err = v.ListVms(ctx, storage.VmQuery{Sort: storage.VmSortName, Cursor: page.NextCursor})
+	return expect(errors.Is(err, storage.ErrInvalidQuery), "ListVms accepted the cursor of another sort order, got %v", err)
+}
+
+func floatingIps(ctx context.Context, store storage.Store) error {
+	f := store.FloatingIps()
+	now := time.Now()
+
+	ips := []database.FloatingIp{
+		{Id: "fip-a", Ip: "10.0.0.1", Target: "default", Pool: "public", Allocated: now, Updated: now.Add(-time.Hour)},
+		{Id: "fip-b", Ip: "10.0.0.2", Target: "default", Pool: "public", ServerId: "server-1", Allocated: now, Updated: now},
+		{Id: "fip-c", Ip: "10.0.0.3", Target: "other", Pool: "public", Allocated: now, Updated: now},
+		{Id: "fip-d", Ip: "10.0.0.4", Target: "default", Pool: "public", Allocated: now, Updated: now},
+	}
+	for _, v := range ips {
+		if err := f.SaveFloatingIp(ctx, v); err != nil {
+			return err
+		}
+	}
+
+	// Saving again replaces the record.
+	ips[2].Pool = "external"
+	if err := f.SaveFloatingIp(ctx, ips[2]); err != nil {
+		return err
+	}
+
+	list, err := f.GetFloatingIps(ctx)
+	if err != nil {
+		return err
+	}
+	if err := expect(len(list) == 4, "GetFloatingIps returned %d floating ips, expected 4", len(list)); err != nil {
+		return err
+	}
+	if err := expect(list[3].Id == "fip-c" && list[3].Pool == "external", "GetFloatingIps did not return the saved fip-c last: %+v", list[3]); err != nil {
+		return err
+	}
+
+	claimed, err := f.ClaimFloatingIp(ctx, "default", "server-2")
+	if err != nil {
+		return err
+	}
+	if err := expect(claimed.Id == "fip-a" && claimed.ServerId == "server-2" && claimed.Ip == "10.0.0.1",
+		"ClaimFloatingIp returned %+v, expected the least recently updated free ip fip-a", claimed); err != nil {
+		return err
+	}
+
+	claimed, err = f.ClaimFloatingIp(ctx, "default", "server-3")
+	if err != nil {
+		return err
+	}
+	if err := expect(claimed.Id == "fip-d", "ClaimFloatingIp returned %s, expected fip-d", claimed.Id); err != nil {
+		return err
+	}
+
+	_, err = f.ClaimFloatingIp(ctx, "default", "server-4")
+	if err := expectNotFound(err, "ClaimFloatingIp without a free ip"); err != nil {
+		return err
+	}
+
+	if err := f.DeleteFloatingIp(ctx, "fip-b"); err != nil {
+		return err
+	}
+	if err := f.DeleteFloatingIp(ctx, "fip-missing"); err != nil {
+		return err
+	}
+
+	list, err = f.GetFloatingIps(ctx)
+	if err != nil {
+		return err
+	}
+	if err := expect(len(list) == 3, "GetFloatingIps returned %d floating ips after a delete, expected 3", len(list)); err != nil {
+		return err
+	}
+	for _, v := range list {
+		if v.Id == "fip-a" {
+			if err := expect(v.ServerId == "server-2", "fip-a has server %q after the claim, expected server-2", v.ServerId); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func schedules(ctx context.Context, store storage.Store) error {
+	s := store.Schedules()
+	now := time.Now()
+
+	id, err := s.InsertSchedule(ctx, database.Schedule{
+		Name:       "nightly",
+		TargetType: database.ScheduleTargetVm,
+		TargetId:   "server-1",
+		TimeZone:   "Europe/Oslo",
+		StopCron:   "0 18 * * *",
+		Windows:    []database.ScheduleWindow{{Start: "2022-03-01T08:00", End: "2022-03-01T16:00"}},
+		Enabled:    true,
+		CreatedBy:  "admin@uia.no",
+	})
+	if err != nil {
+		return err
+	}
+
+	schedule, err := s.GetScheduleById(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := expect(schedule.Id == id && schedule.Name == "nightly" && schedule.TimeZone == "Europe/Oslo" && schedule.StopCron == "0 18 * * *" &&
+		schedule.Enabled && schedule.CreatedBy == "admin@uia.no", "GetScheduleById returned %+v", schedule); err != nil {
+		return err
+	}
+	if err := expect(len(schedule.Windows) == 1 && schedule.Windows[0].End == "2022-03-01T16:00", "schedule has windows %+v", schedule.Windows); err != nil {
+		return err
+	}
+	if err := expect(!schedule.LastRun.IsZero(), "InsertSchedule did not set the last run"); err != nil {
+		return err
+	}
+
+	_, err = s.GetScheduleById(ctx, missingId)
+	if err := expectNotFound(err, "GetScheduleById of a missing schedule"); err != nil {
+		return err
+	}
+
+	schedule.Name, schedule.CreatedBy = "evening", "other@uia.no"
+	if err := s.UpdateScheduleById(ctx, *schedule); err != nil {
+		return err
+	}
+	list, err := s.GetSchedules(ctx)
+	if err != nil {
+		return err
+	}
+	if err := expect(len(list) == 1 && list[0].Name == "evening" && list[0].CreatedBy == "admin@uia.no",
+		"GetSchedules after an update returned %+v, expected the new name and the creator kept", list); err != nil {
+		return err
+	}
+
+	schedule.Id = missingId
+	err = s.UpdateScheduleById(ctx, *schedule)
+	if err := expectNotFound(err, "UpdateScheduleById of a missing schedule"); err != nil {
+		return err
+	}
+
+	// Of two instances claiming the same run only the first one gets it.
+	lastRun := list[0].LastRun
+	claimed, err := s.ClaimScheduleRun(ctx, id, lastRun, now.Add(time.Minute))
+	if err != nil {
+		return err
+	}
+	if err := expect(claimed, "ClaimScheduleRun did not claim the run"); err != nil {
+		return err
+	}
+	claimed, err = s.ClaimScheduleRun(ctx, id, lastRun, now.Add(time.Minute))
+	if err != nil {
+		return err
+	}
+	if err := expect(!claimed, "ClaimScheduleRun claimed a run twice"); err != nil {
+		return err
+	}
+
+	schedule, err = s.GetScheduleById(ctx, id)
+	if err != nil {
+		return err
+	}
+	claimed, err = s.ClaimScheduleRun(ctx, id, schedule.LastRun, now.Add(2*time.Minute))
+	if err != nil {
+		return err
+	}
+	if err := expect(claimed, "ClaimScheduleRun did not claim the next run"); err != nil {
+		return err
+	}
+
+	runs := []database.ScheduleRun{
+		{ScheduleId: id, Action: database.ScheduleActionStop, Trigger: "schedule", Started: now.Add(-time.Hour), Finished: now.Add(-time.Hour), Skipped: 1,
+			Results: []database.ScheduleRunResult{{ServerId: "server-1", ServerName: "VM-1", Result: database.ScheduleResultSkipped}}},
+		{ScheduleId: id, Action: database.ScheduleActionStart, Trigger: "manual", Started: now, Finished: now, Succeeded: 1,
+			Results: []database.ScheduleRunResult{{ServerId: "server-1", ServerName: "VM-1", Result: database.ScheduleResultOk}}},
+	}
+	for _, v := range runs {
+		if err := s.InsertScheduleRun(ctx, v); err != nil {
+			return err
+		}
+	}
+
+	latest, err := s.GetScheduleRuns(ctx, id, 1)
+	if err != nil {
+		return err
+	}
+	if err := expect(len(latest) == 1 && latest[0].Action == database.ScheduleActionStart && latest[0].Succeeded == 1,
+		"GetScheduleRuns with a limit of 1 returned %+v, expected the newest run", latest); err != nil {
+		return err
+	}
+	if err := expect(len(latest[0].Results) == 1 && latest[0].Results[0].ServerName == "VM-1", "run has results %+v", latest[0].Results); err != nil {
+		return err
+	}
+
+	all, err := s.GetScheduleRuns(ctx, id, 10)
+	if err != nil {
+		return err
+	}
+	if err := expect(len(all) == 2, "GetScheduleRuns returned %d runs, expected 2", len(all)); err != nil {
+		return err
+	}
+
+	if err := s.DeleteScheduleById(ctx, id); err != nil {
+		return err
+	}
+	err = s.DeleteScheduleById(ctx, id)
+	return expectNotFound(err, "DeleteScheduleById of a deleted schedule")
+}
+
+func notifications(ctx context.Context, store storage.Store) error {
+	n := store.Notifications()
+	now := time.Now()
+	lease := 5 * time.Minute
+
+	queued := []database.Notification{
+		{UserId: "user@uia.no", Event: "vm_ready", Subject: "first", Status: database.NotificationStatusPending, NextAttempt: now.Add(-2 * time.Minute), Created: now},
+		{UserId: "user@uia.no", Event: "vm_deleted", Subject: "second", Status: database.NotificationStatusPending, NextAttempt: now.Add(-time.Minute), Created: now},
+		{UserId: "user@uia.no", Event: "vm_respawned", Subject: "later", Status: database.NotificationStatusPending, NextAttempt: now.Add(time.Hour), Created: now},
+	}
+	for _, v := range queued {
+		if err := n.InsertNotification(ctx, v); err != nil {
+			return err
+		}
+	}
+
+	first, err := n.ClaimDueNotification(ctx, now, lease)
+	if err != nil {
+		return err
+	}
+	if err := expect(first.Subject == "first" && first.Status == database.NotificationStatusSending && len(first.Id) > 0,
+		"ClaimDueNotification returned %+v, expected the oldest due notification", first); err != nil {
+		return err
+	}
+
+	second, err := n.ClaimDueNotification(ctx, now, lease)
+	if err != nil {
+		return err
+	}
+	if err := expect(second.Subject == "second", "ClaimDueNotification returned %q, expected second", second.Subject); err != nil {
+		return err
+	}
+
+	_, err = n.ClaimDueNotification(ctx, now, lease)
+	if err := expectNotFound(err, "ClaimDueNotification without a due notification"); err != nil {
+		return err
+	}
+
+	first.Status, first.Attempts, first.Sent = database.NotificationStatusSent, 1, now
+	if err := n.UpdateNotificationDelivery(ctx, *first); err != nil {
+		return err
+	}
+	second.Status, second.Attempts, second.LastError, second.NextAttempt = database.NotificationStatusPending, 1, "timeout", now.Add(10*time.Minute)
+	if err := n.UpdateNotificationDelivery(ctx, *second); err != nil {
+		return err
+	}
+
+	// The retry is due again, the sent notification never is.
+	retried, err := n.ClaimDueNotification(ctx, now.Add(30*time.Minute), lease)
+	if err != nil {
+		return err
+	}
+	if err := expect(retried.Subject == "second" && retried.Attempts == 1 && retried.LastError == "timeout",
+		"ClaimDueNotification returned %+v, expected the retry of second", retried); err != nil {
+		return err
+	}
+
+	first.Id = missingId
+	err = n.UpdateNotificationDelivery(ctx, *first)
+	if err := expectNotFound(err, "UpdateNotificationDelivery of a missing notification"); err != nil {
+		return err
+	}
+
+	preferences, err := n.GetNotificationPreferences(ctx, "user@uia.no")
+	if err != nil {
+		return err
+	}
+	if err := expect(preferences.UserId == "user@uia.no" && !preferences.Disabled && preferences.OptOut != nil && len(preferences.OptOut) == 0,
+		"GetNotificationPreferences of a new user returned %+v", preferences); err != nil {
+		return err
+	}
+
+	for _, optOut := range [][]string{{"vm_ready", "vm_deleted"}, {"vm_ready"}} {
+		update := database.NotificationPreferences{UserId: "user@uia.no", Disabled: true, OptOut: optOut}
+		if err := n.UpdateNotificationPreferences(ctx, update); err != nil {
+			return err
+		}
+	}
+
+	preferences, err = n.GetNotificationPreferences(ctx, "user@uia.no")
+	if err != nil {
+		return err
+	}
+	return expect(preferences.Disabled && len(preferences.OptOut) == 1 && preferences.OptOut[0] == "vm_ready",
+		"GetNotificationPreferences after two updates returned %+v", preferences)
+}
+
+func webhooks(ctx context.Context, store storage.Store) error {
+	w := store.Webhooks()
+	now := time.Now()
+
+	subscriptions := []database.WebhookSubscription{
+		{Url: "https://a.example.com", Secret: "secret", Events: []string{"vm.created"}, Enabled: true, CreatedBy: "admin@uia.no"},
+		{Url: "https://b.example.com", Secret: "secret", Enabled: true},
+		{Url: "https://c.example.com", Secret: "secret", Events: []string{"vm.created"}},
+	}
+	var ids []string
+	for _, v := range subscriptions {
+		id, err := w.InsertWebhook(ctx, v)
+		if err != nil {
+			return err
+		}
+		ids = append(ids, id)
+	}
+
+	list, err := w.GetWebhooks(ctx)
+	if err != nil {
+		return err
+	}
+	if err := expect(len(list) == 3, "GetWebhooks returned %d webhooks, expected 3", len(list)); err != nil {
+		return err
+	}
+
+	webhook, err := w.GetWebhookById(ctx, ids[0])
+	if err != nil {
+		return err
+	}
+	if err := expect(webhook.Url == "https://a.example.com" && webhook.Secret == "secret" && len(webhook.Events) == 1 &&
+		webhook.CreatedBy == "admin@uia.no" && !webhook.Created.IsZero(), "GetWebhookById returned %+v", webhook); err != nil {
+		return err
+	}
+
+	_, err = w.GetWebhookById(ctx, missingId)
+	if err := expectNotFound(err, "GetWebhookById of a missing webhook"); err != nil {
+		return err
+	}
+
+	// An empty event list receives every event, a disabled subscription none.
+	for event, expected := range map[string]int{"vm.created": 2, "vm.deleted": 1} {
+		subscribed, err := w.GetWebhooksForEvent(ctx, event)
+		if err != nil {
+			return err
+		}
+		if err := expect(len(subscribed) == expected, "GetWebhooksForEvent(%s) returned %d webhooks, expected %d", event, len(subscribed), expected); err != nil {
+			return err
+		}
+	}
+
+	webhook.Enabled = false
+	if err := w.UpdateWebhookById(ctx, *webhook); err != nil {
+		return err
+	}
+	subscribed, err := w.GetWebhooksForEvent(ctx, "vm.created")
+	if err != nil {
+		return err
+	}
+	if err := expect(len(subscribed) == 1 && subscribed[0].Id == ids[1], "GetWebhooksForEvent after disabling returned %d webhooks", len(subscribed)); err != nil {
+		return err
+	}
+
+	webhook.Id = missingId
+	err = w.UpdateWebhookById(ctx, *webhook)
+	if err := expectNotFound(err, "UpdateWebhookById of a missing webhook"); err != nil {
+		return err
+	}
+
+	deliveries := []database.WebhookDelivery{
+		{SubscriptionId: ids[0], Event: "vm.created", Payload: "{}", Status: database.WebhookDeliveryPending, NextAttempt: now.Add(-time.Minute), Created: now},
+		{SubscriptionId: ids[1], Event: "vm.deleted", Payload: "{}", Status: database.WebhookDeliveryDead, Attempts: 8, NextAttempt: now.Add(-time.Hour), Created: now},
+	}
+	for _, v := range deliveries {
+		if err := w.InsertWebhookDelivery(ctx, v); err != nil {
+			return err
+		}
+	}
+
+	dead, err := w.GetWebhookDeliveries(ctx, database.WebhookDeliveryDead, 10)
+	if err != nil {
+		return err
+	}
+	if err := expect(len(dead) == 1 && dead[0].Attempts == 8, "GetWebhookDeliveries returned %+v, expected the dead delivery", dead); err != nil {
+		return err
+	}
+
+	delivery, err := w.GetWebhookDeliveryById(ctx, dead[0].Id)
+	if err != nil {
+		return err
+	}
+	if err := expect(delivery.Event == "vm.deleted" && delivery.SubscriptionId == ids[1], "GetWebhookDeliveryById returned %+v", delivery); err != nil {
+		return err
+	}
+
+	_, err = w.GetWebhookDeliveryById(ctx, missingId)
+	if err := expectNotFound(err, "GetWebhookDeliveryById of a missing delivery"); err != nil {
+		return err
+	}
+
+	claimed, err := w.ClaimDueWebhookDelivery(ctx, now, 5*time.Minute)
+	if err != nil {
+		return err
+	}
+	if err := expect(claimed.Event == "vm.created" && claimed.Status == database.WebhookDeliverySending,
+		"ClaimDueWebhookDelivery returned %+v, expected the pending delivery", claimed); err != nil {
+		return err
+	}
+
+	_, err = w.ClaimDueWebhookDelivery(ctx, now, 5*time.Minute)
+	if err := expectNotFound(err, "ClaimDueWebhookDelivery of a claimed delivery"); err != nil {
+		return err
+	}
+
+	claimed.Status, claimed.Attempts, claimed.LastStatusCode, claimed.Delivered = database.WebhookDeliveryDelivered, 1, 200, now
+	if err := w.UpdateWebhookDelivery(ctx, *claimed); err != nil {
+		return err
+	}
+	delivery, err = w.GetWebhookDeliveryById(ctx, claimed.Id)
+	if err != nil {
+		return err
+	}
+	if err := expect(delivery.Status == database.WebhookDeliveryDelivered && delivery.LastStatusCode == 200 && !delivery.Delivered.IsZero(),
+		"GetWebhookDeliveryById after an update returned %+v", delivery); err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		if err := w.DeleteWebhookById(ctx, id); err != nil {
+			return err
+		}
+	}
+	err = w.DeleteWebhookById(ctx, ids[0])
+	return expectNotFound(err, "DeleteWebhookById of a deleted webhook")
+}
+
+func appState(ctx context.Context, store storage.Store) error {
+	a := store.AppState()
+
+	if err := a.SetInitialized(ctx); err != nil {
+		return err
+	}
+	if err := a.SetInitialized(ctx); err != nil {
+		return err
+	}
+
+	initialized, err := a.IsInitialized(ctx)
+	if err != nil {
+		return err
+	}
+	if err := expect(initialized, "IsInitialized is false after SetInitialized"); err != nil {
+		return err
+	}
+
+	if err := store.Admins().InsertAdmin(ctx, "admin@uia.no", "Admin"); err != nil {
+		return err
+	}
+	if err := a.Reset(ctx); err != nil {
+		return err
+	}
+
+	initialized, err = a.IsInitialized(ctx)
+	if err != nil {
+		return err
+	}
+	if err := expect(!initialized, "IsInitialized is true after Reset"); err != nil {
+		return err
+	}
+
+	admins, err := store.Admins().ReadAdmins(ctx)
+	if err != nil {
+		return err
+	}
+	if err := expect(len(admins) == 0, "Reset kept %d administrators", len(admins)); err != nil {
+		return err
+	}
+
+	vms, err := store.Vms().GetVMS(ctx)
+	if err != nil {
+		return err
+	}
+	if err := expect(len(vms) == 0, "Reset kept %d virtual machines", len(vms)); err != nil {
+		return err
+	}
+
+	ips, err := store.FloatingIps().GetFloatingIps(ctx)
+	if err != nil {
+		return err
+	}
+	return expect(len(ips) == 0, "Reset kept %d floating ips", len(ips))
+}
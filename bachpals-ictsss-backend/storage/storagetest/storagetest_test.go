@@ -0,0 +1,75 @@
+package storagetest_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/database"
+	_ "gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/database/repositories"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/storage"
+	_ "gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/storage/sqlstore"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/storage/storagetest"
+)
+
+// run migrates the store and runs the contract against it.
+func run(t *testing.T, backend string, dsn string) {
+	ctx := context.Background()
+
+	store, err := storage.New(ctx, backend, dsn)
+	if err != nil {
+		t.Fatalf("could not open %s: %v", backend, err)
+	}
+	defer store.Close(ctx)
+
+	if _, err := store.Migrator().Up(ctx); err != nil {
+		t.Fatalf("could not migrate %s: %v", backend, err)
+	}
+
+	for _, v := range storagetest.Run(ctx, store) {
+		t.Error(v)
+	}
+}
+
+func TestSqlite(t *testing.T) {
+	run(t, "sqlite", "file::memory:")
+}
+
+// TestPostgres needs an empty database in IKT_STACK_TEST_POSTGRES_DSN.
+func TestPostgres(t *testing.T) {
+	dsn := os.Getenv("IKT_STACK_TEST_POSTGRES_DSN")
+	if len(dsn) == 0 {
+		t.Skip("IKT_STACK_TEST_POSTGRES_DSN is not set")
+	}
+	run(t, "postgres", dsn)
+}
+
+// TestMongo needs a MongoDB at IKT_STACK_TEST_MONGO_URL, it runs in a database of its
+// own that is dropped afterwards.
+func TestMongo(t *testing.T) {
+	url := os.Getenv("IKT_STACK_TEST_MONGO_URL")
+	if len(url) == 0 {
+		t.Skip("IKT_STACK_TEST_MONGO_URL is not set")
+	}
+
+	ctx := context.Background()
+	name := fmt.Sprintf("ikt-stack-test-%d", time.Now().UnixNano())
+	viper.Set("IKT_STACK_DB_URL", url)
+	viper.Set("IKT_STACK_DB_NAME", name)
+	defer viper.Set("IKT_STACK_DB_NAME", "")
+
+	if err := database.Connect(ctx); err != nil {
+		t.Fatalf("could not connect to mongo: %v", err)
+	}
+	defer database.Disconnect(ctx)
+	defer func() {
+		if err := database.Client().Database(name).Drop(ctx); err != nil {
+			t.Errorf("could not drop %s: %v", name, err)
+		}
+	}()
+
+	run(t, "mongo", url)
+}
@@ -0,0 +1,151 @@
+package storage
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/database"
+)
+
+// Sort keys of VmStore.ListVms, ties are broken by server id.
+const (
+	VmSortCreated = "created"
+	VmSortName    = "server_name"
+	VmSortStatus  = "server_status"
+	VmSortCourse  = "course_code"
+	VmSortImage   = "server_image"
+)
+
+// VmSortKeys lists the valid values of VmQuery.Sort.
+var VmSortKeys = []string{VmSortCreated, VmSortName, VmSortStatus, VmSortCourse, VmSortImage}
+
+const DefaultVmPageSize = 50
+const MaxVmPageSize = 500
+
+// ErrInvalidQuery is returned by ListVms when the query does not validate.
+var ErrInvalidQuery = errors.New("invalid query")
+
+// VmQuery selects one page of virtual machines, empty fields do not filter.
+type VmQuery struct {
+	Status     string
+	ImageId    string
+	OwnerId    string
+	CourseCode string
+	Target     string
+	// Name matches server names containing it, ignoring case.
+	Name string
+	// CreatedFrom is inclusive and CreatedTo exclusive.
+	CreatedFrom time.Time
+	CreatedTo   time.Time
+	Sort        string
+	Descending  bool
+	Limit       int
+	// Cursor continues the listing after the previous page, it is that page's NextCursor.
+	Cursor string
+}
+
+// VmPage is one page of ListVms, Total counts every match of the filters.
+type VmPage struct {
+	Vms        []database.VirtualMachine `json:"vms"`
+	Total      int                       `json:"total"`
+	NextCursor string                    `json:"next_cursor,omitempty"`
+}
+
+// VmCursor is the position of the last virtual machine of a page, Value holds the sort
+// field unless the listing is sorted by creation time.
+type VmCursor struct {
+	Sort       string    `json:"s"`
+	Descending bool      `json:"d,omitempty"`
+	Created    time.Time `json:"c,omitempty"`
+	Value      string    `json:"v,omitempty"`
+	ServerId   string    `json:"id"`
+}
+
+// Normalize fills in the default sort and page size and checks the query, the returned
+// cursor is nil on the first page.
+func (q *VmQuery) Normalize() (*VmCursor, error) {
+	if len(q.Sort) == 0 {
+		q.Sort = VmSortCreated
+	}
+
+	valid := false
+	for _, v := range VmSortKeys {
+		valid = valid || v == q.Sort
+	}
+	if !valid {
+		return nil, fmtQueryError("unknown sort key %q", q.Sort)
+	}
+
+	if q.Limit <= 0 {
+		q.Limit = DefaultVmPageSize
+	}
+	if q.Limit > MaxVmPageSize {
+		q.Limit = MaxVmPageSize
+	}
+
+	if !q.CreatedFrom.IsZero() && !q.CreatedTo.IsZero() && !q.CreatedFrom.Before(q.CreatedTo) {
+		return nil, fmtQueryError("the created range is empty")
+	}
+
+	if len(q.Cursor) == 0 {
+		return nil, nil
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(q.Cursor)
+	if err != nil {
+		return nil, fmtQueryError("malformed cursor")
+	}
+
+	var cursor VmCursor
+	if err := json.Unmarshal(raw, &cursor); err != nil || len(cursor.ServerId) == 0 {
+		return nil, fmtQueryError("malformed cursor")
+	}
+	if cursor.Sort != q.Sort || cursor.Descending != q.Descending {
+		return nil, fmtQueryError("the cursor belongs to a listing with another sort order")
+	}
+
+	return &cursor, nil
+}
+
+// SortValue returns the field of vm the query sorts on, for the string sort keys.
+func (q VmQuery) SortValue(vm database.VirtualMachine) string {
+	switch q.Sort {
+	case VmSortName:
+		return vm.ServerName
+	case VmSortStatus:
+		return vm.ServerStatus
+	case VmSortCourse:
+		return vm.CourseCode
+	case VmSortImage:
+		return vm.ServerImage
+	}
+	return ""
+}
+
+// NextCursor returns the cursor of the page following the one ending with vm.
+func (q VmQuery) NextCursor(vm database.VirtualMachine) string {
+	cursor := VmCursor{Sort: q.Sort, Descending: q.Descending, ServerId: vm.ServerId}
+	if q.Sort == VmSortCreated {
+		cursor.Created = vm.Created
+	} else {
+		cursor.Value = q.SortValue(vm)
+	}
+
+	raw, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// QueryError is an ErrInvalidQuery with the reason.
+type QueryError struct {
+	Reason string
+}
+
+func (e *QueryError) Error() string { return "invalid query: " + e.Reason }
+func (e *QueryError) Unwrap() error { return ErrInvalidQuery }
+
+func fmtQueryError(format string, args ...interface{}) error {
+	return &QueryError{Reason: fmt.Sprintf(format, args...)}
+}
@@ -0,0 +1,100 @@
+package storage
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/database"
+)
+
+func TestNormalize(t *testing.T) {
+	now := time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC)
+	vm := database.VirtualMachine{ServerId: "server", ServerName: "VM-1", Created: now}
+	encode := func(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) }
+
+	tests := []struct {
+		name      string
+		query     VmQuery
+		wantSort  string
+		wantLimit int
+		wantErr   bool
+	}{
+		{"defaults", VmQuery{}, VmSortCreated, DefaultVmPageSize, false},
+		{"limit kept", VmQuery{Sort: VmSortName, Limit: 10}, VmSortName, 10, false},
+		{"negative limit", VmQuery{Limit: -1}, VmSortCreated, DefaultVmPageSize, false},
+		{"limit at the maximum", VmQuery{Limit: MaxVmPageSize}, VmSortCreated, MaxVmPageSize, false},
+		{"limit above the maximum", VmQuery{Limit: MaxVmPageSize + 1}, VmSortCreated, MaxVmPageSize, false},
+		{"created range", VmQuery{CreatedFrom: now, CreatedTo: now.Add(time.Hour)}, VmSortCreated, DefaultVmPageSize, false},
+		{"own cursor", VmQuery{Cursor: VmQuery{Sort: VmSortCreated}.NextCursor(vm)}, VmSortCreated, DefaultVmPageSize, false},
+		{"unknown sort key", VmQuery{Sort: "owner"}, "", 0, true},
+		{"empty created range", VmQuery{CreatedFrom: now, CreatedTo: now}, "", 0, true},
+		{"cursor is not base64", VmQuery{Cursor: "not a cursor!"}, "", 0, true},
+		{"cursor is not json", VmQuery{Cursor: encode("server")}, "", 0, true},
+		{"cursor without server id", VmQuery{Cursor: encode(`{"s":"created"}`)}, "", 0, true},
+		{"cursor of another sort key", VmQuery{Sort: VmSortName, Cursor: VmQuery{Sort: VmSortCreated}.NextCursor(vm)}, "", 0, true},
+		{"cursor of another direction", VmQuery{Descending: true, Cursor: VmQuery{Sort: VmSortCreated}.NextCursor(vm)}, "", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query := tt.query
+			_, err := query.Normalize()
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidQuery) {
+					t.Errorf("Normalize() = %v, want ErrInvalidQuery", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Normalize() = %v", err)
+			}
+			if query.Sort != tt.wantSort || query.Limit != tt.wantLimit {
+				t.Errorf("Normalize() left sort %q and limit %d, want %q and %d", query.Sort, query.Limit, tt.wantSort, tt.wantLimit)
+			}
+		})
+	}
+}
+
+func TestNextCursor(t *testing.T) {
+	vm := database.VirtualMachine{
+		ServerId:     "server",
+		ServerName:   "VM-1",
+		ServerStatus: database.VirtualMachineStatusActive,
+		CourseCode:   "DAT304",
+		ServerImage:  "image",
+		Created:      time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC),
+	}
+
+	tests := []struct {
+		sort       string
+		descending bool
+		wantValue  string
+	}{
+		{VmSortCreated, false, ""},
+		{VmSortCreated, true, ""},
+		{VmSortName, false, "VM-1"},
+		{VmSortStatus, true, database.VirtualMachineStatusActive},
+		{VmSortCourse, false, "DAT304"},
+		{VmSortImage, false, "image"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.sort, func(t *testing.T) {
+			query := VmQuery{Sort: tt.sort, Descending: tt.descending}
+			query.Cursor = query.NextCursor(vm)
+
+			cursor, err := query.Normalize()
+			if err != nil {
+				t.Fatalf("Normalize() = %v", err)
+			}
+			if cursor.ServerId != vm.ServerId || cursor.Value != tt.wantValue {
+				t.Errorf("cursor = %+v, want server id %q and value %q", cursor, vm.ServerId, tt.wantValue)
+			}
+			if tt.sort == VmSortCreated && !cursor.Created.Equal(vm.Created) {
+				t.Errorf("cursor created = %v, want %v", cursor.Created, vm.Created)
+			}
+		})
+	}
+}
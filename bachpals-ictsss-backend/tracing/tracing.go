@@ -0,0 +1,131 @@
+// Package tracing sets up OpenTelemetry tracing. Spans are made for every api request,
+// OpenStack and Canvas call, repository function and Mongo command, and exported over
+// OTLP to a collector or written to standard output, based on IKT_STACK_TRACING_EXPORTER.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/spf13/viper"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/metrics"
+	"go.mongodb.org/mongo-driver/event"
+	"go.opentelemetry.io/contrib/instrumentation/go.mongodb.org/mongo-driver/mongo/otelmongo"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.10.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ServiceName is the service of the spans and the name of the tracer.
+const ServiceName = "ictsss-backend"
+
+// Setup installs the tracer provider for IKT_STACK_TRACING_EXPORTER, "otlp" or "stdout".
+// Tracing is off when it is empty. The returned function flushes the spans that are
+// left and has to be called before the process exits.
+func Setup(ctx context.Context) (func(context.Context) error, error) {
+	var exporter sdktrace.SpanExporter
+	var err error
+
+	switch name := viper.GetString("IKT_STACK_TRACING_EXPORTER"); name {
+	case "":
+		return func(context.Context) error { return nil }, nil
+	case "otlp":
+		// Without an endpoint the exporter reads OTEL_EXPORTER_OTLP_ENDPOINT, or uses localhost:4318.
+		var opts []otlptracehttp.Option
+		if endpoint := viper.GetString("IKT_STACK_TRACING_ENDPOINT"); len(endpoint) > 0 {
+			opts = append(opts, otlptracehttp.WithEndpoint(endpoint))
+		}
+		if viper.GetBool("IKT_STACK_TRACING_INSECURE") {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		exporter, err = otlptracehttp.New(ctx, opts...)
+	case "stdout":
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	default:
+		return nil, fmt.Errorf("unknown tracing exporter %q, available: otlp, stdout", name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ratio := 1.0
+	if viper.IsSet("IKT_STACK_TRACING_SAMPLE_RATIO") {
+		ratio = viper.GetFloat64("IKT_STACK_TRACING_SAMPLE_RATIO")
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String(ServiceName))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	return provider.Shutdown, nil
+}
+
+// Start starts a span named after the function it times, like "repositories.ScheduleRepository.GetSchedules".
+// It is a no-op span while tracing is off.
+func Start(ctx context.Context, name string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return otel.Tracer(ServiceName).Start(ctx, name, trace.WithAttributes(attributes...))
+}
+
+// End records err on the span, if any, and ends it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Continue returns ctx with the span of from as parent, for work that goes on in the
+// background after the request of from has been answered.
+func Continue(ctx context.Context, from context.Context) context.Context {
+	return trace.ContextWithSpanContext(ctx, trace.SpanContextFromContext(from))
+}
+
+// TraceId returns the id of the trace of ctx, empty when it is not sampled.
+func TraceId(ctx context.Context) string {
+	spanContext := trace.SpanContextFromContext(ctx)
+	if !spanContext.IsSampled() {
+		return ""
+	}
+	return spanContext.TraceID().String()
+}
+
+// transport makes a client span for every call, named after the system and the
+// operation like the metrics, "openstack GET /v2.1/servers/{id}".
+func transport(next http.RoundTripper, system string) http.RoundTripper {
+	return otelhttp.NewTransport(next, otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+		return system + " " + metrics.Operation(r.Method, r.URL.Path)
+	}))
+}
+
+// OpenStackTransport traces the calls of the OpenStack clients, authentication included.
+func OpenStackTransport(next http.RoundTripper) http.RoundTripper {
+	return transport(next, "openstack")
+}
+
+// CanvasTransport traces the calls to the Canvas api.
+func CanvasTransport(next http.RoundTripper) http.RoundTripper {
+	return transport(next, "canvas")
+}
+
+// CommandMonitor makes a span for every Mongo command.
+func CommandMonitor() *event.CommandMonitor {
+	return otelmongo.NewMonitor()
+}
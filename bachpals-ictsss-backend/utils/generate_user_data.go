@@ -2,8 +2,10 @@ package utils
 
 import (
     "bytes"
+    "context"
     "fmt"
     "github.com/spf13/viper"
+    "gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/logging"
     "io/ioutil"
     "strings"
 )
@@ -11,7 +13,7 @@ import (
 func readFile(templatePath string) []byte {
     input, err := ioutil.ReadFile(templatePath)
     if err != nil {
-        fmt.Println(err)
+        logging.Error(context.Background(), "could not read template", err, "path", templatePath)
     }
 
     return input
@@ -57,12 +59,12 @@ func CleanUserNames(users string) string {
 // users has to be a comma separated string of usernames or just a single user without a comma at the end.
 func GenerateUserData(imageConfig string, users string) []byte {
     if len(imageConfig) == 0 {
-        fmt.Println("Image needs to have a valid value!")
+        logging.Warn(context.Background(), "image config needs to have a valid value")
         return nil
     }
 
     if len(users) == 0 {
-        fmt.Println("Users needs to have a valid value!")
+        logging.Warn(context.Background(), "users need to have a valid value")
         return nil
     }
 
@@ -88,4 +90,25 @@ func GenerateUserData(imageConfig string, users string) []byte {
     userDataOutput = bytes.Replace(userDataOutput, []byte("{SUDOERS}"), generateSudoers(cleanUsers), -1)
 
     return userDataOutput
-}
\ No newline at end of file
+}
+// GenerateAccessConfig renders sssd.conf and the sudoers file for a comma separated list of users,
+// with the same users user data gives access when a virtual machine is created.
+func GenerateAccessConfig(users string) ([]byte, []byte) {
+    cleanUsers := CleanUserNames(users)
+
+    sssd := readFile(viper.GetString("IKT_STACK_TEMPLATES_CONFIGS_DIR") + viper.GetString("IKT_STACK_SSSD_TEMPLATE_NAME"))
+    sssd = bytes.Replace(sssd, []byte("{USERS}"), []byte(cleanUsers), -1)
+
+    // The template is indented for user data, sssd reads indented lines as continuations.
+    var sssdOutput []byte
+    for _, v := range strings.Split(string(sssd), "\n") {
+        sssdOutput = append(sssdOutput, []byte(strings.TrimLeft(v, " \t")+"\n")...)
+    }
+
+    sudoers := []byte("# Added by IKT-STACK Deployment Script\n")
+    for _, v := range strings.Split(cleanUsers, ",") {
+        sudoers = append(sudoers, []byte(fmt.Sprintf("%s    ALL=(ALL:ALL) ALL\n", v))...)
+    }
+
+    return sssdOutput, sudoers
+}
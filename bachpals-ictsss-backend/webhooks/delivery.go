@@ -0,0 +1,163 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/spf13/viper"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/database"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/logging"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/storage"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/tracing"
+)
+
+const defaultMaxAttempts = 8
+const deliveryInterval = 15 * time.Second
+const deliveryBatchSize = 50
+const deliveryTimeout = 10 * time.Second
+const baseBackoff = 30 * time.Second
+const maxBackoff = 6 * time.Hour
+
+// deliveryLease is how long a claimed delivery is left to one instance, well above
+// deliveryTimeout so it is only posted again after that instance crashed.
+const deliveryLease = 5 * time.Minute
+
+var httpClient = &http.Client{Timeout: deliveryTimeout}
+
+// Start posts queued deliveries until ctx is cancelled.
+func Start(ctx context.Context) {
+	ticker := time.NewTicker(deliveryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			deliverDue(logging.Job(ctx, "webhook_delivery"), now)
+		}
+	}
+}
+
+func deliverDue(ctx context.Context, now time.Time) {
+	ctx, span := tracing.Start(ctx, "webhooks.deliverDue")
+	defer span.End()
+
+	maxAttempts := viper.GetInt("IKT_STACK_WEBHOOK_MAX_ATTEMPTS")
+	if maxAttempts <= 0 {
+		maxAttempts = defaultMaxAttempts
+	}
+
+	for i := 0; i < deliveryBatchSize; i++ {
+		delivery, err := storage.Webhooks().ClaimDueWebhookDelivery(ctx, now, deliveryLease)
+		if err == storage.ErrNotFound {
+			return
+		}
+		if err != nil {
+			logging.Error(ctx, "could not claim webhook delivery", err)
+			return
+		}
+
+		subscription, err := storage.Webhooks().GetWebhookById(ctx, delivery.SubscriptionId)
+		if err == storage.ErrNotFound {
+			// The subscription was deleted, nothing left to deliver to.
+			delivery.Status = database.WebhookDeliveryDead
+			delivery.LastError = "subscription no longer exists"
+			update(ctx, *delivery)
+			continue
+		}
+		if err != nil {
+			// The claim runs out and the delivery is tried again.
+			logging.Error(ctx, "could not read webhook", err, "subscription_id", delivery.SubscriptionId)
+			continue
+		}
+
+		statusCode, err := post(*subscription, *delivery)
+		if err != nil {
+			logging.Warn(ctx, "webhook delivery failed", "error", err, "event", delivery.Event, "url", subscription.Url, "attempts", delivery.Attempts+1)
+		}
+
+		update(ctx, attempted(*delivery, statusCode, err, maxAttempts, time.Now()))
+	}
+}
+
+// attempted returns the delivery after one more attempt that ended with statusCode and err.
+// A failed delivery is retried after a backoff until maxAttempts, then it is dead.
+func attempted(delivery database.WebhookDelivery, statusCode int, err error, maxAttempts int, now time.Time) database.WebhookDelivery {
+	delivery.Attempts++
+	delivery.LastStatusCode = statusCode
+
+	if err == nil {
+		delivery.Status = database.WebhookDeliveryDelivered
+		delivery.LastError = ""
+		delivery.Delivered = now
+		return delivery
+	}
+
+	delivery.LastError = err.Error()
+	if delivery.Attempts >= maxAttempts {
+		delivery.Status = database.WebhookDeliveryDead
+	} else {
+		delivery.Status = database.WebhookDeliveryPending
+		delivery.NextAttempt = now.Add(backoff(delivery.Attempts))
+	}
+
+	return delivery
+}
+
+func update(ctx context.Context, delivery database.WebhookDelivery) {
+	if err := storage.Webhooks().UpdateWebhookDelivery(ctx, delivery); err != nil {
+		logging.Error(ctx, "could not update webhook delivery", err, "delivery_id", delivery.Id)
+	}
+}
+
+// backoff doubles the wait after every failed attempt, 30s, 1m, 2m... up to maxBackoff.
+func backoff(attempts int) time.Duration {
+	wait := baseBackoff
+	for i := 1; i < attempts && wait < maxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > maxBackoff {
+		wait = maxBackoff
+	}
+	return wait
+}
+
+func post(subscription database.WebhookSubscription, delivery database.WebhookDelivery) (int, error) {
+	body := []byte(delivery.Payload)
+
+	req, err := http.NewRequest("POST", subscription.Url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, delivery.Event)
+	req.Header.Set(DeliveryHeader, delivery.Id)
+	req.Header.Set(SignatureHeader, Sign(subscription.Secret, body))
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	return resp.StatusCode, nil
+}
+
+// Redeliver puts a dead delivery back into the queue with a fresh set of attempts.
+func Redeliver(ctx context.Context, delivery database.WebhookDelivery) error {
+	delivery.Status = database.WebhookDeliveryPending
+	delivery.Attempts = 0
+	delivery.NextAttempt = time.Now()
+	return storage.Webhooks().UpdateWebhookDelivery(ctx, delivery)
+}
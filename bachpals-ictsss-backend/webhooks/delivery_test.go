@@ -0,0 +1,114 @@
+package webhooks
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/database"
+)
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{10, 512 * 30 * time.Second},
+		{11, maxBackoff},
+		{50, maxBackoff},
+	}
+
+	for _, tt := range tests {
+		if got := backoff(tt.attempts); got != tt.want {
+			t.Errorf("backoff(%d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestAttempted(t *testing.T) {
+	now := time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC)
+	failure := errors.New("unexpected status code 500")
+
+	tests := []struct {
+		name        string
+		attempts    int
+		statusCode  int
+		err         error
+		wantStatus  string
+		wantNext    time.Time
+		wantError   string
+		wantDeliver bool
+	}{
+		{"delivered", 0, 200, nil, database.WebhookDeliveryDelivered, time.Time{}, "", true},
+		{"delivered on a retry", 3, 204, nil, database.WebhookDeliveryDelivered, time.Time{}, "", true},
+		{"first failure", 0, 500, failure, database.WebhookDeliveryPending, now.Add(30 * time.Second), failure.Error(), false},
+		{"failure before the last attempt", 6, 500, failure, database.WebhookDeliveryPending, now.Add(backoff(7)), failure.Error(), false},
+		{"last attempt fails", 7, 500, failure, database.WebhookDeliveryDead, time.Time{}, failure.Error(), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			delivery := database.WebhookDelivery{Status: database.WebhookDeliverySending, Attempts: tt.attempts, LastError: "earlier"}
+			got := attempted(delivery, tt.statusCode, tt.err, 8, now)
+
+			if got.Status != tt.wantStatus || got.Attempts != tt.attempts+1 || got.LastStatusCode != tt.statusCode {
+				t.Errorf("attempted() = %s after %d attempts with %d, want %s after %d with %d",
+					got.Status, got.Attempts, got.LastStatusCode, tt.wantStatus, tt.attempts+1, tt.statusCode)
+			}
+			if got.LastError != tt.wantError {
+				t.Errorf("attempted() last error = %q, want %q", got.LastError, tt.wantError)
+			}
+			if !got.NextAttempt.Equal(tt.wantNext) {
+				t.Errorf("attempted() next attempt = %v, want %v", got.NextAttempt, tt.wantNext)
+			}
+			if got.Delivered.Equal(now) != tt.wantDeliver {
+				t.Errorf("attempted() delivered = %v", got.Delivered)
+			}
+		})
+	}
+}
+
+func TestPost(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		wantErr bool
+	}{
+		{"accepted", http.StatusAccepted, false},
+		{"server error", http.StatusInternalServerError, true},
+		{"not modified is no success", http.StatusNotModified, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var signature, event, id string
+			var body []byte
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				signature, event, id = r.Header.Get(SignatureHeader), r.Header.Get(EventHeader), r.Header.Get(DeliveryHeader)
+				body, _ = io.ReadAll(r.Body)
+				w.WriteHeader(tt.status)
+			}))
+			defer server.Close()
+
+			subscription := database.WebhookSubscription{Url: server.URL, Secret: "secret"}
+			delivery := database.WebhookDelivery{Id: "delivery", Event: EventVmCreated, Payload: `{"event":"vm.created"}`}
+
+			status, err := post(subscription, delivery)
+			if status != tt.status || (err != nil) != tt.wantErr {
+				t.Errorf("post() = %d, %v, want %d and error %v", status, err, tt.status, tt.wantErr)
+			}
+			if string(body) != delivery.Payload || event != EventVmCreated || id != "delivery" {
+				t.Errorf("post() sent %q as %q with id %q", body, event, id)
+			}
+			if signature != Sign("secret", []byte(delivery.Payload)) {
+				t.Errorf("post() signed with %q, want %q", signature, Sign("secret", []byte(delivery.Payload)))
+			}
+		})
+	}
+}
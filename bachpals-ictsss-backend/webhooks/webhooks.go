@@ -0,0 +1,141 @@
+package webhooks
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/database"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/logging"
+	"gitlab.internal.uia.no/dat304-g-22v/ictsss/bachpals-ictsss-backend/storage"
+)
+
+const EventVmCreated = "vm.created"
+const EventVmDeleted = "vm.deleted"
+const EventVmRespawned = "vm.respawned"
+const EventVmStatusChanged = "vm.status_changed"
+const EventVmMembersChanged = "vm.members_changed"
+const EventAdminCreated = "admin.created"
+const EventAdminUpdated = "admin.updated"
+const EventAdminDeleted = "admin.deleted"
+const EventImageCreated = "image.created"
+const EventImageUpdated = "image.updated"
+const EventImageDeleted = "image.deleted"
+
+// Events lists every event a subscription can filter on.
+var Events = []string{
+	EventVmCreated,
+	EventVmDeleted,
+	EventVmRespawned,
+	EventVmStatusChanged,
+	EventVmMembersChanged,
+	EventAdminCreated,
+	EventAdminUpdated,
+	EventAdminDeleted,
+	EventImageCreated,
+	EventImageUpdated,
+	EventImageDeleted,
+}
+
+const SignatureHeader = "X-ICTSSS-Signature"
+const EventHeader = "X-ICTSSS-Event"
+const DeliveryHeader = "X-ICTSSS-Delivery"
+
+type VmPayload struct {
+	ServerId       string   `json:"server_id"`
+	ServerName     string   `json:"server_name,omitempty"`
+	ServerIp       string   `json:"server_ip,omitempty"`
+	ServerImage    string   `json:"server_image,omitempty"`
+	ServerStatus   string   `json:"server_status,omitempty"`
+	PreviousStatus string   `json:"previous_status,omitempty"`
+	PreviousId     string   `json:"previous_id,omitempty"`
+	CourseCode     string   `json:"course_code,omitempty"`
+	Users          []string `json:"users,omitempty"`
+}
+
+type AdminPayload struct {
+	UserId    string `json:"user_id"`
+	Name      string `json:"name,omitempty"`
+	UpdatedId string `json:"updated_id,omitempty"`
+}
+
+type ImagePayload struct {
+	Id               string `json:"id,omitempty"`
+	ImageId          string `json:"image_id,omitempty"`
+	ImageDisplayName string `json:"image_display_name,omitempty"`
+	Published        string `json:"published,omitempty"`
+}
+
+// Envelope is the body posted to subscribers.
+type Envelope struct {
+	Event   string      `json:"event"`
+	Created time.Time   `json:"created"`
+	Data    interface{} `json:"data"`
+}
+
+// Publish queues the event for every enabled subscription listening to it.
+// Delivery happens in the background, see Start.
+func Publish(ctx context.Context, event string, data interface{}) {
+	subscriptions, err := storage.Webhooks().GetWebhooksForEvent(ctx, event)
+	if err != nil {
+		logging.Error(ctx, "could not read webhooks", err, "event", event)
+		return
+	}
+	if len(subscriptions) == 0 {
+		return
+	}
+
+	payload, err := json.Marshal(Envelope{Event: event, Created: time.Now(), Data: data})
+	if err != nil {
+		logging.Error(ctx, "could not encode webhook payload", err, "event", event)
+		return
+	}
+
+	now := time.Now()
+	for _, subscription := range subscriptions {
+		delivery := database.WebhookDelivery{
+			SubscriptionId: subscription.Id,
+			Event:          event,
+			Payload:        string(payload),
+			Status:         database.WebhookDeliveryPending,
+			NextAttempt:    now,
+			Created:        now,
+		}
+
+		if err := storage.Webhooks().InsertWebhookDelivery(ctx, delivery); err != nil {
+			logging.Error(ctx, "could not queue webhook", err, "event", event, "url", subscription.Url)
+		}
+	}
+}
+
+// Sign returns the signature header value for a payload, subscribers compute
+// the same HMAC-SHA256 with their secret and compare.
+func Sign(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// GenerateSecret returns a random secret for subscriptions created without one.
+func GenerateSecret() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		logging.Error(context.Background(), "could not generate webhook secret", err)
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+// IsEvent reports whether event is a known webhook event.
+func IsEvent(event string) bool {
+	for _, v := range Events {
+		if v == event {
+			return true
+		}
+	}
+	return false
+}
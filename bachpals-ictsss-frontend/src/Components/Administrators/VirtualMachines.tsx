@@ -15,18 +15,26 @@ function VirtualMachines() {
   const [servers, setServers] = useState<Array<SERVER_INFO> | null>(null);
   const [isLoading, setIsLoading] = useState<boolean>(false);
   const [open, setModalOpen] = useState<boolean>(false);
+  const [nextCursor, setNextCursor] = useState<string>("");
+
+  const loadPage = (cursor: string) => {
+    setIsLoading(true);
+    get(`/vms/all${cursor ? `?cursor=${encodeURIComponent(cursor)}` : ""}`, auth.user)
+      .then(handleJSONResponse)
+      .then((r: any) => {
+        if (r.data !== null) {
+          setServers((prev) => (cursor && prev ? [...prev, ...r.data.vms] : r.data.vms));
+          setNextCursor(r.data.next_cursor ?? "");
+        }
+        setIsLoading(false);
+      })
+      .catch(handleErrorResponse)
+      .finally(() => setIsLoading(false));
+  };
 
   useEffect(() => {
     if (!servers) {
-      setIsLoading(true);
-      get("/vms/all", auth.user)
-        .then(handleJSONResponse)
-        .then((r: any) => {
-          r.data !== null && setServers(r.data);
-          setIsLoading(false);
-        })
-        .catch(handleErrorResponse)
-        .finally(() => setIsLoading(false));
+      loadPage("");
     }
   }, []);
 
@@ -90,6 +98,15 @@ function VirtualMachines() {
               </div>
             </div>
           )}
+          {!isLoading && nextCursor && (
+            <button
+              type="button"
+              className="flex mt-4 w-min bg-gray-900 text-white px-8 py-2 rounded-md text-sm font-medium whitespace-nowrap"
+              onClick={() => loadPage(nextCursor)}
+            >
+              Load more
+            </button>
+          )}
         </div>
       </div>
       {open && (
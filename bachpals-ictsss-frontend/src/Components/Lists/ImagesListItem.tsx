@@ -34,7 +34,7 @@ function ImagesListItem(props: IAdminList) {
       "/image/",
       {
         id: image.Id,
-        published: image.Published === "true" ? "false" : "true",
+        published: image.Published !== "true",
         image_id: image.ImageId,
         image_name: image.ImageName,
         image_description: image.ImageDescription,
@@ -29,7 +29,7 @@ function AddImageModal(props: IAddImageModal) {
     useState<boolean>(true);
   const auth = useAuthProviderContext();
   const [fields, setFields] = useState<{
-    published: string;
+    published: boolean;
     image_id: string;
     image_name: string;
     image_description: string;
@@ -37,7 +37,7 @@ function AddImageModal(props: IAddImageModal) {
     image_config: string;
     image_read_root_password: boolean;
   } | null>({
-    published: "false",
+    published: false,
     image_id: "",
     image_name: "",
     image_description: "",
@@ -130,7 +130,7 @@ function AddImageModal(props: IAddImageModal) {
             setIsLoading(false);
             r.data !== null && setImagesState(r.data);
             setFields({
-              published: "false",
+              published: false,
               image_id: "",
               image_name: "",
               image_description: "",
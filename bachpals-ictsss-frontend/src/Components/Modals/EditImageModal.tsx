@@ -33,7 +33,7 @@ function EditImageModal(props: IEditImageModal) {
   const auth = useAuthProviderContext();
   const [fields, setFields] = useState<{
     id: string;
-    published: string;
+    published: boolean;
     image_id: string;
     image_name: string;
     image_description: string;
@@ -42,7 +42,7 @@ function EditImageModal(props: IEditImageModal) {
     image_read_root_password: boolean;
   } | null>({
     id: image.Id,
-    published: "false",
+    published: false,
     image_id: image.ImageId,
     image_name: image.ImageName,
     image_description: image.ImageDescription,
@@ -131,7 +131,7 @@ function EditImageModal(props: IEditImageModal) {
             setFields({
               image_config: "",
               id: "",
-              published: "false",
+              published: false,
               image_id: "",
               image_name: "",
               image_description: "",
@@ -123,7 +123,7 @@ function OrderVmModal(props: IOrderVmModal) {
 
       setFields((prevState) => ({
         ...prevState,
-        [e.target.id]: values[0].replaceAll(" ", "-"),
+        [e.target.id]: values[0].replace(/[^A-Za-z0-9-]+/g, "-"),
         course_id: values[1],
         group_students: [],
         group_name: "",
@@ -314,9 +314,9 @@ function OrderVmModal(props: IOrderVmModal) {
           group_name: fields.group_students.length > 1 ? fields.group_name : "",
           users: fields.group_students,
           server_image: fields.server_image,
-          everyone: fields.everyone,
-          include_ta: fields.include_ta,
-          include_teacher: fields.include_teacher,
+          everyone: fields.everyone === "1",
+          include_ta: fields.include_ta === "true",
+          include_teacher: fields.include_teacher === "true",
           course_code: fields.course_id,
         })
           .then(handleJSONResponse)
@@ -134,6 +134,6 @@ async function getMessageFromResponse(response: Response): Promise<string> {
   if (!response?.json) return undefined;
   return response
     .json()
-    .then((obj) => obj?.error)
+    .then((obj) => obj?.detail ?? obj?.error)
     .catch(() => "could_not_parse_json");
 }